@@ -0,0 +1,147 @@
+package main
+
+import (
+	"IP-Addr-Counter/ipcounter/cluster"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runCoordinate splits the files into ranges, hands them to the workers that
+// connect and prints the exact distinct count of their merged bitsets.
+func runCoordinate(args []string) {
+	fs := flag.NewFlagSet("coordinate", flag.ExitOnError)
+	addr := fs.String("listen", "127.0.0.1:7070", "`address` workers connect to")
+	rangeSize := fs.String("range-size", "256M", "nominal `size` of the byte ranges handed to workers")
+	formatSpec := fs.String("format", "plain", "input `format`: plain, combined, json:KEY, csv:N[:SEP] or regex:EXPR")
+	local := fs.Int("local", 0, "also start `N` worker processes on this machine")
+	save := fs.String("save", "", "write the merged set of IPs to a snapshot `file`")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ip-addr-counter coordinate [flags] <filename>...")
+		fmt.Fprintln(os.Stderr, "Counts the files with the workers started by 'ip-addr-counter work -connect ADDR'.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	rangeBytes, err := parseSize(*rangeSize)
+	if err != nil {
+		fail(err)
+	}
+
+	start := time.Now()
+	tasks, err := cluster.Split(fs.Args(), rangeBytes)
+	if err != nil {
+		fail(err)
+	}
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fail(err)
+	}
+	fmt.Printf("Coordinating %d ranges on tcp://%s\n", len(tasks), ln.Addr())
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Local workers are killed if the count ends without them.
+	var workers []*exec.Cmd
+	if *local > 0 {
+		exe, err := os.Executable()
+		if err != nil {
+			fail(err)
+		}
+		for i := 0; i < *local; i++ {
+			cmd := exec.CommandContext(ctx, exe, "work", "-connect", ln.Addr().String(), "-name", fmt.Sprintf("local-%d", i))
+			cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+			if err := cmd.Start(); err != nil {
+				fail(fmt.Errorf("failed to start local worker: %w", err))
+			}
+			workers = append(workers, cmd)
+		}
+	}
+
+	c := cluster.NewCoordinator(tasks, cluster.Config{
+		Format: *formatSpec,
+		Logf:   func(format string, args ...any) { fmt.Fprintf(os.Stderr, format+"\n", args...) },
+	})
+	res, err := c.Serve(ctx, ln)
+	stop()
+	for _, cmd := range workers {
+		cmd.Wait()
+	}
+	if err != nil {
+		fail(err)
+	}
+
+	fmt.Printf("Unique IPs: %d\n", res.Unique)
+	if res.Invalid > 0 {
+		fmt.Printf("Skipped %d of %d lines without a valid IP\n", res.Invalid, res.Lines)
+	}
+	fmt.Printf("Time taken: %v\n", time.Since(start))
+	if *save != "" {
+		if err := res.Bitmap.Save(*save); err != nil {
+			fail(err)
+		}
+		fmt.Printf("Snapshot saved to %s\n", *save)
+	}
+}
+
+// runWork counts ranges for a coordinator until it has none left.
+func runWork(args []string) {
+	fs := flag.NewFlagSet("work", flag.ExitOnError)
+	addr := fs.String("connect", "", "`address` of the coordinator (required)")
+	name := fs.String("name", "", "worker `name` in the coordinator's log (default: host:pid)")
+	wait := fs.Duration("wait", 30*time.Second, "how long to keep retrying while the coordinator is not up yet")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ip-addr-counter work -connect ADDR [flags]")
+		fmt.Fprintln(os.Stderr, "Counts ranges of the files given to 'ip-addr-counter coordinate'; they must exist at the same paths here.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *addr == "" {
+		fail(fmt.Errorf("-connect is required"))
+	}
+	if *name == "" {
+		host, _ := os.Hostname()
+		*name = fmt.Sprintf("%s:%d", host, os.Getpid())
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var conn net.Conn
+	deadline := time.Now().Add(*wait)
+	for {
+		var err error
+		var d net.Dialer
+		if conn, err = d.DialContext(ctx, "tcp", *addr); err == nil {
+			break
+		}
+		if time.Now().After(deadline) || ctx.Err() != nil {
+			fail(err)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	if err := cluster.Work(ctx, conn, *name); err != nil && !errors.Is(err, context.Canceled) {
+		fail(err)
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/follow"
+	"IP-Addr-Counter/ipcounter/metrics"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runFollow keeps counting a growing file until interrupted, printing the
+// running unique count every interval. If metricsAddr is set, metrics are
+// served there too.
+func runFollow(counter *concurrent.BitsetCounter, filename string, interval time.Duration, metricsAddr string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	f := follow.New(filename, counter)
+	if metricsAddr != "" {
+		reg := metrics.NewRegistry()
+		counter.RegisterMetrics(reg)
+		reg.CounterFunc("ipcounter_follow_rotations_total", "Times the followed path was found to name a new file.",
+			func() float64 { return float64(f.Stats().Rotations) })
+		reg.CounterFunc("ipcounter_follow_truncations_total", "Times the followed file shrank below the read offset.",
+			func() float64 { return float64(f.Stats().Truncations) })
+		if err := serveMetrics(metricsAddr, reg); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Following %s (Ctrl-C to stop)\n", filename)
+
+	done := make(chan error, 1)
+	go func() { done <- f.Run(ctx) }()
+
+	start := time.Now()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			st := f.Stats()
+			fmt.Printf("Unique IPs: %d\n", st.Unique)
+			fmt.Printf("Time taken: %v\n", time.Since(start).Round(time.Millisecond))
+			return err
+		case <-ticker.C:
+			st := f.Stats()
+			fmt.Printf("%s unique=%d bytes=%d rotations=%d truncations=%d\n",
+				time.Now().Format(time.RFC3339), st.Unique, st.Bytes, st.Rotations, st.Truncations)
+		}
+	}
+}
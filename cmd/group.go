@@ -0,0 +1,141 @@
+package main
+
+import (
+	"IP-Addr-Counter/ipcounter/format"
+	"IP-Addr-Counter/ipcounter/groupby"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// runGroup prints the keys with the most distinct IPs, such as the endpoints
+// or customers reached from the most addresses.
+func runGroup(args []string) {
+	fs := flag.NewFlagSet("group", flag.ExitOnError)
+	keySpec := fs.String("key", "", "key `field`: json:KEY, csv:N[:SEP] or regex:EXPR (required)")
+	formatSpec := fs.String("format", "combined", "input `format` of the IP field: plain, combined, json:KEY, csv:N[:SEP] or regex:EXPR")
+	top := fs.Int("top", 20, "print the `N` keys with the most distinct IPs (0 for all)")
+	maxMemory := fs.String("max-memory", "4G", "memory `ceiling` for all groups, e.g. 512M or 8G")
+	estimate := fs.Bool("estimate", false, "estimate every group with HyperLogLog (16KB per key)")
+	output := fs.String("output", "text", "output `format`: text, csv or json")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ip-addr-counter group [flags] -key FIELD [<filename>]")
+		fmt.Fprintln(os.Stderr, "Prints the distinct IPs per key; reads stdin if no file or - is given.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *keySpec == "" {
+		fail(fmt.Errorf("-key is required"))
+	}
+	if *output != "text" && *output != "csv" && *output != "json" {
+		fail(fmt.Errorf("unknown output format: %s (formats: text, csv, json)", *output))
+	}
+	maxBytes, err := parseSize(*maxMemory)
+	if err != nil {
+		fail(err)
+	}
+	key, err := format.Parse(*keySpec)
+	if err != nil {
+		fail(err)
+	}
+	ip, err := format.Parse(*formatSpec)
+	if err != nil {
+		fail(err)
+	}
+	counter, err := groupby.New(groupby.Config{
+		MaxBytes: maxBytes,
+		Estimate: *estimate,
+		Warn:     func(msg string) { fmt.Fprintf(os.Stderr, "Warning: %s\n", msg) },
+	}, key, ip)
+	if err != nil {
+		fail(err)
+	}
+
+	var in io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "" && name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			fail(fmt.Errorf("failed to open file: %w", err))
+		}
+		defer file.Close()
+		in = file
+	}
+	if err := counter.Add(in); err != nil {
+		fail(err)
+	}
+	if n := counter.Invalid(); n > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped %d records without a valid IP or key\n", n)
+	}
+
+	groups := counter.Top(*top)
+	switch *output {
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(groups)
+	case "csv":
+		fmt.Println("key,distinct,records,estimated")
+		for _, g := range groups {
+			fmt.Printf("%s,%d,%d,%t\n", csvQuote(g.Key), g.Distinct, g.Records, g.Estimated)
+		}
+	default:
+		s := counter.Stats()
+		fmt.Printf("Keys: %d\n", s.Keys)
+		for i, g := range groups {
+			approx := ""
+			if g.Estimated {
+				approx = "~"
+			}
+			fmt.Printf("%3d. %-40s %s%d distinct (%d records)\n", i+1, g.Key, approx, g.Distinct, g.Records)
+		}
+		if s.Overflow > 0 {
+			fmt.Printf("%d records with new keys past the memory ceiling are counted under %s\n", s.Overflow, groupby.Overflow)
+		}
+	}
+	if err != nil {
+		fail(fmt.Errorf("write error: %w", err))
+	}
+}
+
+// csvQuote quotes s if it holds a comma, quote or newline.
+func csvQuote(s string) string {
+	if !strings.ContainsAny(s, ",\"\n") {
+		return s
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// parseSize parses a byte count with an optional K, M, G or T suffix (powers of 1024).
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	shift := 0
+	if n := len(num); n > 0 {
+		switch num[n-1] {
+		case 'K':
+			shift = 10
+		case 'M':
+			shift = 20
+		case 'G':
+			shift = 30
+		case 'T':
+			shift = 40
+		}
+		if shift != 0 {
+			num = num[:n-1]
+		}
+	}
+	v, err := strconv.ParseInt(num, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid size: %q (e.g. 512M, 4G)", s)
+	}
+	return v << shift, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/format"
+	"IP-Addr-Counter/ipcounter/listener"
+	"IP-Addr-Counter/ipcounter/metrics"
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runListen receives syslog on UDP and TCP until interrupted, printing the
+// running unique count every interval and saving snapshots if asked.
+func runListen(args []string) {
+	fs := flag.NewFlagSet("listen", flag.ExitOnError)
+	udpAddr := fs.String("udp", "127.0.0.1:5514", "UDP `address` to receive syslog on (empty to disable)")
+	tcpAddr := fs.String("tcp", "127.0.0.1:5514", "TCP `address` to receive syslog on (empty to disable)")
+	formatSpec := fs.String("format", "", "`format` of the IP field in the message body: combined, json:KEY, csv:N[:SEP] or regex:EXPR (default: first IPv4 address)")
+	interval := fs.Duration("interval", 10*time.Second, "how often to print the running unique count")
+	save := fs.String("save", "", "write the set of seen IPs to a snapshot `file` every -save-every and on exit")
+	saveEvery := fs.Duration("save-every", time.Minute, "how often to write the -save snapshot")
+	metricsAddr := fs.String("metrics", "", "serve Prometheus metrics at http://`ADDR`/metrics")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ip-addr-counter listen [flags]")
+		fmt.Fprintln(os.Stderr, "Counts the distinct IPs of syslog messages (RFC3164/RFC5424) received over UDP and TCP.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *udpAddr == "" && *tcpAddr == "" {
+		fail(fmt.Errorf("nothing to listen on: set -udp or -tcp"))
+	}
+	var extract format.Extractor
+	if *formatSpec != "" {
+		var err error
+		if extract, err = format.Parse(*formatSpec); err != nil {
+			fail(err)
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	counter := concurrent.New()
+	l := listener.New(counter, extract)
+	if *metricsAddr != "" {
+		reg := metrics.NewRegistry()
+		counter.RegisterMetrics(reg)
+		reg.CounterFunc("ipcounter_syslog_connections_total", "TCP connections accepted.",
+			func() float64 { return float64(l.Stats().Connections) })
+		if err := serveMetrics(*metricsAddr, reg); err != nil {
+			fail(err)
+		}
+	}
+	done := make(chan error, 2)
+	serving := 0
+	if *udpAddr != "" {
+		pc, err := net.ListenPacket("udp", *udpAddr)
+		if err != nil {
+			fail(err)
+		}
+		fmt.Printf("Listening for syslog on udp://%s\n", pc.LocalAddr())
+		go func() { done <- l.ServeUDP(ctx, pc) }()
+		serving++
+	}
+	if *tcpAddr != "" {
+		ln, err := net.Listen("tcp", *tcpAddr)
+		if err != nil {
+			fail(err)
+		}
+		fmt.Printf("Listening for syslog on tcp://%s\n", ln.Addr())
+		go func() { done <- l.ServeTCP(ctx, ln) }()
+		serving++
+	}
+
+	saveSnapshot := func() {
+		if err := counter.Bitmap().Save(*save); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+	}
+	var saveTick <-chan time.Time
+	if *save != "" {
+		t := time.NewTicker(*saveEvery)
+		defer t.Stop()
+		saveTick = t.C
+	}
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	var serveErr error
+	for serving > 0 {
+		select {
+		case err := <-done:
+			serving--
+			if err != nil && serveErr == nil {
+				serveErr = err
+				stop() // Bring the other listener down too.
+			}
+		case <-ticker.C:
+			st := l.Stats()
+			fmt.Printf("%s unique=%d messages=%d invalid=%d connections=%d\n",
+				time.Now().Format(time.RFC3339), st.Unique, st.Messages, st.Invalid, st.Connections)
+		case <-saveTick:
+			saveSnapshot()
+		}
+	}
+
+	fmt.Printf("Unique IPs: %d\n", l.Stats().Unique)
+	if *save != "" {
+		saveSnapshot()
+		fmt.Printf("Snapshot saved to %s\n", *save)
+	}
+	if serveErr != nil {
+		fail(serveErr)
+	}
+}
@@ -1,44 +1,252 @@
 package main
 
 import (
+	"IP-Addr-Counter/ipcounter"
+	"IP-Addr-Counter/ipcounter/adaptive"
 	"IP-Addr-Counter/ipcounter/assembly"
+	"IP-Addr-Counter/ipcounter/auto"
 	"IP-Addr-Counter/ipcounter/bitset"
+	"IP-Addr-Counter/ipcounter/checkpoint"
 	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/format"
+	"IP-Addr-Counter/ipcounter/hugemem"
+	"IP-Addr-Counter/ipcounter/ipset"
 	"IP-Addr-Counter/ipcounter/naive"
+	"IP-Addr-Counter/ipcounter/partitioned"
+	"IP-Addr-Counter/ipcounter/pipeline"
+	"IP-Addr-Counter/ipcounter/topk"
+	"IP-Addr-Counter/ipcounter/tuning"
+	"IP-Addr-Counter/ipcounter/uring"
+	"IP-Addr-Counter/ipcounter/utils"
+	"errors"
+	"flag"
 	"fmt"
 	"os"
 	"time"
 )
 
-func main() {
-	if len(os.Args) < 3 {
-		fmt.Println("Usage: ip-addr-counter <implementation> <filename>")
-		fmt.Println("Implementations: naive, bitset, concurrent, assembly")
-		os.Exit(1)
-	}
+// subcommands maps mode names to their entry points. Any other first argument
+// is treated as an implementation name and runs a plain count.
+var subcommands = map[string]func(args []string){
+	"coordinate": runCoordinate,
+	"group":      runGroup,
+	"listen":     runListen,
+	"query":      runQuery,
+	"range":      runRange,
+	"serve":      runServe,
+	"uniq":       runUniq,
+	"window":     runWindow,
+	"work":       runWork,
+}
+
+// heavyHitters is implemented by the counters that can track the most frequent IPs.
+type heavyHitters interface {
+	TrackTopK(k int)
+	TopK() *topk.Result
+}
 
-	impl := os.Args[1]
-	filename := os.Args[2]
+// formatted is implemented by the counters that can read IPs out of structured records.
+type formatted interface {
+	UseFormat(e format.Extractor)
+}
 
-	var counter interface {
-		CountUniqueIPs(filename string) (int64, error)
-	}
+// checkpointer is implemented by the counters that can checkpoint a count and resume it.
+type checkpointer interface {
+	UseCheckpoint(filename string, interval time.Duration, format string)
+	Resume(c *checkpoint.Checkpoint)
+}
+
+// snapshotter is implemented by the counters whose bitset can be saved as a snapshot.
+type snapshotter interface {
+	Bitmap() *ipset.Bitmap
+}
+
+// paged is implemented by the counters that can tell what memory their bitset is on.
+type paged interface {
+	Memory() hugemem.Info
+}
+
+func usage() {
+	fmt.Println("Usage: ip-addr-counter [flags] <implementation> <filename>")
+	fmt.Println("       ip-addr-counter query [flags] [<implementation> <filename>]")
+	fmt.Println("       ip-addr-counter range [flags] [<implementation> <filename>] [<range>...]")
+	fmt.Println("       ip-addr-counter serve [flags]")
+	fmt.Println("       ip-addr-counter uniq [flags] [<filename>]")
+	fmt.Println("       ip-addr-counter group [flags] -key FIELD [<filename>]")
+	fmt.Println("       ip-addr-counter listen [flags]")
+	fmt.Println("       ip-addr-counter window [flags] -size DURATION [<filename>]")
+	fmt.Println("       ip-addr-counter coordinate [flags] <filename>...")
+	fmt.Println("       ip-addr-counter work -connect ADDR [flags]")
+	fmt.Println("Implementations: naive, bitset, adaptive, concurrent, asm, partitioned, auto")
+	fmt.Println("Flags:")
+	flag.PrintDefaults()
+}
 
+// newCounter returns the counter for the named implementation. The bitset
+// implementations, bitset, concurrent, asm and partitioned, are set up with
+// opts, which must be valid.
+func newCounter(impl string, opts ...tuning.Option) (ipcounter.Counter, error) {
 	switch impl {
 	case "naive":
-		counter = naive.New()
+		return naive.New(), nil
 	case "bitset":
-		counter = bitset.New()
+		return bitset.New(opts...), nil
+	case "adaptive":
+		return adaptive.New(), nil
 	case "concurrent":
-		counter = concurrent.New()
+		return concurrent.New(opts...), nil
 	case "asm":
-		counter = assembly.New()
+		return assembly.New(opts...), nil
+	case "partitioned":
+		return partitioned.New(opts...), nil
 	default:
-		fmt.Printf("Unknown implementation: %s\n", impl)
-		fmt.Println("Implementations: naive, bitset, concurrent, assembly")
+		return nil, fmt.Errorf("unknown implementation: %s (implementations: naive, bitset, adaptive, concurrent, asm, partitioned, auto)", impl)
+	}
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
+	}
+
+	topN := flag.Int("top", 0, "also report the `N` most frequent IPs (concurrent, asm and partitioned only)")
+	formatSpec := flag.String("format", "plain", "input `format`: plain, combined, json:KEY, csv:N[:SEP] or regex:EXPR (concurrent, asm and partitioned only)")
+	followMode := flag.Bool("follow", false, "keep reading data appended to the file, like tail -F (concurrent only)")
+	interval := flag.Duration("interval", 10*time.Second, "how often -follow prints the running unique count")
+	metricsAddr := flag.String("metrics", "", "serve Prometheus metrics at http://`ADDR`/metrics while -follow runs")
+	save := flag.String("save", "", "write the set of seen IPs to a snapshot `file` (bitset, concurrent, asm and partitioned only)")
+	checkpointFile := flag.String("checkpoint", "", "periodically save the progress of the count to `file` (concurrent and asm only)")
+	checkpointEvery := flag.Duration("checkpoint-every", checkpoint.DefaultInterval, "how often to write the -checkpoint file")
+	resume := flag.Bool("resume", false, "continue the count from the -checkpoint file, if there is one")
+	workers := flag.Int("workers", 0, "number of worker goroutines, 0 for one per CPU (concurrent, asm and partitioned only)")
+	chunkSize := flag.String("chunk-size", "16M", "`size` of the chunks the input is read in (concurrent, asm and partitioned only)")
+	queueLen := flag.Int("queue", tuning.DefaultQueueLen, "most chunks waiting for a worker, fewer if memory is short (concurrent, asm and partitioned only)")
+	shards := flag.Int("shards", tuning.DefaultShards, "number of bitset shards, a power of two (concurrent and asm only)")
+	layoutName := flag.String("layout", "interleaved", "order of the IPs in the bitset: interleaved across the shards or ranged, one range of IPs per shard (concurrent and asm only)")
+	deferred := flag.Bool("deferred", false, "set bits with atomic OR and count them with a popcount at the end (concurrent and asm only)")
+	hugePages := flag.Bool("hugepages", false, "put the bitset on huge pages, interleaved over NUMA nodes (bitset, concurrent, asm and partitioned only)")
+	ioUring := flag.Bool("io-uring", false, "read the file through io_uring with many reads in flight, on Linux (concurrent, asm and partitioned only)")
+	direct := flag.Bool("direct", false, "read the file with O_DIRECT, keeping it out of the page cache, on Linux (concurrent, asm and partitioned only)")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() < 2 {
+		usage()
+		os.Exit(1)
+	}
+
+	impl := flag.Arg(0)
+	filename := flag.Arg(1)
+
+	chunkBytes, err := parseSize(*chunkSize)
+	if err != nil {
+		fmt.Printf("Error: invalid -chunk-size: %v\n", err)
+		os.Exit(1)
+	}
+	if chunkBytes > tuning.MaxChunkBytes {
+		chunkBytes = tuning.MaxChunkBytes + 1 // Let Validate report it without overflowing int.
+	}
+	layout, err := ipset.ParseLayout(*layoutName)
+	if err != nil {
+		fmt.Printf("Error: invalid -layout: %v\n", err)
+		os.Exit(1)
+	}
+	opts := []tuning.Option{tuning.Workers(*workers), tuning.ChunkBytes(int(chunkBytes)), tuning.QueueLen(*queueLen), tuning.Shards(*shards), tuning.Layout(layout), tuning.Deferred(*deferred), tuning.HugePages(*hugePages), tuning.IOUring(*ioUring), tuning.Direct(*direct)}
+	sharding, err := tuning.New(opts...)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
 	}
 
+	if impl == "auto" {
+		choice, err := auto.Choose(filename, auto.Requirements{
+			Format:     *formatSpec != "plain",
+			TopK:       *topN > 0,
+			Checkpoint: *checkpointFile != "" || *resume,
+			Follow:     *followMode,
+			Snapshot:   *save != "",
+		})
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Auto-selected %s: %s\n", choice.Impl, choice.Reason)
+		impl = choice.Impl
+	} else if err := auto.Check(impl, auto.CurrentHost(), sharding); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	counter, err := newCounter(impl, opts...)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	if *formatSpec != "plain" {
+		if err := applyFormat(counter, *formatSpec); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var hh heavyHitters
+	if *topN > 0 {
+		var ok bool
+		if hh, ok = counter.(heavyHitters); !ok {
+			fmt.Printf("Error: -top is not supported by the %s implementation\n", impl)
+			os.Exit(1)
+		}
+		hh.TrackTopK(*topN)
+	}
+
+	var snap snapshotter
+	if *save != "" {
+		var ok bool
+		if snap, ok = counter.(snapshotter); !ok {
+			fmt.Printf("Error: -save is not supported by the %s implementation\n", impl)
+			os.Exit(1)
+		}
+	}
+
+	if *checkpointFile != "" || *resume {
+		if err := setupCheckpoint(counter, *checkpointFile, *checkpointEvery, *resume, *followMode, filename, *formatSpec); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if *followMode {
+		c, ok := counter.(*concurrent.BitsetCounter)
+		if !ok {
+			fmt.Println("Error: -follow requires the concurrent implementation")
+			os.Exit(1)
+		}
+		if hh != nil {
+			fmt.Println("Error: -top cannot be used with -follow")
+			os.Exit(1)
+		}
+		if err := runFollow(c, filename, *interval, *metricsAddr); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *ioUring {
+		if err := uring.Probe(); err != nil {
+			fmt.Printf("io_uring is not available (%v), reading with read(2)\n", err)
+		}
+	}
+	if *direct {
+		if err := pipeline.ProbeDirect(filename); err != nil {
+			fmt.Printf("O_DIRECT is not available (%v), dropping the file from the page cache behind the reader\n", err)
+		}
+	}
+
 	fmt.Printf("Starting to count unique IPs using %s implementation on %s\n", impl, filename)
 	start := time.Now()
 	count, err := counter.CountUniqueIPs(filename)
@@ -48,9 +256,100 @@ func main() {
 	}
 
 	fmt.Printf("Unique IPs: %d\n", count)
+	if hh != nil {
+		printTopK(hh.TopK())
+	}
 	fmt.Printf("Time taken: %v\n", time.Since(start))
+	if p, ok := counter.(paged); ok && *hugePages {
+		fmt.Printf("Bitset memory: %v\n", p.Memory())
+	}
+
+	if snap != nil {
+		if err := snap.Bitmap().Save(*save); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Snapshot saved to %s\n", *save)
+	}
 
 	if os.Getenv("PPROF") != "" {
 		fmt.Println("Profiling enabled; check cpu.prof, mem.prof, or goroutine.prof")
 	}
 }
+
+// setupCheckpoint makes counter checkpoint to filename its count of input
+// with the format spec, and if resume is set, loads the checkpoint left by an
+// earlier run. A missing checkpoint starts the count from the beginning, so a
+// failed run can be retried with the same command; one of another input, or
+// of the same read with another format, is refused.
+func setupCheckpoint(counter ipcounter.Counter, filename string, every time.Duration, resume, follow bool, input, format string) error {
+	if filename == "" {
+		return fmt.Errorf("-resume requires -checkpoint")
+	}
+	if follow {
+		return fmt.Errorf("-checkpoint cannot be used with -follow")
+	}
+	cp, ok := counter.(checkpointer)
+	if !ok {
+		return fmt.Errorf("-checkpoint is not supported by this implementation")
+	}
+	if resume {
+		c, err := checkpoint.Load(filename)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			fmt.Printf("No checkpoint at %s, starting from the beginning\n", filename)
+		case err != nil:
+			return err
+		default:
+			info, err := os.Stat(input)
+			if err != nil {
+				return err
+			}
+			if err := c.Input.Match(checkpoint.InputOf(info, format)); err != nil {
+				return fmt.Errorf("cannot resume from %s: %w", filename, err)
+			}
+			cp.Resume(c)
+			fmt.Printf("Resuming at byte %d with %d unique IPs from %s\n", c.Offset, c.Set.Count(), filename)
+		}
+	}
+	cp.UseCheckpoint(filename, every, format)
+	return nil
+}
+
+// applyFormat configures counter to extract IPs from records in the given format.
+func applyFormat(counter ipcounter.Counter, spec string) error {
+	e, err := format.Parse(spec)
+	if err != nil {
+		return err
+	}
+	f, ok := counter.(formatted)
+	if !ok {
+		return fmt.Errorf("-format is not supported by this implementation")
+	}
+	f.UseFormat(e)
+	return nil
+}
+
+// bound returns the overcount bound shared by all entries of res.
+func bound(res *topk.Result) uint64 {
+	if len(res.Entries) == 0 {
+		return 0
+	}
+	return res.Entries[0].Error
+}
+
+// printTopK prints the heavy hitters with their Count-Min error bounds.
+func printTopK(res *topk.Result) {
+	if res == nil {
+		return
+	}
+	fmt.Printf("Top %d IPs by hits (of %d lines, estimates overcount by at most %d with %.1f%% confidence):\n",
+		len(res.Entries), res.Total, bound(res), (1-res.Delta)*100)
+	for i, e := range res.Entries {
+		low := uint64(0)
+		if e.Count > e.Error {
+			low = e.Count - e.Error
+		}
+		fmt.Printf("%4d. %-15s %d (at least %d)\n", i+1, utils.Uint32ToIP(e.IP), e.Count, low)
+	}
+}
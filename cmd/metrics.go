@@ -0,0 +1,27 @@
+package main
+
+import (
+	"IP-Addr-Counter/ipcounter/metrics"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+)
+
+// serveMetrics serves reg at /metrics on addr in the background. It fails
+// early if addr cannot be listened on.
+func serveMetrics(addr string, reg *metrics.Registry) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", reg)
+	go func() {
+		if err := http.Serve(ln, mux); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: metrics server: %v\n", err)
+		}
+	}()
+	fmt.Printf("Serving metrics on http://%s/metrics\n", ln.Addr())
+	return nil
+}
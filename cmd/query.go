@@ -0,0 +1,109 @@
+package main
+
+import (
+	"IP-Addr-Counter/ipcounter"
+	"IP-Addr-Counter/ipcounter/ipset"
+	"IP-Addr-Counter/ipcounter/utils"
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// queryBatchSize is the number of candidate IPs checked per ContainsAll call.
+const queryBatchSize = 4096
+
+// runQuery reads candidate IPs from stdin and prints the ones present in a set,
+// so the tool can act as a lookup filter in shell pipelines.
+func runQuery(args []string) {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	snapshot := fs.String("snapshot", "", "query a snapshot `file` written with -save instead of counting a file")
+	invert := fs.Bool("v", false, "print the candidates that are NOT present instead")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ip-addr-counter query [flags] [<implementation> <filename>]")
+		fmt.Fprintln(os.Stderr, "Reads one IP per line from stdin and prints those present in the set.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	set, err := loadSet(*snapshot, fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	scanner := bufio.NewScanner(os.Stdin)
+	lines := make([][]byte, 0, queryBatchSize)
+	ips := make([]uint32, 0, queryBatchSize)
+	valid := make([]bool, 0, queryBatchSize)
+
+	flush := func() {
+		found := set.ContainsAll(ips)
+		for i, line := range lines {
+			present := valid[i] && found[i]
+			if present != *invert {
+				out.Write(line)
+				out.WriteByte('\n')
+			}
+		}
+		lines, ips, valid = lines[:0], ips[:0], valid[:0]
+	}
+
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		ip, err := utils.ParseIPv4(line)
+		lines = append(lines, append([]byte(nil), line...))
+		ips = append(ips, ip)
+		valid = append(valid, err == nil)
+		if len(lines) == queryBatchSize {
+			flush()
+		}
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: reading stdin: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// loadSet returns the set to query: a loaded snapshot if one is given,
+// otherwise the bitset built by counting the file named in args.
+func loadSet(snapshot string, args []string) (ipcounter.Set, error) {
+	if snapshot != "" {
+		return ipset.Load(snapshot)
+	}
+	counter, err := countFile(args)
+	if err != nil {
+		return nil, err
+	}
+	set, ok := counter.(ipcounter.Set)
+	if !ok {
+		return nil, fmt.Errorf("the %s implementation does not support queries", args[0])
+	}
+	return set, nil
+}
+
+// countFile runs the implementation named by args[0] on the file named by
+// args[1] and returns the counter holding the seen IPs.
+func countFile(args []string) (ipcounter.Counter, error) {
+	if len(args) < 2 {
+		return nil, fmt.Errorf("either -snapshot or <implementation> <filename> is required")
+	}
+	counter, err := newCounter(args[0])
+	if err != nil {
+		return nil, err
+	}
+	if _, err := counter.CountUniqueIPs(args[1]); err != nil {
+		return nil, err
+	}
+	return counter, nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"IP-Addr-Counter/ipcounter/ipset"
+	"IP-Addr-Counter/ipcounter/utils"
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// indexer is implemented by the counters that can build a rank index over their seen IPs.
+type indexer interface {
+	Index() *ipset.Index
+}
+
+// runRange answers range cardinality and select queries against a set. Ranges
+// are CIDR prefixes, "A-B" pairs or single IPs, given as arguments or read from
+// stdin one per line.
+func runRange(args []string) {
+	fs := flag.NewFlagSet("range", flag.ExitOnError)
+	snapshot := fs.String("snapshot", "", "query a snapshot `file` written with -save instead of counting a file")
+	selects := fs.String("select", "", "comma-separated `ranks` k; print the k-th smallest IP of the set (from 0)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ip-addr-counter range [flags] [<implementation> <filename>] [<range>...]")
+		fmt.Fprintln(os.Stderr, "Prints the number of distinct IPs in each range (10.0.0.0/8, 1.2.3.4-1.2.3.9, 1.2.3.4).")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	idx, queries, err := loadIndex(*snapshot, fs.Args())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+
+	if *selects != "" {
+		for _, field := range strings.Split(*selects, ",") {
+			k, err := strconv.ParseUint(strings.TrimSpace(field), 10, 64)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid rank %q\n", field)
+				os.Exit(1)
+			}
+			if ip, ok := idx.Select(k); ok {
+				fmt.Fprintf(out, "#%d\t%s\n", k, utils.Uint32ToIP(ip))
+			} else {
+				fmt.Fprintf(out, "#%d\t-\n", k)
+			}
+		}
+		if len(queries) == 0 {
+			return
+		}
+	}
+
+	answer := func(q string) {
+		lo, hi, err := utils.ParseRange(q)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return
+		}
+		fmt.Fprintf(out, "%s\t%d\n", q, idx.RangeCount(lo, hi))
+	}
+
+	if len(queries) > 0 {
+		for _, q := range queries {
+			answer(q)
+		}
+		return
+	}
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if q := strings.TrimSpace(scanner.Text()); q != "" {
+			answer(q)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: reading stdin: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// loadIndex builds the rank index to query and returns the remaining
+// arguments, which are the range queries.
+func loadIndex(snapshot string, args []string) (*ipset.Index, []string, error) {
+	if snapshot != "" {
+		m, err := ipset.Load(snapshot)
+		if err != nil {
+			return nil, nil, err
+		}
+		return ipset.NewIndex(m), args, nil
+	}
+
+	counter, err := countFile(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	ix, ok := counter.(indexer)
+	if !ok {
+		return nil, nil, fmt.Errorf("the %s implementation does not support range queries", args[0])
+	}
+	return ix.Index(), args[2:], nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"IP-Addr-Counter/ipcounter/server"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// runServe runs the HTTP/JSON API until interrupted.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "127.0.0.1:8080", "`address` to listen on")
+	dataDir := fs.String("data", "ipcounter-data", "`directory` for persisted sets and uploads")
+	root := fs.String("root", "", "`directory` whose files may be counted by path (default: none)")
+	maxJobs := fs.Int("max-jobs", server.DefaultMaxJobs, "counts running at once; more are queued")
+	keepJobs := fs.Int("keep-jobs", server.DefaultKeepJobs, "finished jobs remembered; older ones are forgotten")
+	maxMemory := fs.String("max-memory", "8G", "memory `budget` for sets and running counts")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ip-addr-counter serve [flags]")
+		fmt.Fprintln(os.Stderr, "Serves counts and named IP sets over a local HTTP/JSON API.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	maxBytes, err := parseSize(*maxMemory)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	s, err := server.New(server.Config{DataDir: *dataDir, PathRoot: *root, MaxJobs: *maxJobs, KeepJobs: *keepJobs, MaxBytes: maxBytes})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	httpServer := &http.Server{Addr: *addr, Handler: s.Handler()}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		httpServer.Shutdown(shutdown)
+	}()
+
+	fmt.Printf("Listening on http://%s\n", *addr)
+	if err := httpServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
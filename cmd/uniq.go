@@ -0,0 +1,44 @@
+package main
+
+import (
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// runUniq works as a streaming uniq for IPs: it writes each line of the input
+// to stdout the first time its IP is seen, without sorting the input first.
+func runUniq(args []string) {
+	fs := flag.NewFlagSet("uniq", flag.ExitOnError)
+	formatSpec := fs.String("format", "plain", "input `format`: plain, combined, json:KEY, csv:N[:SEP] or regex:EXPR")
+	ordered := fs.Bool("ordered", false, "keep global file order (default: order is kept within each chunk only)")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ip-addr-counter uniq [flags] [<filename>]")
+		fmt.Fprintln(os.Stderr, "Prints the first record of every IP; reads stdin if no file or - is given.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var in io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "" && name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to open file: %v\n", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		in = file
+	}
+
+	counter := concurrent.New()
+	if err := applyFormat(counter, *formatSpec); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if _, err := counter.Dedup(in, os.Stdout, *ordered); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
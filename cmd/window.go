@@ -0,0 +1,107 @@
+package main
+
+import (
+	"IP-Addr-Counter/ipcounter/format"
+	"IP-Addr-Counter/ipcounter/window"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// runWindow prints the distinct IPs of every tumbling or sliding time window
+// of a timestamped log.
+func runWindow(args []string) {
+	fs := flag.NewFlagSet("window", flag.ExitOnError)
+	formatSpec := fs.String("format", "combined", "input `format` of the IP field: plain, combined, json:KEY, csv:N[:SEP] or regex:EXPR")
+	timeSpec := fs.String("time", "", "timestamp `field`: clf, json:KEY, csv:N[:SEP] or regex:EXPR (default: clf for combined, the next column for csv)")
+	size := fs.Duration("size", 0, "window `length`, e.g. 1h (required)")
+	slide := fs.Duration("slide", 0, "step between sliding windows, e.g. 15m (default: tumbling windows)")
+	exact := fs.Bool("exact", false, "count exactly, even with fine buckets")
+	estimate := fs.Bool("estimate", false, "estimate with HyperLogLog, even with coarse buckets")
+	output := fs.String("output", "csv", "output `format`: csv or json")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: ip-addr-counter window [flags] -size DURATION [<filename>]")
+		fmt.Fprintln(os.Stderr, "Prints the distinct IPs per time window; reads stdin if no file or - is given.")
+		fmt.Fprintf(os.Stderr, "Buckets shorter than %v are estimated with HyperLogLog, longer ones counted exactly.\n", window.CoarseBucket)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	fail := func(err error) {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	if *size == 0 {
+		fail(fmt.Errorf("-size is required"))
+	}
+	if *exact && *estimate {
+		fail(fmt.Errorf("-exact and -estimate are mutually exclusive"))
+	}
+	if *output != "csv" && *output != "json" {
+		fail(fmt.Errorf("unknown output format: %s (formats: csv, json)", *output))
+	}
+
+	cfg := window.Config{Size: *size, Slide: *slide}
+	cfg.Exact = *exact || (!*estimate && cfg.Bucket() >= window.CoarseBucket)
+	ip, err := format.Parse(*formatSpec)
+	if err != nil {
+		fail(err)
+	}
+	stamp, err := timeField(*timeSpec, *formatSpec)
+	if err != nil {
+		fail(err)
+	}
+	counter, err := window.New(cfg, ip, stamp)
+	if err != nil {
+		fail(err)
+	}
+
+	var in io.Reader = os.Stdin
+	if name := fs.Arg(0); name != "" && name != "-" {
+		file, err := os.Open(name)
+		if err != nil {
+			fail(fmt.Errorf("failed to open file: %w", err))
+		}
+		defer file.Close()
+		in = file
+	}
+	if err := counter.Add(in); err != nil {
+		fail(err)
+	}
+	if n := counter.Invalid(); n > 0 {
+		fmt.Fprintf(os.Stderr, "Skipped %d records without a valid IP or timestamp\n", n)
+	}
+
+	rows := counter.Rows()
+	if *output == "json" {
+		err = window.WriteJSON(os.Stdout, rows)
+	} else {
+		err = window.WriteCSV(os.Stdout, rows)
+	}
+	if err != nil {
+		fail(fmt.Errorf("write error: %w", err))
+	}
+}
+
+// timeField returns the timestamp extractor for spec. Without a spec it is
+// derived from the IP format: the bracketed date of access logs, or the column
+// after the IP for CSV.
+func timeField(spec, ipSpec string) (format.Extractor, error) {
+	if spec == "clf" {
+		return window.CLF{}, nil
+	}
+	if spec != "" {
+		return format.Parse(spec)
+	}
+	if ipSpec == "combined" {
+		return window.CLF{}, nil
+	}
+	if ip, err := format.Parse(ipSpec); err == nil && strings.HasPrefix(ipSpec, "csv:") {
+		c := ip.(format.CSV)
+		c.Column++
+		return c, nil
+	}
+	return nil, fmt.Errorf("-time is required for the %s format", ipSpec)
+}
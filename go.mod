@@ -1,3 +1,5 @@
 module IP-Addr-Counter
 
 go 1.24.5
+
+require golang.org/x/sys v0.34.0
@@ -0,0 +1,124 @@
+/*
+Package adaptive provides an implementation for counting unique IPv4 addresses
+whose memory grows with the number of distinct IPs instead of being fixed.
+
+It reads the file in chunks with the concurrent worker pool. Workers parse
+their chunks in parallel and add the IPs, one batch per chunk, to an
+ipset.Adaptive: a sorted array of 4 bytes per IP that promotes itself to a
+512MB bitmap once that is smaller.
+
+Pros:
+  - Uses a few megabytes for inputs with a few hundred thousand distinct IPs,
+    where the bitset counters always take 512MB.
+  - Stops growing at the bitmap's 512MB, however many IPs there are (briefly
+    about 1GB while the array is converted).
+
+Cons:
+- Inserts are serialized, so it is slower than the sharded bitsets on large inputs.
+*/
+package adaptive
+
+import (
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/format"
+	"IP-Addr-Counter/ipcounter/ipset"
+	"IP-Addr-Counter/ipcounter/sysinfo"
+	"IP-Addr-Counter/ipcounter/utils"
+	"bytes"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// AdaptiveCounter counts unique IPs into an ipset.Adaptive.
+type AdaptiveCounter struct {
+	mu      sync.Mutex       // Guards set.
+	set     *ipset.Adaptive  // IPs seen so far.
+	extract format.Extractor // Pulls the IP field out of each line, nil for bare IPs.
+}
+
+// New returns an empty AdaptiveCounter.
+func New() *AdaptiveCounter {
+	return &AdaptiveCounter{set: ipset.NewAdaptive()}
+}
+
+// UseFormat makes subsequent counts read the IP from the field selected by e
+// instead of treating each line as a bare IP. A nil e restores bare IPs.
+func (a *AdaptiveCounter) UseFormat(e format.Extractor) {
+	a.extract = e
+}
+
+// CountUniqueIPs counts the unique IPv4 addresses of the file that were not
+// seen by a previous count.
+func (a *AdaptiveCounter) CountUniqueIPs(filename string) (int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	before := a.set.Count()
+	numWorkers := sysinfo.CPUs()
+	batches := make([][]uint32, numWorkers) // Per-worker parse buffers, reused across chunks.
+	err = concurrent.ProcessChunks(file, numWorkers, func(worker int, chunk []byte) {
+		batches[worker] = a.parseChunk(chunk, batches[worker][:0])
+		a.mu.Lock()
+		for _, ip := range batches[worker] {
+			a.set.Add(ip)
+		}
+		a.mu.Unlock()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return a.set.Count() - before, nil
+}
+
+// parseChunk appends the IP of every valid line of chunk to ips.
+func (a *AdaptiveCounter) parseChunk(chunk []byte, ips []uint32) []uint32 {
+	start := 0
+	for i, c := range chunk {
+		if c != '\n' {
+			continue
+		}
+		line := bytes.TrimSpace(chunk[start:i])
+		start = i + 1
+		if a.extract != nil && len(line) > 0 {
+			line = a.extract.Extract(line)
+		}
+		if len(line) == 0 {
+			continue // Skip empty lines and records without an IP field.
+		}
+		if ip, err := utils.ParseIPv4(line); err == nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips
+}
+
+// Contains reports whether ip was seen by a previous count.
+func (a *AdaptiveCounter) Contains(ip uint32) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.set.Contains(ip)
+}
+
+// ContainsAll reports, for each of ips, whether it was seen by a previous count.
+func (a *AdaptiveCounter) ContainsAll(ips []uint32) []bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	found := make([]bool, len(ips))
+	for i, ip := range ips {
+		found[i] = a.set.Contains(ip)
+	}
+	return found
+}
+
+// Bitmap copies the seen IPs into a flat ipset.Bitmap.
+func (a *AdaptiveCounter) Bitmap() *ipset.Bitmap {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	m := ipset.NewBitmap()
+	a.set.ForEach(func(ip uint32) { m.Add(ip) })
+	return m
+}
@@ -0,0 +1,37 @@
+package adaptive
+
+import (
+	"IP-Addr-Counter/ipcounter/format"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCountUniqueIPs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ips.txt")
+	os.WriteFile(path, []byte("10.0.0.1\n\n 10.0.0.2 \nbad\n10.0.0.1\n255.255.255.255"), 0o644)
+
+	a := New()
+	count, err := a.CountUniqueIPs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("CountUniqueIPs() = %d, want 3", count)
+	}
+	if !a.Contains(0xFFFFFFFF) || a.Contains(0x0A000003) {
+		t.Errorf("Contains() disagrees with the input")
+	}
+	if n := a.Bitmap().Count(); n != 3 {
+		t.Errorf("Bitmap().Count() = %d, want 3", n)
+	}
+
+	// IPs seen before are not counted again.
+	csv := filepath.Join(t.TempDir(), "ips.csv")
+	os.WriteFile(csv, []byte("a,10.0.0.1\nb,10.0.0.9\nc,\n"), 0o644)
+	e, _ := format.Parse("csv:1")
+	a.UseFormat(e)
+	if count, err := a.CountUniqueIPs(csv); err != nil || count != 1 {
+		t.Errorf("second CountUniqueIPs() = %d, %v, want 1", count, err)
+	}
+}
@@ -6,5 +6,14 @@ package assembly
 //go:noescape
 func setBitAsmRaw(ptr uintptr, mask uint32) bool
 
+//go:noescape
+func orBitAsmRaw(ptr uintptr, mask uint32)
+
+//go:noescape
+func setBitsAsm(bitset []byte, rot uint, ips []uint32) (added int)
+
+//go:noescape
+func orBitsAsm(bitset []byte, rot uint, ips []uint32)
+
 //go:noescape
 func ParseIPv4AsmRaw(b []byte) (uint32, bool)
@@ -7,21 +7,34 @@ import (
 
 var errInvalidIP = errors.New("invalid IP")
 
-// setBit in Go (in assembly package)
-func setBitAsm(s *shard, offset uint32) bool {
+// setBitAsm atomically sets the bit at offset of bitset in assembly and reports
+// whether it was newly set.
+func setBitAsm(bitset []byte, offset uint32) bool {
 	byteIndex := offset / 8
 	bitIndex := offset % 8
 	mask := byte(1 << bitIndex)
 	wordIndex := byteIndex / 4
 	byteOffset := byteIndex % 4
 	wordMask := uint32(mask) << (byteOffset * 8)
-	ptr := uintptr(unsafe.Pointer(&s.bitset[0])) + uintptr(wordIndex)*4
+	ptr := uintptr(unsafe.Pointer(&bitset[0])) + uintptr(wordIndex)*4
 	return setBitAsmRaw(ptr, wordMask)
 }
 
-// ParseIPv4Asm parses an IPv4 address from a byte slice using assembly.
+// orBitAsm atomically sets the bit at offset of bitset in assembly, without
+// finding out whether it was set before.
+func orBitAsm(bitset []byte, offset uint32) {
+	ptr := uintptr(unsafe.Pointer(&bitset[offset/32*4]))
+	orBitAsmRaw(ptr, uint32(1)<<(offset%32))
+}
+
+// parseIPv4Asm parses an IPv4 address from a byte slice using assembly, with
+// the SIMD kernel when the CPU has one. The kernel loads 16 bytes at once, so
+// lines too short or long to hold an address are rejected first.
 func parseIPv4Asm(b []byte) (uint32, error) {
-	ip, ok := ParseIPv4AsmRaw(b)
+	if len(b) < 7 || len(b) > 15 {
+		return 0, errInvalidIP
+	}
+	ip, ok := parseField(b)
 	if !ok {
 		return 0, errInvalidIP
 	}
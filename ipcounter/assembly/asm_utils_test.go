@@ -1,6 +1,8 @@
 package assembly
 
 import (
+	"math/bits"
+	"math/rand"
 	"sync"
 	"testing"
 )
@@ -17,6 +19,12 @@ func TestParseIPv4Asm(t *testing.T) {
 		{"1.2.3.4", 0x01020304, false},
 		{"192.168.1.1", 0xC0A80101, false},
 		{"001.002.003.004", 0x01020304, false},
+		{"256.0.0.1", 0, true},
+		{"1.2.3", 0, true},
+		{"1..2.3", 0, true},
+		{"1.2.3.4.", 0, true},
+		{"1.2.3.0004", 0, true},
+		{"1.2.3.a", 0, true},
 	}
 
 	for _, tt := range tests {
@@ -31,41 +39,41 @@ func TestParseIPv4Asm(t *testing.T) {
 }
 
 func TestSetBitAsm(t *testing.T) {
-	// Initialize a shard with a small bitset (4 bytes = 32 bits)
-	s := &shard{bitset: make([]byte, 4)}
+	// Initialize a small bitset (4 bytes = 32 bits)
+	bitset := make([]byte, 4)
 
 	// Test case 1: Set a bit (offset 0, bit 0 in first byte)
 	offset := uint32(0) // First bit in first byte
-	got := setBitAsm(s, offset)
+	got := setBitAsm(bitset, offset)
 	if !got {
-		t.Errorf("setBitAsm(s, %d) = false, want true (bit should be set)", offset)
+		t.Errorf("setBitAsm(bitset, %d) = false, want true (bit should be set)", offset)
 	}
-	if s.bitset[0] != 0x01 {
-		t.Errorf("setBitAsm(s, %d) did not set bit correctly, got bitset[0] = 0x%02X, want 0x01", offset, s.bitset[0])
+	if bitset[0] != 0x01 {
+		t.Errorf("setBitAsm(bitset, %d) did not set bit correctly, got bitset[0] = 0x%02X, want 0x01", offset, bitset[0])
 	}
 
 	// Test case 2: Set the same bit again (should return false)
-	got = setBitAsm(s, offset)
+	got = setBitAsm(bitset, offset)
 	if got {
-		t.Errorf("setBitAsm(s, %d) = true, want false (bit already set)", offset)
+		t.Errorf("setBitAsm(bitset, %d) = true, want false (bit already set)", offset)
 	}
-	if s.bitset[0] != 0x01 {
-		t.Errorf("setBitAsm(s, %d) modified bitset incorrectly, got bitset[0] = 0x%02X, want 0x01", offset, s.bitset[0])
+	if bitset[0] != 0x01 {
+		t.Errorf("setBitAsm(bitset, %d) modified bitset incorrectly, got bitset[0] = 0x%02X, want 0x01", offset, bitset[0])
 	}
 
 	// Test case 3: Set a different bit (offset 9, bit 1 in second byte)
 	offset = uint32(9) // Bit 1 in second byte
-	got = setBitAsm(s, offset)
+	got = setBitAsm(bitset, offset)
 	if !got {
-		t.Errorf("setBitAsm(s, %d) = false, want true (bit should be set)", offset)
+		t.Errorf("setBitAsm(bitset, %d) = false, want true (bit should be set)", offset)
 	}
-	if s.bitset[1] != 0x02 {
-		t.Errorf("setBitAsm(s, %d) did not set bit correctly, got bitset[1] = 0x%02X, want 0x02", offset, s.bitset[1])
+	if bitset[1] != 0x02 {
+		t.Errorf("setBitAsm(bitset, %d) did not set bit correctly, got bitset[1] = 0x%02X, want 0x02", offset, bitset[1])
 	}
 
 	// Test case 4: Concurrent calls to setBitAsm to verify atomicity
-	s = &shard{bitset: make([]byte, 4)} // Reset bitset
-	offset = uint32(8)                  // Bit 0 in second byte
+	bitset = make([]byte, 4) // Reset bitset
+	offset = uint32(8)       // Bit 0 in second byte
 	var wg sync.WaitGroup
 	successCount := 0
 	mu := sync.Mutex{}
@@ -74,7 +82,7 @@ func TestSetBitAsm(t *testing.T) {
 	for i := 0; i < n; i++ {
 		go func() {
 			defer wg.Done()
-			if setBitAsm(s, offset) {
+			if setBitAsm(bitset, offset) {
 				mu.Lock()
 				successCount++
 				mu.Unlock()
@@ -83,9 +91,48 @@ func TestSetBitAsm(t *testing.T) {
 	}
 	wg.Wait()
 	if successCount != 1 {
-		t.Errorf("setBitAsm(s, %d) succeeded %d times, want 1 (atomic operation)", offset, successCount)
+		t.Errorf("setBitAsm(bitset, %d) succeeded %d times, want 1 (atomic operation)", offset, successCount)
 	}
-	if s.bitset[1] != 0x01 {
-		t.Errorf("setBitAsm(s, %d) did not set bit correctly, got bitset[1] = 0x%02X, want 0x01", offset, s.bitset[1])
+	if bitset[1] != 0x01 {
+		t.Errorf("setBitAsm(bitset, %d) did not set bit correctly, got bitset[1] = 0x%02X, want 0x01", offset, bitset[1])
+	}
+}
+
+func TestSetBitsAsm(t *testing.T) {
+	for _, rot := range []uint{0, 4} {
+		// IPs whose rotated offsets fall in a 1KB bitset, the bits of its first
+		// 8192 IPs with rot 0; with rot 4, of IPs that are multiples of 16.
+		rng := rand.New(rand.NewSource(1))
+		ips := make([]uint32, 3000) // Plenty of repeats, some within a batch.
+		for i := range ips {
+			ips[i] = bits.RotateLeft32(uint32(rng.Intn(8192)), int(rot))
+		}
+
+		exact, marked := make([]byte, 1024), make([]byte, 1024)
+		seen := make(map[uint32]bool)
+		for _, n := range []int{0, 1, 15, 17, 1000, 1967} {
+			batch := ips[:n]
+			ips = ips[n:]
+			want := 0
+			for _, ip := range batch {
+				if !seen[ip] {
+					seen[ip] = true
+					want++
+				}
+			}
+			if got := setBitsAsm(exact, rot, batch); got != want {
+				t.Errorf("rot %d: setBitsAsm() of %d IPs = %d, want %d", rot, n, got, want)
+			}
+			orBitsAsm(marked, rot, batch)
+		}
+		for offset := uint32(0); offset < 8192; offset++ {
+			ip := bits.RotateLeft32(offset, int(rot))
+			if got := exact[offset/8]&(1<<(offset%8)) != 0; got != seen[ip] {
+				t.Errorf("rot %d: setBitsAsm: bit of %d is %v, want %v", rot, ip, got, seen[ip])
+			}
+			if got := marked[offset/8]&(1<<(offset%8)) != 0; got != seen[ip] {
+				t.Errorf("rot %d: orBitsAsm: bit of %d is %v, want %v", rot, ip, got, seen[ip])
+			}
+		}
 	}
 }
@@ -3,7 +3,13 @@ Package assembly provides an efficient implementation for counting unique IPv4 a
 It reads a file containing one IPv4 address per line, processes the file in chunks using
 multiple goroutines, and tracks uniqueness with a sharded bitset to minimize memory usage.
 Atomic operations ensure thread-safe bitset updates, eliminating lock contention. A sync.Pool
-reuses buffers to reduce memory allocation overhead.
+reuses buffers to reduce memory allocation overhead. The reader, worker pool and counter
+are the shared pipeline package; this package supplies the parser and the bit setting, both
+in assembly, over an ipset.Sharded bitset. Addresses are parsed with SSSE3 or AVX2 on
+amd64 and NEON on arm64 when the CPU has them, a batch of lines per call, and each
+batch is set in one more call that prefetches the bitset words ahead. With
+tuning.Deferred, bits are set with a locked OR instead of compare-and-swap and
+counted with a popcount at the end.
 
 Pros:
 - Memory-efficient due to bitset usage (512MB for 2^32 IPs, divided across shards).
@@ -18,167 +24,77 @@ Cons:
 package assembly
 
 import (
-	"bufio"
-	"bytes"
-	"fmt"
-	"io"
-	"os"
-	"runtime"
-	"sync"
+	"IP-Addr-Counter/ipcounter/ipset"
+	"IP-Addr-Counter/ipcounter/pipeline"
+	"IP-Addr-Counter/ipcounter/tuning"
 )
 
-// Constants defining configuration for the concurrent implementation.
-const (
-	maxIPv4       = 1 << 32          // Total number of possible IPv4 addresses (2^32).
-	bytesPerChunk = 16 * 1024 * 1024 // Size of each file chunk (16MB) for reading.
-	chunkQueueLen = 128              // Buffered channel size for chunk processing.
-	numShards     = 16384            // Number of shards to distribute IP addresses.
-)
-
-// shard represents a portion of the bitset for storing unique IPs.
-type shard struct {
-	bitset []byte // Bitset for storing unique IPs in this shard, updated atomically.
-}
+// bitsetBytes is the memory of the bitset over the whole IPv4 space.
+const bitsetBytes = 1 << 32 / 8
 
-// BitsetCounter manages a sharded bitset for counting unique IPs.
+// BitsetCounter manages a sharded bitset for counting unique IPs. Counts,
+// queries, formats, heavy hitters and checkpoints are those of the embedded
+// pipeline.Counter.
 type BitsetCounter struct {
-	shards []*shard // Array of shards, each covering a subset of the IP space.
+	*pipeline.Counter
 }
 
-// New initializes a BitsetCounter with pre-allocated shards.
-func New() *BitsetCounter {
-	// Calculate size of each shard's bitset (2^32 bits / 8 / numShards).
-	shardSize := maxIPv4 / 8 / numShards
-	shards := make([]*shard, numShards)
-	for i := 0; i < numShards; i++ {
-		shards[i] = &shard{
-			bitset: make([]byte, shardSize), // Allocate bitset for this shard.
-		}
-	}
-	return &BitsetCounter{shards: shards}
+// asmSet is an ipset.Sharded whose bits are set in assembly.
+type asmSet struct {
+	*ipset.Sharded
 }
 
-// CountUniqueIPs counts unique IPv4 addresses in the specified file.
-// It reads the file in chunks, processes them concurrently using multiple goroutines,
-// and aggregates the count of unique IPs using a sharded bitset with atomic updates.
-func (b *BitsetCounter) CountUniqueIPs(filename string) (int64, error) {
-	// Open the input file for reading.
-	file, err := os.Open(filename)
-	if err != nil {
-		return 0, fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
-
-	// Create a buffered reader for efficient file reading.
-	reader := bufio.NewReader(file)
+// Add atomically marks ip as seen and reports whether it was new.
+func (s asmSet) Add(ip uint32) bool {
+	bitset, offset := s.Locate(ip)
+	return setBitAsm(bitset, offset)
+}
 
-	// Channels for distributing chunks to workers and collecting results.
-	chunkChan := make(chan []byte, chunkQueueLen)
-	resultChan := make(chan int64, chunkQueueLen)
+// AddBatch atomically marks ips as seen in one call to assembly, prefetching
+// the bitset words ahead of the ones being set, and returns how many were new.
+func (s asmSet) AddBatch(ips []uint32) int64 {
+	bitset, rot := s.Layout()
+	return int64(setBitsAsm(bitset, rot, ips))
+}
 
-	// Initialize a sync.Pool to reuse chunk buffers and reduce allocations.
-	bufPool := sync.Pool{
-		New: func() interface{} {
-			return make([]byte, bytesPerChunk)
-		},
-	}
+// Mark atomically marks ip as seen in assembly, with a locked OR instead of a
+// compare-and-swap.
+func (s asmSet) Mark(ip uint32) {
+	bitset, offset := s.Locate(ip)
+	orBitAsm(bitset, offset)
+}
 
-	// Set number of workers to CPU core count for optimal parallelism.
-	numWorkers := runtime.NumCPU()
-	//runtime.GOMAXPROCS(numWorkers)
-
-	// Start worker goroutines to process chunks concurrently.
-	var wg sync.WaitGroup
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for chunk := range chunkChan {
-				// Process chunk and count unique IPs.
-				count := processChunk(chunk, b)
-				resultChan <- count
-				// Return buffer to pool for reuse.
-				bufPool.Put(chunk)
-			}
-		}()
-	}
+// MarkBatch atomically marks ips as seen in one call to assembly.
+func (s asmSet) MarkBatch(ips []uint32) {
+	bitset, rot := s.Layout()
+	orBitsAsm(bitset, rot, ips)
+}
 
-	// Start a goroutine to aggregate results from workers.
-	var resultWg sync.WaitGroup
-	resultWg.Add(1)
-	var total int64
-	go func() {
-		defer resultWg.Done()
-		for c := range resultChan {
-			total += c // Sum unique IP counts from all chunks.
-		}
-	}()
-
-	// Read file in chunks and distribute to workers.
-	for {
-		// Get a buffer from the pool.
-		buf := bufPool.Get().([]byte)
-		n, err := io.ReadFull(reader, buf)
-		if err == io.EOF || err == io.ErrUnexpectedEOF {
-			if n > 0 {
-				// Read until newline to avoid splitting IP addresses.
-				rem, _ := reader.ReadBytes('\n')
-				buf = append(buf[:n], rem...)
-				chunkChan <- buf // Send chunk to workers without copying.
-			} else {
-				bufPool.Put(buf) // Return unused buffer.
-			}
-			break
-		}
-		if err != nil {
-			bufPool.Put(buf)
-			return 0, fmt.Errorf("read error: %w", err)
-		}
-
-		// Extend chunk to include complete IP addresses (until newline).
-		rem, _ := reader.ReadBytes('\n')
-		if len(rem) > 0 {
-			buf = append(buf[:n], rem...)
-		} else {
-			buf = buf[:n]
-		}
-
-		// Send chunk to workers without copying to avoid allocations.
-		chunkChan <- buf
-	}
+// asmParser parses bare IPs in assembly, a field or a run of lines at a time.
+type asmParser struct{}
 
-	// Close channels and wait for workers to finish.
-	close(chunkChan)
-	wg.Wait()
-	close(resultChan)
-	resultWg.Wait()
+// Parse parses one field.
+func (asmParser) Parse(field []byte) (uint32, bool) {
+	ip, err := parseIPv4Asm(field)
+	return ip, err == nil
+}
 
-	return total, nil
+// ParseLines parses lines with the widest SIMD kernel the CPU supports.
+func (asmParser) ParseLines(buf []byte, ips []uint32) (n, used int) {
+	return parseLines(buf, ips)
 }
 
-// processChunk processes a chunk of the input file, parsing IPv4 addresses
-// and updating the sharded bitset to count unique IPs using atomic operations.
-// Returns the number of new unique IPs found in the chunk.
-// processChunk processes a chunk of the input file, parsing IPv4 addresses
-// and updating the sharded bitset to count unique IPs using atomic operations.
-// Returns the number of new unique IPs found in the chunk.
-func processChunk(chunk []byte, b *BitsetCounter) int64 {
-	var count int64
-	start := 0
-	for {
-		i := bytes.IndexByte(chunk[start:], '\n')
-		if i == -1 {
-			break
-		}
-		line := chunk[start : start+i]
-		start += i + 1
-		ipInt, _ := parseIPv4Asm(line)
-		shardIdx := ipInt % numShards
-		s := b.shards[shardIdx]
-		offset := ipInt / numShards
-		if setBitAsm(s, offset) {
-			count++
-		}
+// parser is the parser of every count.
+var parser asmParser
+
+// New initializes a BitsetCounter with pre-allocated shards, using the default
+// settings changed by opts. It panics if the settings are invalid; validate
+// user input with tuning.New first.
+func New(opts ...tuning.Option) *BitsetCounter {
+	o, err := tuning.New(opts...)
+	if err != nil {
+		panic("assembly: " + err.Error())
 	}
-	return count
+	set := ipset.NewShardedIn(o.Shards, o.Layout, o.Alloc(bitsetBytes))
+	return &BitsetCounter{pipeline.NewCounter(asmSet{set}, parser, o)}
 }
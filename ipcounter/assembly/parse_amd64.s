@@ -1,163 +1,59 @@
 #include "textflag.h"
 
 // func ParseIPv4AsmRaw(b []byte) (uint32, bool)
-TEXT ·ParseIPv4AsmRaw(SB), NOSPLIT, $0-33
-	MOVQ b+0(FP), DI   // ptr
-	MOVQ b+8(FP), DX   // len
-	MOVQ $0, CX        // ip
-	MOVQ $0, R8        // part
-	MOVQ $10, R9       // const 10
-	MOVQ $'0', R10     // '0'
-	MOVQ $'9', R11     // '9' (for cmp)
-
-	// Octet 1
-	MOVBLZX (DI), R12
-	INCQ DI
-	DECQ DX
-	SUBQ R10, R12
-	MOVQ R12, R8
-
-	MOVBLZX (DI), R12
-	SUBQ R10, R12
-	CMPQ R12, $0
-	JL dot1
-	CMPQ R12, $9
-	JG dot1
-	IMULQ R9, R8
-	ADDQ R12, R8
-	INCQ DI
-	DECQ DX
-
-	MOVBLZX (DI), R12
-	SUBQ R10, R12
-	CMPQ R12, $0
-	JL dot1
-	CMPQ R12, $9
-	JG dot1
-	IMULQ R9, R8
-	ADDQ R12, R8
-	INCQ DI
-	DECQ DX
-
-dot1:
-	INCQ DI
-	DECQ DX
-	MOVQ R8, CX
-	MOVQ $0, R8
-
-	// Octet 2
-	MOVBLZX (DI), R12
-	INCQ DI
-	DECQ DX
-	SUBQ R10, R12
-	MOVQ R12, R8
-
-	MOVBLZX (DI), R12
-	SUBQ R10, R12
-	CMPQ R12, $0
-	JL dot2
-	CMPQ R12, $9
-	JG dot2
-	IMULQ R9, R8
-	ADDQ R12, R8
-	INCQ DI
-	DECQ DX
-
-	MOVBLZX (DI), R12
-	SUBQ R10, R12
-	CMPQ R12, $0
-	JL dot2
-	CMPQ R12, $9
-	JG dot2
-	IMULQ R9, R8
-	ADDQ R12, R8
-	INCQ DI
-	DECQ DX
-
-dot2:
-	INCQ DI
-	DECQ DX
-	SHLQ $8, CX
-	ORQ R8, CX
-	MOVQ $0, R8
-
-	// Octet 3
-	MOVBLZX (DI), R12
-	INCQ DI
-	DECQ DX
-	SUBQ R10, R12
-	MOVQ R12, R8
-
-	MOVBLZX (DI), R12
-	SUBQ R10, R12
-	CMPQ R12, $0
-	JL dot3
-	CMPQ R12, $9
-	JG dot3
-	IMULQ R9, R8
-	ADDQ R12, R8
-	INCQ DI
-	DECQ DX
-
-	MOVBLZX (DI), R12
-	SUBQ R10, R12
-	CMPQ R12, $0
-	JL dot3
-	CMPQ R12, $9
-	JG dot3
-	IMULQ R9, R8
-	ADDQ R12, R8
-	INCQ DI
-	DECQ DX
-
-dot3:
-	INCQ DI
-	DECQ DX
-	SHLQ $8, CX
-	ORQ R8, CX
-	MOVQ $0, R8
-
-	// Octet 4
-	MOVBLZX (DI), R12
-	INCQ DI
-	DECQ DX
-	SUBQ R10, R12
-	MOVQ R12, R8
-
-	CMPQ DX, $0
-	JE finish
-	MOVBLZX (DI), R12
-	SUBQ R10, R12
-	CMPQ R12, $0
-	JL finish
-	CMPQ R12, $9
-	JG finish
-	IMULQ R9, R8
-	ADDQ R12, R8
-	INCQ DI
-	DECQ DX
-
-	CMPQ DX, $0
-	JE finish
-	MOVBLZX (DI), R12
-	SUBQ R10, R12
-	CMPQ R12, $0
-	JL finish
-	CMPQ R12, $9
-	JG finish
-	IMULQ R9, R8
-	ADDQ R12, R8
-	INCQ DI
-	DECQ DX
-
-finish:
-	SHLQ $8, CX
-	ORQ R8, CX
+TEXT ·ParseIPv4AsmRaw(SB), NOSPLIT, $0-29
+	MOVQ b_base+0(FP), SI   // SI = next byte
+	MOVQ b_len+8(FP), DX
+	LEAQ (SI)(DX*1), DI     // DI = end of b
+	XORL CX, CX             // CX = ip
+	MOVL $4, R8             // R8 = octets left
+
+octet:
+	// First digit: required.
+	CMPQ SI, DI
+	JAE invalid
+	MOVBLZX (SI), AX
+	SUBL $'0', AX
+	CMPL AX, $9
+	JHI invalid             // Unsigned, so below '0' is caught too.
+	INCQ SI
+	MOVL $2, R9             // R9 = more digits allowed
+
+digit:
+	CMPQ SI, DI
+	JAE octet_end
+	MOVBLZX (SI), BX
+	SUBL $'0', BX
+	CMPL BX, $9
+	JHI octet_end
+	IMUL3L $10, AX, AX
+	ADDL BX, AX
+	INCQ SI
+	DECL R9
+	JNZ digit
+
+octet_end:
+	CMPL AX, $255
+	JHI invalid
+	SHLL $8, CX
+	ORL AX, CX
+	DECL R8
+	JZ last
+	CMPQ SI, DI             // A dot must follow the first three octets.
+	JAE invalid
+	CMPB (SI), $'.'
+	JNE invalid
+	INCQ SI
+	JMP octet
+
+last:
+	CMPQ SI, DI             // Nothing may follow the last one.
+	JNE invalid
 	MOVL CX, ret+24(FP)
-	MOVB $1, ret+28(FP)
+	MOVB $1, ret1+28(FP)
 	RET
 
 invalid:
 	MOVL $0, ret+24(FP)
-	MOVB $0, ret+28(FP)
+	MOVB $0, ret1+28(FP)
 	RET
@@ -1,149 +1,62 @@
 #include "textflag.h"
 
 // func ParseIPv4AsmRaw(b []byte) (uint32, bool)
-TEXT ·ParseIPv4AsmRaw(SB), NOSPLIT, $0-32
-    MOVD b+0(FP), R0  // b.ptr
-    MOVD b+8(FP), R1  // b.len
-    MOVD $0, R2       // ip = 0
-    MOVD $0, R3       // part = 0
-    MOVD $10, R6      // constant 10
-    MOVD $255, R7     // max octet (unused, assume <=255)
-    MOVD $'.', R8     // '.' (unused, assume dot)
-    MOVD $'0', R9     // '0'
-    MOVD $'9', R10    // '9' (unused, but for CMP)
+TEXT ·ParseIPv4AsmRaw(SB), NOSPLIT, $0-29
+    MOVD b_base+0(FP), R0  // R0 = next byte
+    MOVD b_len+8(FP), R1
+    ADD R0, R1, R1         // R1 = end of b
+    MOVD $0, R2            // R2 = ip
+    MOVD $4, R3            // R3 = octets left
+    MOVD $10, R6           // constant 10
 
-// Octet 1
-    MOVBU (R0), R5
-    SUB R9, R5, R3
-    ADD $1, R0
-    SUB $1, R1
-    MOVBU (R0), R5
-    SUB R9, R5, R14
-    CMP $0, R14
-    BLT dot1
-    CMP $9, R14
-    BHI dot1
-    MUL R6, R3, R3
-    ADD R14, R3
-    ADD $1, R0
-    SUB $1, R1
-    MOVBU (R0), R5
-    SUB R9, R5, R14
-    CMP $0, R14
-    BLT dot1
-    CMP $9, R14
-    BHI dot1
-    MUL R6, R3, R3
-    ADD R14, R3
-    ADD $1, R0
-    SUB $1, R1
-dot1:
-    ADD $1, R0
-    SUB $1, R1
-    MOVD R3, R2
-    MOVD $0, R3
+octet:
+    // First digit: required.
+    CMP R1, R0
+    BHS invalid
+    MOVBU (R0), R4
+    SUB $'0', R4, R4
+    CMP $9, R4
+    BHI invalid            // Unsigned, so below '0' is caught too.
+    ADD $1, R0
+    MOVD $2, R7            // R7 = more digits allowed
 
-// Octet 2
-    MOVBU (R0), R5
-    SUB R9, R5, R3
-    ADD $1, R0
-    SUB $1, R1
-    MOVBU (R0), R5
-    SUB R9, R5, R14
-    CMP $0, R14
-    BLT dot2
-    CMP $9, R14
-    BHI dot2
-    MUL R6, R3, R3
-    ADD R14, R3
-    ADD $1, R0
-    SUB $1, R1
-    MOVBU (R0), R5
-    SUB R9, R5, R14
-    CMP $0, R14
-    BLT dot2
-    CMP $9, R14
-    BHI dot2
-    MUL R6, R3, R3
-    ADD R14, R3
-    ADD $1, R0
-    SUB $1, R1
-dot2:
-    ADD $1, R0
-    SUB $1, R1
-    LSL $8, R2, R2
-    ORR R3, R2, R2
-    MOVD $0, R3
+digit:
+    CMP R1, R0
+    BHS octet_end
+    MOVBU (R0), R5
+    SUB $'0', R5, R5
+    CMP $9, R5
+    BHI octet_end
+    MUL R6, R4, R4
+    ADD R5, R4
+    ADD $1, R0
+    SUB $1, R7
+    CBNZ R7, digit
 
-// Octet 3
-    MOVBU (R0), R5
-    SUB R9, R5, R3
-    ADD $1, R0
-    SUB $1, R1
-    MOVBU (R0), R5
-    SUB R9, R5, R14
-    CMP $0, R14
-    BLT dot3
-    CMP $9, R14
-    BHI dot3
-    MUL R6, R3, R3
-    ADD R14, R3
-    ADD $1, R0
-    SUB $1, R1
-    MOVBU (R0), R5
-    SUB R9, R5, R14
-    CMP $0, R14
-    BLT dot3
-    CMP $9, R14
-    BHI dot3
-    MUL R6, R3, R3
-    ADD R14, R3
-    ADD $1, R0
-    SUB $1, R1
-dot3:
-    ADD $1, R0
-    SUB $1, R1
+octet_end:
+    CMP $255, R4
+    BHI invalid
     LSL $8, R2, R2
-    ORR R3, R2, R2
-    MOVD $0, R3
-
-// Octet 4
-    MOVBU (R0), R5
-    SUB R9, R5, R3
-    ADD $1, R0
-    SUB $1, R1
-    CBZ R1, finish
+    ORR R4, R2, R2
+    SUB $1, R3
+    CBZ R3, last
+    CMP R1, R0             // A dot must follow the first three octets.
+    BHS invalid
     MOVBU (R0), R5
-    SUB R9, R5, R14
-    CMP $0, R14
-    BLT finish
-    CMP $9, R14
-    BHI finish
-    MUL R6, R3, R3
-    ADD R14, R3
+    CMP $'.', R5
+    BNE invalid
     ADD $1, R0
-    SUB $1, R1
-    CBZ R1, finish
-    MOVBU (R0), R5
-    SUB R9, R5, R14
-    CMP $0, R14
-    BLT finish
-    CMP $9, R14
-    BHI finish
-    MUL R6, R3, R3
-    ADD R14, R3
-    ADD $1, R0
-    SUB $1, R1
+    B octet
 
-finish:
-    LSL $8, R2, R2
-    ORR R3, R2, R2
+last:
+    CMP R1, R0             // Nothing may follow the last one.
+    BNE invalid
     MOVW R2, ret+24(FP)
     MOVD $1, R5
-    MOVB R5, ret+28(FP)
+    MOVB R5, ret1+28(FP)
     RET
 
 invalid:
     MOVW ZR, ret+24(FP)
-    MOVB ZR, ret+28(FP)
+    MOVB ZR, ret1+28(FP)
     RET
@@ -20,3 +20,89 @@ loop:
 already_set:
     MOVB $0, ret+16(FP)   // Return false
     RET
+
+// func orBitAsmRaw(ptr uintptr, mask uint32)
+TEXT ·orBitAsmRaw(SB), NOSPLIT, $0-12
+    MOVQ ptr+0(FP), DI    // DI = ptr
+    MOVL mask+8(FP), BX   // BX = mask
+    LOCK                  // Atomic operation
+    ORL BX, 0(DI)         // Set the bit, whatever it was
+    RET
+
+// PREFETCH_AHEAD is how many IPs ahead of the one being set the batch kernels
+// prefetch the bitset word of, enough to cover a cache miss.
+#define PREFETCH_AHEAD 16
+
+// WORD_ADDR sets addr to the address of ip's 32-bit bitset word and ip to its
+// bit in the word. SI holds the bitset and CX the rotation of its layout.
+#define WORD_ADDR(ip, addr, t) \
+    RORL CX, ip                \
+    MOVL ip, t                 \
+    SHRL $5, t                 \
+    LEAQ (SI)(t*4), addr       \
+    ANDL $31, ip
+
+// PREFETCH_NEXT prefetches the word of the IP PREFETCH_AHEAD after the next,
+// if there is one.
+#define PREFETCH_NEXT(skip)           \
+    LEAQ PREFETCH_AHEAD(R9), R12      \
+    CMPQ R12, R8                      \
+    JAE skip                          \
+    MOVL (DI)(R12*4), AX              \
+    WORD_ADDR(AX, BX, DX)             \
+    PREFETCHT0 (BX)
+
+// func setBitsAsm(bitset []byte, rot uint, ips []uint32) (added int)
+TEXT ·setBitsAsm(SB), NOSPLIT, $0-64
+    MOVQ bitset_base+0(FP), SI  // SI = bitset
+    MOVQ rot+24(FP), CX         // CX = rotation of the layout
+    MOVQ ips_base+32(FP), DI    // DI = ips
+    MOVQ ips_len+40(FP), R8     // R8 = len(ips)
+    XORQ R9, R9                 // R9 = index of the next IP
+    XORQ R10, R10               // R10 = bits newly set
+
+set_loop:
+    CMPQ R9, R8
+    JAE set_done
+    PREFETCH_NEXT(set_bit)
+
+set_bit:
+    MOVL (DI)(R9*4), AX
+    WORD_ADDR(AX, BX, DX)
+    INCQ R9
+    MOVL (BX), DX         // Check first, so duplicates do not lock the line
+    BTL AX, DX
+    JCS set_loop
+    LOCK                  // Atomic test-and-set
+    BTSL AX, (BX)
+    JCS set_loop          // Set by another goroutine meanwhile
+    INCQ R10
+    JMP set_loop
+
+set_done:
+    MOVQ R10, added+56(FP)
+    RET
+
+// func orBitsAsm(bitset []byte, rot uint, ips []uint32)
+TEXT ·orBitsAsm(SB), NOSPLIT, $0-56
+    MOVQ bitset_base+0(FP), SI  // SI = bitset
+    MOVQ rot+24(FP), CX         // CX = rotation of the layout
+    MOVQ ips_base+32(FP), DI    // DI = ips
+    MOVQ ips_len+40(FP), R8     // R8 = len(ips)
+    XORQ R9, R9                 // R9 = index of the next IP
+
+or_loop:
+    CMPQ R9, R8
+    JAE or_done
+    PREFETCH_NEXT(or_bit)
+
+or_bit:
+    MOVL (DI)(R9*4), AX
+    WORD_ADDR(AX, BX, DX)
+    INCQ R9
+    LOCK                  // Set the bit, whatever it was
+    BTSL AX, (BX)
+    JMP or_loop
+
+or_done:
+    RET
@@ -19,3 +19,105 @@ loop:
 already_set:
     MOVB ZR, ret+16(FP)
     RET
+
+// func orBitAsmRaw(ptr uintptr, mask uint32)
+TEXT ·orBitAsmRaw(SB), NOSPLIT, $0-12
+    MOVD ptr+0(FP), R0
+    MOVW mask+8(FP), R1
+
+or_loop:
+    LDXRW (R0), R2      // Load exclusive 32-bit
+    ORRW R1, R2, R2     // Set bit, whatever it was
+    STXRW R2, (R0), R3  // Store exclusive, status in R3
+    CBNZ R3, or_loop    // Retry if failed
+    RET
+
+// PREFETCH_AHEAD is how many IPs ahead of the one being set the batch kernels
+// prefetch the bitset word of, enough to cover a cache miss.
+#define PREFETCH_AHEAD 16
+
+// WORD_ADDR sets addr to the address of ip's 32-bit bitset word and ip to its
+// bit in the word. R0 holds the bitset and R1 the rotation of its layout.
+#define WORD_ADDR(ip, addr, t) \
+    RORW R1, ip, ip            \
+    LSR $5, ip, t              \
+    ADD t<<2, R0, addr         \
+    AND $31, ip, ip
+
+// PREFETCH_NEXT prefetches the word of the IP PREFETCH_AHEAD after the next,
+// if there is one, for a store.
+#define PREFETCH_NEXT(skip)        \
+    ADD $PREFETCH_AHEAD, R5, R7    \
+    CMP R3, R7                     \
+    BHS skip                       \
+    MOVWU (R2)(R7<<2), R8          \
+    WORD_ADDR(R8, R9, R10)         \
+    PRFM (R9), PSTL1KEEP
+
+// func setBitsAsm(bitset []byte, rot uint, ips []uint32) (added int)
+TEXT ·setBitsAsm(SB), NOSPLIT, $0-64
+    MOVD bitset_base+0(FP), R0  // R0 = bitset
+    MOVD rot+24(FP), R1         // R1 = rotation of the layout
+    MOVD ips_base+32(FP), R2    // R2 = ips
+    MOVD ips_len+40(FP), R3     // R3 = len(ips)
+    MOVD ZR, R5                 // R5 = index of the next IP
+    MOVD ZR, R6                 // R6 = bits newly set
+
+set_loop:
+    CMP R3, R5
+    BHS set_done
+    PREFETCH_NEXT(set_bit)
+
+set_bit:
+    MOVWU (R2)(R5<<2), R8
+    WORD_ADDR(R8, R9, R10)
+    ADD $1, R5
+    MOVD $1, R11
+    LSLW R8, R11, R11       // R11 = mask of the bit
+    MOVWU (R9), R12         // Check first, so duplicates do not take the line
+    TSTW R11, R12
+    BNE set_loop
+
+set_cas:
+    LDXRW (R9), R12         // Load exclusive 32-bit
+    TSTW R11, R12           // Set by another goroutine meanwhile?
+    BNE set_loop
+    ORRW R11, R12, R12
+    STXRW R12, (R9), R13    // Store exclusive, status in R13
+    CBNZ R13, set_cas       // Retry if failed
+    ADD $1, R6
+    B set_loop
+
+set_done:
+    MOVD R6, added+56(FP)
+    RET
+
+// func orBitsAsm(bitset []byte, rot uint, ips []uint32)
+TEXT ·orBitsAsm(SB), NOSPLIT, $0-56
+    MOVD bitset_base+0(FP), R0  // R0 = bitset
+    MOVD rot+24(FP), R1         // R1 = rotation of the layout
+    MOVD ips_base+32(FP), R2    // R2 = ips
+    MOVD ips_len+40(FP), R3     // R3 = len(ips)
+    MOVD ZR, R5                 // R5 = index of the next IP
+
+or_batch_loop:
+    CMP R3, R5
+    BHS or_batch_done
+    PREFETCH_NEXT(or_bit)
+
+or_bit:
+    MOVWU (R2)(R5<<2), R8
+    WORD_ADDR(R8, R9, R10)
+    ADD $1, R5
+    MOVD $1, R11
+    LSLW R8, R11, R11       // R11 = mask of the bit
+
+or_cas:
+    LDXRW (R9), R12         // Load exclusive 32-bit
+    ORRW R11, R12, R12      // Set bit, whatever it was
+    STXRW R12, (R9), R13    // Store exclusive, status in R13
+    CBNZ R13, or_cas        // Retry if failed
+    B or_batch_loop
+
+or_batch_done:
+    RET
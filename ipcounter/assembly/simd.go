@@ -0,0 +1,70 @@
+package assembly
+
+import "bytes"
+
+// Vectorized parsing of IPv4 lines.
+//
+// A line of 7 to 15 bytes fits in a 16-byte register. Comparing the register
+// against '\n', '.' and the digit range gives bit masks of where the line
+// ends, where its dots are and which bytes are digits, and the dots give the
+// length of each octet. The lengths pick one of 81 shuffles that move the
+// digits of each octet into a 4-byte group [hundreds, tens, ones, 0], and two
+// multiply-adds with the weights [100, 10, 1, 0] turn the groups into the four
+// octets. A line is accepted only if it is made of digits and three dots, each
+// octet has 1 to 3 digits and none is above 255: exactly the lines
+// utils.ParseIPv4 accepts.
+
+// Vector widths the kernels may use, from none to 32-byte registers.
+const (
+	scalar  = iota // Byte at a time, with ParseIPv4AsmRaw.
+	simd128        // 16-byte registers: SSSE3 on amd64, NEON on arm64.
+	simd256        // 32-byte registers, two lines at a time: AVX2 on amd64.
+)
+
+// simdLevel is the widest vector width the CPU supports, set by the
+// architecture's init. Tests lower it to cover the narrower kernels.
+var simdLevel = scalar
+
+// lineShuffles holds the shuffle of every combination of octet lengths, at
+// index ((l1-1)*3+l2-1)*3+l3-1)*3+l4-1. Byte 4i+j of the result takes digit j
+// of the 3-digit, zero-padded octet i; the index 0x80 yields a zero byte.
+var lineShuffles = func() (t [81][16]byte) {
+	for k := range t {
+		lens := [4]int{k / 27 % 3, k / 9 % 3, k / 3 % 3, k % 3}
+		start := 0
+		for i, l := range lens {
+			l++
+			for j := 0; j < 4; j++ {
+				t[k][4*i+j] = 0x80
+				if pad := 3 - l; j >= pad && j < 3 {
+					t[k][4*i+j] = byte(start + j - pad)
+				}
+			}
+			start += l + 1 // The octet and its dot.
+		}
+	}
+	return t
+}()
+
+// parseLinesGeneric parses newline-terminated lines from the start of buf
+// into ips, as long as each is a bare address and ips has room. It returns the
+// number of addresses written and the bytes of buf they took. It is the
+// scalar fallback of parseLines, which has the same contract except that it
+// may stop up to 32 bytes before the end of buf, where its loads would run
+// past it. Callers handle the line at used another way and call again.
+func parseLinesGeneric(buf []byte, ips []uint32) (n, used int) {
+	for n < len(ips) {
+		i := bytes.IndexByte(buf[used:], '\n')
+		if i < 0 {
+			break
+		}
+		ip, ok := ParseIPv4AsmRaw(buf[used : used+i])
+		if !ok {
+			break
+		}
+		ips[n] = ip
+		n++
+		used += i + 1
+	}
+	return n, used
+}
@@ -0,0 +1,51 @@
+//go:build amd64
+// +build amd64
+
+package assembly
+
+import "golang.org/x/sys/cpu"
+
+func init() {
+	switch {
+	case cpu.X86.HasAVX2 && cpu.X86.HasSSSE3:
+		simdLevel = simd256
+	case cpu.X86.HasSSSE3:
+		simdLevel = simd128
+	}
+}
+
+// parseField parses a field of 7 to 15 bytes with the widest kernel the CPU
+// supports.
+func parseField(b []byte) (uint32, bool) {
+	if simdLevel == scalar {
+		return ParseIPv4AsmRaw(b)
+	}
+	if cap(b) >= 16 {
+		return parseFieldSSE(&b[0], len(b)) // The bytes past len are read, then masked out.
+	}
+	var w [16]byte
+	copy(w[:], b)
+	return parseFieldSSE(&w[0], len(b))
+}
+
+// parseLines is parseLinesGeneric with the widest kernel the CPU supports:
+// AVX2 takes two lines per iteration, and SSSE3 the lines it leaves.
+func parseLines(buf []byte, ips []uint32) (n, used int) {
+	if simdLevel == scalar {
+		return parseLinesGeneric(buf, ips)
+	}
+	if simdLevel == simd256 {
+		n, used = parseLinesAVX2(buf, ips)
+	}
+	m, u := parseLinesSSE(buf[used:], ips[n:])
+	return n + m, used + u
+}
+
+//go:noescape
+func parseFieldSSE(p *byte, length int) (ip uint32, ok bool)
+
+//go:noescape
+func parseLinesSSE(buf []byte, ips []uint32) (n, used int)
+
+//go:noescape
+func parseLinesAVX2(buf []byte, ips []uint32) (n, used int)
@@ -0,0 +1,251 @@
+#include "textflag.h"
+
+// Byte patterns the kernels broadcast to every lane.
+DATA lineConsts<>+0(SB)/4, $0x0a0a0a0a  // '\n'
+DATA lineConsts<>+4(SB)/4, $0x2e2e2e2e  // '.'
+DATA lineConsts<>+8(SB)/4, $0x30303030  // '0'
+DATA lineConsts<>+12(SB)/4, $0x09090909 // Largest digit value
+DATA lineConsts<>+16(SB)/4, $0x00010a64 // Weights 100, 10, 1, 0
+DATA lineConsts<>+20(SB)/4, $0x00010001 // Word pairs, summed
+DATA lineConsts<>+24(SB)/4, $0x000000ff // Largest octet
+DATA lineConsts<>+28(SB)/4, $0x0004080c // Octet bytes, last first
+GLOBL lineConsts<>(SB), RODATA|NOPTR, $32
+
+// CHECK_LINE validates the line of CX bytes whose dot mask is in dots and
+// whose digit-or-dot mask is in ok, and leaves the offset of its shuffle in
+// ·lineShuffles in idx. It jumps to reject unless the line holds only digits
+// and three dots, with octets of 1 to 3 digits. dots, ok, t1 and t2 are
+// clobbered.
+#define CHECK_LINE(dots, ok, idx, t1, t2, reject) \
+	MOVL $1, t1; \
+	SHLL CX, t1; \
+	DECL t1; \
+	ANDL t1, ok; \
+	CMPL ok, t1; \
+	JNE reject; \
+	ANDL t1, dots; \
+	BSFL dots, t1; \
+	JZ reject; \
+	LEAL -1(t1), idx; \
+	CMPL idx, $2; \
+	JHI reject; \
+	LEAL -1(dots), ok; \
+	ANDL ok, dots; \
+	BSFL dots, t2; \
+	JZ reject; \
+	MOVL t2, ok; \
+	SUBL t1, ok; \
+	SUBL $2, ok; \
+	CMPL ok, $2; \
+	JHI reject; \
+	LEAL (idx)(idx*2), idx; \
+	ADDL ok, idx; \
+	LEAL -1(dots), ok; \
+	ANDL ok, dots; \
+	BSFL dots, t1; \
+	JZ reject; \
+	MOVL t1, ok; \
+	SUBL t2, ok; \
+	SUBL $2, ok; \
+	CMPL ok, $2; \
+	JHI reject; \
+	LEAL (idx)(idx*2), idx; \
+	ADDL ok, idx; \
+	LEAL -1(dots), ok; \
+	ANDL ok, dots; \
+	JNZ reject; \
+	MOVL CX, ok; \
+	SUBL t1, ok; \
+	SUBL $2, ok; \
+	CMPL ok, $2; \
+	JHI reject; \
+	LEAL (idx)(idx*2), idx; \
+	ADDL ok, idx; \
+	SHLL $4, idx
+
+// SSE_CONSTS loads the constants of the 16-byte kernels into X7-X14.
+#define SSE_CONSTS \
+	MOVSS lineConsts<>+0(SB), X8; \
+	PSHUFL $0, X8, X8; \
+	MOVSS lineConsts<>+4(SB), X9; \
+	PSHUFL $0, X9, X9; \
+	MOVSS lineConsts<>+8(SB), X10; \
+	PSHUFL $0, X10, X10; \
+	MOVSS lineConsts<>+12(SB), X11; \
+	PSHUFL $0, X11, X11; \
+	MOVSS lineConsts<>+16(SB), X12; \
+	PSHUFL $0, X12, X12; \
+	MOVSS lineConsts<>+20(SB), X13; \
+	PSHUFL $0, X13, X13; \
+	MOVSS lineConsts<>+24(SB), X14; \
+	PSHUFL $0, X14, X14; \
+	MOVSS lineConsts<>+28(SB), X7
+
+// SSE_MASKS turns the 16 bytes in X0 into their digit values, leaving the
+// dot mask in AX and the digit-or-dot mask in BX.
+#define SSE_MASKS \
+	MOVOU X0, X1; \
+	PCMPEQB X9, X1; \
+	PMOVMSKB X1, AX; \
+	PSUBB X10, X0; \
+	MOVOU X0, X1; \
+	PMINUB X11, X1; \
+	PCMPEQB X0, X1; \
+	PMOVMSKB X1, BX; \
+	ORL AX, BX
+
+// SSE_OCTETS turns the digit values in X0 into the address in AX, with the
+// shuffle at offset idx of ·lineShuffles, or jumps to reject if an octet is
+// above 255.
+#define SSE_OCTETS(idx, reject) \
+	LEAQ ·lineShuffles(SB), AX; \
+	MOVOU (AX)(idx*1), X1; \
+	PSHUFB X1, X0; \
+	PMADDUBSW X12, X0; \
+	PMADDWL X13, X0; \
+	MOVOU X0, X1; \
+	PCMPGTL X14, X1; \
+	PMOVMSKB X1, AX; \
+	TESTL AX, AX; \
+	JNZ reject; \
+	PSHUFB X7, X0; \
+	MOVQ X0, AX
+
+// func parseFieldSSE(p *byte, length int) (ip uint32, ok bool)
+TEXT ·parseFieldSSE(SB), NOSPLIT, $0-21
+	MOVQ p+0(FP), SI
+	MOVQ length+8(FP), CX
+	SSE_CONSTS
+	MOVOU (SI), X0
+	SSE_MASKS
+	CHECK_LINE(AX, BX, DX, R10, R11, field_invalid)
+	SSE_OCTETS(DX, field_invalid)
+	MOVL AX, ip+16(FP)
+	MOVB $1, ok+20(FP)
+	RET
+
+field_invalid:
+	MOVL $0, ip+16(FP)
+	MOVB $0, ok+20(FP)
+	RET
+
+// func parseLinesSSE(buf []byte, ips []uint32) (n, used int)
+TEXT ·parseLinesSSE(SB), NOSPLIT, $0-64
+	MOVQ buf_base+0(FP), SI      // SI = start of the line
+	MOVQ buf_len+8(FP), DI
+	MOVQ ips_base+24(FP), R8     // R8 = next IP
+	MOVQ ips_len+32(FP), R9
+	LEAQ -16(SI)(DI*1), DI       // DI = last start with 16 bytes to load
+	LEAQ (R8)(R9*4), R9          // R9 = end of ips
+	SSE_CONSTS
+
+sse_loop:
+	CMPQ SI, DI
+	JHI sse_done
+	CMPQ R8, R9
+	JAE sse_done
+	MOVOU (SI), X0
+	MOVOU X0, X1
+	PCMPEQB X8, X1
+	PMOVMSKB X1, CX
+	BSFL CX, CX                  // CX = length of the line
+	JZ sse_done
+	SSE_MASKS
+	CHECK_LINE(AX, BX, DX, R10, R11, sse_done)
+	SSE_OCTETS(DX, sse_done)
+	MOVL AX, (R8)
+	ADDQ $4, R8
+	LEAQ 1(SI)(CX*1), SI
+	JMP sse_loop
+
+sse_done:
+	SUBQ buf_base+0(FP), SI
+	MOVQ SI, used+56(FP)
+	SUBQ ips_base+24(FP), R8
+	SHRQ $2, R8
+	MOVQ R8, n+48(FP)
+	RET
+
+// func parseLinesAVX2(buf []byte, ips []uint32) (n, used int)
+//
+// Each iteration loads 32 bytes, which hold two lines if both are addresses,
+// and takes their masks at once. The second line is then loaded again into
+// the upper lane, so both are shuffled and multiplied together.
+TEXT ·parseLinesAVX2(SB), NOSPLIT, $0-64
+	MOVQ buf_base+0(FP), SI      // SI = start of the first line
+	MOVQ buf_len+8(FP), DI
+	MOVQ ips_base+24(FP), R8     // R8 = next IP
+	MOVQ ips_len+32(FP), R9
+	LEAQ -32(SI)(DI*1), DI       // DI = last start with 32 bytes to load
+	LEAQ -8(R8)(R9*4), R9        // R9 = last IP with room for two
+	VPBROADCASTD lineConsts<>+0(SB), Y8
+	VPBROADCASTD lineConsts<>+4(SB), Y9
+	VPBROADCASTD lineConsts<>+8(SB), Y10
+	VPBROADCASTD lineConsts<>+12(SB), Y11
+	VPBROADCASTD lineConsts<>+16(SB), Y12
+	VPBROADCASTD lineConsts<>+20(SB), Y13
+	VPBROADCASTD lineConsts<>+24(SB), Y14
+	VPBROADCASTD lineConsts<>+28(SB), Y7
+
+avx_loop:
+	CMPQ SI, DI
+	JHI avx_done
+	CMPQ R8, R9
+	JHI avx_done
+	VMOVDQU (SI), Y0
+	VPCMPEQB Y8, Y0, Y1
+	VPMOVMSKB Y1, R12            // R12 = newlines
+	VPCMPEQB Y9, Y0, Y3          // Y3 = dots
+	VPSUBB Y10, Y0, Y2
+	VPMINUB Y11, Y2, Y4
+	VPCMPEQB Y2, Y4, Y4
+	VPOR Y3, Y4, Y4              // Y4 = digits or dots
+
+	// First line.
+	VPMOVMSKB Y3, AX
+	VPMOVMSKB Y4, BX
+	BSFL R12, CX
+	JZ avx_done
+	CHECK_LINE(AX, BX, DX, R10, R11, avx_done)
+	LEAL 1(CX), R13              // R13 = start of the second line
+
+	// Second line: the same masks, shifted past the first.
+	VPMOVMSKB Y3, AX
+	VPMOVMSKB Y4, BX
+	MOVL R13, CX
+	SHRL CX, R12
+	SHRL CX, AX
+	SHRL CX, BX
+	BSFL R12, CX
+	JZ avx_done
+	CHECK_LINE(AX, BX, R12, R10, R11, avx_done)
+
+	// Digits of the first line in the lower lane, of the second in the upper.
+	VINSERTI128 $1, (SI)(R13*1), Y0, Y5
+	VPSUBB Y10, Y5, Y5
+	LEAQ ·lineShuffles(SB), R10
+	VMOVDQU (R10)(DX*1), X6
+	VINSERTI128 $1, (R10)(R12*1), Y6, Y6
+	VPSHUFB Y6, Y5, Y5
+	VPMADDUBSW Y12, Y5, Y5
+	VPMADDWD Y13, Y5, Y5
+	VPCMPGTD Y14, Y5, Y6
+	VPTEST Y6, Y6
+	JNZ avx_done                 // An octet above 255.
+	VPSHUFB Y7, Y5, Y5
+	VMOVD X5, 0(R8)
+	VEXTRACTI128 $1, Y5, X6
+	VMOVD X6, 4(R8)
+	ADDQ $8, R8
+	LEAQ 1(SI)(R13*1), SI
+	ADDQ CX, SI
+	JMP avx_loop
+
+avx_done:
+	VZEROUPPER
+	SUBQ buf_base+0(FP), SI
+	MOVQ SI, used+56(FP)
+	SUBQ ips_base+24(FP), R8
+	SHRQ $2, R8
+	MOVQ R8, n+48(FP)
+	RET
@@ -0,0 +1,39 @@
+//go:build arm64
+// +build arm64
+
+package assembly
+
+import "golang.org/x/sys/cpu"
+
+func init() {
+	if cpu.ARM64.HasASIMD { // NEON, part of the base architecture.
+		simdLevel = simd128
+	}
+}
+
+// parseField parses a field of 7 to 15 bytes with NEON.
+func parseField(b []byte) (uint32, bool) {
+	if simdLevel == scalar {
+		return ParseIPv4AsmRaw(b)
+	}
+	if cap(b) >= 16 {
+		return parseFieldNEON(&b[0], len(b)) // The bytes past len are read, then masked out.
+	}
+	var w [16]byte
+	copy(w[:], b)
+	return parseFieldNEON(&w[0], len(b))
+}
+
+// parseLines is parseLinesGeneric with NEON, one line per iteration.
+func parseLines(buf []byte, ips []uint32) (n, used int) {
+	if simdLevel == scalar {
+		return parseLinesGeneric(buf, ips)
+	}
+	return parseLinesNEON(buf, ips)
+}
+
+//go:noescape
+func parseFieldNEON(p *byte, length int) (ip uint32, ok bool)
+
+//go:noescape
+func parseLinesNEON(buf []byte, ips []uint32) (n, used int)
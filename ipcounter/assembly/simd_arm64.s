@@ -0,0 +1,166 @@
+#include "textflag.h"
+
+// NEON_CONSTS loads the constants of the kernels into V20-V25.
+#define NEON_CONSTS \
+    VMOVI $10, V20.B16; \
+    VMOVI $46, V21.B16; \
+    VMOVI $48, V22.B16; \
+    VMOVI $9, V23.B16; \
+    VMOVQ $0x8040201008040201, $0x8040201008040201, V24; \
+    VMOVQ $0x00000001000a0064, $0x00000001000a0064, V25
+
+// MOVEMASK gathers the top bits of the 16 compare results in v into the low
+// 16 bits of r: each byte keeps one bit of its half, and three pairwise adds
+// sum each half into a byte. v is clobbered.
+#define MOVEMASK(v, r) \
+    VAND V24.B16, v.B16, v.B16; \
+    VADDP v.B16, v.B16, v.B16; \
+    VADDP v.B16, v.B16, v.B16; \
+    VADDP v.B16, v.B16, v.B16; \
+    VMOV v.H[0], r
+
+// NEON_MASKS turns the 16 bytes in V0 into their digit values in V3, leaving
+// the dot mask in R6 and the digit-or-dot mask in R7.
+#define NEON_MASKS \
+    VCMEQ V21.B16, V0.B16, V1.B16; \
+    VSUB V22.B16, V0.B16, V3.B16; \
+    VUMIN V23.B16, V3.B16, V2.B16; \
+    VCMEQ V3.B16, V2.B16, V2.B16; \
+    VORR V1.B16, V2.B16, V2.B16; \
+    MOVEMASK(V1, R6); \
+    MOVEMASK(V2, R7)
+
+// CHECK_LINE validates the line of L bytes whose dot mask is in dots and
+// whose digit-or-dot mask is in ok, and leaves the offset of its shuffle in
+// ·lineShuffles in idx. It jumps to reject unless the line holds only digits
+// and three dots, with octets of 1 to 3 digits. dots, ok, t1 and t2 are
+// clobbered.
+#define CHECK_LINE(L, dots, ok, idx, t1, t2, reject) \
+    MOVD $1, t1; \
+    LSL L, t1, t1; \
+    SUB $1, t1, t1; \
+    AND t1, ok, ok; \
+    CMP t1, ok; \
+    BNE reject; \
+    AND t1, dots, dots; \
+    CBZ dots, reject; \
+    RBIT dots, t1; \
+    CLZ t1, t1; \
+    SUB $1, t1, idx; \
+    CMP $2, idx; \
+    BHI reject; \
+    SUB $1, dots, ok; \
+    AND ok, dots, dots; \
+    CBZ dots, reject; \
+    RBIT dots, t2; \
+    CLZ t2, t2; \
+    SUB t1, t2, ok; \
+    SUB $2, ok, ok; \
+    CMP $2, ok; \
+    BHI reject; \
+    ADD idx<<1, idx, idx; \
+    ADD ok, idx, idx; \
+    SUB $1, dots, ok; \
+    AND ok, dots, dots; \
+    CBZ dots, reject; \
+    RBIT dots, t1; \
+    CLZ t1, t1; \
+    SUB t2, t1, ok; \
+    SUB $2, ok, ok; \
+    CMP $2, ok; \
+    BHI reject; \
+    ADD idx<<1, idx, idx; \
+    ADD ok, idx, idx; \
+    SUB $1, dots, ok; \
+    AND ok, dots, dots; \
+    CBNZ dots, reject; \
+    SUB t1, L, ok; \
+    SUB $2, ok, ok; \
+    CMP $2, ok; \
+    BHI reject; \
+    ADD idx<<1, idx, idx; \
+    ADD ok, idx, idx; \
+    LSL $4, idx, idx
+
+// NEON_OCTETS turns the digit values in V3 into the address in R12, with the
+// shuffle at offset idx of ·lineShuffles, or jumps to reject if an octet is
+// above 255. The octets are widened to 16 bits, multiplied by the weights and
+// summed pairwise twice.
+#define NEON_OCTETS(idx, reject) \
+    MOVD $·lineShuffles(SB), R11; \
+    ADD idx, R11, R11; \
+    VLD1 (R11), [V4.B16]; \
+    VTBL V4.B16, [V3.B16], V5.B16; \
+    VUSHLL $0, V5.B8, V6.H8; \
+    VUSHLL2 $0, V5.B16, V7.H8; \
+    VMUL V25.H8, V6.H8, V6.H8; \
+    VMUL V25.H8, V7.H8, V7.H8; \
+    VADDP V7.H8, V6.H8, V6.H8; \
+    VADDP V6.H8, V6.H8, V6.H8; \
+    VMOV V6.D[0], R12; \
+    TST $0xff00ff00ff00ff00, R12; \
+    BNE reject; \
+    ORR R12>>8, R12, R12; \
+    AND $0x0000ffff0000ffff, R12, R12; \
+    ORR R12>>16, R12, R12; \
+    REVW R12, R12
+
+// func parseFieldNEON(p *byte, length int) (ip uint32, ok bool)
+TEXT ·parseFieldNEON(SB), NOSPLIT, $0-21
+    MOVD p+0(FP), R0
+    MOVD length+8(FP), R5
+    NEON_CONSTS
+    VLD1 (R0), [V0.B16]
+    NEON_MASKS
+    CHECK_LINE(R5, R6, R7, R8, R9, R10, field_invalid)
+    NEON_OCTETS(R8, field_invalid)
+    MOVW R12, ip+16(FP)
+    MOVD $1, R4
+    MOVB R4, ok+20(FP)
+    RET
+
+field_invalid:
+    MOVW ZR, ip+16(FP)
+    MOVB ZR, ok+20(FP)
+    RET
+
+// func parseLinesNEON(buf []byte, ips []uint32) (n, used int)
+TEXT ·parseLinesNEON(SB), NOSPLIT, $0-64
+    MOVD buf_base+0(FP), R0    // R0 = start of the line
+    MOVD buf_len+8(FP), R1
+    MOVD ips_base+24(FP), R2   // R2 = next IP
+    MOVD ips_len+32(FP), R3
+    ADD R0, R1, R1
+    SUB $16, R1, R1            // R1 = last start with 16 bytes to load
+    ADD R3<<2, R2, R3          // R3 = end of ips
+    NEON_CONSTS
+
+loop:
+    CMP R1, R0
+    BHI done
+    CMP R3, R2
+    BHS done
+    VLD1 (R0), [V0.B16]
+    VCMEQ V20.B16, V0.B16, V1.B16
+    MOVEMASK(V1, R4)
+    CBZ R4, done
+    RBIT R4, R5
+    CLZ R5, R5                 // R5 = length of the line
+    NEON_MASKS
+    CHECK_LINE(R5, R6, R7, R8, R9, R10, done)
+    NEON_OCTETS(R8, done)
+    MOVW R12, (R2)
+    ADD $4, R2
+    ADD R5, R0, R0
+    ADD $1, R0
+    B loop
+
+done:
+    MOVD buf_base+0(FP), R4
+    SUB R4, R0, R0
+    MOVD R0, used+56(FP)
+    MOVD ips_base+24(FP), R4
+    SUB R4, R2, R2
+    LSR $2, R2, R2
+    MOVD R2, n+48(FP)
+    RET
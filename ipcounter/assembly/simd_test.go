@@ -0,0 +1,186 @@
+package assembly
+
+import (
+	"IP-Addr-Counter/ipcounter/utils"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// parseSamples are lines around the edges of the grammar, valid or not.
+var parseSamples = []string{
+	"0.0.0.0", "1.2.3.4", "255.255.255.255", "001.002.003.004", "192.168.1.1",
+	"10.0.0.255", "99.99.99.99", "100.10.1.0",
+	"256.1.1.1", "1.2.3.256", "1.2.3.999", "1.2.3", "1.2.3.4.", ".1.2.3.4",
+	"1..2.3", "1.2.3.4.5", "1234.1.1.1", "1.2.3.0004", "a.b.c.d", "1.2.3.4 ",
+	" 1.2.3.4", "1.2.3.4\r", "1.2.3.-4", "1.2.3./", "1.2.3.:", "", "1.2.3.4\x00",
+	"12345678901234", "1.2.3.4444444444", "...", "1.2.3.44\n5",
+}
+
+// levels returns the vector widths this CPU can run, widest first.
+func levels() []int {
+	var l []int
+	for level := simdLevel; level >= scalar; level-- {
+		l = append(l, level)
+	}
+	return l
+}
+
+// checkParse compares every parser of this package with utils.ParseIPv4 on
+// line, at every vector width.
+func checkParse(t *testing.T, line []byte) {
+	t.Helper()
+	saved := simdLevel
+	defer func() { simdLevel = saved }()
+
+	want, err := utils.ParseIPv4(line)
+	wantOK := err == nil
+	if ip, ok := ParseIPv4AsmRaw(line); ok != wantOK || ip != want && ok {
+		t.Errorf("ParseIPv4AsmRaw(%q) = %08X, %v; want %08X, %v", line, ip, ok, want, wantOK)
+	}
+	for _, level := range levels() {
+		simdLevel = level
+		if ip, err := parseIPv4Asm(line); (err == nil) != wantOK || ip != want && wantOK {
+			t.Errorf("level %d: parseIPv4Asm(%q) = %08X, %v; want %08X, %v", level, line, ip, err, want, wantOK)
+		}
+
+		// As a line of a buffer, padded so the vector loads stay inside it.
+		buf := append(append(append([]byte{}, line...), '\n'), strings.Repeat("x", 32)...)
+		refIPs := make([]uint32, len(buf))
+		refN, refUsed := parseLinesGeneric(buf, refIPs)
+		ips := make([]uint32, len(buf))
+		n, used := parseLines(buf, ips)
+		checkLines(t, level, buf, ips[:n], used, false, refIPs[:refN], refUsed)
+	}
+}
+
+// checkLines checks that parseLines returned a prefix of what
+// parseLinesGeneric did, stopping only where it may: at the end of the valid
+// lines, near the end of buf or, if full is set, when the batch filled up.
+func checkLines(t *testing.T, level int, buf []byte, ips []uint32, used int, full bool, refIPs []uint32, refUsed int) {
+	t.Helper()
+	if len(ips) > len(refIPs) || used > refUsed {
+		t.Fatalf("level %d: parseLines(%q) took %d lines, %d bytes; the reference only %d, %d", level, buf, len(ips), used, len(refIPs), refUsed)
+	}
+	for i := range ips {
+		if ips[i] != refIPs[i] {
+			t.Fatalf("level %d: parseLines(%q)[%d] = %08X, want %08X", level, buf, i, ips[i], refIPs[i])
+		}
+	}
+	if used < refUsed && len(buf)-used >= 32 && !full {
+		t.Fatalf("level %d: parseLines(%q) stopped at byte %d of a valid line, 32 or more from the end", level, buf, used)
+	}
+	if len(ips) == len(refIPs) && used != refUsed {
+		t.Fatalf("level %d: parseLines(%q) took %d lines in %d bytes, want %d bytes", level, buf, len(ips), used, refUsed)
+	}
+}
+
+func TestParseMatchesUtils(t *testing.T) {
+	for _, s := range parseSamples {
+		checkParse(t, []byte(s))
+	}
+	// Every length of every octet, and every digit in every position.
+	for a := 0; a < 1000; a += 7 {
+		for _, b := range []int{0, 5, 25, 99, 100, 255, 256} {
+			checkParse(t, []byte(fmt.Sprintf("%d.%d.%d.%d", a, b, 255-a%256, a%10)))
+			checkParse(t, []byte(fmt.Sprintf("%03d.%d.%02d.%d", b, a, a%100, b)))
+		}
+	}
+}
+
+func TestParseLinesRuns(t *testing.T) {
+	saved := simdLevel
+	defer func() { simdLevel = saved }()
+
+	// Valid lines with an invalid one in the middle, and batches of every size.
+	rng := rand.New(rand.NewSource(1))
+	var buf bytes.Buffer
+	for i := 0; i < 500; i++ {
+		if i == 333 {
+			buf.WriteString("1.2.3.4 \n")
+		}
+		fmt.Fprintf(&buf, "%d.%d.%d.%d\n", rng.Intn(256), rng.Intn(256), rng.Intn(256), rng.Intn(256))
+	}
+	data := buf.Bytes()
+	refIPs := make([]uint32, 1000)
+	refN, refUsed := parseLinesGeneric(data, refIPs)
+	if refN != 333 {
+		t.Fatalf("parseLinesGeneric took %d lines, want 333", refN)
+	}
+	for _, level := range levels() {
+		simdLevel = level
+		for _, batch := range []int{0, 1, 2, 3, 64, 1000} {
+			// Call again after every full batch, as a caller would.
+			ips := make([]uint32, batch)
+			var got []uint32
+			used := 0
+			for {
+				n, u := parseLines(data[used:], ips)
+				got = append(got, ips[:n]...)
+				used += u
+				if n < batch || batch == 0 {
+					break
+				}
+			}
+			checkLines(t, level, data, got, used, batch == 0, refIPs[:refN], refUsed)
+		}
+	}
+}
+
+func FuzzParseIPv4(f *testing.F) {
+	for _, s := range parseSamples {
+		f.Add([]byte(s))
+	}
+	f.Add([]byte("1.2.3.4\n5.6.7.8\n9.10.11.12"))
+	f.Add([]byte("255.255.255.255\n0.0.0.0\n"))
+	f.Fuzz(func(t *testing.T, line []byte) {
+		checkParse(t, line)
+	})
+}
+
+func BenchmarkParse(b *testing.B) {
+	rng := rand.New(rand.NewSource(1))
+	var sb strings.Builder
+	for sb.Len() < 1<<20 {
+		fmt.Fprintf(&sb, "%d.%d.%d.%d\n", rng.Intn(256), rng.Intn(256), rng.Intn(256), rng.Intn(256))
+	}
+	data := []byte(sb.String())
+	lines := bytes.Split(data[:len(data)-1], []byte{'\n'})
+
+	b.Run("utils", func(b *testing.B) {
+		b.SetBytes(int64(len(data)))
+		for i := 0; i < b.N; i++ {
+			for _, line := range lines {
+				utils.ParseIPv4(line)
+			}
+		}
+	})
+	saved := simdLevel
+	defer func() { simdLevel = saved }()
+	for _, level := range levels() {
+		simdLevel = level
+		b.Run(fmt.Sprintf("field/level%d", level), func(b *testing.B) {
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				for _, line := range lines {
+					parseIPv4Asm(line)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("lines/level%d", level), func(b *testing.B) {
+			ips := make([]uint32, 4096)
+			b.SetBytes(int64(len(data)))
+			for i := 0; i < b.N; i++ {
+				for buf := data; len(buf) > 0; {
+					n, used := parseLines(buf, ips)
+					if n == 0 {
+						used = bytes.IndexByte(buf, '\n') + 1 // A tail line the vector loads cannot reach.
+					}
+					buf = buf[used:]
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,200 @@
+/*
+Package auto picks the counting implementation that suits an input and the
+machine it runs on, so users do not have to, and a map-based count of a huge
+file cannot take the host down.
+
+The choice goes by input size first: a map for tiny inputs, an adaptive set
+for medium ones, whose memory grows with the distinct IPs, and a sharded
+bitset for large ones, in assembly on amd64 and arm64 when there are several
+CPUs to share it, and a plain single-threaded bitset on one CPU. An
+implementation is skipped if it lacks a feature the caller needs or its
+estimated memory does not fit in what is available, cgroup limits included.
+*/
+package auto
+
+import (
+	"IP-Addr-Counter/ipcounter/partitioned"
+	"IP-Addr-Counter/ipcounter/sysinfo"
+	"IP-Addr-Counter/ipcounter/tuning"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+// Size classes and the memory model used to estimate each implementation.
+const (
+	TinyBytes   = 4 << 20   // Inputs up to this size count as tiny.
+	MediumBytes = 256 << 20 // Inputs up to this size count as medium.
+
+	minLineBytes     = 8         // Shortest line holding an IP, "1.2.3.4\n"; bounds the distinct IPs.
+	mapEntryBytes    = 48        // Memory per distinct IP in a Go map, including growth slack.
+	sparseEntryBytes = 8         // Memory per distinct IP in an adaptive array, including its unsorted tail.
+	promoteAt        = 32 << 20  // IPs at which an adaptive set becomes a bitmap.
+	bitmapBytes      = 512 << 20 // A bitmap over the whole IPv4 space.
+)
+
+// Host describes the machine a count runs on.
+type Host struct {
+	Memory sysinfo.Memory // Memory the process can still allocate.
+	CPUs   int            // CPUs available to the process.
+	Arch   string         // GOARCH.
+}
+
+// CurrentHost returns the Host the process runs on.
+func CurrentHost() Host {
+	return Host{Memory: sysinfo.AvailableMemory(), CPUs: sysinfo.CPUs(), Arch: runtime.GOARCH}
+}
+
+// Requirements lists the features the caller needs from the implementation.
+type Requirements struct {
+	Format     bool // Reading IPs out of records (-format).
+	TopK       bool // Heavy hitters (-top).
+	Checkpoint bool // Checkpoints and resuming (-checkpoint).
+	Follow     bool // Following a growing file (-follow).
+	Snapshot   bool // Saving the seen IPs (-save).
+}
+
+// supports reports whether the named implementation has every required feature.
+func (r Requirements) supports(impl string) bool {
+	switch impl {
+	case "naive":
+		return !r.Format && !r.TopK && !r.Checkpoint && !r.Follow && !r.Snapshot
+	case "bitset":
+		return !r.Format && !r.TopK && !r.Checkpoint && !r.Follow
+	case "adaptive":
+		return !r.TopK && !r.Checkpoint && !r.Follow
+	case "asm":
+		return !r.Follow
+	}
+	return true
+}
+
+// Choice is the implementation picked for a count.
+type Choice struct {
+	Impl   string // Implementation name, as accepted by the CLI.
+	Reason string // Why it was picked, for the log.
+}
+
+// Choose picks the implementation for counting the named file on this host.
+func Choose(filename string, req Requirements) (Choice, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return Choice{}, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return Decide(info.Size(), CurrentHost(), req)
+}
+
+// Decide picks the implementation for an input of size bytes on h.
+func Decide(size int64, h Host, req Requirements) (Choice, error) {
+	sharded := "concurrent"
+	if h.CPUs > 1 && (h.Arch == "amd64" || h.Arch == "arm64") {
+		sharded = "asm"
+	}
+
+	var class string
+	var order []string
+	switch {
+	case size <= TinyBytes:
+		class, order = "tiny", []string{"naive", "adaptive", "bitset", sharded, "concurrent"}
+	case size <= MediumBytes:
+		class, order = "medium", []string{"adaptive", sharded, "concurrent", "bitset"}
+	case h.CPUs <= 1:
+		class, order = "large", []string{"bitset", "concurrent", "adaptive"}
+	default:
+		class, order = "large", []string{sharded, "concurrent", "bitset", "adaptive"}
+	}
+
+	sharding := tuning.Default()
+	sharding.Workers = h.CPUs
+
+	why := fmt.Sprintf("%s input (%s), %d CPUs on %s", FormatBytes(size), class, h.CPUs, h.Arch)
+	if h.Memory.Available >= 0 {
+		why += fmt.Sprintf(", %s memory available (%s)", FormatBytes(h.Memory.Available), h.Memory.Source)
+	} else {
+		why += ", available memory unknown"
+	}
+
+	var skipped []string
+	seen := make(map[string]bool)
+	for _, impl := range order {
+		if seen[impl] {
+			continue
+		}
+		seen[impl] = true
+		if !req.supports(impl) {
+			continue // Not a memory concern, so not worth logging.
+		}
+		need := footprint(impl, size, sharding)
+		if h.Memory.Available >= 0 && need > h.Memory.Available {
+			skipped = append(skipped, fmt.Sprintf("%s would need %s", impl, FormatBytes(need)))
+			continue
+		}
+		if len(skipped) > 0 {
+			why += "; " + strings.Join(skipped, ", ")
+		}
+		return Choice{Impl: impl, Reason: why}, nil
+	}
+	if len(skipped) == 0 {
+		return Choice{}, fmt.Errorf("no implementation supports the requested options")
+	}
+	return Choice{}, fmt.Errorf("not enough memory for any implementation: %s; %s", why, strings.Join(skipped, ", "))
+}
+
+// Check reports an error if the named implementation cannot fit in the memory
+// available on h, whatever the input, so an explicit choice fails up front
+// rather than being OOM-killed halfway through a count. The sharded counters
+// are checked with settings o, running one worker per CPU of h unless o sets
+// the count. Implementations whose memory grows with the input always pass.
+func Check(impl string, h Host, o tuning.Options) error {
+	switch impl {
+	case "bitset", "concurrent", "asm", "partitioned":
+	default:
+		return nil
+	}
+	if o.Workers == 0 {
+		o.Workers = h.CPUs
+	}
+	need := footprint(impl, 0, o)
+	if h.Memory.Available < 0 || need <= h.Memory.Available {
+		return nil
+	}
+	return fmt.Errorf("not enough memory for %s: it needs at least %s with %d workers, but only %s is available (%s); try auto to pick one that fits",
+		impl, FormatBytes(need), o.Workers, FormatBytes(h.Memory.Available), h.Memory.Source)
+}
+
+// footprint estimates the memory the named implementation needs for an input
+// of size bytes, assuming every line could hold a distinct IP. The sharded
+// counters are estimated with settings o.
+func footprint(impl string, size int64, o tuning.Options) int64 {
+	maxIPs := size/minLineBytes + 1
+	switch impl {
+	case "naive":
+		return maxIPs * mapEntryBytes
+	case "adaptive":
+		if maxIPs <= promoteAt {
+			return maxIPs * sparseEntryBytes
+		}
+		return bitmapBytes + promoteAt*sparseEntryBytes
+	case "bitset":
+		return bitmapBytes
+	case "partitioned":
+		return partitioned.Footprint(o)
+	default: // The sharded counters.
+		return o.Footprint()
+	}
+}
+
+// FormatBytes formats n with a binary unit, e.g. 1.5GB.
+func FormatBytes(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%dB", n)
+	}
+	v, i := float64(n)/1024, 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%cB", v, units[i])
+}
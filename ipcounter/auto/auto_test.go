@@ -0,0 +1,95 @@
+package auto
+
+import (
+	"IP-Addr-Counter/ipcounter/sysinfo"
+	"IP-Addr-Counter/ipcounter/tuning"
+	"strings"
+	"testing"
+)
+
+func TestDecide(t *testing.T) {
+	const gb = 1 << 30
+	plenty := sysinfo.Memory{Available: 64 * gb, Source: "/proc/meminfo"}
+	tests := []struct {
+		name string
+		size int64
+		host Host
+		req  Requirements
+		want string
+	}{
+		{"tiny", 1 << 20, Host{plenty, 8, "amd64"}, Requirements{}, "naive"},
+		{"tiny with format", 1 << 20, Host{plenty, 8, "amd64"}, Requirements{Format: true}, "adaptive"},
+		{"tiny with top", 1 << 20, Host{plenty, 8, "amd64"}, Requirements{TopK: true}, "asm"},
+		{"medium", 100 << 20, Host{plenty, 8, "amd64"}, Requirements{}, "adaptive"},
+		{"large amd64", 10 * gb, Host{plenty, 8, "amd64"}, Requirements{}, "asm"},
+		{"large arm64", 10 * gb, Host{plenty, 8, "arm64"}, Requirements{}, "asm"},
+		{"large other arch", 10 * gb, Host{plenty, 8, "riscv64"}, Requirements{}, "concurrent"},
+		{"large one CPU", 10 * gb, Host{plenty, 1, "amd64"}, Requirements{}, "bitset"},
+		{"large follow", 10 * gb, Host{plenty, 8, "amd64"}, Requirements{Follow: true}, "concurrent"},
+		{"large unknown memory", 10 * gb, Host{sysinfo.Memory{Available: -1, Source: "unknown"}, 8, "amd64"}, Requirements{}, "asm"},
+		{"large in a 600MB container", 10 * gb, Host{sysinfo.Memory{Available: 600 << 20, Source: "cgroup v2"}, 8, "amd64"}, Requirements{}, "bitset"},
+		{"tiny map too big", 4 << 20, Host{sysinfo.Memory{Available: 20 << 20, Source: "cgroup v1"}, 2, "amd64"}, Requirements{}, "adaptive"},
+	}
+	for _, tt := range tests {
+		c, err := Decide(tt.size, tt.host, tt.req)
+		if err != nil {
+			t.Errorf("%s: Decide() failed: %v", tt.name, err)
+			continue
+		}
+		if c.Impl != tt.want {
+			t.Errorf("%s: Decide() = %s (%s), want %s", tt.name, c.Impl, c.Reason, tt.want)
+		}
+	}
+}
+
+func TestDecideReasons(t *testing.T) {
+	c, err := Decide(10<<30, Host{sysinfo.Memory{Available: 600 << 20, Source: "cgroup v2"}, 8, "amd64"}, Requirements{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"10.0GB input (large)", "8 CPUs on amd64", "600.0MB memory available (cgroup v2)", "asm would need"} {
+		if !strings.Contains(c.Reason, want) {
+			t.Errorf("Reason %q lacks %q", c.Reason, want)
+		}
+	}
+
+	_, err = Decide(10<<30, Host{sysinfo.Memory{Available: 256 << 20, Source: "cgroup v1"}, 8, "amd64"}, Requirements{})
+	if err == nil || !strings.Contains(err.Error(), "not enough memory") {
+		t.Errorf("Decide() with 256MB = %v, want a memory error", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	small := Host{sysinfo.Memory{Available: 600 << 20, Source: "cgroup v1"}, 4, "amd64"}
+	if err := Check("bitset", small, tuning.Default()); err != nil {
+		t.Errorf("Check(bitset) = %v, want nil", err)
+	}
+	if err := Check("naive", small, tuning.Default()); err != nil {
+		t.Errorf("Check(naive) = %v, want nil", err)
+	}
+	err := Check("asm", small, tuning.Default())
+	if err == nil {
+		t.Fatal("Check(asm) = nil, want a memory error")
+	}
+	for _, want := range []string{"not enough memory for asm", "608.0MB with 4 workers", "600.0MB is available (cgroup v1)"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Check(asm) = %q, lacks %q", err, want)
+		}
+	}
+	if err := Check("asm", Host{sysinfo.Memory{Available: -1, Source: "unknown"}, 4, "amd64"}, tuning.Default()); err != nil {
+		t.Errorf("Check(asm) with unknown memory = %v, want nil", err)
+	}
+	if err := Check("asm", small, tuning.Options{Workers: 1, ChunkBytes: 1 << 20, QueueLen: 1, Shards: 1}); err != nil {
+		t.Errorf("Check(asm) with small chunks = %v, want nil", err)
+	}
+
+	// Partitioned also holds the batches for its owners, many with many CPUs.
+	o := tuning.Options{Workers: 64, ChunkBytes: 1 << 20, QueueLen: 1, Shards: 1}
+	fits := Host{sysinfo.Memory{Available: o.Footprint() + 100<<20, Source: "cgroup v2"}, 64, "amd64"}
+	if err := Check("asm", fits, o); err != nil {
+		t.Errorf("Check(asm) = %v, want nil", err)
+	}
+	if err := Check("partitioned", fits, o); err == nil {
+		t.Error("Check(partitioned) = nil, want a memory error for its batches")
+	}
+}
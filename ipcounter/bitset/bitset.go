@@ -1,6 +1,9 @@
 package bitset
 
 import (
+	"IP-Addr-Counter/ipcounter/hugemem"
+	"IP-Addr-Counter/ipcounter/ipset"
+	"IP-Addr-Counter/ipcounter/tuning"
 	"IP-Addr-Counter/ipcounter/utils"
 	"bufio"
 	"fmt"
@@ -11,15 +14,27 @@ import (
 // BitsetCounter efficiently tracks unique IPv4 addresses using a fixed-size bitset.
 type BitsetCounter struct {
 	bitset []byte
+	mem    *hugemem.Region // Memory behind bitset.
 }
 
 const maxIPv4 = 1 << 32 // 2^32 IPs = 4,294,967,296 bits (512MB)
 
 // New returns a new BitsetCounter with enough space to track every possible IPv4 address.
-func New() *BitsetCounter {
-	return &BitsetCounter{
-		bitset: make([]byte, maxIPv4/8), // 512MB
+// Of the settings in opts, only tuning.HugePages is used, with the worker
+// count setting how many goroutines fault the pages in. It panics if the
+// settings are invalid.
+func New(opts ...tuning.Option) *BitsetCounter {
+	o, err := tuning.New(opts...)
+	if err != nil {
+		panic("bitset: " + err.Error())
 	}
+	mem := o.Alloc(maxIPv4 / 8) // 512MB
+	return &BitsetCounter{bitset: mem.Bytes(), mem: mem}
+}
+
+// Memory describes the memory the bitset is on, including the page size.
+func (b *BitsetCounter) Memory() hugemem.Info {
+	return b.mem.Info()
 }
 
 // CountUniqueIPs counts the number of unique IPv4 addresses in the given file.
@@ -60,3 +75,28 @@ func (b *BitsetCounter) CountUniqueIPs(filename string) (int64, error) {
 
 	return int64(count), nil
 }
+
+// Contains reports whether ip was seen by a previous count.
+func (b *BitsetCounter) Contains(ip uint32) bool {
+	return b.bitset[ip/8]&(1<<(ip%8)) != 0
+}
+
+// ContainsAll reports, for each of ips, whether it was seen by a previous count.
+func (b *BitsetCounter) ContainsAll(ips []uint32) []bool {
+	found := make([]bool, len(ips))
+	for i, ip := range ips {
+		found[i] = b.Contains(ip)
+	}
+	return found
+}
+
+// Bitmap returns the bitset as an ipset.Bitmap without copying it.
+// Byte i bit j and word i/8 bit (i%8)*8+j name the same IP on little-endian CPUs.
+func (b *BitsetCounter) Bitmap() *ipset.Bitmap {
+	return ipset.NewBitmapIn(b.mem)
+}
+
+// Index builds a rank index over the seen IPs for range counts and select queries.
+func (b *BitsetCounter) Index() *ipset.Index {
+	return ipset.NewIndex(b.Bitmap())
+}
@@ -0,0 +1,306 @@
+/*
+Package checkpoint lets long counts survive a crash. A checkpoint is a snapshot
+of the bitset together with the input offset up to which every line is known
+to be in it; a resumed count loads the snapshot and reads on from the offset.
+
+The parallel counters finish chunks out of order, so the offset is a watermark:
+the end of the longest prefix of the input whose chunks have all finished.
+Chunks past the watermark may already be in the snapshot too, which is
+harmless, since counting their lines again sets the same bits.
+
+A checkpoint also records the size and modification time of the input and the
+format its lines were read with, and is only resumed on the same input read
+the same way.
+
+File format, all integers little-endian:
+
+	magic   [8]byte  "IPCKPT02"
+	offset  uint64   input offset to resume from
+	size    uint64   size of the input
+	mtime   int64    modification time of the input, in nanoseconds since 1970
+	fmtlen  uint16   length of the format spec
+	format  [fmtlen]byte
+	                 followed by an ipset snapshot
+*/
+package checkpoint
+
+import (
+	"IP-Addr-Counter/ipcounter/ipset"
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultInterval is how often a checkpoint is written by default.
+const DefaultInterval = 5 * time.Minute
+
+const (
+	magic     = "IPCKPT02"
+	headerLen = 34 // Bytes before the format spec.
+)
+
+var (
+	errBadCheckpoint = errors.New("invalid checkpoint")
+	errOtherInput    = errors.New("checkpoint is of another input")
+)
+
+// Input identifies the input of a count and how its lines are read.
+type Input struct {
+	Format  string    // Format spec the lines are read with.
+	Size    int64     // Size of the input file.
+	ModTime time.Time // Modification time of the input file.
+}
+
+// InputOf returns the Input of a file with the given info, read with format.
+func InputOf(info os.FileInfo, format string) Input {
+	return Input{Format: format, Size: info.Size(), ModTime: info.ModTime()}
+}
+
+// Match returns an error unless cur is the same input as in, read the same way.
+func (in Input) Match(cur Input) error {
+	if in.Format != cur.Format {
+		return fmt.Errorf("%w: taken with format %q, resumed with %q", errOtherInput, in.Format, cur.Format)
+	}
+	if in.Size != cur.Size || !in.ModTime.Equal(cur.ModTime) {
+		return fmt.Errorf("%w: taken of a %d byte file modified at %v, resumed on a %d byte file modified at %v",
+			errOtherInput, in.Size, in.ModTime, cur.Size, cur.ModTime)
+	}
+	return nil
+}
+
+// Checkpoint is the state of a count at a point in time.
+type Checkpoint struct {
+	Offset int64         // Every line before Offset is in Set.
+	Input  Input         // What was being counted.
+	Set    *ipset.Bitmap // IPs seen so far.
+}
+
+// WriteTo writes the checkpoint to w.
+func (c *Checkpoint) WriteTo(w io.Writer) (int64, error) {
+	if len(c.Input.Format) > 0xFFFF {
+		return 0, fmt.Errorf("format spec of %d bytes is too long for a checkpoint", len(c.Input.Format))
+	}
+	hdr := make([]byte, headerLen, headerLen+len(c.Input.Format))
+	copy(hdr[:8], magic)
+	binary.LittleEndian.PutUint64(hdr[8:], uint64(c.Offset))
+	binary.LittleEndian.PutUint64(hdr[16:], uint64(c.Input.Size))
+	binary.LittleEndian.PutUint64(hdr[24:], uint64(c.Input.ModTime.UnixNano()))
+	binary.LittleEndian.PutUint16(hdr[32:], uint16(len(c.Input.Format)))
+	hdr = append(hdr, c.Input.Format...)
+	n, err := w.Write(hdr)
+	if err != nil {
+		return int64(n), err
+	}
+	m, err := c.Set.WriteTo(w)
+	return int64(n) + m, err
+}
+
+// Save atomically writes the checkpoint to the named file, so a crash while
+// saving leaves the previous checkpoint in place.
+func (c *Checkpoint) Save(filename string) error {
+	return ipset.SaveFile(filename, c)
+}
+
+// Load reads a checkpoint written by Save.
+func Load(filename string) (*Checkpoint, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint: %w", err)
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	var hdr [headerLen]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil || string(hdr[:8]) != magic {
+		return nil, fmt.Errorf("failed to read checkpoint %s: %w", filename, errBadCheckpoint)
+	}
+	format := make([]byte, binary.LittleEndian.Uint16(hdr[32:]))
+	if _, err := io.ReadFull(r, format); err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint %s: %w", filename, errBadCheckpoint)
+	}
+	c := &Checkpoint{
+		Offset: int64(binary.LittleEndian.Uint64(hdr[8:])),
+		Input: Input{
+			Format:  string(format),
+			Size:    int64(binary.LittleEndian.Uint64(hdr[16:])),
+			ModTime: time.Unix(0, int64(binary.LittleEndian.Uint64(hdr[24:]))),
+		},
+		Set: ipset.NewBitmap(),
+	}
+	if _, err := c.Set.ReadFrom(r); err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint %s: %w", filename, err)
+	}
+	return c, nil
+}
+
+// Options configures checkpointing for the next count of a counter.
+type Options struct {
+	Filename string        // Checkpoint file, empty to write none.
+	Interval time.Duration // Time between checkpoints, 0 for DefaultInterval.
+	Offset   int64         // Input offset to resume from.
+	Resumed  int64         // Distinct IPs loaded from the checkpoint resumed from.
+	Format   string        // Format spec of the count, recorded in its checkpoints.
+	From     *Input        // Input of the checkpoint resumed from, nil if none.
+}
+
+// Enabled reports whether the count checkpoints or resumes.
+func (o *Options) Enabled() bool {
+	return o.Filename != "" || o.From != nil
+}
+
+// Count runs a count of file with the given options: it checks that a resumed
+// checkpoint was taken of file, seeks to the resume offset, calls count with a
+// watermark and saves checkpoints of set while it runs. The result includes
+// the IPs resumed from, so it is the distinct count of the whole file.
+func Count(file *os.File, o Options, set func() *ipset.Bitmap, count func(r io.Reader, wm *Watermark) (int64, error)) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat file: %w", err)
+	}
+	in := InputOf(info, o.Format)
+	if o.From != nil {
+		if err := o.From.Match(in); err != nil {
+			return 0, err
+		}
+	}
+	if info.Size() < o.Offset {
+		return 0, fmt.Errorf("cannot resume at byte %d of a %d byte file; is it the same input?", o.Offset, info.Size())
+	}
+	if _, err := file.Seek(o.Offset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek to the checkpoint: %w", err)
+	}
+
+	var wm *Watermark
+	var w *Writer
+	if o.Filename != "" {
+		wm = NewWatermark(o.Offset, info.Size())
+		w = Start(o.Filename, o.Interval, in, wm, set)
+	}
+	n, err := count(file, wm)
+	if w != nil {
+		if serr := w.Stop(); err == nil {
+			err = serr
+		}
+	}
+	return o.Resumed + n, err
+}
+
+// Watermark tracks the end of the longest prefix of the input whose chunks
+// have all been processed. The reader calls Add for each chunk in input order
+// and a worker calls Done once it has processed it. A nil *Watermark ignores
+// every call, so counters can use one unconditionally.
+type Watermark struct {
+	mu      sync.Mutex
+	offset  int64  // End of the finished prefix.
+	limit   int64  // Cap on offset.
+	end     int64  // End of the last chunk added.
+	first   int    // Sequence number of pending[0].
+	pending []span // Chunks after the finished prefix, in input order.
+}
+
+// span is the end offset of one chunk and whether it is done.
+type span struct {
+	end  int64
+	done bool
+}
+
+// NewWatermark returns a watermark for input read from offset on. The offset
+// never passes limit, the size of the input, since counters terminate an
+// unterminated last line with a newline of their own.
+func NewWatermark(offset, limit int64) *Watermark {
+	return &Watermark{offset: offset, limit: limit, end: offset}
+}
+
+// Add records the next chunk of n input bytes and returns its sequence number.
+func (w *Watermark) Add(n int) int {
+	if w == nil {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.end += int64(n)
+	w.pending = append(w.pending, span{end: w.end})
+	return w.first + len(w.pending) - 1
+}
+
+// Done marks the chunk with sequence number seq as processed.
+func (w *Watermark) Done(seq int) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.pending[seq-w.first].done = true
+	for len(w.pending) > 0 && w.pending[0].done {
+		w.offset = min(w.pending[0].end, w.limit)
+		w.pending = w.pending[1:]
+		w.first++
+	}
+}
+
+// Offset returns the end of the finished prefix. Every bit set by a chunk in
+// it is visible to the caller once Offset returns.
+func (w *Watermark) Offset() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.offset
+}
+
+// Writer saves checkpoints of a running count in the background.
+type Writer struct {
+	filename string
+	input    Input
+	wm       *Watermark
+	set      func() *ipset.Bitmap
+	stop     chan struct{}
+	done     chan struct{}
+	err      error // First failed save.
+}
+
+// Start writes a checkpoint of a count of in to filename every interval,
+// taking the offset from wm before copying the bitset with set, so the
+// snapshot holds at least the lines before the offset. Stop must be called
+// when the count ends.
+func Start(filename string, interval time.Duration, in Input, wm *Watermark, set func() *ipset.Bitmap) *Writer {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	w := &Writer{filename: filename, input: in, wm: wm, set: set, stop: make(chan struct{}), done: make(chan struct{})}
+	go func() {
+		defer close(w.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				w.save()
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+	return w
+}
+
+// save writes one checkpoint, remembering the first error.
+func (w *Writer) save() {
+	c := &Checkpoint{Offset: w.wm.Offset(), Input: w.input}
+	c.Set = w.set()
+	if err := c.Save(w.filename); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+// Stop ends the periodic checkpoints and writes a last one with the final
+// offset. It returns the first error of any save.
+func (w *Writer) Stop() error {
+	close(w.stop)
+	<-w.done
+	w.save()
+	return w.err
+}
@@ -0,0 +1,55 @@
+package checkpoint
+
+import (
+	"IP-Addr-Counter/ipcounter/ipset"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatermark(t *testing.T) {
+	wm := NewWatermark(100, 145)
+	a, b, c := wm.Add(10), wm.Add(20), wm.Add(16) // Ends at 110, 130 and 146.
+	wm.Done(b)
+	if got := wm.Offset(); got != 100 {
+		t.Errorf("Offset() = %d with the first chunk pending, want 100", got)
+	}
+	wm.Done(a)
+	if got := wm.Offset(); got != 130 {
+		t.Errorf("Offset() = %d, want 130", got)
+	}
+	d := wm.Add(0)
+	wm.Done(c)
+	wm.Done(d)
+	if got := wm.Offset(); got != 145 {
+		t.Errorf("Offset() = %d, want it capped at 145", got)
+	}
+
+	var none *Watermark
+	none.Done(none.Add(10)) // Must not panic.
+}
+
+func TestSaveLoad(t *testing.T) {
+	set := ipset.NewBitmap()
+	for _, ip := range []uint32{1, 0x0A000001, 0xFFFFFFFF} {
+		set.Add(ip)
+	}
+	path := filepath.Join(t.TempDir(), "count.ckpt")
+	in := Input{Format: "csv:1", Size: 1 << 41, ModTime: time.Date(2026, time.March, 1, 12, 0, 0, 123, time.UTC)}
+	if err := (&Checkpoint{Offset: 1 << 40, Input: in, Set: set}).Save(path); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Offset != 1<<40 || c.Set.Count() != 3 || !c.Set.Contains(0x0A000001) {
+		t.Errorf("Load() = offset %d with %d IPs", c.Offset, c.Set.Count())
+	}
+	if err := c.Input.Match(in); err != nil {
+		t.Errorf("Load() = input %+v: %v", c.Input, err)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("Load() of a missing file succeeded")
+	}
+}
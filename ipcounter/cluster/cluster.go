@@ -0,0 +1,162 @@
+/*
+Package cluster counts files too large for one machine's disk bandwidth by
+splitting them across worker processes, possibly on several machines, and
+merging their bitsets into an exact global count.
+
+A Coordinator splits the input files into newline-aligned byte ranges and
+hands them out to the workers that connect to it over TCP. Each worker counts
+its ranges into its own bitset and, once no ranges are left, sends the bitset
+back as a snapshot (see package ipset), which stores sparse regions as sorted
+arrays. The coordinator ORs the snapshots together and counts the result.
+
+The input paths are opened by the workers, so they must name the same files on
+every machine, e.g. on a shared filesystem or as identical copies.
+
+If a worker disconnects before its snapshot has been merged, the ranges it
+counted go back to the queue for the remaining workers. Merging a partial
+snapshot is harmless: it only holds IPs that are in the input anyway.
+
+Protocol, one JSON message per line:
+
+	worker:      {"type":"hello","worker":NAME}
+	coordinator: {"type":"config","format":SPEC}
+	then, repeated:
+	worker:      {"type":"next"}
+	coordinator: {"type":"task","task":{...}}   count the range, then ask again
+	             {"type":"flush"}                send the bitset and start over
+	             {"type":"bye"}                  every range is merged, disconnect
+	after a flush:
+	worker:      {"type":"result","lines":N,"invalid":N} followed by a snapshot
+	a worker that cannot count a range instead sends
+	             {"type":"failed","task":{...},"error":MSG}
+*/
+package cluster
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DefaultRangeBytes is the nominal size of the byte ranges handed to workers.
+const DefaultRangeBytes = 256 * 1024 * 1024
+
+// Message types exchanged between the coordinator and the workers.
+const (
+	msgHello  = "hello"
+	msgConfig = "config"
+	msgNext   = "next"
+	msgTask   = "task"
+	msgFlush  = "flush"
+	msgBye    = "bye"
+	msgResult = "result"
+	msgFailed = "failed"
+)
+
+// Task is one byte range of an input file. Offset is 0 or just past a newline,
+// and the range ends just past a newline or at the end of the file.
+type Task struct {
+	ID     int    `json:"id"`
+	Path   string `json:"path"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// message is the envelope of every protocol message. Only the fields of its
+// type are set.
+type message struct {
+	Type    string `json:"type"`
+	Worker  string `json:"worker,omitempty"`
+	Format  string `json:"format,omitempty"`
+	Task    *Task  `json:"task,omitempty"`
+	Lines   int64  `json:"lines,omitempty"`
+	Invalid int64  `json:"invalid,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// send writes msg as one line.
+func send(w io.Writer, msg message) error {
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// receive reads the next message and checks that it is one of the wanted types.
+func receive(r *bufio.Reader, want ...string) (message, error) {
+	var msg message
+	line, err := r.ReadBytes('\n')
+	if err != nil {
+		if err == io.EOF && len(line) == 0 {
+			return msg, io.EOF
+		}
+		return msg, fmt.Errorf("failed to read message: %w", err)
+	}
+	if err := json.Unmarshal(line, &msg); err != nil {
+		return msg, fmt.Errorf("malformed message: %w", err)
+	}
+	for _, t := range want {
+		if msg.Type == t {
+			return msg, nil
+		}
+	}
+	return msg, fmt.Errorf("unexpected %q message, want one of %v", msg.Type, want)
+}
+
+// Split cuts the files into ranges of about rangeBytes each. Every boundary is
+// moved forward to just past the next newline, so no line is split; files
+// smaller than rangeBytes become a single range.
+func Split(paths []string, rangeBytes int64) ([]Task, error) {
+	if rangeBytes <= 0 {
+		rangeBytes = DefaultRangeBytes
+	}
+	var tasks []Task
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file: %w", err)
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to stat file: %w", err)
+		}
+		size := info.Size()
+		for start := int64(0); start < size; {
+			end, err := lineEnd(file, start+rangeBytes, size)
+			if err != nil {
+				file.Close()
+				return nil, fmt.Errorf("failed to split %s: %w", path, err)
+			}
+			tasks = append(tasks, Task{ID: len(tasks), Path: path, Offset: start, Length: end - start})
+			start = end
+		}
+		file.Close()
+	}
+	return tasks, nil
+}
+
+// lineEnd returns the first line start at or after off, or size if there is
+// none.
+func lineEnd(r io.ReaderAt, off, size int64) (int64, error) {
+	buf := make([]byte, 64*1024)
+	for off--; off < size; {
+		n, err := r.ReadAt(buf, off)
+		if i := bytes.IndexByte(buf[:n], '\n'); i >= 0 {
+			return off + int64(i) + 1, nil
+		}
+		if err != nil && err != io.EOF {
+			return 0, err
+		}
+		if n == 0 {
+			break
+		}
+		off += int64(n)
+	}
+	return size, nil
+}
@@ -0,0 +1,153 @@
+package cluster
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	dir := t.TempDir()
+	full := filepath.Join(dir, "full.txt")
+	os.WriteFile(full, []byte(strings.Repeat("10.0.0.1\n192.168.100.200\n", 100)), 0o644)
+	tail := filepath.Join(dir, "tail.txt")
+	os.WriteFile(tail, []byte("1.1.1.1\n2.2.2.2"), 0o644)
+	empty := filepath.Join(dir, "empty.txt")
+	os.WriteFile(empty, nil, 0o644)
+
+	tasks, err := Split([]string{full, empty, tail}, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sizes := map[string]int64{}
+	for i, task := range tasks {
+		if task.ID != i {
+			t.Errorf("task %d has ID %d", i, task.ID)
+		}
+		if task.Offset != sizes[task.Path] {
+			t.Errorf("task %+v does not start where the previous one ended (%d)", task, sizes[task.Path])
+		}
+		data, _ := os.ReadFile(task.Path)
+		end := task.Offset + task.Length
+		if end < int64(len(data)) && data[end-1] != '\n' {
+			t.Errorf("task %+v does not end at a newline", task)
+		}
+		sizes[task.Path] = end
+	}
+	if sizes[full] != 2500 || sizes[tail] != 15 || sizes[empty] != 0 {
+		t.Errorf("ranges cover %v, want the whole files", sizes)
+	}
+	if n := len(tasks); n != 26 {
+		t.Errorf("got %d tasks, want 25 for full.txt and 1 for tail.txt", n)
+	}
+}
+
+// quitter takes one range from the coordinator at addr and disconnects without
+// sending anything back.
+func quitter(t *testing.T, addr string) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	send(conn, message{Type: msgHello, Worker: "quitter"})
+	if _, err := receive(r, msgConfig); err != nil {
+		t.Fatal(err)
+	}
+	send(conn, message{Type: msgNext})
+	if _, err := receive(r, msgTask); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDistributedCount(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&b, "10.%d.%d.%d\n", i%7, i%251, i%199)
+	}
+	b.WriteString("junk\n203.0.113.9") // Last line without a newline.
+	path := filepath.Join(t.TempDir(), "ips.txt")
+	os.WriteFile(path, []byte(b.String()), 0o644)
+
+	want := map[string]bool{"203.0.113.9": true}
+	for i := 0; i < 20000; i++ {
+		want[fmt.Sprintf("10.%d.%d.%d", i%7, i%251, i%199)] = true
+	}
+
+	tasks, err := Split([]string{path}, 16*1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCoordinator(tasks, Config{Logf: t.Logf})
+	type outcome struct {
+		res *Result
+		err error
+	}
+	served := make(chan outcome, 1)
+	go func() {
+		res, err := c.Serve(context.Background(), ln)
+		served <- outcome{res, err}
+	}()
+
+	// The first range handed out is lost with its worker and must be redone.
+	quitter(t, ln.Addr().String())
+
+	const workers = 2
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		go func(i int) {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				errs <- err
+				return
+			}
+			errs <- Work(context.Background(), conn, fmt.Sprintf("w%d", i))
+		}(i)
+	}
+	for i := 0; i < workers; i++ {
+		if err := <-errs; err != nil {
+			t.Errorf("worker: %v", err)
+		}
+	}
+	out := <-served
+	if out.err != nil {
+		t.Fatal(out.err)
+	}
+	if out.res.Unique != int64(len(want)) {
+		t.Errorf("Unique = %d, want %d", out.res.Unique, len(want))
+	}
+	if out.res.Lines != 20002 || out.res.Invalid != 1 {
+		t.Errorf("Lines = %d, Invalid = %d, want 20002 and 1", out.res.Lines, out.res.Invalid)
+	}
+	if !out.res.Bitmap.Contains(0xCB007109) {
+		t.Errorf("merged bitmap lacks the unterminated last line")
+	}
+}
+
+func TestWorkerFailure(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(t.TempDir(), "missing.txt")
+	c := NewCoordinator([]Task{{Path: missing, Length: 10}}, Config{})
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err == nil {
+			Work(context.Background(), conn, "w")
+		}
+	}()
+	if _, err := c.Serve(context.Background(), ln); err == nil || !strings.Contains(err.Error(), "missing.txt") {
+		t.Errorf("Serve() error = %v, want the worker's failure", err)
+	}
+}
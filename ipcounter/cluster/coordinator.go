@@ -0,0 +1,267 @@
+package cluster
+
+import (
+	"IP-Addr-Counter/ipcounter/ipset"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// byeGrace is how long Serve waits for the workers to take their leave once
+// the count is done, before closing their connections.
+const byeGrace = 5 * time.Second
+
+// Config configures a Coordinator.
+type Config struct {
+	Format string                           // Input format spec passed to the workers, empty for bare IPs.
+	Logf   func(format string, args ...any) // Progress log, nil to stay quiet.
+}
+
+// Result is the outcome of a distributed count.
+type Result struct {
+	Bitmap  *ipset.Bitmap // Union of the workers' bitsets.
+	Unique  int64         // Distinct IPs over all ranges.
+	Lines   int64         // Non-empty lines counted by the workers whose bitsets were merged.
+	Invalid int64         // Non-empty lines without a valid IP among them.
+	Merged  int           // Snapshots merged.
+}
+
+// Coordinator hands out ranges to workers and merges their bitsets.
+type Coordinator struct {
+	cfg  Config
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	queue     []Task                // Ranges not yet handed out.
+	remaining int                   // Ranges not yet merged.
+	err       error                 // First fatal error; ends the count.
+	conns     map[net.Conn]struct{} // Open worker connections.
+	res       Result
+
+	mergeMu sync.Mutex // Serializes merges into res.Bitmap.
+}
+
+// NewCoordinator returns a coordinator for tasks, usually made by Split.
+func NewCoordinator(tasks []Task, cfg Config) *Coordinator {
+	c := &Coordinator{
+		cfg:       cfg,
+		queue:     append([]Task(nil), tasks...),
+		remaining: len(tasks),
+		conns:     make(map[net.Conn]struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// Serve accepts workers on ln until every range has been counted and merged,
+// then closes ln and returns the global count. It fails if a worker cannot
+// count a range or ctx is cancelled.
+func (c *Coordinator) Serve(ctx context.Context, ln net.Listener) (*Result, error) {
+	c.res.Bitmap = ipset.NewBitmap()
+	stop := context.AfterFunc(ctx, func() { c.fail(ctx.Err()) })
+	defer stop()
+
+	var wg sync.WaitGroup
+	accepting := make(chan struct{})
+	go func() {
+		defer close(accepting)
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					c.fail(fmt.Errorf("failed to accept worker: %w", err))
+				}
+				return
+			}
+			c.mu.Lock()
+			c.conns[conn] = struct{}{}
+			c.mu.Unlock()
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				c.handle(conn)
+			}()
+		}
+	}()
+
+	c.mu.Lock()
+	for c.remaining > 0 && c.err == nil {
+		c.cond.Wait()
+	}
+	err := c.err
+	c.mu.Unlock()
+	ln.Close()
+	<-accepting
+
+	// Idle workers are told goodbye on their next request; give them a
+	// moment before hanging up on the rest.
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	if err == nil {
+		select {
+		case <-done:
+		case <-time.After(byeGrace):
+		}
+	}
+	c.mu.Lock()
+	for conn := range c.conns {
+		conn.Close()
+	}
+	c.mu.Unlock()
+	<-done
+
+	if err != nil {
+		return nil, err
+	}
+	c.res.Unique = c.res.Bitmap.Count()
+	return &c.res, nil
+}
+
+// fail records the first fatal error and wakes everyone up.
+func (c *Coordinator) fail(err error) {
+	c.mu.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.cond.Broadcast()
+	c.mu.Unlock()
+}
+
+// logf logs a progress message if logging is enabled.
+func (c *Coordinator) logf(format string, args ...any) {
+	if c.cfg.Logf != nil {
+		c.cfg.Logf(format, args...)
+	}
+}
+
+// next decides what a worker holding unmerged ranges (or not) does next: count
+// another range, flush its bitset, or leave. It waits while ranges held by other
+// workers may still come back to the queue.
+func (c *Coordinator) next(holding bool) (Task, string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		switch {
+		case c.err != nil:
+			return Task{}, msgBye
+		case len(c.queue) > 0:
+			t := c.queue[0]
+			c.queue = c.queue[1:]
+			return t, msgTask
+		case holding:
+			return Task{}, msgFlush
+		case c.remaining == 0:
+			return Task{}, msgBye
+		}
+		c.cond.Wait()
+	}
+}
+
+// handle runs the protocol with one worker. Ranges the worker has counted but
+// not yet sent back are requeued if it goes away.
+func (c *Coordinator) handle(conn net.Conn) {
+	var held []Task
+	name := conn.RemoteAddr().String()
+	defer func() {
+		conn.Close()
+		c.mu.Lock()
+		delete(c.conns, conn)
+		if len(held) > 0 {
+			c.queue = append(c.queue, held...)
+			c.cond.Broadcast()
+		}
+		c.mu.Unlock()
+		if len(held) > 0 {
+			c.logf("worker %s left, requeueing %d ranges", name, len(held))
+		}
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	reply := func(msg message) error {
+		if err := send(w, msg); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	hello, err := receive(r, msgHello)
+	if err != nil {
+		c.logf("worker %s: %v", name, err)
+		return
+	}
+	if hello.Worker != "" {
+		name = hello.Worker
+	}
+	c.logf("worker %s connected", name)
+	if err := reply(message{Type: msgConfig, Format: c.cfg.Format}); err != nil {
+		return
+	}
+
+	flushing := false
+	for {
+		want := []string{msgNext, msgFailed}
+		if flushing {
+			want = []string{msgResult, msgFailed}
+		}
+		msg, err := receive(r, want...)
+		if err != nil {
+			c.logf("worker %s: %v", name, err)
+			return
+		}
+
+		switch msg.Type {
+		case msgFailed:
+			path := "?"
+			if msg.Task != nil {
+				path = fmt.Sprintf("%s at %d", msg.Task.Path, msg.Task.Offset)
+			}
+			c.fail(fmt.Errorf("worker %s failed on %s: %s", name, path, msg.Error))
+			return
+
+		case msgResult:
+			c.mergeMu.Lock()
+			_, err := c.res.Bitmap.MergeFrom(r)
+			c.mergeMu.Unlock()
+			if err != nil {
+				c.logf("worker %s: failed to merge bitset: %v", name, err)
+				return
+			}
+			c.mu.Lock()
+			c.remaining -= len(held)
+			c.res.Lines += msg.Lines
+			c.res.Invalid += msg.Invalid
+			c.res.Merged++
+			c.cond.Broadcast()
+			c.mu.Unlock()
+			c.logf("merged %d ranges from worker %s", len(held), name)
+			held = nil
+			flushing = false
+
+		case msgNext:
+			t, action := c.next(len(held) > 0)
+			switch action {
+			case msgTask:
+				held = append(held, t)
+				err = reply(message{Type: msgTask, Task: &t})
+			case msgFlush:
+				flushing = true
+				err = reply(message{Type: msgFlush})
+			case msgBye:
+				reply(message{Type: msgBye})
+				return
+			}
+			if err != nil {
+				c.logf("worker %s: %v", name, err)
+				return
+			}
+		}
+	}
+}
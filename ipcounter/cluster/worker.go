@@ -0,0 +1,104 @@
+package cluster
+
+import (
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/format"
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+)
+
+// Work runs a worker on conn, a connection to a coordinator, until the
+// coordinator has no more ranges for it. Each range is counted on all CPU
+// cores into a concurrent bitset, which is sent back when the coordinator asks
+// for it. Cancelling ctx closes conn.
+func Work(ctx context.Context, conn net.Conn, name string) error {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	say := func(msg message) error {
+		if err := send(w, msg); err != nil {
+			return err
+		}
+		return w.Flush()
+	}
+
+	if err := say(message{Type: msgHello, Worker: name}); err != nil {
+		return fmt.Errorf("failed to greet coordinator: %w", err)
+	}
+	cfg, err := receive(r, msgConfig)
+	if err != nil {
+		return err
+	}
+	var extract format.Extractor
+	if cfg.Format != "" && cfg.Format != "plain" {
+		if extract, err = format.Parse(cfg.Format); err != nil {
+			return err
+		}
+	}
+
+	var counter *concurrent.BitsetCounter
+	for {
+		if err := say(message{Type: msgNext}); err != nil {
+			return err
+		}
+		msg, err := receive(r, msgTask, msgFlush, msgBye)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		switch msg.Type {
+		case msgTask:
+			if msg.Task == nil {
+				return fmt.Errorf("task message without a task")
+			}
+			if counter == nil {
+				counter = concurrent.New()
+				counter.UseFormat(extract)
+			}
+			if err := countRange(counter, *msg.Task); err != nil {
+				say(message{Type: msgFailed, Task: msg.Task, Error: err.Error()})
+				return err
+			}
+
+		case msgFlush:
+			if counter == nil {
+				return fmt.Errorf("asked to flush before counting anything")
+			}
+			m := counter.Metrics()
+			if err := say(message{Type: msgResult, Lines: m.Lines.Load(), Invalid: m.Invalid.Load()}); err != nil {
+				return err
+			}
+			if _, err := counter.Bitmap().WriteTo(w); err != nil {
+				return fmt.Errorf("failed to send bitset: %w", err)
+			}
+			if err := w.Flush(); err != nil {
+				return fmt.Errorf("failed to send bitset: %w", err)
+			}
+			counter = nil // Any further ranges start a fresh bitset.
+
+		case msgBye:
+			return nil
+		}
+	}
+}
+
+// countRange counts the lines of one range into counter.
+func countRange(counter *concurrent.BitsetCounter, t Task) error {
+	file, err := os.Open(t.Path)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	_, err = counter.Count(io.NewSectionReader(file, t.Offset, t.Length))
+	return err
+}
@@ -4,7 +4,10 @@ Package concurrent provides an efficient implementation for counting unique IPv4
 It reads a file containing one IPv4 address per line, processes the file in chunks using
 multiple goroutines, and tracks uniqueness with a sharded bitset to minimize memory usage.
 Atomic operations ensure thread-safe bitset updates, eliminating lock contention. A sync.Pool
-reuses buffers to reduce memory allocation overhead.
+reuses buffers to reduce memory allocation overhead. The reader, worker pool and counter are
+the shared pipeline package; this package supplies the parser, utils.ParseIPv4, and
+the set, an ipset.Sharded updated with compare-and-swap. With tuning.Deferred, counts
+set bits with a plain atomic OR instead and popcount the bitset at the end.
 
 Pros:
 - Memory-efficient due to bitset usage (512MB for 2^32 IPs, divided across shards).
@@ -18,203 +21,109 @@ Cons:
 package concurrent
 
 import (
+	"IP-Addr-Counter/ipcounter/ipset"
+	"IP-Addr-Counter/ipcounter/pipeline"
+	"IP-Addr-Counter/ipcounter/tuning"
 	"IP-Addr-Counter/ipcounter/utils"
-	"bufio"
 	"bytes"
-	"fmt"
 	"io"
-	"os"
-	"runtime"
 	"sync"
 	"sync/atomic"
-	"unsafe"
+	"time"
 )
 
-// Constants defining configuration for the concurrent implementation.
+// Constants defining configuration for the concurrent implementation. The
+// chunk size, queue length and shard count are set with tuning options.
 const (
-	maxIPv4       = 1 << 32          // Total number of possible IPv4 addresses (2^32).
-	bytesPerChunk = 16 * 1024 * 1024 // Size of each file chunk (16MB) for reading.
-	chunkQueueLen = 128              // Buffered channel size for chunk processing.
-	numShards     = 16384            // Number of shards to distribute IP addresses.
+	maxIPv4          = 1 << 32     // Total number of possible IPv4 addresses (2^32).
+	minParallelChunk = 1024 * 1024 // Smallest chunk AddChunk splits across workers.
 )
 
-// shard represents a portion of the bitset for storing unique IPs.
-type shard struct {
-	bitset []byte // Bitset for storing unique IPs in this shard, updated atomically.
-}
-
-// BitsetCounter manages a sharded bitset for counting unique IPs.
+// BitsetCounter manages a sharded bitset for counting unique IPs. Counts,
+// queries, formats, heavy hitters and checkpoints are those of the embedded
+// pipeline.Counter.
 type BitsetCounter struct {
-	shards []*shard // Array of shards, each covering a subset of the IP space.
+	*pipeline.Counter
 }
 
-// New initializes a BitsetCounter with pre-allocated shards.
-func New() *BitsetCounter {
-	// Calculate size of each shard's bitset (2^32 bits / 8 / numShards).
-	shardSize := maxIPv4 / 8 / numShards
-	shards := make([]*shard, numShards)
-	for i := 0; i < numShards; i++ {
-		shards[i] = &shard{
-			bitset: make([]byte, shardSize), // Allocate bitset for this shard.
-		}
+// parser parses bare IPs with utils.ParseIPv4.
+var parser = pipeline.ParserFunc(func(field []byte) (uint32, bool) {
+	ip, err := utils.ParseIPv4(field)
+	return ip, err == nil
+})
+
+// New initializes a BitsetCounter with pre-allocated shards, using the default
+// settings changed by opts. It panics if the settings are invalid; validate
+// user input with tuning.New first.
+func New(opts ...tuning.Option) *BitsetCounter {
+	o, err := tuning.New(opts...)
+	if err != nil {
+		panic("concurrent: " + err.Error())
 	}
-	return &BitsetCounter{shards: shards}
+	set := ipset.NewShardedIn(o.Shards, o.Layout, o.Alloc(BitsetBytes))
+	c := pipeline.NewCounter(set, parser, o)
+	c.UseMetrics(&Metrics{})
+	return &BitsetCounter{c}
 }
 
-// setBit atomically sets a bit in the shard's bitset for the given offset.
-// Returns true if the bit was newly set (indicating a unique IP), false if already set.
-func setBit(s *shard, offset uint32) bool {
-	byteIndex := offset / 8
-	bitIndex := offset % 8
-	mask := byte(1 << bitIndex)
-
-	// Calculate the uint32-aligned index and bit offset within it
-	wordIndex := byteIndex / 4
-	byteOffset := byteIndex % 4
-	wordMask := uint32(mask) << (byteOffset * 8)
-
-	// Get the uint32 pointer using unsafe.Pointer
-	ptr := (*uint32)(unsafe.Pointer(&s.bitset[wordIndex*4]))
-
-	for {
-		old := atomic.LoadUint32(ptr)
-		if old&wordMask != 0 {
-			return false // Bit already set, IP is not unique.
-		}
-		new := old | wordMask
-		if atomic.CompareAndSwapUint32(ptr, old, new) {
-			return true // Bit newly set, IP is unique.
-		}
+// Add marks a single ip as seen and reports whether it was new. It is safe for
+// concurrent use, so streams that deliver one IP at a time can share the bitset.
+func (b *BitsetCounter) Add(ip uint32) bool {
+	stats := b.Metrics()
+	stats.Lines.Add(1)
+	if b.Insert(ip) {
+		stats.Unique.Add(1)
+		return true
 	}
+	return false
 }
 
-// CountUniqueIPs counts unique IPv4 addresses in the specified file.
-// It reads the file in chunks, processes them concurrently using multiple goroutines,
-// and aggregates the count of unique IPs using a sharded bitset with atomic updates.
-func (b *BitsetCounter) CountUniqueIPs(filename string) (int64, error) {
-	// Open the input file for reading.
-	file, err := os.Open(filename)
-	if err != nil {
-		return 0, fmt.Errorf("failed to open file: %w", err)
-	}
-	defer file.Close()
-
-	// Create a buffered reader for efficient file reading.
-	reader := bufio.NewReader(file)
-	// Channels for distributing chunks to workers and collecting results.
-	chunkChan := make(chan []byte, chunkQueueLen)
-	resultChan := make(chan int64, chunkQueueLen)
-
-	// Initialize a sync.Pool to reuse chunk buffers and reduce allocations.
-	bufPool := sync.Pool{
-		New: func() interface{} {
-			return make([]byte, bytesPerChunk)
-		},
+// AddChunk counts the lines of chunk into the bitset and returns the number of
+// IPs that were not seen before. Every line must be newline-terminated. Large
+// chunks are split at newlines and processed by all CPU cores. Since the bitset
+// is kept between calls, a long-lived counter can be fed chunk by chunk to keep
+// a running count of a stream.
+func (b *BitsetCounter) AddChunk(chunk []byte) int64 {
+	stats := b.Metrics()
+	stats.Bytes.Add(int64(len(chunk)))
+	numWorkers := b.Options().NumWorkers()
+	if numWorkers == 1 || len(chunk) < minParallelChunk {
+		start := time.Now()
+		defer func() { stats.BusyNanos.Add(int64(time.Since(start))) }()
+		return b.AddLines(chunk)
 	}
 
-	// Set number of workers to CPU core count for optimal parallelism.
-	numWorkers := runtime.NumCPU()
-	runtime.GOMAXPROCS(numWorkers)
-
-	// Start worker goroutines to process chunks concurrently.
+	var total atomic.Int64
 	var wg sync.WaitGroup
-	for i := 0; i < numWorkers; i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for chunk := range chunkChan {
-				// Process chunk and count unique IPs.
-				count := processChunk(chunk, b)
-				resultChan <- count
-				// Return buffer to pool for reuse.
-				bufPool.Put(chunk)
-			}
-		}()
-	}
-
-	// Start a goroutine to aggregate results from workers.
-	var resultWg sync.WaitGroup
-	resultWg.Add(1)
-	var total int64
-	go func() {
-		defer resultWg.Done()
-		for c := range resultChan {
-			total += c // Sum unique IP counts from all chunks.
-		}
-	}()
-
-	// Read file in chunks and distribute to workers.
-	for {
-		// Get a buffer from the pool.
-		buf := bufPool.Get().([]byte)
-		n, err := io.ReadFull(reader, buf)
-		if err == io.EOF || err == io.ErrUnexpectedEOF {
-			if n > 0 {
-				// Read until newline to avoid splitting IP addresses.
-				rem, _ := reader.ReadBytes('\n')
-				buf = append(buf[:n], rem...)
-				chunkChan <- buf // Send chunk to workers without copying.
-			} else {
-				bufPool.Put(buf) // Return unused buffer.
-			}
-			break
-		}
-		if err != nil {
-			bufPool.Put(buf)
-			return 0, fmt.Errorf("read error: %w", err)
-		}
-
-		// Extend chunk to include complete IP addresses (until newline).
-		rem, _ := reader.ReadBytes('\n')
-		if len(rem) > 0 {
-			buf = append(buf[:n], rem...)
+	partSize := len(chunk)/numWorkers + 1
+	for start := 0; start < len(chunk); {
+		end := start + partSize
+		if end >= len(chunk) {
+			end = len(chunk)
+		} else if nl := bytes.IndexByte(chunk[end:], '\n'); nl >= 0 {
+			end += nl + 1
 		} else {
-			buf = buf[:n]
+			end = len(chunk)
 		}
-
-		// Send chunk to workers without copying to avoid allocations.
-		chunkChan <- buf
+		wg.Add(1)
+		go func(part []byte) {
+			defer wg.Done()
+			began := time.Now()
+			total.Add(b.AddLines(part))
+			stats.BusyNanos.Add(int64(time.Since(began)))
+		}(chunk[start:end])
+		start = end
 	}
-
-	// Close channels and wait for workers to finish.
-	close(chunkChan)
 	wg.Wait()
-	close(resultChan)
-	resultWg.Wait()
-
-	return total, nil
+	return total.Load()
 }
 
-// processChunk processes a chunk of the input file, parsing IPv4 addresses
-// and updating the sharded bitset to count unique IPs using atomic operations.
-// Returns the number of new unique IPs found in the chunk.
-func processChunk(chunk []byte, b *BitsetCounter) int64 {
-	var count int64
-	start := 0
-	for i, c := range chunk {
-		if c == '\n' {
-			// Extract and trim the current line (IP address).
-			line := bytes.TrimSpace(chunk[start:i])
-			start = i + 1
-			if len(line) == 0 {
-				continue // Skip empty lines.
-			}
-			// Parse IP address to uint32 using optimized byte-based parser.
-			ipInt, err := utils.ParseIPv4(line)
-			if err != nil {
-				continue // Skip invalid IPs.
-			}
-
-			// Determine shard and bit position for the IP.
-			shardIdx := ipInt % numShards
-			s := b.shards[shardIdx]
-			offset := ipInt / numShards
-
-			// Atomically update bitset to mark IP as seen.
-			if setBit(s, offset) {
-				count++ // Increment count for new IPs.
-			}
-		}
-	}
-	return count
+// ProcessChunks reads r in chunks and calls process for each of them on one of
+// numWorkers goroutines. Chunks hold whole newline-terminated lines. The worker
+// index lets callers keep per-worker state without locking. A chunk is only
+// valid during the call; its buffer is reused afterwards.
+func ProcessChunks(r io.Reader, numWorkers int, process func(worker int, chunk []byte)) error {
+	o := tuning.Default()
+	o.Workers = numWorkers
+	return pipeline.Run(r, pipeline.Config{Options: o}, process)
 }
@@ -0,0 +1,206 @@
+package concurrent
+
+import (
+	"IP-Addr-Counter/ipcounter/pipeline"
+	"IP-Addr-Counter/ipcounter/utils"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"unicode"
+)
+
+// Dedup works like a streaming uniq for IPs: it copies each line of r to w the
+// first time its IP is seen, and drops repeats and invalid lines. With a format
+// set by UseFormat, whole records are copied and only their IP field is
+// compared. It shares the bitset with CountUniqueIPs, so IPs seen by an earlier
+// call are dropped too. Returns the number of lines written.
+//
+// Chunks are processed concurrently. Without ordered, each chunk is written as
+// soon as its worker finishes, so lines keep their input order within a chunk
+// but chunks may be interleaved. With ordered, workers only parse; the bits are
+// then set chunk by chunk in file order, so the output is exactly the first
+// occurrence of every IP, in file order.
+func (b *BitsetCounter) Dedup(r io.Reader, w io.Writer, ordered bool) (int64, error) {
+	numWorkers := b.Options().NumWorkers()
+	if ordered {
+		return b.dedupOrdered(r, w, numWorkers)
+	}
+
+	var (
+		mu       sync.Mutex // Serializes writes to w and guards total and writeErr.
+		total    int64
+		writeErr error
+	)
+	outs := make([][]byte, numWorkers) // Per-worker output buffers, reused across chunks.
+	readErr := pipeline.Run(r, pipeline.Config{Options: b.Options()}, func(worker int, chunk []byte) {
+		var n int64
+		outs[worker], n = dedupChunk(chunk, b, outs[worker][:0])
+
+		mu.Lock()
+		if writeErr == nil {
+			_, writeErr = w.Write(outs[worker])
+		}
+		total += n
+		mu.Unlock()
+	})
+
+	if readErr != nil {
+		return total, readErr
+	}
+	if writeErr != nil {
+		return total, fmt.Errorf("write error: %w", writeErr)
+	}
+	return total, nil
+}
+
+// dedupChunk appends to out every line of chunk whose IP is new, each followed
+// by a newline, and returns out and the number of lines appended.
+func dedupChunk(chunk []byte, b *BitsetCounter, out []byte) ([]byte, int64) {
+	var count int64
+	start := 0
+	for i, c := range chunk {
+		if c == '\n' {
+			line := bytes.TrimSpace(chunk[start:i])
+			start = i + 1
+			if len(line) == 0 {
+				continue // Skip empty lines.
+			}
+			ipInt, err := utils.ParseIPv4(b.Field(line))
+			if err != nil {
+				continue // Skip invalid IPs.
+			}
+			if b.Insert(ipInt) {
+				out = append(out, line...)
+				out = append(out, '\n')
+				count++
+			}
+		}
+	}
+	return out, count
+}
+
+// parsedChunk is a chunk whose lines have been parsed but not yet inserted.
+type parsedChunk struct {
+	seq   int         // Position of the chunk in the input.
+	data  []byte      // The raw chunk, returned to the pool once written.
+	ips   []uint32    // Parsed IP of each valid line.
+	lines [][2]uint32 // Start and end of each valid line's trimmed text in data.
+}
+
+// dedupOrdered runs Dedup in file order. Workers parse chunks concurrently and
+// a single sequencer inserts and writes them in the order they were read.
+func (b *BitsetCounter) dedupOrdered(r io.Reader, w io.Writer, numWorkers int) (int64, error) {
+	reader := bufio.NewReader(r)
+	bufPool := pipeline.NewBufferPool(b.Options().ChunkBytes)
+	type seqChunk struct {
+		seq  int
+		data []byte
+	}
+	// Parsed chunks are several times larger than raw ones, so keep fewer in flight.
+	chunkChan := make(chan seqChunk, numWorkers)
+	parsedChan := make(chan *parsedChunk, numWorkers)
+	// A slot is taken before each chunk is read and freed once it is written,
+	// so a slow chunk stalls the reader instead of piling later ones up in
+	// pending.
+	slots := make(chan struct{}, numWorkers+b.Options().Queue())
+
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range chunkChan {
+				parsedChan <- b.parseChunk(c.seq, c.data)
+			}
+		}()
+	}
+
+	// The sequencer holds chunks that finished early until their turn comes.
+	var (
+		total    int64
+		writeErr error
+		done     = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		out := bufio.NewWriterSize(w, 1<<20)
+		pending := make(map[int]*parsedChunk)
+		next := 0
+		for p := range parsedChan {
+			pending[p.seq] = p
+			for {
+				p, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+				for i, ip := range p.ips {
+					if b.Insert(ip) {
+						total++
+						if writeErr == nil {
+							out.Write(p.data[p.lines[i][0]:p.lines[i][1]])
+							writeErr = out.WriteByte('\n')
+						}
+					}
+				}
+				bufPool.Put(p.data)
+				<-slots
+			}
+		}
+		if err := out.Flush(); writeErr == nil {
+			writeErr = err
+		}
+	}()
+
+	seq := 0
+	slots <- struct{}{}
+	readErr := pipeline.ReadChunks(reader, bufPool, func(chunk []byte) {
+		chunkChan <- seqChunk{seq: seq, data: chunk}
+		seq++
+		slots <- struct{}{} // For the next chunk.
+	})
+	close(chunkChan)
+	wg.Wait()
+	close(parsedChan)
+	<-done
+
+	if readErr != nil {
+		return total, readErr
+	}
+	if writeErr != nil {
+		return total, fmt.Errorf("write error: %w", writeErr)
+	}
+	return total, nil
+}
+
+// parseChunk parses every valid line of a chunk without touching the bitset.
+func (b *BitsetCounter) parseChunk(seq int, chunk []byte) *parsedChunk {
+	p := &parsedChunk{seq: seq, data: chunk}
+	start := 0
+	for i, c := range chunk {
+		if c == '\n' {
+			lineStart, lineEnd := trimBounds(chunk, start, i)
+			start = i + 1
+			if lineStart == lineEnd {
+				continue // Skip empty lines.
+			}
+			ipInt, err := utils.ParseIPv4(b.Field(chunk[lineStart:lineEnd]))
+			if err != nil {
+				continue // Skip invalid IPs.
+			}
+			p.ips = append(p.ips, ipInt)
+			p.lines = append(p.lines, [2]uint32{uint32(lineStart), uint32(lineEnd)})
+		}
+	}
+	return p
+}
+
+// trimBounds returns the bounds of chunk[start:end] without surrounding whitespace.
+func trimBounds(chunk []byte, start, end int) (int, int) {
+	lead := end - start - len(bytes.TrimLeftFunc(chunk[start:end], unicode.IsSpace))
+	n := len(bytes.TrimSpace(chunk[start:end]))
+	return start + lead, start + lead + n
+}
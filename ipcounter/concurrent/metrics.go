@@ -0,0 +1,24 @@
+package concurrent
+
+import (
+	"IP-Addr-Counter/ipcounter/metrics"
+	"IP-Addr-Counter/ipcounter/pipeline"
+)
+
+// BitsetBytes is the memory held by the sharded bitset of one BitsetCounter.
+const BitsetBytes = maxIPv4 / 8
+
+// Metrics holds the running totals of a counter for monitoring.
+type Metrics = pipeline.Metrics
+
+// RegisterMetrics exposes the counter's totals and bitset memory in r.
+func (b *BitsetCounter) RegisterMetrics(r *metrics.Registry) {
+	b.Metrics().Register(r)
+	r.GaugeFunc("ipcounter_bitset_bytes", "Memory held by the bitset.",
+		func() float64 { return BitsetBytes })
+	mem := b.Memory()
+	r.GaugeFunc("ipcounter_bitset_page_bytes", "Size of the pages most of the bitset is on.",
+		func() float64 { return float64(mem.PageSize) })
+	r.GaugeFunc("ipcounter_bitset_numa_nodes", "NUMA nodes the bitset pages are interleaved over.",
+		func() float64 { return float64(mem.Nodes) })
+}
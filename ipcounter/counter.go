@@ -4,3 +4,10 @@ package ipcounter
 type Counter interface {
 	CountUniqueIPs(filename string) (int64, error)
 }
+
+// Set is implemented by the counters whose seen IPs can be queried after a
+// count, and by loaded snapshots.
+type Set interface {
+	Contains(ip uint32) bool
+	ContainsAll(ips []uint32) []bool
+}
@@ -0,0 +1,226 @@
+/*
+Package follow keeps a live distinct-IP count of a log file that is still being
+written, like `tail -F` feeding the concurrent counter.
+
+A Follower reads whatever has been appended since the last read, hands the
+complete lines to one long-lived concurrent.BitsetCounter, and carries a partial
+last line over to the next read. When it reaches the end of the file it polls
+for more data, and checks whether the file was truncated (size dropped below
+the read offset) or rotated (the path now names a different inode). A rotated
+file is read to its end before the new one is opened.
+
+Pros:
+- One bitset for the whole life of the log, across rotations and truncations.
+- Catching up on a large existing file still uses every CPU core.
+
+Cons:
+- Polling adds up to one poll interval of latency.
+- A file truncated and refilled past the old offset within one poll is missed.
+*/
+package follow
+
+import (
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// Constants defining the default follow behaviour.
+const (
+	readSize    = 16 * 1024 * 1024       // Largest read handed to the counter at once (16MB).
+	maxLine     = 1024 * 1024            // Longest partial line carried over between reads (1MB).
+	defaultPoll = 250 * time.Millisecond // How long to wait at the end of the file before checking again.
+)
+
+// Stats is a point-in-time view of a Follower.
+type Stats struct {
+	Unique      int64 // Distinct IPs seen so far.
+	Bytes       int64 // Bytes read across all files.
+	Rotations   int64 // Times the path was found to name a new file.
+	Truncations int64 // Times the file shrank below the read offset.
+}
+
+// Follower tails a file and counts the distinct IPs of every complete line.
+type Follower struct {
+	path    string
+	counter *concurrent.BitsetCounter
+	poll    time.Duration
+
+	unique      atomic.Int64
+	bytes       atomic.Int64
+	rotations   atomic.Int64
+	truncations atomic.Int64
+
+	skipping bool // Dropping the rest of a line longer than maxLine.
+}
+
+// New returns a Follower for path that feeds counter. The counter is kept for
+// the whole run, so it may already hold IPs from an earlier count.
+func New(path string, counter *concurrent.BitsetCounter) *Follower {
+	return &Follower{path: path, counter: counter, poll: defaultPoll}
+}
+
+// SetPoll changes how long the Follower waits at the end of the file before
+// checking for new data, rotation or truncation.
+func (f *Follower) SetPoll(d time.Duration) {
+	f.poll = d
+}
+
+// Stats returns the current counts. It is safe to call while Run is running.
+func (f *Follower) Stats() Stats {
+	return Stats{
+		Unique:      f.unique.Load(),
+		Bytes:       f.bytes.Load(),
+		Rotations:   f.rotations.Load(),
+		Truncations: f.truncations.Load(),
+	}
+}
+
+// Run follows the file until ctx is cancelled. If the file does not exist yet,
+// Run waits for it to appear. A partial last line is counted only once it is
+// completed, or once its file has been rotated away. A line that grows past
+// maxLine before it is completed is dropped and counted as invalid.
+func (f *Follower) Run(ctx context.Context) error {
+	buf := make([]byte, readSize)
+	var (
+		file    *os.File
+		info    os.FileInfo
+		offset  int64
+		partial []byte // Unterminated tail of the last read.
+	)
+	defer func() {
+		if file != nil {
+			file.Close()
+		}
+	}()
+
+	for {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if file == nil {
+			var err error
+			file, info, err = open(f.path)
+			if errors.Is(err, fs.ErrNotExist) {
+				if !sleep(ctx, f.poll) {
+					return nil
+				}
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			offset = 0
+		}
+
+		n, err := file.Read(buf)
+		if n > 0 {
+			offset += int64(n)
+			f.bytes.Add(int64(n))
+			partial = f.consume(partial, buf[:n])
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("read error: %w", err)
+		}
+
+		// At the end of the file: look for rotation or truncation before waiting.
+		current, err := os.Stat(f.path)
+		switch {
+		case err == nil && !os.SameFile(info, current):
+			// Rotated, and the old file has been read to its end.
+			if len(partial) > 0 {
+				partial = f.consume(partial, []byte{'\n'})
+			}
+			file.Close()
+			file = nil
+			f.rotations.Add(1)
+			continue
+		case err == nil && current.Size() < offset:
+			// Truncated in place: start over, the partial line is gone.
+			if _, err := file.Seek(0, io.SeekStart); err != nil {
+				return fmt.Errorf("failed to rewind truncated file: %w", err)
+			}
+			offset = 0
+			partial = partial[:0]
+			f.skipping = false
+			f.truncations.Add(1)
+			continue
+		}
+		// Either no change, or the path is briefly missing mid-rotation.
+		if !sleep(ctx, f.poll) {
+			return nil
+		}
+	}
+}
+
+// consume counts the complete lines of partial+data and returns the new
+// unterminated tail. partial's storage is reused for the tail.
+func (f *Follower) consume(partial, data []byte) []byte {
+	if f.skipping {
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			return partial
+		}
+		f.skipping = false
+		data = data[end+1:]
+	}
+	last := bytes.LastIndexByte(data, '\n')
+	if last < 0 {
+		return f.carry(partial, data)
+	}
+
+	lines := data[:last+1]
+	if len(partial) > 0 {
+		lines = append(partial, lines...)
+	}
+	f.unique.Add(f.counter.AddChunk(lines))
+	return f.carry(partial[:0], data[last+1:])
+}
+
+// carry appends tail to partial, unless the line would exceed maxLine: then it
+// is counted as invalid and the rest of it is skipped.
+func (f *Follower) carry(partial, tail []byte) []byte {
+	if len(partial)+len(tail) <= maxLine {
+		return append(partial, tail...)
+	}
+	stats := f.counter.Metrics()
+	stats.Lines.Add(1)
+	stats.Invalid.Add(1)
+	f.skipping = true
+	return partial[:0]
+}
+
+// open opens path and returns its identity for rotation checks.
+func open(path string) (*os.File, os.FileInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+	return file, info, nil
+}
+
+// sleep waits for d and reports false if ctx was cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
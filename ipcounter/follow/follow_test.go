@@ -0,0 +1,105 @@
+package follow
+
+import (
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFollowerHandlesPartialLinesRotationAndTruncation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	f := New(path, concurrent.New())
+	f.SetPoll(10 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- f.Run(ctx) }()
+
+	// The file does not exist yet; the follower waits for it.
+	writeFile(t, path, "10.0.0.1\n10.0.0.2\n10.0.0")
+	waitFor(t, f, func(s Stats) bool { return s.Unique == 2 })
+
+	// The partial line is completed by the next write.
+	appendFile(t, path, ".3\n10.0.0.1\n")
+	waitFor(t, f, func(s Stats) bool { return s.Unique == 3 })
+
+	// Rotation: the unterminated tail of the old file still counts.
+	appendFile(t, path, "10.0.0.4")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("rename failed: %v", err)
+	}
+	writeFile(t, path, "10.0.0.5\n")
+	waitFor(t, f, func(s Stats) bool { return s.Unique == 5 && s.Rotations == 1 })
+
+	// Truncation in place, then new data from the start.
+	writeFile(t, path, "")
+	waitFor(t, f, func(s Stats) bool { return s.Truncations == 1 })
+	writeFile(t, path, "10.0.0.6\n10.0.0.5\n")
+	waitFor(t, f, func(s Stats) bool { return s.Unique == 6 })
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run returned %v", err)
+	}
+}
+
+func TestFollowerDropsOverlongLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	counter := concurrent.New()
+	f := New(path, counter)
+	f.SetPoll(10 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- f.Run(ctx) }()
+
+	// A line that never ends within maxLine is dropped, in pieces as they
+	// arrive, and the lines after it still count.
+	writeFile(t, path, "10.0.0.1\n"+strings.Repeat("x", maxLine))
+	appendFile(t, path, strings.Repeat("x", maxLine))
+	appendFile(t, path, "10.0.0.2\n10.0.0.3\n")
+	waitFor(t, f, func(s Stats) bool { return s.Unique == 2 })
+	if invalid := counter.Metrics().Invalid.Load(); invalid != 1 {
+		t.Errorf("%d invalid lines, want the overlong one", invalid)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("Run returned %v", err)
+	}
+}
+
+// waitFor polls the follower's stats until cond holds or a deadline passes.
+func waitFor(t *testing.T, f *Follower, cond func(Stats) bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond(f.Stats()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out; stats = %+v", f.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+}
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open failed: %v", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatalf("append failed: %v", err)
+	}
+}
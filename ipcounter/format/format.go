@@ -0,0 +1,217 @@
+/*
+Package format extracts the IP field from input records that are not one bare
+IPv4 address per line: access logs, JSON lines, CSV exports, or anything a
+regular expression can match.
+
+Extractors return a sub-slice of the record instead of a copy, so they can sit
+in the hot path of the concurrent and asm counters without allocating.
+
+Supported formats, as accepted by Parse:
+- plain: the whole line (the default).
+- combined: the first whitespace-separated field, as in Apache/Nginx access logs.
+- json:KEY: the string value of KEY in a flat JSON object per line.
+- csv:N: column N (from 0) of a comma-separated line; csv:N:SEP uses another separator.
+- regex:EXPR: the first capture group of EXPR, or the group named "ip" if there is one.
+*/
+package format
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Extractor pulls the IP field out of one input record.
+type Extractor interface {
+	// Extract returns the IP field of line as a sub-slice of it, or nil if the
+	// record has no such field.
+	Extract(line []byte) []byte
+}
+
+// Parse returns the Extractor described by spec.
+func Parse(spec string) (Extractor, error) {
+	name, arg, _ := strings.Cut(spec, ":")
+	switch name {
+	case "", "plain":
+		return Plain{}, nil
+	case "combined":
+		return Combined{}, nil
+	case "json":
+		if arg == "" {
+			return nil, fmt.Errorf("json format needs a key, e.g. json:client_ip")
+		}
+		return NewJSON(arg), nil
+	case "csv":
+		col, sep, _ := strings.Cut(arg, ":")
+		n, err := strconv.Atoi(col)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("csv format needs a column index, e.g. csv:0")
+		}
+		c := CSV{Column: n, Separator: ','}
+		if sep != "" {
+			if len(sep) != 1 {
+				return nil, fmt.Errorf("csv separator must be a single byte: %q", sep)
+			}
+			c.Separator = sep[0]
+		}
+		return c, nil
+	case "regex":
+		return NewRegex(arg)
+	default:
+		return nil, fmt.Errorf("unknown format: %s (formats: plain, combined, json:KEY, csv:N, regex:EXPR)", name)
+	}
+}
+
+// Plain treats the whole line as the IP field.
+type Plain struct{}
+
+// Extract returns line with surrounding whitespace removed.
+func (Plain) Extract(line []byte) []byte {
+	return bytes.TrimSpace(line)
+}
+
+// Combined extracts the first field of Apache/Nginx common and combined logs.
+type Combined struct{}
+
+// Extract returns the first whitespace-separated field of line.
+func (Combined) Extract(line []byte) []byte {
+	start := 0
+	for start < len(line) && isSpace(line[start]) {
+		start++
+	}
+	end := start
+	for end < len(line) && !isSpace(line[end]) {
+		end++
+	}
+	if start == end {
+		return nil
+	}
+	return line[start:end]
+}
+
+// JSON extracts a string value from a flat JSON object per line. It scans for
+// the quoted key instead of decoding the object, so it does not handle escaped
+// quotes inside the key or the value.
+type JSON struct {
+	needle []byte // The key with its quotes, e.g. "client_ip".
+}
+
+// NewJSON returns an extractor for the string value of key.
+func NewJSON(key string) JSON {
+	return JSON{needle: []byte(`"` + key + `"`)}
+}
+
+// Extract returns the value of the key in line, without its quotes.
+func (j JSON) Extract(line []byte) []byte {
+	pos := 0
+	for {
+		i := bytes.Index(line[pos:], j.needle)
+		if i < 0 {
+			return nil
+		}
+		pos += i + len(j.needle)
+
+		// The needle is only a key if a colon follows; otherwise it was a value.
+		p := skipSpace(line, pos)
+		if p >= len(line) || line[p] != ':' {
+			continue
+		}
+		p = skipSpace(line, p+1)
+		if p >= len(line) || line[p] != '"' {
+			return nil // Not a string value.
+		}
+		end := bytes.IndexByte(line[p+1:], '"')
+		if end < 0 {
+			return nil
+		}
+		return line[p+1 : p+1+end]
+	}
+}
+
+// CSV extracts one column of a separated line. A field wrapped in double quotes
+// may contain the separator; the quotes are stripped.
+type CSV struct {
+	Column    int  // Index of the IP column, from 0.
+	Separator byte // Field separator, usually ','.
+}
+
+// Extract returns the configured column of line.
+func (c CSV) Extract(line []byte) []byte {
+	pos := 0
+	for col := 0; ; col++ {
+		var field []byte
+		next := -1
+		if pos < len(line) && line[pos] == '"' {
+			end := bytes.IndexByte(line[pos+1:], '"')
+			if end < 0 {
+				return nil
+			}
+			field = line[pos+1 : pos+1+end]
+			if after := pos + 2 + end; after < len(line) && line[after] == c.Separator {
+				next = after + 1
+			}
+		} else {
+			end := bytes.IndexByte(line[pos:], c.Separator)
+			if end < 0 {
+				field = line[pos:]
+			} else {
+				field = line[pos : pos+end]
+				next = pos + end + 1
+			}
+		}
+		if col == c.Column {
+			return bytes.TrimSpace(field)
+		}
+		if next < 0 {
+			return nil // Fewer columns than expected.
+		}
+		pos = next
+	}
+}
+
+// Regex extracts a capture group of a regular expression. It is the most
+// flexible format and by far the slowest.
+type Regex struct {
+	re    *regexp.Regexp
+	group int
+}
+
+// NewRegex compiles expr and uses its group named "ip", or its first group.
+func NewRegex(expr string) (Regex, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return Regex{}, fmt.Errorf("invalid regex format: %w", err)
+	}
+	if re.NumSubexp() == 0 {
+		return Regex{}, fmt.Errorf("regex format needs a capture group: %s", expr)
+	}
+	group := 1
+	if i := re.SubexpIndex("ip"); i > 0 {
+		group = i
+	}
+	return Regex{re: re, group: group}, nil
+}
+
+// Extract returns the capture group matched in line.
+func (r Regex) Extract(line []byte) []byte {
+	m := r.re.FindSubmatchIndex(line)
+	if m == nil || m[2*r.group] < 0 {
+		return nil
+	}
+	return line[m[2*r.group]:m[2*r.group+1]]
+}
+
+// isSpace reports whether c is an ASCII whitespace byte.
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\v' || c == '\f'
+}
+
+// skipSpace returns the index of the first non-space byte of b at or after pos.
+func skipSpace(b []byte, pos int) int {
+	for pos < len(b) && isSpace(b[pos]) {
+		pos++
+	}
+	return pos
+}
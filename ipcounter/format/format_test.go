@@ -0,0 +1,54 @@
+package format
+
+import (
+	"testing"
+)
+
+func TestExtractors(t *testing.T) {
+	tests := []struct {
+		spec string
+		line string
+		want string
+	}{
+		{"plain", "  10.0.0.1 \r", "10.0.0.1"},
+		{"combined", `203.0.113.7 - frank [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 2326`, "203.0.113.7"},
+		{"combined", "", ""},
+		{"json:client_ip", `{"ts":"2024-01-01T00:00:00Z","client_ip": "198.51.100.4","path":"/"}`, "198.51.100.4"},
+		{"json:ip", `{"note":"ip","ip":"1.2.3.4"}`, "1.2.3.4"},
+		{"json:ip", `{"ip":42}`, ""},
+		{"json:ip", `{"addr":"1.2.3.4"}`, ""},
+		{"csv:1", "alice,192.0.2.1,GET", "192.0.2.1"},
+		{"csv:2", `bob,"a, quoted",192.0.2.2`, "192.0.2.2"},
+		{"csv:1", `bob,"192.0.2.3"`, "192.0.2.3"},
+		{"csv:3", "a,b,c", ""},
+		{"csv:0:;", "192.0.2.4;x", "192.0.2.4"},
+		{"regex:client=(\\S+)", "level=info client=192.0.2.5 msg=ok", "192.0.2.5"},
+		{"regex:(\\w+) from (?P<ip>[0-9.]+)", "login from 192.0.2.6", "192.0.2.6"},
+	}
+
+	for _, tt := range tests {
+		e, err := Parse(tt.spec)
+		if err != nil {
+			t.Fatalf("Parse(%q) failed: %v", tt.spec, err)
+		}
+		if got := string(e.Extract([]byte(tt.line))); got != tt.want {
+			t.Errorf("%s: Extract(%q) = %q, want %q", tt.spec, tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseRejectsBadSpecs(t *testing.T) {
+	for _, spec := range []string{"json", "csv:x", "csv:1:ab", "regex:no-group", "regex:(", "xml"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestExtractDoesNotCopy(t *testing.T) {
+	line := []byte(`{"ip":"1.2.3.4"}`)
+	got := NewJSON("ip").Extract(line)
+	if &got[0] != &line[7] {
+		t.Errorf("Extract returned a copy instead of a sub-slice")
+	}
+}
@@ -0,0 +1,379 @@
+/*
+Package groupby counts distinct IPv4 addresses per key, such as the API
+endpoint or customer ID carried by each log record.
+
+Every key gets its own set. Groups start as a sorted array (4 bytes per IP) and
+are promoted to a 512MB bitmap once they hold PromoteAt IPs, so the few big
+groups stay exact without paying array overhead. With Estimate, every group is
+a 16KB HyperLogLog sketch instead.
+
+Memory is kept under MaxBytes. Near the ceiling, growing arrays and groups due
+for promotion are turned into HyperLogLog sketches, and once it is reached,
+records for keys not seen before are counted in one overflow group, reported
+under the Overflow key. Each of these is reported once through the Warn
+callback.
+
+Pros:
+- Exact counts for every key while memory allows, estimates past that.
+- Millions of small keys cost little more than their IPs.
+
+Cons:
+- Memory accounting is approximate: map and per-key overheads are estimated.
+- A key whose first record arrives after the ceiling is never counted on its own.
+*/
+package groupby
+
+import (
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/format"
+	"IP-Addr-Counter/ipcounter/hll"
+	"IP-Addr-Counter/ipcounter/ipset"
+	"IP-Addr-Counter/ipcounter/sysinfo"
+	"IP-Addr-Counter/ipcounter/utils"
+	"bytes"
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// Constants defining the default group behaviour.
+const (
+	Overflow         = "(other)" // Key the overflow group is reported under.
+	DefaultMaxBytes  = 4 << 30   // Default memory ceiling (4GB).
+	DefaultPromoteAt = 4 << 20   // Default group size promoted to a bitmap (16MB of array).
+	bitmapBytes      = 1 << 29   // Size of a promoted group (512MB).
+	sketchBytes      = 16 << 10  // Size of an estimated group (16KB).
+	keyOverhead      = 96        // Estimated bytes per key besides its name and set.
+	batchSize        = 4096      // IPs a worker buffers per key before locking its group.
+	maxBatchedKeys   = 1 << 16   // Keys a worker buffers before flushing them all.
+)
+
+// Config describes how groups are stored.
+type Config struct {
+	MaxBytes  int64            // Memory ceiling for all groups; 0 means DefaultMaxBytes.
+	PromoteAt int              // Array length at which an exact group becomes a bitmap; 0 means DefaultPromoteAt.
+	Estimate  bool             // Use a HyperLogLog sketch for every group.
+	Warn      func(msg string) // Called once per kind of degradation; may be nil.
+}
+
+// Group is the distinct count of one key.
+type Group struct {
+	Key       string `json:"key"`
+	Distinct  int64  `json:"distinct"`
+	Records   int64  `json:"records"`
+	Estimated bool   `json:"estimated,omitempty"` // Distinct is a HyperLogLog estimate.
+	Overflow  bool   `json:"overflow,omitempty"`  // The records whose own key did not fit under MaxBytes.
+}
+
+// Stats summarizes a Counter.
+type Stats struct {
+	Keys      int   // Distinct keys with a group of their own.
+	Estimated int   // Groups counted with a sketch, including the overflow group.
+	Bitmaps   int   // Groups promoted to a bitmap.
+	Overflow  int64 // Records counted in the overflow group.
+	Bytes     int64 // Estimated memory held by the groups.
+}
+
+// group is the set of one key. Exactly one of sparse, bitmap and sketch is set.
+type group struct {
+	mu      sync.Mutex
+	sparse  *ipset.Sparse
+	bitmap  *ipset.Bitmap
+	sketch  *hll.Sketch
+	records int64
+	bytes   int64 // Memory last accounted for the set, without the key.
+}
+
+// Counter counts distinct IPs per key.
+type Counter struct {
+	cfg      Config
+	key      format.Extractor
+	ip       format.Extractor
+	mu       sync.Mutex
+	group    map[string]*group
+	overflow *group // Records of the keys past MaxBytes; nil until one comes.
+
+	used    atomic.Int64 // Estimated bytes held by all groups.
+	warnMu  sync.Mutex
+	warned  map[string]bool
+	invalid atomic.Int64
+}
+
+// New returns a Counter that groups the IPs read with ip by the keys read with
+// key. A nil ip extractor uses the whole line, trimmed.
+func New(cfg Config, key, ip format.Extractor) (*Counter, error) {
+	if key == nil {
+		return nil, fmt.Errorf("a key field is required")
+	}
+	if cfg.MaxBytes == 0 {
+		cfg.MaxBytes = DefaultMaxBytes
+	}
+	if cfg.PromoteAt == 0 {
+		cfg.PromoteAt = DefaultPromoteAt
+	}
+	if cfg.MaxBytes < sketchBytes+keyOverhead {
+		return nil, fmt.Errorf("memory ceiling too small: %d bytes", cfg.MaxBytes)
+	}
+	return &Counter{
+		cfg:    cfg,
+		key:    key,
+		ip:     ip,
+		group:  make(map[string]*group),
+		warned: make(map[string]bool),
+	}, nil
+}
+
+// Invalid returns the number of non-empty records skipped so far because their
+// IP could not be parsed or they had no key.
+func (c *Counter) Invalid() int64 {
+	return c.invalid.Load()
+}
+
+// batch is the per-worker buffer of one key.
+type batch struct {
+	ips     []uint32
+	records int64
+}
+
+// Add reads r and counts its records into their groups. It may be called
+// several times to combine inputs.
+func (c *Counter) Add(r io.Reader) error {
+	numWorkers := sysinfo.CPUs()
+	batches := make([]map[string]*batch, numWorkers)
+	for i := range batches {
+		batches[i] = make(map[string]*batch)
+	}
+	err := concurrent.ProcessChunks(r, numWorkers, func(worker int, chunk []byte) {
+		c.processChunk(batches[worker], chunk)
+	})
+	for _, m := range batches {
+		c.flushAll(m)
+	}
+	return err
+}
+
+// processChunk buffers every valid line of chunk under its key.
+func (c *Counter) processChunk(pending map[string]*batch, chunk []byte) {
+	start := 0
+	for i, ch := range chunk {
+		if ch != '\n' {
+			continue
+		}
+		line := bytes.TrimSpace(chunk[start:i])
+		start = i + 1
+		if len(line) == 0 {
+			continue // Skip empty lines.
+		}
+
+		field := line
+		if c.ip != nil {
+			field = c.ip.Extract(line)
+		}
+		ip, err := utils.ParseIPv4(field)
+		key := c.key.Extract(line)
+		if err != nil || key == nil {
+			c.invalid.Add(1)
+			continue
+		}
+
+		b, ok := pending[string(key)] // Does not allocate.
+		if !ok {
+			if len(pending) >= maxBatchedKeys {
+				c.flushAll(pending)
+			}
+			b = &batch{}
+			pending[string(key)] = b
+		}
+		b.ips = append(b.ips, ip)
+		b.records++
+		if len(b.ips) >= batchSize {
+			c.flush(string(key), b)
+		}
+	}
+}
+
+// flushAll flushes and forgets every pending batch.
+func (c *Counter) flushAll(pending map[string]*batch) {
+	for key, b := range pending {
+		c.flush(key, b)
+	}
+	clear(pending)
+}
+
+// flush adds a batch to its group and empties it.
+func (c *Counter) flush(key string, b *batch) {
+	if b.records == 0 {
+		return
+	}
+	g := c.get(key)
+	g.mu.Lock()
+	g.records += b.records
+	for _, ip := range b.ips {
+		switch {
+		case g.sparse != nil:
+			g.sparse.Add(ip)
+		case g.bitmap != nil:
+			g.bitmap.Add(ip)
+		default:
+			g.sketch.Add(ip)
+		}
+	}
+	if g.sparse != nil {
+		c.resize(g)
+	}
+	g.mu.Unlock()
+	b.ips = b.ips[:0]
+	b.records = 0
+}
+
+// resize accounts for the growth of a sparse group and changes its storage
+// when it is due for promotion or memory runs short. g.mu must be held.
+func (c *Counter) resize(g *group) {
+	size := g.sparse.Bytes()
+	used := c.used.Add(size - g.bytes)
+	g.bytes = size
+
+	// Len avoids compacting the array on every batch.
+	due := g.sparse.Len() >= c.cfg.PromoteAt
+	switch {
+	case due && used+bitmapBytes-size <= c.cfg.MaxBytes:
+		g.bitmap = ipset.NewBitmap()
+		g.sparse.ForEach(func(ip uint32) { g.bitmap.Add(ip) })
+		c.setBytes(g, bitmapBytes)
+	case due:
+		c.warn("bitmap", fmt.Sprintf("memory ceiling of %d bytes leaves no room for a %dMB bitmap; large groups are now estimated", c.cfg.MaxBytes, bitmapBytes>>20))
+		c.toSketch(g)
+	case used > c.cfg.MaxBytes && size > sketchBytes:
+		c.warn("sketch", fmt.Sprintf("memory ceiling of %d bytes reached; growing groups are now estimated", c.cfg.MaxBytes))
+		c.toSketch(g)
+	}
+}
+
+// toSketch replaces the sparse set of g with a HyperLogLog sketch. g.mu must be held.
+func (c *Counter) toSketch(g *group) {
+	g.sketch = hll.New()
+	g.sparse.ForEach(g.sketch.Add)
+	c.setBytes(g, sketchBytes)
+}
+
+// setBytes drops the sparse set of g and accounts for its new size.
+func (c *Counter) setBytes(g *group, size int64) {
+	g.sparse = nil
+	c.used.Add(size - g.bytes)
+	g.bytes = size
+}
+
+// get returns the group for key, creating it if memory allows. Otherwise the
+// overflow group is returned.
+func (c *Counter) get(key string) *group {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if g, ok := c.group[key]; ok {
+		return g
+	}
+
+	var set int64 // Initial size of the set; arrays start empty.
+	if c.cfg.Estimate {
+		set = sketchBytes
+	}
+	if c.used.Load()+int64(len(key)+keyOverhead)+set > c.cfg.MaxBytes {
+		c.warn("keys", fmt.Sprintf("memory ceiling of %d bytes reached at %d keys; records for new keys are counted under %s", c.cfg.MaxBytes, len(c.group), Overflow))
+		if c.overflow == nil {
+			c.overflow = &group{sketch: hll.New(), bytes: sketchBytes}
+			c.used.Add(keyOverhead + sketchBytes)
+		}
+		return c.overflow
+	}
+
+	g := &group{bytes: set}
+	if c.cfg.Estimate {
+		g.sketch = hll.New()
+	} else {
+		g.sparse = ipset.NewSparse()
+	}
+	c.used.Add(int64(len(key)+keyOverhead) + set)
+	c.group[key] = g
+	return g
+}
+
+// warn reports msg the first time a kind of degradation happens.
+func (c *Counter) warn(kind, msg string) {
+	c.warnMu.Lock()
+	defer c.warnMu.Unlock()
+	if c.warned[kind] || c.cfg.Warn == nil {
+		return
+	}
+	c.warned[kind] = true
+	c.cfg.Warn(msg)
+}
+
+// Top returns the n groups with the most distinct IPs, largest first, with
+// ties broken by key. n <= 0 returns every group.
+func (c *Counter) Top(n int) []Group {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	groups := make([]Group, 0, len(c.group)+1)
+	for key, g := range c.group {
+		groups = append(groups, g.result(key))
+	}
+	if c.overflow != nil {
+		r := c.overflow.result(Overflow)
+		r.Overflow = true
+		groups = append(groups, r)
+	}
+	// Stable, so the overflow group comes after a real key of the same name.
+	slices.SortStableFunc(groups, func(a, b Group) int {
+		if c := cmp.Compare(b.Distinct, a.Distinct); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Key, b.Key)
+	})
+	if n > 0 && n < len(groups) {
+		groups = groups[:n]
+	}
+	return groups
+}
+
+// result returns the count of g under key.
+func (g *group) result(key string) Group {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	r := Group{Key: key, Records: g.records}
+	switch {
+	case g.sparse != nil:
+		r.Distinct = g.sparse.Count()
+	case g.bitmap != nil:
+		r.Distinct = g.bitmap.Count()
+	default:
+		r.Distinct = g.sketch.Count()
+		r.Estimated = true
+	}
+	return r
+}
+
+// Stats returns a summary of the groups.
+func (c *Counter) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := Stats{Keys: len(c.group), Bytes: c.used.Load()}
+	for _, g := range c.group {
+		g.mu.Lock()
+		if g.sketch != nil {
+			s.Estimated++
+		}
+		if g.bitmap != nil {
+			s.Bitmaps++
+		}
+		g.mu.Unlock()
+	}
+	if g := c.overflow; g != nil {
+		g.mu.Lock()
+		s.Estimated++
+		s.Overflow = g.records
+		g.mu.Unlock()
+	}
+	return s
+}
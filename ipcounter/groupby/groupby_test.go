@@ -0,0 +1,157 @@
+package groupby
+
+import (
+	"IP-Addr-Counter/ipcounter/format"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// keyed writes key,ip lines: key k has k*10 distinct IPs, each seen twice.
+func keyed(numKeys int) *bytes.Buffer {
+	var buf bytes.Buffer
+	for k := 1; k <= numKeys; k++ {
+		for rep := 0; rep < 2; rep++ {
+			for i := 0; i < k*10; i++ {
+				fmt.Fprintf(&buf, "key%03d,10.%d.%d.%d\n", k, k, i/256, i%256)
+			}
+		}
+	}
+	return &buf
+}
+
+func newCSV(t *testing.T, cfg Config) *Counter {
+	t.Helper()
+	c, err := New(cfg, format.CSV{Column: 0, Separator: ','}, format.CSV{Column: 1, Separator: ','})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestTopKeys(t *testing.T) {
+	c := newCSV(t, Config{})
+	input := keyed(50)
+	input.WriteString("key001,not-an-ip\n\n")
+	if err := c.Add(input); err != nil {
+		t.Fatal(err)
+	}
+
+	top := c.Top(3)
+	if len(top) != 3 {
+		t.Fatalf("got %d groups, want 3", len(top))
+	}
+	for i, g := range top {
+		k := 50 - i
+		want := Group{Key: fmt.Sprintf("key%03d", k), Distinct: int64(k * 10), Records: int64(k * 20)}
+		if g != want {
+			t.Errorf("top[%d] = %+v, want %+v", i, g, want)
+		}
+	}
+	if n := len(c.Top(0)); n != 50 {
+		t.Errorf("Top(0) returned %d groups, want 50", n)
+	}
+	if c.Invalid() != 1 {
+		t.Errorf("Invalid() = %d, want 1", c.Invalid())
+	}
+}
+
+func TestPromotion(t *testing.T) {
+	c := newCSV(t, Config{PromoteAt: 1000})
+	input := keyed(20)
+	for i := 0; i < 5000; i++ {
+		fmt.Fprintf(input, "big,192.168.%d.%d\n", i/256%8, i%256)
+	}
+	if err := c.Add(input); err != nil {
+		t.Fatal(err)
+	}
+	if s := c.Stats(); s.Keys != 21 || s.Bitmaps != 1 || s.Estimated != 0 {
+		t.Errorf("Stats() = %+v, want one bitmap and no estimates", s)
+	}
+	if top := c.Top(1)[0]; top != (Group{Key: "big", Distinct: 2048, Records: 5000}) {
+		t.Errorf("top group = %+v", top)
+	}
+}
+
+func TestMemoryCeiling(t *testing.T) {
+	var warnings []string
+	c := newCSV(t, Config{
+		MaxBytes:  64 << 10,
+		PromoteAt: 1000,
+		Warn:      func(msg string) { warnings = append(warnings, msg) },
+	})
+	if err := c.Add(keyed(200)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := c.Stats()
+	if s.Overflow == 0 || s.Estimated == 0 || s.Bitmaps != 0 {
+		t.Errorf("Stats() = %+v, want overflow, estimates and no bitmaps", s)
+	}
+	if len(warnings) == 0 {
+		t.Fatal("expected warnings")
+	}
+	for _, w := range warnings {
+		if !strings.Contains(w, "memory ceiling") {
+			t.Errorf("unexpected warning: %s", w)
+		}
+	}
+	var records int64
+	for _, g := range c.Top(0) {
+		records += g.Records
+	}
+	if want := int64(200 * 201 * 10); records != want {
+		t.Errorf("groups hold %d records, want %d", records, want)
+	}
+}
+
+func TestOverflowKey(t *testing.T) {
+	// A real key named like the overflow group is a key like any other.
+	c := newCSV(t, Config{})
+	if err := c.Add(strings.NewReader(Overflow + ",1.1.1.1\n")); err != nil {
+		t.Fatal(err)
+	}
+	if top := c.Top(0); len(top) != 1 || top[0] != (Group{Key: Overflow, Distinct: 1, Records: 1}) {
+		t.Errorf("Top(0) = %+v, want one exact group", top)
+	}
+	if s := c.Stats(); s.Overflow != 0 || s.Keys != 1 {
+		t.Errorf("Stats() = %+v, want one key and no overflow", s)
+	}
+
+	// Past the ceiling it overflows like any other new key.
+	c = newCSV(t, Config{MaxBytes: 64 << 10, PromoteAt: 1000})
+	if err := c.Add(keyed(200)); err != nil {
+		t.Fatal(err)
+	}
+	before := c.Stats()
+	if err := c.Add(strings.NewReader(strings.Repeat(Overflow+",1.1.1.1\n", 5))); err != nil {
+		t.Fatal(err)
+	}
+	if s := c.Stats(); s.Overflow != before.Overflow+5 || s.Keys != before.Keys {
+		t.Errorf("Stats() = %+v after 5 records of a new key, want 5 more overflow than %+v", s, before)
+	}
+	var overflows int
+	for _, g := range c.Top(0) {
+		if g.Overflow {
+			overflows++
+		}
+	}
+	if overflows != 1 {
+		t.Errorf("Top(0) has %d overflow groups, want 1", overflows)
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	c := newCSV(t, Config{Estimate: true})
+	if err := c.Add(keyed(100)); err != nil {
+		t.Fatal(err)
+	}
+	for _, g := range c.Top(0) {
+		var k int
+		fmt.Sscanf(g.Key, "key%d", &k)
+		if !g.Estimated || g.Distinct < int64(k*10)*97/100 || g.Distinct > int64(k*10)*103/100 {
+			t.Errorf("group %+v: want an estimate near %d", g, k*10)
+		}
+	}
+}
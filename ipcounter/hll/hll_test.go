@@ -0,0 +1,35 @@
+package hll
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCountWithinError(t *testing.T) {
+	for _, n := range []int{0, 1, 100, 10000, 1000000} {
+		s := New()
+		for i := 0; i < n; i++ {
+			ip := uint32(i) * 2654435761 // Spread IPs over the whole space.
+			s.Add(ip)
+			s.Add(ip) // Duplicates must not change the estimate.
+		}
+		got := s.Count()
+		if diff := math.Abs(float64(got - int64(n))); diff > 4*StdError()*float64(n)+1 {
+			t.Errorf("n=%d: Count() = %d, off by %.0f", n, got, diff)
+		}
+	}
+}
+
+func TestMergeIsUnion(t *testing.T) {
+	a, b := New(), New()
+	for i := uint32(0); i < 60000; i++ {
+		a.Add(i)
+	}
+	for i := uint32(40000); i < 100000; i++ {
+		b.Add(i)
+	}
+	a.Merge(b)
+	if got := a.Count(); math.Abs(float64(got-100000)) > 4*StdError()*100000 {
+		t.Errorf("merged Count() = %d, want about 100000", got)
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Package hugemem allocates the 512MB bitsets of the counters in memory suited to
+being written at random.
+
+With 4KB pages, a bitset that big spans 131072 pages, far more than the TLB
+holds, so nearly every insert also misses the TLB. On Linux, Alloc maps the
+bitset itself and backs it with huge pages: explicit ones from the hugetlbfs
+pool when it has enough free, otherwise transparent huge pages requested with
+MADV_HUGEPAGE. On machines with several NUMA nodes, the pages are interleaved
+across the nodes so every socket sees the same average latency and bandwidth.
+The pages are then faulted in by several goroutines at once, so the cost is
+paid up front and in parallel instead of by the first IPs of the count.
+
+The kernel may give fewer huge pages than asked for, so Info reports what the
+memory actually ended up on. Elsewhere, and when mapping fails, Alloc falls
+back to the Go heap.
+*/
+package hugemem
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// Region is memory returned by Alloc. Memory mapped outside the Go heap is
+// unmapped once the Region is unreachable, so whoever uses Bytes must keep the
+// Region too.
+type Region struct {
+	b    []byte
+	info Info
+}
+
+// Info describes the memory behind a Region.
+type Info struct {
+	Source    string // "hugetlbfs", "transparent" or "heap".
+	Size      int64  // Bytes of the region.
+	PageSize  int    // Size of the pages most of the region is on.
+	HugeBytes int64  // Bytes backed by huge pages, -1 if unknown.
+	Nodes     int    // NUMA nodes the pages are interleaved over; 1 if not interleaved.
+}
+
+// String describes i for the stats, e.g. "2MB pages (transparent, 100% huge)".
+func (i Info) String() string {
+	s := fmt.Sprintf("%s pages (%s", formatSize(int64(i.PageSize)), i.Source)
+	if i.Source == "transparent" && i.HugeBytes >= 0 && i.Size > 0 {
+		// The kernel may back only part of the region with huge pages.
+		s += fmt.Sprintf(", %d%% huge", i.HugeBytes*100/i.Size)
+	}
+	s += ")"
+	if i.Nodes > 1 {
+		s += fmt.Sprintf(", interleaved over %d NUMA nodes", i.Nodes)
+	}
+	return s
+}
+
+// Bytes returns the memory of the region, zeroed when it was allocated.
+func (r *Region) Bytes() []byte {
+	return r.b
+}
+
+// Info returns what the region was allocated on.
+func (r *Region) Info() Info {
+	return r.info
+}
+
+// Heap returns a Region of size bytes on the Go heap, with the system's base
+// page size.
+func Heap(size int) *Region {
+	return &Region{b: make([]byte, size), info: HeapInfo(int64(size))}
+}
+
+// HeapInfo returns the Info of size bytes on the Go heap.
+func HeapInfo(size int64) Info {
+	return Info{Source: "heap", Size: size, PageSize: os.Getpagesize(), HugeBytes: -1, Nodes: 1}
+}
+
+// prefault writes a zero to every page of b, on up to workers goroutines, so
+// the kernel allocates the pages now.
+func prefault(b []byte, pageSize, workers int) {
+	pages := (len(b) + pageSize - 1) / pageSize
+	workers = max(1, min(workers, pages))
+	per := (pages + workers - 1) / workers
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		lo, hi := w*per*pageSize, min((w+1)*per*pageSize, len(b))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for off := lo; off < hi; off += pageSize {
+				b[off] = 0
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// formatSize formats n bytes with the largest binary unit that divides it.
+func formatSize(n int64) string {
+	for _, u := range []struct {
+		size int64
+		name string
+	}{{1 << 30, "GB"}, {1 << 20, "MB"}, {1 << 10, "KB"}} {
+		if n >= u.size && n%u.size == 0 {
+			return fmt.Sprintf("%d%s", n/u.size, u.name)
+		}
+	}
+	return fmt.Sprintf("%dB", n)
+}
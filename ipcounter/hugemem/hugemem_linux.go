@@ -0,0 +1,227 @@
+//go:build linux
+// +build linux
+
+package hugemem
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Constants of the kernel interfaces used.
+const (
+	mpolInterleave = 3       // MPOL_INTERLEAVE, the mbind(2) mode that spreads pages round-robin.
+	defaultTHPSize = 2 << 20 // Transparent huge page size if sysfs does not say.
+)
+
+// Alloc returns size zeroed bytes on huge pages, interleaved across the NUMA
+// nodes with memory and faulted in by up to workers goroutines. It takes
+// explicit huge pages if the hugetlbfs pool has enough free, then transparent
+// ones, then the Go heap if mapping fails.
+func Alloc(size, workers int) *Region {
+	nodes := memoryNodes("/")
+	if free, page := hugetlbFree("/"); page > 0 && free >= int64(size) {
+		if r, ok := mapRegion(size, workers, page, nodes); ok {
+			return r
+		}
+	}
+	if r, ok := mapRegion(size, workers, 0, nodes); ok {
+		return r
+	}
+	return Heap(size)
+}
+
+// mapRegion maps size bytes on explicit huge pages of hugePage bytes, or on
+// transparent huge pages if hugePage is 0, interleaves them over nodes and
+// faults them in. It reports false if the mapping fails.
+func mapRegion(size, workers, hugePage int, nodes []int) (*Region, bool) {
+	flags := syscall.MAP_PRIVATE | syscall.MAP_ANONYMOUS
+	align := thpSize("/")
+	length := roundUp(size, align) + align // Room to start on a huge page boundary.
+	if hugePage > 0 {
+		flags |= syscall.MAP_HUGETLB // Reserves the pages now, so faulting them cannot fail.
+		length = roundUp(size, hugePage)
+	}
+	mem, err := syscall.Mmap(-1, 0, length, syscall.PROT_READ|syscall.PROT_WRITE, flags)
+	if err != nil {
+		return nil, false
+	}
+
+	info := Info{Source: "hugetlbfs", Size: int64(size), PageSize: hugePage, HugeBytes: int64(size), Nodes: 1}
+	b := mem[:size]
+	faultPage := hugePage
+	if hugePage == 0 {
+		// Transparent huge pages only cover whole, aligned 2MB ranges.
+		start := roundUp(int(addr(mem)), align) - int(addr(mem))
+		b = mem[start : start+size]
+		syscall.Madvise(mem[start:start+roundUp(size, align)], syscall.MADV_HUGEPAGE) // Only fails without THP support.
+		info.Source = "transparent"
+		faultPage = os.Getpagesize()
+	}
+	if len(nodes) > 1 && interleave(b, nodes) == nil {
+		info.Nodes = len(nodes)
+	}
+	prefault(b, faultPage, workers)
+
+	if hugePage == 0 {
+		// Mappings next to b with the same settings may be merged with it.
+		info.HugeBytes = min(anonHugeBytes("/", addr(b), addr(b)+uintptr(len(b))), int64(size))
+		info.PageSize = os.Getpagesize()
+		if info.HugeBytes >= int64(size)/2 {
+			info.PageSize = align
+		}
+	}
+	r := &Region{b: b, info: info}
+	runtime.AddCleanup(r, func(mem []byte) { syscall.Munmap(mem) }, mem)
+	return r, true
+}
+
+// interleave sets the NUMA policy of b, which must start on a page boundary,
+// to spread its pages round-robin over nodes. It must be called before the
+// pages are faulted in.
+func interleave(b []byte, nodes []int) error {
+	mask := make([]uint64, nodes[len(nodes)-1]/64+1)
+	for _, n := range nodes {
+		mask[n/64] |= 1 << (n % 64)
+	}
+	_, _, errno := syscall.Syscall6(syscall.SYS_MBIND, addr(b), uintptr(len(b)), mpolInterleave,
+		uintptr(unsafe.Pointer(&mask[0])), uintptr(len(mask)*64+1), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// memoryNodes returns the NUMA nodes with memory, looked up under root, or
+// nil if the system does not say.
+func memoryNodes(root string) []int {
+	data, err := os.ReadFile(filepath.Join(root, "sys/devices/system/node/has_memory"))
+	if err != nil {
+		return nil
+	}
+	return parseNodeList(strings.TrimSpace(string(data)))
+}
+
+// parseNodeList parses a sysfs node list such as "0-1,4", in increasing
+// order, returning nil if it is malformed.
+func parseNodeList(s string) []int {
+	var nodes []int
+	for _, part := range strings.Split(s, ",") {
+		lo, hi, isRange := strings.Cut(part, "-")
+		first, err := strconv.Atoi(lo)
+		if err != nil || first < 0 {
+			return nil
+		}
+		last := first
+		if isRange {
+			if last, err = strconv.Atoi(hi); err != nil || last < first {
+				return nil
+			}
+		}
+		for n := first; n <= last; n++ {
+			nodes = append(nodes, n)
+		}
+	}
+	return nodes
+}
+
+// hugetlbFree returns the free bytes of the default hugetlbfs pool and its
+// page size, looked up under root. The page size is 0 if there is no pool.
+func hugetlbFree(root string) (free int64, pageSize int) {
+	data, err := os.ReadFile(filepath.Join(root, "proc/meminfo"))
+	if err != nil {
+		return 0, 0
+	}
+	var pages, kb int64
+	for _, line := range strings.Split(string(data), "\n") {
+		f := strings.Fields(line)
+		if len(f) < 2 {
+			continue
+		}
+		switch f[0] {
+		case "HugePages_Free:":
+			pages, _ = strconv.ParseInt(f[1], 10, 64)
+		case "Hugepagesize:":
+			kb, _ = strconv.ParseInt(f[1], 10, 64)
+		}
+	}
+	return pages * kb * 1024, int(kb * 1024)
+}
+
+// thpSize returns the size of a transparent huge page, looked up under root.
+func thpSize(root string) int {
+	data, err := os.ReadFile(filepath.Join(root, "sys/kernel/mm/transparent_hugepage/hpage_pmd_size"))
+	if err != nil {
+		return defaultTHPSize
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || n <= 0 {
+		return defaultTHPSize
+	}
+	return n
+}
+
+// anonHugeBytes returns the bytes of the mappings within [start, end) backed
+// by transparent huge pages, from the process's smaps under root, or -1 if
+// they cannot be read.
+func anonHugeBytes(root string, start, end uintptr) int64 {
+	f, err := os.Open(filepath.Join(root, "proc/self/smaps"))
+	if err != nil {
+		return -1
+	}
+	defer f.Close()
+
+	var total int64
+	inside := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		// Each mapping starts with a line "start-end perms offset ...".
+		if lo, hi, ok := mappingRange(line); ok {
+			inside = lo < end && hi > start
+			continue
+		}
+		if inside && bytes.HasPrefix(line, []byte("AnonHugePages:")) {
+			if f := strings.Fields(string(line)); len(f) >= 2 {
+				kb, _ := strconv.ParseInt(f[1], 10, 64)
+				total += kb * 1024
+			}
+		}
+	}
+	if scanner.Err() != nil {
+		return -1
+	}
+	return total
+}
+
+// mappingRange parses the address range of an smaps mapping header.
+func mappingRange(line []byte) (lo, hi uintptr, ok bool) {
+	field, _, _ := bytes.Cut(line, []byte(" "))
+	a, b, found := bytes.Cut(field, []byte("-"))
+	if !found {
+		return 0, 0, false
+	}
+	l, err1 := strconv.ParseUint(string(a), 16, 64)
+	h, err2 := strconv.ParseUint(string(b), 16, 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, false
+	}
+	return uintptr(l), uintptr(h), true
+}
+
+// addr returns the address of the first byte of b.
+func addr(b []byte) uintptr {
+	return uintptr(unsafe.Pointer(unsafe.SliceData(b)))
+}
+
+// roundUp rounds n up to a multiple of align, a power of two.
+func roundUp(n, align int) int {
+	return (n + align - 1) &^ (align - 1)
+}
@@ -0,0 +1,110 @@
+package hugemem
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeRoot writes files under a temporary root directory.
+func fakeRoot(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, data := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+func TestMemoryNodes(t *testing.T) {
+	tests := []struct {
+		list string
+		want []int
+	}{
+		{"0\n", []int{0}},
+		{"0-1\n", []int{0, 1}},
+		{"0,2-3,6\n", []int{0, 2, 3, 6}},
+		{"1-0\n", nil},
+		{"x\n", nil},
+	}
+	for _, tt := range tests {
+		root := fakeRoot(t, map[string]string{"sys/devices/system/node/has_memory": tt.list})
+		if got := memoryNodes(root); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("memoryNodes(%q) = %v, want %v", tt.list, got, tt.want)
+		}
+	}
+	if got := memoryNodes(t.TempDir()); got != nil {
+		t.Errorf("memoryNodes() without sysfs = %v, want nil", got)
+	}
+}
+
+func TestHugetlbFree(t *testing.T) {
+	root := fakeRoot(t, map[string]string{"proc/meminfo": "MemTotal: 16000000 kB\nHugePages_Total: 300\nHugePages_Free: 260\nHugepagesize: 2048 kB\n"})
+	if free, page := hugetlbFree(root); free != 260<<21 || page != 2<<20 {
+		t.Errorf("hugetlbFree() = %d, %d; want %d, %d", free, page, 260<<21, 2<<20)
+	}
+	if free, page := hugetlbFree(t.TempDir()); free != 0 || page != 0 {
+		t.Errorf("hugetlbFree() without /proc = %d, %d; want 0, 0", free, page)
+	}
+}
+
+func TestAnonHugeBytes(t *testing.T) {
+	smaps := `7f0000000000-7f0000400000 rw-p 00000000 00:00 0
+Size:               4096 kB
+AnonHugePages:      4096 kB
+7f0000400000-7f0000600000 rw-p 00000000 00:00 0
+Size:               2048 kB
+AnonHugePages:         0 kB
+7f0000600000-7f0000800000 rw-p 00000000 00:00 0
+Size:               2048 kB
+AnonHugePages:      2048 kB
+`
+	root := fakeRoot(t, map[string]string{"proc/self/smaps": smaps})
+	if got := anonHugeBytes(root, 0x7f0000000000, 0x7f0000600000); got != 4<<20 {
+		t.Errorf("anonHugeBytes() = %d, want %d", got, 4<<20)
+	}
+	if got := anonHugeBytes(root, 0x7f0000200000, 0x7f0000800000); got != 6<<20 {
+		t.Errorf("anonHugeBytes() of overlapping mappings = %d, want %d", got, 6<<20)
+	}
+}
+
+func TestAllocUnmaps(t *testing.T) {
+	r := Alloc(4<<20, 1)
+	if r.Info().Source == "heap" {
+		t.Skip("mapping failed, nothing to unmap")
+	}
+	start := addr(r.Bytes())
+	r = nil
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		runtime.GC()
+		if !mapped(t, start) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("the region at %#x is still mapped after it became unreachable", start)
+}
+
+// mapped reports whether a of the process's address space is mapped.
+func mapped(t *testing.T, a uintptr) bool {
+	data, err := os.ReadFile("/proc/self/maps")
+	if err != nil {
+		t.Skip("no /proc/self/maps")
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if lo, hi, ok := mappingRange([]byte(line)); ok && lo <= a && a < hi {
+			return true
+		}
+	}
+	return false
+}
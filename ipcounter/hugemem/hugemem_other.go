@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package hugemem
+
+// Alloc returns size zeroed bytes on the Go heap: huge pages and NUMA
+// placement are only requested on Linux. The pages are still faulted in by up
+// to workers goroutines.
+func Alloc(size, workers int) *Region {
+	r := Heap(size)
+	prefault(r.b, r.info.PageSize, workers)
+	return r
+}
@@ -0,0 +1,41 @@
+package hugemem
+
+import "testing"
+
+func TestAlloc(t *testing.T) {
+	const size = 8 << 20
+	r := Alloc(size, 4)
+	b := r.Bytes()
+	if len(b) != size {
+		t.Fatalf("Alloc() gave %d bytes, want %d", len(b), size)
+	}
+	for i := 0; i < size; i += 4093 {
+		if b[i] != 0 {
+			t.Fatalf("byte %d is %d, want 0", i, b[i])
+		}
+		b[i] = byte(i)
+	}
+	info := r.Info()
+	if info.Size != size || info.PageSize <= 0 || info.HugeBytes > size || info.Nodes < 1 {
+		t.Errorf("Info() = %+v", info)
+	}
+	t.Logf("allocated on %v", info)
+}
+
+func TestInfoString(t *testing.T) {
+	tests := []struct {
+		info Info
+		want string
+	}{
+		{Info{Source: "transparent", Size: 512 << 20, PageSize: 2 << 20, HugeBytes: 384 << 20, Nodes: 2},
+			"2MB pages (transparent, 75% huge), interleaved over 2 NUMA nodes"},
+		{Info{Source: "hugetlbfs", Size: 512 << 20, PageSize: 1 << 30, HugeBytes: 512 << 20, Nodes: 1},
+			"1GB pages (hugetlbfs)"},
+		{HeapInfo(512 << 20), formatSize(int64(HeapInfo(0).PageSize)) + " pages (heap)"},
+	}
+	for _, tt := range tests {
+		if got := tt.info.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.info, got, tt.want)
+		}
+	}
+}
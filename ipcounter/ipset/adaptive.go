@@ -0,0 +1,189 @@
+package ipset
+
+import (
+	"slices"
+)
+
+// Constants defining when small sets give up their compact form.
+const (
+	compactSlack     = 1024     // Unsorted IPs tolerated before the first compaction.
+	defaultPromoteAt = 32 << 20 // Promote at 32M IPs, when the array reaches a quarter of the bitmap's 512MB.
+)
+
+// Sparse is a compact set of IPv4 addresses stored as a sorted array, costing
+// 4 bytes per IP. New IPs are appended unsorted and merged in batches, so Add
+// is amortized O(log n). It is not safe for concurrent use.
+type Sparse struct {
+	ips    []uint32 // ips[:sorted] is sorted and unique; the rest is unsorted.
+	sorted int
+}
+
+// NewSparse returns an empty Sparse set.
+func NewSparse() *Sparse {
+	return &Sparse{}
+}
+
+// Add inserts ip.
+func (s *Sparse) Add(ip uint32) {
+	s.ips = append(s.ips, ip)
+	if len(s.ips)-s.sorted > s.sorted+compactSlack {
+		s.compact()
+	}
+}
+
+// compact sorts and deduplicates the set.
+func (s *Sparse) compact() {
+	if s.sorted == len(s.ips) {
+		return
+	}
+	slices.Sort(s.ips)
+	s.ips = slices.Compact(s.ips)
+	s.sorted = len(s.ips)
+}
+
+// Count returns the number of IPs in the set.
+func (s *Sparse) Count() int64 {
+	s.compact()
+	return int64(len(s.ips))
+}
+
+// Len returns the length of the array without compacting it. IPs added since
+// the last compaction may be repeated, so Len is an upper bound of Count.
+func (s *Sparse) Len() int {
+	return len(s.ips)
+}
+
+// Contains reports whether ip is in the set.
+func (s *Sparse) Contains(ip uint32) bool {
+	s.compact()
+	_, found := slices.BinarySearch(s.ips, ip)
+	return found
+}
+
+// ForEach calls fn for every IP of the set, in increasing order.
+func (s *Sparse) ForEach(fn func(ip uint32)) {
+	s.compact()
+	for _, ip := range s.ips {
+		fn(ip)
+	}
+}
+
+// Bytes returns the memory held by the set.
+func (s *Sparse) Bytes() int64 {
+	return int64(cap(s.ips)) * 4
+}
+
+// Adaptive is an exact set that starts as a Sparse array and is promoted to a
+// 512MB Bitmap once it holds more than promoteAt IPs. Small sets
+// stay small, and big ones stop growing past the bitmap's fixed size.
+// It is not safe for concurrent use.
+type Adaptive struct {
+	sparse    *Sparse
+	bitmap    *Bitmap
+	promoteAt int
+}
+
+// NewAdaptive returns an empty Adaptive set with the default promotion size.
+func NewAdaptive() *Adaptive {
+	return NewAdaptiveAt(defaultPromoteAt)
+}
+
+// NewAdaptiveAt returns an empty Adaptive set that promotes itself to a bitmap
+// once it holds more than promoteAt IPs.
+func NewAdaptiveAt(promoteAt int) *Adaptive {
+	return &Adaptive{sparse: NewSparse(), promoteAt: promoteAt}
+}
+
+// FromBitmap returns an Adaptive set holding the IPs of m. It takes ownership
+// of m if the set is large, and converts it to a sorted array otherwise.
+func FromBitmap(m *Bitmap) *Adaptive {
+	a := NewAdaptive()
+	if m.Count() > int64(a.promoteAt) {
+		a.sparse, a.bitmap = nil, m
+		return a
+	}
+	m.ForEach(a.Add)
+	return a
+}
+
+// Add inserts ip.
+func (a *Adaptive) Add(ip uint32) {
+	if a.bitmap != nil {
+		a.bitmap.Add(ip)
+		return
+	}
+	a.sparse.Add(ip)
+	// Only the compacted part is known to be unique; it is refreshed as the array grows.
+	if a.sparse.sorted > a.promoteAt {
+		a.Promote()
+	}
+}
+
+// Promote converts the set to a bitmap, if it is not one already.
+func (a *Adaptive) Promote() {
+	if a.bitmap != nil {
+		return
+	}
+	a.bitmap = NewBitmap()
+	a.sparse.ForEach(func(ip uint32) { a.bitmap.Add(ip) })
+	a.sparse = nil
+}
+
+// Promoted reports whether the set is stored as a bitmap.
+func (a *Adaptive) Promoted() bool {
+	return a.bitmap != nil
+}
+
+// Count returns the number of IPs in the set.
+func (a *Adaptive) Count() int64 {
+	if a.bitmap != nil {
+		return a.bitmap.Count()
+	}
+	return a.sparse.Count()
+}
+
+// Contains reports whether ip is in the set.
+func (a *Adaptive) Contains(ip uint32) bool {
+	if a.bitmap != nil {
+		return a.bitmap.Contains(ip)
+	}
+	return a.sparse.Contains(ip)
+}
+
+// ForEach calls fn for every IP of the set, in increasing order.
+func (a *Adaptive) ForEach(fn func(ip uint32)) {
+	if a.bitmap != nil {
+		a.bitmap.ForEach(fn)
+		return
+	}
+	a.sparse.ForEach(fn)
+}
+
+// Merge adds every IP of other to a.
+func (a *Adaptive) Merge(other *Adaptive) {
+	if a.bitmap != nil && other.bitmap != nil {
+		for i, w := range other.bitmap.words {
+			a.bitmap.words[i] |= w
+		}
+		return
+	}
+	other.ForEach(a.Add)
+}
+
+// Reset empties the set. A promoted set keeps its bitmap, so it can be
+// refilled without allocating 512MB again.
+func (a *Adaptive) Reset() {
+	if a.bitmap != nil {
+		clear(a.bitmap.words)
+		return
+	}
+	a.sparse.ips, a.sparse.sorted = a.sparse.ips[:0], 0
+}
+
+// Bytes returns the memory held by the set.
+func (a *Adaptive) Bytes() int64 {
+	if a.bitmap != nil {
+		return a.bitmap.Bytes()
+	}
+	return a.sparse.Bytes()
+}
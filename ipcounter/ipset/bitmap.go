@@ -0,0 +1,118 @@
+/*
+Package ipset provides a flat bitmap over the whole IPv4 space and a compact
+snapshot format for persisting it.
+
+The counters keep their bitsets in layouts tuned for counting (a byte slice, or
+the shards interleaved by IP of Sharded). Bitmap is the common,
+layout-independent view used for queries and persistence: bit ip%64 of word
+ip/64 is set when ip is present.
+
+Pros:
+- Constant-time membership queries on 512MB, regardless of cardinality.
+- Snapshots store sparse regions as sorted arrays, so small sets stay small on disk.
+
+Cons:
+- Always allocates 512MB in memory, even for a handful of IPs.
+*/
+package ipset
+
+import (
+	"IP-Addr-Counter/ipcounter/hugemem"
+	"IP-Addr-Counter/ipcounter/popcount"
+	"math/bits"
+	"unsafe"
+)
+
+// Constants describing the bitmap geometry.
+const (
+	maxIPv4  = 1 << 32      // Total number of possible IPv4 addresses (2^32).
+	numWords = maxIPv4 / 64 // Number of uint64 words covering the IPv4 space.
+)
+
+// Bitmap is a set of IPv4 addresses stored as one bit per address.
+// It is not safe for concurrent modification.
+type Bitmap struct {
+	words []uint64        // Bit ip%64 of words[ip/64] is set when ip is present.
+	mem   *hugemem.Region // Memory behind words, nil if they were made here.
+}
+
+// NewBitmap returns an empty Bitmap covering every IPv4 address.
+func NewBitmap() *Bitmap {
+	return &Bitmap{words: make([]uint64, numWords)}
+}
+
+// NewBitmapIn returns a Bitmap over the 512MB of mem without copying it, so
+// a zeroed region gives an empty Bitmap. The Bitmap keeps mem alive.
+func NewBitmapIn(mem *hugemem.Region) *Bitmap {
+	b := mem.Bytes()
+	if len(b) != numWords*8 {
+		panic("ipset: bitmap memory must be 512MB")
+	}
+	return &Bitmap{words: unsafe.Slice((*uint64)(unsafe.Pointer(&b[0])), numWords), mem: mem}
+}
+
+// Memory describes the memory the bitmap is on.
+func (m *Bitmap) Memory() hugemem.Info {
+	if m.mem == nil {
+		return hugemem.HeapInfo(numWords * 8)
+	}
+	return m.mem.Info()
+}
+
+// FromWords wraps an existing slice of 2^26 words without copying it.
+func FromWords(words []uint64) *Bitmap {
+	if len(words) != numWords {
+		panic("ipset: bitmap must have 2^26 words")
+	}
+	return &Bitmap{words: words}
+}
+
+// Words returns the underlying words of the bitmap.
+func (m *Bitmap) Words() []uint64 {
+	return m.words
+}
+
+// Add inserts ip and returns true if it was not present before.
+func (m *Bitmap) Add(ip uint32) bool {
+	w := &m.words[ip/64]
+	mask := uint64(1) << (ip % 64)
+	if *w&mask != 0 {
+		return false
+	}
+	*w |= mask
+	return true
+}
+
+// Contains reports whether ip is in the set.
+func (m *Bitmap) Contains(ip uint32) bool {
+	return m.words[ip/64]&(uint64(1)<<(ip%64)) != 0
+}
+
+// ContainsAll reports, for each of ips, whether it is in the set.
+func (m *Bitmap) ContainsAll(ips []uint32) []bool {
+	found := make([]bool, len(ips))
+	for i, ip := range ips {
+		found[i] = m.Contains(ip)
+	}
+	return found
+}
+
+// Count returns the number of IPs in the set.
+func (m *Bitmap) Count() int64 {
+	return popcount.Count(m.words)
+}
+
+// ForEach calls fn for every IP of the bitmap, in increasing order.
+func (m *Bitmap) ForEach(fn func(ip uint32)) {
+	for i, w := range m.words {
+		for w != 0 {
+			fn(uint32(i*64 + bits.TrailingZeros64(w)))
+			w &= w - 1
+		}
+	}
+}
+
+// Bytes returns the memory held by the bitmap.
+func (m *Bitmap) Bytes() int64 {
+	return int64(len(m.words)) * 8
+}
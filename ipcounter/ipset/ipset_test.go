@@ -0,0 +1,271 @@
+package ipset
+
+import (
+	"IP-Addr-Counter/ipcounter/hugemem"
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestBitmapAddContains(t *testing.T) {
+	m := NewBitmap()
+	if !m.Add(0xC0A80101) {
+		t.Errorf("Add(192.168.1.1) = false on an empty set, want true")
+	}
+	if m.Add(0xC0A80101) {
+		t.Errorf("Add(192.168.1.1) = true on a duplicate, want false")
+	}
+	m.Add(0xFFFFFFFF)
+
+	got := m.ContainsAll([]uint32{0xC0A80101, 0xC0A80102, 0xFFFFFFFF, 0})
+	want := []bool{true, false, true, false}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("ContainsAll()[%d] = %v, want %v", i, got[i], want[i])
+		}
+	}
+	if m.Count() != 2 {
+		t.Errorf("Count() = %d, want 2", m.Count())
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	m := NewBitmap()
+	// A sparse container stored as an array.
+	m.Add(0x0A000001)
+	m.Add(0x0A00FFFF)
+	// A dense container stored as a bitmap.
+	for low := uint32(0); low < 5000; low++ {
+		m.Add(0xC0A80000 | low*13%65536)
+	}
+
+	var buf bytes.Buffer
+	if _, err := m.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	loaded := NewBitmap()
+	if _, err := loaded.ReadFrom(&buf); err != nil {
+		t.Fatalf("ReadFrom failed: %v", err)
+	}
+	if loaded.Count() != m.Count() {
+		t.Fatalf("loaded Count() = %d, want %d", loaded.Count(), m.Count())
+	}
+	for i, w := range m.Words() {
+		if loaded.Words()[i] != w {
+			t.Fatalf("word %d = %x, want %x", i, loaded.Words()[i], w)
+		}
+	}
+}
+
+func TestSaveFileConcurrent(t *testing.T) {
+	// Saves of one file racing each other leave one whole snapshot behind.
+	dir := t.TempDir()
+	path := filepath.Join(dir, "set.snap")
+	var wg sync.WaitGroup
+	for i := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			a := NewAdaptive()
+			for ip := range uint32(10000) {
+				a.Add(ip * uint32(i+1))
+			}
+			if err := a.Save(path); err != nil {
+				t.Errorf("Save: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if a, err := LoadAdaptive(path); err != nil || a.Count() != 10000 {
+		t.Errorf("LoadAdaptive() = %v; want a set of 10000 IPs", err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("%d files left in the directory, want only the snapshot", len(entries))
+	}
+}
+
+func TestSnapshotRejectsGarbage(t *testing.T) {
+	m := NewBitmap()
+	if _, err := m.ReadFrom(bytes.NewReader([]byte("not a snapshot"))); err == nil {
+		t.Errorf("ReadFrom accepted garbage input")
+	}
+}
+
+func TestIndexRankAndSelect(t *testing.T) {
+	m := NewBitmap()
+	var sorted []uint32
+	// Spread IPs across several superblocks, including both ends of the space.
+	for ip := uint64(0); ip < 1<<32; ip += 104729 * 7 {
+		m.Add(uint32(ip))
+		sorted = append(sorted, uint32(ip))
+	}
+	m.Add(0xFFFFFFFF)
+	sorted = append(sorted, 0xFFFFFFFF)
+
+	idx := NewIndex(m)
+	if idx.Count() != uint64(len(sorted)) {
+		t.Fatalf("Count() = %d, want %d", idx.Count(), len(sorted))
+	}
+
+	for k, want := range sorted {
+		got, ok := idx.Select(uint64(k))
+		if !ok || got != want {
+			t.Fatalf("Select(%d) = %d, %v, want %d, true", k, got, ok, want)
+		}
+		if r := idx.Rank(want); r != uint64(k) {
+			t.Fatalf("Rank(%d) = %d, want %d", want, r, k)
+		}
+	}
+	if _, ok := idx.Select(uint64(len(sorted))); ok {
+		t.Errorf("Select past the end returned ok")
+	}
+
+	ranges := [][2]uint32{{0, 0}, {0, 0xFFFFFFFF}, {0x0A000000, 0x0AFFFFFF}, {1, 733102}, {733103, 733103}, {0xFFFFFFFF, 0xFFFFFFFF}}
+	for _, r := range ranges {
+		var want uint64
+		for _, ip := range sorted {
+			if ip >= r[0] && ip <= r[1] {
+				want++
+			}
+		}
+		if got := idx.RangeCount(r[0], r[1]); got != want {
+			t.Errorf("RangeCount(%d, %d) = %d, want %d", r[0], r[1], got, want)
+		}
+	}
+}
+
+func TestAdaptivePromotes(t *testing.T) {
+	a := NewAdaptiveAt(5000)
+	for i := uint32(0); i < 4000; i++ {
+		a.Add(i * 7)
+		a.Add(i * 7) // Duplicates do not count towards promotion.
+	}
+	if a.Promoted() {
+		t.Fatalf("set promoted at %d IPs, limit is 5000", a.Count())
+	}
+	for i := uint32(0); i < 10000; i++ {
+		a.Add(0xF0000000 + i)
+	}
+	if !a.Promoted() {
+		t.Fatalf("set of %d IPs was not promoted", a.Count())
+	}
+	if a.Count() != 14000 || !a.Contains(7*3999) || !a.Contains(0xF0000000+9999) || a.Contains(1) {
+		t.Errorf("promoted set lost IPs: Count() = %d", a.Count())
+	}
+
+	b := NewAdaptive()
+	b.Add(1)
+	b.Add(7)
+	b.Merge(a)
+	if b.Count() != 14001 {
+		t.Errorf("merged Count() = %d, want 14001", b.Count())
+	}
+}
+
+func TestSparseSnapshotMatchesBitmap(t *testing.T) {
+	m := NewBitmap()
+	s := NewSparse()
+	for _, ip := range []uint32{0x0A000001, 0x0A00FFFF, 0xFFFFFFFF} {
+		m.Add(ip)
+		s.Add(ip)
+	}
+	for low := uint32(0); low < 5000; low++ {
+		m.Add(0xC0A80000 | low*13%65536)
+		s.Add(0xC0A80000 | low*13%65536)
+	}
+
+	var want, got bytes.Buffer
+	if _, err := m.WriteTo(&want); err != nil {
+		t.Fatalf("Bitmap.WriteTo failed: %v", err)
+	}
+	if _, err := s.WriteTo(&got); err != nil {
+		t.Fatalf("Sparse.WriteTo failed: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Fatalf("Sparse snapshot differs from the Bitmap one")
+	}
+
+	a := NewAdaptive()
+	if _, err := a.ReadFrom(&got); err != nil {
+		t.Fatalf("Adaptive.ReadFrom failed: %v", err)
+	}
+	if a.Promoted() || a.Count() != m.Count() || !a.Contains(0xFFFFFFFF) {
+		t.Errorf("loaded set: promoted=%v Count()=%d, want %d", a.Promoted(), a.Count(), m.Count())
+	}
+}
+
+func TestShardedMarkAndCount(t *testing.T) {
+	for _, layout := range []Layout{Interleaved, Ranged} {
+		for _, numShards := range []int{1, 4, 1 << 12} {
+			s := NewSharded(numShards, layout)
+			ips := []uint32{0, 1, 0xC0A80101, 0xC0A80101, 0xFFFFFFFF, 1 << 31}
+			for _, ip := range ips {
+				s.Mark(ip)
+			}
+			for _, workers := range []int{1, 3, 8} {
+				if got := s.Count(workers); got != 5 {
+					t.Errorf("%v, %d shards: Count(%d) = %d, want 5", layout, numShards, workers, got)
+				}
+			}
+			if s.Add(0xC0A80101) || !s.Contains(1<<31) || s.Contains(2) {
+				t.Errorf("%v, %d shards: Add or Contains disagree with Mark", layout, numShards)
+			}
+			m := s.Bitmap()
+			for _, ip := range ips {
+				if !m.Contains(ip) {
+					t.Errorf("%v, %d shards: Bitmap() lost %08X", layout, numShards, ip)
+				}
+			}
+			if got := m.Count(); got != 5 {
+				t.Errorf("%v, %d shards: Bitmap().Count() = %d, want 5", layout, numShards, got)
+			}
+		}
+	}
+}
+
+func TestShardedIn(t *testing.T) {
+	tests := []struct {
+		layout Layout
+		offset uint32 // Bit of 0xC0A80101 in the region, with 1024 shards.
+	}{
+		{Interleaved, 0x101<<22 | 0xC0A80101>>10}, // Shard 0x101, at the IP's high bits.
+		{Ranged, 0xC0A80101},                      // The IP itself: a flat bitmap.
+	}
+	for _, tt := range tests {
+		mem := hugemem.Heap(maxIPv4 / 8)
+		s := NewShardedIn(1<<10, tt.layout, mem)
+		if !s.Add(0xC0A80101) {
+			t.Errorf("%v: Add() = false on an empty set", tt.layout)
+		}
+		if mem.Bytes()[tt.offset/8] != 1<<(tt.offset%8) {
+			t.Errorf("%v: bit %d of the region is not set", tt.layout, tt.offset)
+		}
+		if bitset, offset := s.Locate(0xC0A80101); &bitset[0] != &mem.Bytes()[0] || offset != tt.offset {
+			t.Errorf("%v: Locate() = offset %d, want %d in the region", tt.layout, offset, tt.offset)
+		}
+		if got := s.Memory(); got != mem.Info() {
+			t.Errorf("%v: Memory() = %+v, want %+v", tt.layout, got, mem.Info())
+		}
+	}
+
+	// A Bitmap over a region sees the bits set through the region.
+	mem := hugemem.Heap(maxIPv4 / 8)
+	mem.Bytes()[0xC0A80101/8] = 1 << (0xC0A80101 % 8)
+	if b := NewBitmapIn(mem); !b.Contains(0xC0A80101) || b.Count() != 1 || b.Memory() != mem.Info() {
+		t.Errorf("NewBitmapIn() does not view the region")
+	}
+}
+
+func TestParseLayout(t *testing.T) {
+	for _, l := range []Layout{Interleaved, Ranged} {
+		if got, err := ParseLayout(l.String()); err != nil || got != l {
+			t.Errorf("ParseLayout(%q) = %v, %v, want %v", l.String(), got, err, l)
+		}
+	}
+	if _, err := ParseLayout("striped"); err == nil {
+		t.Errorf("ParseLayout(\"striped\") succeeded, want an error")
+	}
+}
@@ -0,0 +1,109 @@
+package ipset
+
+import (
+	"math/bits"
+	"sort"
+)
+
+// Constants describing the rank index geometry.
+const (
+	blockWords     = 8                           // Words per block (512 bits).
+	blocksPerSuper = containerWords / blockWords // Blocks per superblock (65536 bits).
+	numBlocks      = numWords / blockWords       // Number of blocks in the bitmap.
+	numSuperblocks = numWords / containerWords   // Number of superblocks in the bitmap.
+)
+
+// Index is a rank index over a Bitmap. It stores the cumulative popcount
+// before every 65536-bit superblock, and the popcount before every 512-bit
+// block relative to its superblock, which costs about 16.5MB next to the
+// 512MB bitmap. Rank and range counts then take a constant number of
+// popcounts, and Select a binary search over the cumulative counts.
+//
+// The index describes the bitmap at the time it was built; rebuild it after
+// adding IPs.
+type Index struct {
+	words  []uint64
+	super  []uint64 // super[s] is the number of IPs below superblock s; super[numSuperblocks] is the total.
+	blocks []uint16 // blocks[b] is the number of IPs in b's superblock before block b.
+}
+
+// NewIndex builds a rank index over m.
+func NewIndex(m *Bitmap) *Index {
+	idx := &Index{
+		words:  m.words,
+		super:  make([]uint64, numSuperblocks+1),
+		blocks: make([]uint16, numBlocks),
+	}
+	var total uint64
+	for s := 0; s < numSuperblocks; s++ {
+		idx.super[s] = total
+		var rel int
+		for b := s * blocksPerSuper; b < (s+1)*blocksPerSuper; b++ {
+			idx.blocks[b] = uint16(rel)
+			for _, w := range m.words[b*blockWords : (b+1)*blockWords] {
+				rel += bits.OnesCount64(w)
+			}
+		}
+		total += uint64(rel)
+	}
+	idx.super[numSuperblocks] = total
+	return idx
+}
+
+// Count returns the number of IPs in the indexed set.
+func (idx *Index) Count() uint64 {
+	return idx.super[numSuperblocks]
+}
+
+// Rank returns the number of IPs in the set that are strictly less than ip.
+func (idx *Index) Rank(ip uint32) uint64 {
+	w := ip / 64
+	b := ip / (64 * blockWords)
+	r := idx.super[ip>>16] + uint64(idx.blocks[b])
+	for i := b * blockWords; i < w; i++ {
+		r += uint64(bits.OnesCount64(idx.words[i]))
+	}
+	return r + uint64(bits.OnesCount64(idx.words[w]&(uint64(1)<<(ip%64)-1)))
+}
+
+// RangeCount returns the number of IPs in the set between lo and hi, inclusive.
+func (idx *Index) RangeCount(lo, hi uint32) uint64 {
+	if lo > hi {
+		return 0
+	}
+	n := idx.Rank(hi) - idx.Rank(lo)
+	if idx.words[hi/64]&(uint64(1)<<(hi%64)) != 0 {
+		n++
+	}
+	return n
+}
+
+// Select returns the k-th smallest IP in the set, counting from zero.
+// It returns false if the set has k or fewer IPs.
+func (idx *Index) Select(k uint64) (uint32, bool) {
+	if k >= idx.Count() {
+		return 0, false
+	}
+	// Last superblock whose cumulative count is at most k.
+	s := sort.Search(numSuperblocks, func(i int) bool { return idx.super[i+1] > k })
+	rem := k - idx.super[s]
+
+	// Last block within the superblock whose relative count is at most rem.
+	base := s * blocksPerSuper
+	b := base + sort.Search(blocksPerSuper, func(i int) bool {
+		return i == blocksPerSuper-1 || uint64(idx.blocks[base+i+1]) > rem
+	})
+	rem -= uint64(idx.blocks[b])
+
+	for i := b * blockWords; ; i++ {
+		word := idx.words[i]
+		n := uint64(bits.OnesCount64(word))
+		if rem < n {
+			for ; rem > 0; rem-- {
+				word &= word - 1 // Drop the lowest set bits until the k-th is lowest.
+			}
+			return uint32(i*64 + bits.TrailingZeros64(word)), true
+		}
+		rem -= n
+	}
+}
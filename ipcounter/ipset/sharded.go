@@ -0,0 +1,174 @@
+package ipset
+
+import (
+	"IP-Addr-Counter/ipcounter/hugemem"
+	"IP-Addr-Counter/ipcounter/popcount"
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Layout is the order in which a Sharded puts its shards' IPs in memory.
+type Layout int
+
+// Layouts of a Sharded.
+const (
+	// Interleaved puts ip in shard ip%n at bit ip/n, so neighbouring IPs land
+	// in different shards, and in different cache lines while shards are at
+	// least 64 bytes (up to 2^23 of them). Goroutines inserting IPs that are
+	// close together then do not fight over a line, but a run of sorted or
+	// clustered IPs touches a new line per IP.
+	Interleaved Layout = iota
+	// Ranged puts ip in shard ip>>(32-log2 n), so each shard is a contiguous
+	// range of the IPv4 space and the bitset is a flat bitmap. Sorted and
+	// clustered IPs share cache lines and pages, at the cost of contention
+	// when several goroutines insert the same /24s at once.
+	Ranged
+)
+
+// String returns the name ParseLayout accepts for l.
+func (l Layout) String() string {
+	switch l {
+	case Interleaved:
+		return "interleaved"
+	case Ranged:
+		return "ranged"
+	}
+	return fmt.Sprintf("Layout(%d)", int(l))
+}
+
+// ParseLayout returns the Layout named s, "interleaved" or "ranged".
+func ParseLayout(s string) (Layout, error) {
+	switch s {
+	case "interleaved":
+		return Interleaved, nil
+	case "ranged":
+		return Ranged, nil
+	}
+	return 0, fmt.Errorf("unknown layout %q: must be interleaved or ranged", s)
+}
+
+// Sharded is a set of IPv4 addresses stored as a bitset split into a power of
+// two shards. The shards lie one after another in a single 512MB allocation,
+// so finding a bit takes no pointer chasing, and its Layout decides which IPs
+// share a shard. Its bits are read and set with atomic operations on 32-bit
+// words, so it is safe for concurrent use.
+//
+// In both layouts, ip's bit is bit ip rotated right by rot of the bitset:
+// with Interleaved, rot is log2 of the shard count, moving the low bits of ip
+// that pick the shard to the top; with Ranged, rot is 0.
+type Sharded struct {
+	bitset []byte          // All the shards, 512MB.
+	rot    uint            // ip's bit in bitset is bits.RotateLeft32(ip, -rot).
+	mem    *hugemem.Region // Memory behind bitset.
+}
+
+// NewSharded returns an empty Sharded of numShards shards on the Go heap, in
+// the given layout. numShards must be a power of two of at most 2^26, so
+// every shard holds at least a uint64.
+func NewSharded(numShards int, layout Layout) *Sharded {
+	return NewShardedIn(numShards, layout, hugemem.Heap(maxIPv4/8))
+}
+
+// NewShardedIn is NewSharded on the 512MB of mem, which must be zeroed. The
+// Sharded keeps mem alive.
+func NewShardedIn(numShards int, layout Layout, mem *hugemem.Region) *Sharded {
+	if numShards < 1 || numShards > 1<<26 || numShards&(numShards-1) != 0 {
+		panic("ipset: shard count must be a power of two up to 2^26")
+	}
+	if len(mem.Bytes()) != maxIPv4/8 {
+		panic("ipset: sharded memory must be 512MB")
+	}
+	s := &Sharded{bitset: mem.Bytes(), mem: mem}
+	switch layout {
+	case Interleaved:
+		s.rot = uint(bits.TrailingZeros(uint(numShards)))
+	case Ranged:
+		// Shards are ranges of IPs in order: the bitset is a flat bitmap.
+	default:
+		panic("ipset: unknown layout " + layout.String())
+	}
+	return s
+}
+
+// Memory describes the memory the shards are on.
+func (s *Sharded) Memory() hugemem.Info {
+	return s.mem.Info()
+}
+
+// Locate returns the bitset holding ip and the offset of ip's bit in it, for
+// callers that set bits their own way.
+func (s *Sharded) Locate(ip uint32) ([]byte, uint32) {
+	return s.bitset, bits.RotateLeft32(ip, -int(s.rot))
+}
+
+// Layout returns the bitset and the rotation that gives each IP's bit in it,
+// for callers that set many bits at once their own way: ip lives at bit
+// bits.RotateLeft32(ip, -rot). The bitset is the set's own, not a copy.
+func (s *Sharded) Layout() (bitset []byte, rot uint) {
+	return s.bitset, s.rot
+}
+
+// Add inserts ip and returns true if it was not present before.
+func (s *Sharded) Add(ip uint32) bool {
+	ptr, mask := s.word(ip)
+	for {
+		old := atomic.LoadUint32(ptr)
+		if old&mask != 0 {
+			return false // Bit already set, IP is not unique.
+		}
+		if atomic.CompareAndSwapUint32(ptr, old, old|mask) {
+			return true // Bit newly set, IP is unique.
+		}
+	}
+}
+
+// Mark inserts ip with a single atomic OR, without finding out whether it was
+// new. After a batch of Marks, Count tells how many IPs the set holds.
+func (s *Sharded) Mark(ip uint32) {
+	ptr, mask := s.word(ip)
+	atomic.OrUint32(ptr, mask)
+}
+
+// Count returns the number of IPs in the set, a popcount of the bitset on up
+// to workers goroutines. IPs added while it runs may or may not be counted.
+func (s *Sharded) Count(workers int) int64 {
+	words := unsafe.Slice((*uint64)(unsafe.Pointer(&s.bitset[0])), len(s.bitset)/8)
+	return popcount.Parallel(words, workers)
+}
+
+// Contains reports whether ip is present. It is safe to call while other
+// goroutines add IPs.
+func (s *Sharded) Contains(ip uint32) bool {
+	ptr, mask := s.word(ip)
+	return atomic.LoadUint32(ptr)&mask != 0
+}
+
+// word returns the 32-bit word holding ip's bit and the mask of the bit.
+func (s *Sharded) word(ip uint32) (*uint32, uint32) {
+	offset := bits.RotateLeft32(ip, -int(s.rot))
+	return (*uint32)(unsafe.Pointer(&s.bitset[offset/32*4])), uint32(1) << (offset % 32)
+}
+
+// Bitmap copies the set into a flat Bitmap, rotating each bit of an
+// interleaved bitset back into the IP it stands for.
+func (s *Sharded) Bitmap() *Bitmap {
+	m := NewBitmap()
+	if s.rot == 0 {
+		for i := range m.words {
+			m.words[i] = binary.LittleEndian.Uint64(s.bitset[i*8:]) // Already in IP order.
+		}
+		return m
+	}
+	for i := 0; i < len(s.bitset); i += 8 {
+		word := binary.LittleEndian.Uint64(s.bitset[i:])
+		for word != 0 {
+			ip := bits.RotateLeft32(uint32(i*8+bits.TrailingZeros64(word)), int(s.rot))
+			m.words[ip/64] |= 1 << (ip % 64)
+			word &= word - 1
+		}
+	}
+	return m
+}
@@ -0,0 +1,345 @@
+package ipset
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+)
+
+// Snapshot format, all integers little-endian:
+//
+//	magic      [8]byte  "IPSNAP01"
+//	containers uint32   number of non-empty containers that follow
+//	per container, in increasing order of hi:
+//	  hi       uint16   high 16 bits shared by the container's IPs
+//	  card     uint32   number of IPs in the container (1..65536)
+//	  payload           card sorted uint16 low bits if card <= arrayMax,
+//	                    otherwise the raw 65536-bit bitmap (1024 uint64 words)
+const (
+	snapshotMagic  = "IPSNAP01"
+	containerWords = 1 << 16 / 64 // Words covering one container of 65536 IPs.
+	arrayMax       = 4096         // Largest container stored as a sorted array.
+)
+
+var errBadSnapshot = errors.New("invalid snapshot")
+
+// WriteTo writes the bitmap to w in the snapshot format.
+func (m *Bitmap) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+
+	// Collect container cardinalities first so the header can carry their number.
+	cards := make([]uint32, 1<<16)
+	var containers uint32
+	for hi := range cards {
+		cards[hi] = containerCount(m.words[hi*containerWords : (hi+1)*containerWords])
+		if cards[hi] > 0 {
+			containers++
+		}
+	}
+
+	var hdr [12]byte
+	copy(hdr[:8], snapshotMagic)
+	binary.LittleEndian.PutUint32(hdr[8:], containers)
+	cw.Write(hdr[:])
+
+	var buf [8]byte
+	for hi, card := range cards {
+		if card == 0 {
+			continue
+		}
+		binary.LittleEndian.PutUint16(buf[:2], uint16(hi))
+		binary.LittleEndian.PutUint32(buf[2:6], card)
+		cw.Write(buf[:6])
+
+		block := m.words[hi*containerWords : (hi+1)*containerWords]
+		if card <= arrayMax {
+			for i, word := range block {
+				for word != 0 {
+					low := uint16(i*64 + bits.TrailingZeros64(word))
+					binary.LittleEndian.PutUint16(buf[:2], low)
+					cw.Write(buf[:2])
+					word &= word - 1
+				}
+			}
+		} else {
+			for _, word := range block {
+				binary.LittleEndian.PutUint64(buf[:], word)
+				cw.Write(buf[:])
+			}
+		}
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, bw.Flush()
+}
+
+// ReadFrom replaces the contents of the bitmap with a snapshot read from r.
+func (m *Bitmap) ReadFrom(r io.Reader) (int64, error) {
+	clear(m.words)
+	return readSnapshot(r, func(hi int, block []uint64) {
+		copy(m.words[hi*containerWords:], block)
+	})
+}
+
+// MergeFrom adds the IPs of a snapshot read from r to the bitmap. Only the
+// snapshot is consumed if r is a *bufio.Reader, so it can be read off a stream
+// that carries more messages after it.
+func (m *Bitmap) MergeFrom(r io.Reader) (int64, error) {
+	return readSnapshot(r, func(hi int, block []uint64) {
+		dst := m.words[hi*containerWords : (hi+1)*containerWords]
+		for i, w := range block {
+			dst[i] |= w
+		}
+	})
+}
+
+// readSnapshot reads a snapshot from r and calls fn with the bitmap of every
+// container. The block is reused between calls.
+func readSnapshot(r io.Reader, fn func(hi int, block []uint64)) (int64, error) {
+	br := bufio.NewReader(r)
+	cr := &countingReader{r: br}
+
+	var hdr [12]byte
+	if _, err := io.ReadFull(cr, hdr[:]); err != nil {
+		return cr.n, fmt.Errorf("%w: %v", errBadSnapshot, err)
+	}
+	if string(hdr[:8]) != snapshotMagic {
+		return cr.n, fmt.Errorf("%w: bad magic", errBadSnapshot)
+	}
+	containers := binary.LittleEndian.Uint32(hdr[8:])
+
+	block := make([]uint64, containerWords)
+	var buf [8]byte
+	for c := uint32(0); c < containers; c++ {
+		if _, err := io.ReadFull(cr, buf[:6]); err != nil {
+			return cr.n, fmt.Errorf("%w: %v", errBadSnapshot, err)
+		}
+		hi := int(binary.LittleEndian.Uint16(buf[:2]))
+		card := binary.LittleEndian.Uint32(buf[2:6])
+		if card == 0 || card > 1<<16 {
+			return cr.n, fmt.Errorf("%w: container %d has cardinality %d", errBadSnapshot, hi, card)
+		}
+
+		if card <= arrayMax {
+			clear(block)
+			for i := uint32(0); i < card; i++ {
+				if _, err := io.ReadFull(cr, buf[:2]); err != nil {
+					return cr.n, fmt.Errorf("%w: %v", errBadSnapshot, err)
+				}
+				low := binary.LittleEndian.Uint16(buf[:2])
+				block[low/64] |= 1 << (low % 64)
+			}
+		} else {
+			for i := range block {
+				if _, err := io.ReadFull(cr, buf[:]); err != nil {
+					return cr.n, fmt.Errorf("%w: %v", errBadSnapshot, err)
+				}
+				block[i] = binary.LittleEndian.Uint64(buf[:])
+			}
+		}
+		fn(hi, block)
+	}
+	return cr.n, nil
+}
+
+// Save writes the bitmap to the named file. The snapshot is written to a
+// temporary file first and renamed into place, so readers never see a partial one.
+func (m *Bitmap) Save(filename string) error {
+	return SaveFile(filename, m)
+}
+
+// SaveFile atomically writes src to the named file: it is written to a
+// temporary file of its own in the same directory, synced and renamed into
+// place, so concurrent saves of one file never write to the same temporary.
+func SaveFile(filename string, src io.WriterTo) error {
+	file, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	tmp := file.Name()
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	if _, err := src.WriteTo(file); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to sync snapshot: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to close snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to rename snapshot: %w", err)
+	}
+	return nil
+}
+
+// Load reads a snapshot written by Save into a new Bitmap.
+func Load(filename string) (*Bitmap, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer file.Close()
+
+	m := NewBitmap()
+	if _, err := m.ReadFrom(file); err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %w", filename, err)
+	}
+	return m, nil
+}
+
+// WriteTo writes the set to w in the snapshot format, without building a bitmap.
+func (s *Sparse) WriteTo(w io.Writer) (int64, error) {
+	s.compact()
+	bw := bufio.NewWriter(w)
+	cw := &countingWriter{w: bw}
+
+	var containers uint32
+	for i := 0; i < len(s.ips); i = containerEnd(s.ips, i) {
+		containers++
+	}
+	var hdr [12]byte
+	copy(hdr[:8], snapshotMagic)
+	binary.LittleEndian.PutUint32(hdr[8:], containers)
+	cw.Write(hdr[:])
+
+	var buf [8]byte
+	block := make([]uint64, containerWords)
+	for i := 0; i < len(s.ips); {
+		end := containerEnd(s.ips, i)
+		card := end - i
+		binary.LittleEndian.PutUint16(buf[:2], uint16(s.ips[i]>>16))
+		binary.LittleEndian.PutUint32(buf[2:6], uint32(card))
+		cw.Write(buf[:6])
+
+		if card <= arrayMax {
+			for _, ip := range s.ips[i:end] {
+				binary.LittleEndian.PutUint16(buf[:2], uint16(ip))
+				cw.Write(buf[:2])
+			}
+		} else {
+			clear(block)
+			for _, ip := range s.ips[i:end] {
+				block[uint16(ip)/64] |= 1 << (ip % 64)
+			}
+			for _, word := range block {
+				binary.LittleEndian.PutUint64(buf[:], word)
+				cw.Write(buf[:])
+			}
+		}
+		i = end
+	}
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, bw.Flush()
+}
+
+// containerEnd returns the end of the run of ips starting at i that share its
+// high 16 bits.
+func containerEnd(ips []uint32, i int) int {
+	hi := ips[i] >> 16
+	j := i + 1
+	for j < len(ips) && ips[j]>>16 == hi {
+		j++
+	}
+	return j
+}
+
+// WriteTo writes the set to w in the snapshot format.
+func (a *Adaptive) WriteTo(w io.Writer) (int64, error) {
+	if a.bitmap != nil {
+		return a.bitmap.WriteTo(w)
+	}
+	return a.sparse.WriteTo(w)
+}
+
+// ReadFrom adds the IPs of a snapshot read from r to the set.
+func (a *Adaptive) ReadFrom(r io.Reader) (int64, error) {
+	return readSnapshot(r, func(hi int, block []uint64) {
+		for i, word := range block {
+			for word != 0 {
+				a.Add(uint32(hi)<<16 | uint32(i*64+bits.TrailingZeros64(word)))
+				word &= word - 1
+			}
+		}
+	})
+}
+
+// Save writes the set to the named file, atomically like Bitmap.Save.
+func (a *Adaptive) Save(filename string) error {
+	return SaveFile(filename, a)
+}
+
+// LoadAdaptive reads a snapshot written by Save into a new Adaptive set, which
+// stays a sorted array if the snapshot is small.
+func LoadAdaptive(filename string) (*Adaptive, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer file.Close()
+
+	a := NewAdaptive()
+	if _, err := a.ReadFrom(file); err != nil {
+		return nil, fmt.Errorf("failed to read snapshot %s: %w", filename, err)
+	}
+	return a, nil
+}
+
+// containerCount returns the number of set bits in one container.
+func containerCount(block []uint64) uint32 {
+	var n int
+	for _, w := range block {
+		n += bits.OnesCount64(w)
+	}
+	return uint32(n)
+}
+
+// countingWriter tracks the bytes written and remembers the first error.
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// countingReader tracks the bytes read.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,268 @@
+/*
+Package listener keeps a live distinct-IP count of syslog messages received
+over UDP and TCP, so rsyslog or syslog-ng can forward logs straight to the
+counter.
+
+Messages may be RFC3164 (BSD) or RFC5424. The header is skipped and the IP is
+extracted from the message body with any format.Extractor, by default the first
+IPv4 address of the body. Every IP goes into one long-lived
+concurrent.BitsetCounter, so the count covers everything received since start.
+
+TCP streams may use octet-counting or newline framing (RFC6587); the framing is
+detected per message.
+
+Pros:
+- Constant memory (the 512MB bitset) whatever the traffic.
+- Several goroutines read the UDP socket, and one serves each TCP connection.
+
+Cons:
+- UDP datagrams dropped by the kernel under load are lost, as with any syslog receiver.
+- Only IPv4 addresses are counted.
+*/
+package listener
+
+import (
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/format"
+	"IP-Addr-Counter/ipcounter/sysinfo"
+	"IP-Addr-Counter/ipcounter/utils"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Constants defining the receive limits.
+const (
+	maxMessage   = 64 * 1024                       // Largest message accepted (and UDP read size).
+	defaultRegex = `\b(\d{1,3}(?:\.\d{1,3}){3})\b` // First IPv4-looking token of the body.
+)
+
+// Stats is a point-in-time view of a Listener.
+type Stats struct {
+	Messages    int64 // Messages received.
+	Invalid     int64 // Messages without a valid IP.
+	Unique      int64 // Distinct IPs seen so far.
+	Connections int64 // TCP connections accepted.
+}
+
+// Listener counts the IPs of syslog messages.
+type Listener struct {
+	counter *concurrent.BitsetCounter
+	extract format.Extractor
+
+	messages    atomic.Int64
+	invalid     atomic.Int64
+	unique      atomic.Int64
+	connections atomic.Int64
+}
+
+// New returns a Listener that feeds counter, reading the IP of each message
+// body with extract. A nil extract uses the first IPv4 address of the body.
+func New(counter *concurrent.BitsetCounter, extract format.Extractor) *Listener {
+	if extract == nil {
+		extract, _ = format.NewRegex(defaultRegex)
+	}
+	return &Listener{counter: counter, extract: extract}
+}
+
+// Stats returns the current counts. It is safe to call while serving.
+func (l *Listener) Stats() Stats {
+	return Stats{
+		Messages:    l.messages.Load(),
+		Invalid:     l.invalid.Load(),
+		Unique:      l.unique.Load(),
+		Connections: l.connections.Load(),
+	}
+}
+
+// ServeUDP reads one message per datagram from conn until ctx is cancelled,
+// then closes conn.
+func (l *Listener) ServeUDP(ctx context.Context, conn net.PacketConn) error {
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for i := 0; i < sysinfo.CPUs(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			buf := make([]byte, maxMessage)
+			for {
+				n, _, err := conn.ReadFrom(buf)
+				if err != nil {
+					if ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+						once.Do(func() { firstErr = fmt.Errorf("udp read error: %w", err) })
+					}
+					conn.Close() // Stop the other readers too.
+					return
+				}
+				l.handle(buf[:n])
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// ServeTCP accepts connections on ln until ctx is cancelled, then closes ln
+// and every open connection.
+func (l *Listener) ServeTCP(ctx context.Context, ln net.Listener) error {
+	stop := context.AfterFunc(ctx, func() { ln.Close() })
+	defer stop()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return fmt.Errorf("tcp accept error: %w", err)
+		}
+		l.connections.Add(1)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			stop := context.AfterFunc(ctx, func() { conn.Close() })
+			defer stop()
+			defer conn.Close()
+			l.readStream(conn)
+		}()
+	}
+}
+
+// readStream handles the messages of one TCP connection until it is closed or
+// sends a malformed frame.
+func (l *Listener) readStream(r io.Reader) {
+	br := bufio.NewReaderSize(r, maxMessage)
+	for {
+		first, err := br.Peek(1)
+		if err != nil {
+			return
+		}
+		var msg []byte
+		if first[0] >= '1' && first[0] <= '9' {
+			// Octet counting: "LEN SP MSG".
+			prefix, err := br.ReadSlice(' ')
+			if err != nil {
+				return
+			}
+			n, err := strconv.Atoi(string(prefix[:len(prefix)-1]))
+			if err != nil || n > maxMessage {
+				return
+			}
+			msg = make([]byte, n)
+			if _, err := io.ReadFull(br, msg); err != nil {
+				return
+			}
+		} else {
+			// Non-transparent framing: one message per line.
+			msg, err = br.ReadSlice('\n')
+			if err != nil && (err != io.EOF || len(msg) == 0) {
+				return
+			}
+		}
+		l.handle(msg)
+	}
+}
+
+// handle counts the IP of one message. The counter's metrics see each message
+// as one line.
+func (l *Listener) handle(msg []byte) {
+	m := l.counter.Metrics()
+	m.Bytes.Add(int64(len(msg)))
+	msg = bytes.TrimRight(msg, "\r\n\x00")
+	if len(msg) == 0 {
+		return
+	}
+	l.messages.Add(1)
+	ip, err := utils.ParseIPv4(l.extract.Extract(Body(msg)))
+	if err != nil {
+		l.invalid.Add(1)
+		m.Lines.Add(1)
+		m.Invalid.Add(1)
+		return
+	}
+	if l.counter.Add(ip) {
+		l.unique.Add(1)
+	}
+}
+
+// Body returns the free-form message of an RFC3164 or RFC5424 syslog message,
+// without its priority and header. Input that does not look like syslog is
+// returned whole.
+func Body(msg []byte) []byte {
+	// PRI: "<0>" to "<191>".
+	if len(msg) < 3 || msg[0] != '<' {
+		return msg
+	}
+	end := bytes.IndexByte(msg[:min(len(msg), 5)], '>')
+	if end < 2 {
+		return msg
+	}
+	rest := msg[end+1:]
+
+	// RFC5424: "1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG".
+	if len(rest) > 2 && rest[0] == '1' && rest[1] == ' ' {
+		for i := 0; i < 6; i++ {
+			sp := bytes.IndexByte(rest, ' ')
+			if sp < 0 {
+				return nil
+			}
+			rest = rest[sp+1:]
+		}
+		rest = skipStructuredData(rest)
+		return bytes.TrimPrefix(rest, []byte("\xEF\xBB\xBF")) // UTF-8 BOM.
+	}
+
+	// RFC3164: "Mmm dd hh:mm:ss HOSTNAME TAG: MSG". Without the timestamp the
+	// rest is taken as the message, since a tag cannot be told from its text.
+	if len(rest) < 16 || rest[3] != ' ' || rest[6] != ' ' || rest[9] != ':' || rest[12] != ':' || rest[15] != ' ' {
+		return rest
+	}
+	rest = rest[16:]
+	if sp := bytes.IndexByte(rest, ' '); sp >= 0 {
+		rest = rest[sp+1:] // HOSTNAME.
+	}
+	// TAG is up to 32 characters, optionally followed by "[pid]", and ends at ':'.
+	if colon := bytes.IndexByte(rest[:min(len(rest), 48)], ':'); colon > 0 && bytes.IndexByte(rest[:colon], ' ') < 0 {
+		rest = bytes.TrimPrefix(rest[colon+1:], []byte(" "))
+	}
+	return rest
+}
+
+// skipStructuredData skips the RFC5424 STRUCTURED-DATA field and the space after it.
+func skipStructuredData(b []byte) []byte {
+	if len(b) > 0 && b[0] == '-' {
+		return bytes.TrimPrefix(b[1:], []byte(" "))
+	}
+	for len(b) > 0 && b[0] == '[' {
+		i := 1
+		for ; i < len(b); i++ {
+			if b[i] == '\\' {
+				i++ // Escaped '"', '\' or ']'.
+				continue
+			}
+			if b[i] == ']' {
+				break
+			}
+		}
+		if i >= len(b) {
+			return nil
+		}
+		b = b[i+1:]
+	}
+	return bytes.TrimPrefix(b, []byte(" "))
+}
@@ -0,0 +1,103 @@
+package listener
+
+import (
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/format"
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBody(t *testing.T) {
+	tests := []struct{ msg, want string }{
+		{"<34>Oct 11 22:14:15 mymachine su: 'su root' failed for lonvick on /dev/pts/8", "'su root' failed for lonvick on /dev/pts/8"},
+		{"<13>Feb  5 17:32:18 10.0.0.99 sshd[412]: Failed password from 192.0.2.7", "Failed password from 192.0.2.7"},
+		{`<165>1 2003-10-11T22:14:15.003Z mymachine.example.com evntslog - ID47 [exampleSDID@32473 iut="3" eventSource="App\]"] 198.51.100.1 GET /`, "198.51.100.1 GET /"},
+		{"<165>1 2003-08-24T05:14:15.000003-07:00 192.0.2.1 myproc 8710 - - \xEF\xBB\xBF203.0.113.5 hit", "203.0.113.5 hit"},
+		{"<14>10.1.1.1: no header", "10.1.1.1: no header"},
+		{"not syslog at all", "not syslog at all"},
+	}
+	for _, tt := range tests {
+		if got := string(Body([]byte(tt.msg))); got != tt.want {
+			t.Errorf("Body(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+// waitFor polls until l has received n messages.
+func waitFor(t *testing.T, l *Listener, n int64) Stats {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if s := l.Stats(); s.Messages >= n {
+			return s
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("received %d messages, want %d", l.Stats().Messages, n)
+	return Stats{}
+}
+
+func TestUDPAndTCP(t *testing.T) {
+	counter := concurrent.New()
+	l := New(counter, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 2)
+	go func() { done <- l.ServeUDP(ctx, pc) }()
+	go func() { done <- l.ServeTCP(ctx, ln) }()
+
+	udp, err := net.Dial("udp", pc.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(udp, "<13>Feb  5 17:32:18 host app: client 10.0.0.%d connected", i%10)
+		time.Sleep(time.Millisecond) // Keep the loopback buffer from overflowing.
+	}
+	udp.Close()
+	waitFor(t, l, 50)
+
+	tcp, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Newline framing, then octet counting, on the same stream.
+	fmt.Fprintf(tcp, "<13>Feb  5 17:32:18 host app: client 10.0.1.1 connected\n")
+	fmt.Fprintf(tcp, "<13>Feb  5 17:32:18 host app: nothing to see\n")
+	msg := "<165>1 2003-10-11T22:14:15.003Z host app - - - from 10.0.0.3\nand 10.0.1.2"
+	fmt.Fprintf(tcp, "%d %s", len(msg), msg)
+	tcp.Close()
+	s := waitFor(t, l, 53)
+
+	cancel()
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Errorf("serve returned %v", err)
+		}
+	}
+	if s.Unique != 11 || s.Invalid != 1 || s.Connections != 1 {
+		t.Errorf("Stats() = %+v, want 11 unique, 1 invalid, 1 connection", s)
+	}
+	if !counter.Contains(0x0A000101) || counter.Contains(0x0A000102) {
+		t.Errorf("counter holds the wrong IPs")
+	}
+}
+
+func TestCustomExtractor(t *testing.T) {
+	l := New(concurrent.New(), format.NewJSON("client"))
+	l.handle([]byte(`<14>1 2024-01-01T00:00:00Z h app - - - {"server":"10.9.9.9","client":"10.1.2.3"}`))
+	if s := l.Stats(); s.Unique != 1 || !l.counter.Contains(0x0A010203) {
+		t.Errorf("Stats() = %+v, want the client IP counted", s)
+	}
+}
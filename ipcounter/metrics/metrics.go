@@ -0,0 +1,162 @@
+/*
+Package metrics is a minimal registry of counters and gauges exposed in the
+Prometheus text format, so the long-running modes can be scraped without
+pulling in a client library.
+
+Metrics are either values owned by the registry (Counter, Gauge), updated with
+a single atomic operation, or functions read at scrape time (CounterFunc,
+GaugeFunc) for values that already live elsewhere. Labels are not supported;
+every metric is a single series.
+*/
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// validName matches the metric names Prometheus accepts.
+var validName = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+
+// Counter is a monotonically increasing integer.
+type Counter struct {
+	v atomic.Int64
+}
+
+// Add increases the counter by n, which must not be negative.
+func (c *Counter) Add(n int64) {
+	c.v.Add(n)
+}
+
+// Load returns the current value.
+func (c *Counter) Load() int64 {
+	return c.v.Load()
+}
+
+// Gauge is an integer that can go up and down.
+type Gauge struct {
+	v atomic.Int64
+}
+
+// Set replaces the value of the gauge.
+func (g *Gauge) Set(n int64) {
+	g.v.Store(n)
+}
+
+// Add changes the gauge by n.
+func (g *Gauge) Add(n int64) {
+	g.v.Add(n)
+}
+
+// Load returns the current value.
+func (g *Gauge) Load() int64 {
+	return g.v.Load()
+}
+
+// metric is one registered series.
+type metric struct {
+	name  string
+	help  string
+	kind  string // "counter" or "gauge".
+	value func() float64
+}
+
+// Registry holds a set of metrics. It is safe for concurrent use.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// NewRegistry returns an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register adds a metric. Invalid or duplicate names are programming errors
+// and panic, like the standard library does for duplicate HTTP patterns.
+func (r *Registry) register(name, help, kind string, value func() float64) {
+	if !validName.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic(fmt.Sprintf("metrics: duplicate metric %q", name))
+	}
+	r.names[name] = true
+	r.metrics = append(r.metrics, metric{name: name, help: help, kind: kind, value: value})
+}
+
+// Counter registers and returns a new counter.
+func (r *Registry) Counter(name, help string) *Counter {
+	c := &Counter{}
+	r.register(name, help, "counter", func() float64 { return float64(c.Load()) })
+	return c
+}
+
+// Gauge registers and returns a new gauge.
+func (r *Registry) Gauge(name, help string) *Gauge {
+	g := &Gauge{}
+	r.register(name, help, "gauge", func() float64 { return float64(g.Load()) })
+	return g
+}
+
+// CounterFunc registers a counter whose value is read from fn at scrape time.
+func (r *Registry) CounterFunc(name, help string, fn func() float64) {
+	r.register(name, help, "counter", fn)
+}
+
+// GaugeFunc registers a gauge whose value is read from fn at scrape time.
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(name, help, "gauge", fn)
+}
+
+// WriteTo writes every metric to w in the Prometheus text format, in
+// registration order.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	var n int64
+	for _, m := range metrics {
+		k, _ := fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n%s %s\n",
+			m.name, escapeHelp(m.help), m.name, m.kind, m.name, formatValue(m.value()))
+		n += int64(k)
+	}
+	return n, bw.Flush()
+}
+
+// ServeHTTP serves the metrics, so a Registry can be mounted at /metrics.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.WriteTo(w)
+}
+
+// escapeHelp escapes a help string as the text format requires.
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// formatValue formats a sample value, spelling out the special values.
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
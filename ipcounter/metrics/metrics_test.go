@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	r := NewRegistry()
+	lines := r.Counter("lines_total", "Lines processed.")
+	depth := r.Gauge("queue_depth", "Chunks waiting.\nSampled.")
+	r.GaugeFunc("busy_seconds", "Busy time.", func() float64 { return 1.5 })
+	r.GaugeFunc("broken", "Not a number.", func() float64 { return math.NaN() })
+	lines.Add(3)
+	lines.Add(4)
+	depth.Set(9)
+	depth.Add(-2)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	want := `# HELP lines_total Lines processed.
+# TYPE lines_total counter
+lines_total 7
+# HELP queue_depth Chunks waiting.\nSampled.
+# TYPE queue_depth gauge
+queue_depth 7
+# HELP busy_seconds Busy time.
+# TYPE busy_seconds gauge
+busy_seconds 1.5
+# HELP broken Not a number.
+# TYPE broken gauge
+broken NaN
+`
+	if got := rec.Body.String(); got != want {
+		t.Errorf("exposition:\n%s\nwant:\n%s", got, want)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+}
+
+func TestRegisterPanics(t *testing.T) {
+	for _, name := range []string{"dup", "bad-name"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("registering %q did not panic", name)
+				}
+			}()
+			r := NewRegistry()
+			r.Counter("dup", "")
+			r.Counter(name, "")
+		}()
+	}
+}
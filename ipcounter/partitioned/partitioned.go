@@ -0,0 +1,250 @@
+/*
+Package partitioned provides an implementation for counting unique IPv4
+addresses that sets bits without atomic operations.
+
+The bitset is split by the high bits of the IP into one contiguous range per
+owner goroutine. Workers parse their chunks and scatter the IPs into small
+per-range batches; full batches go to the owner of the range, which is the only
+goroutine that ever writes it and so sets bits with plain stores. The count is
+a popcount of the bitset once the input ends, each owner summing its own range.
+
+Pros:
+  - No compare-and-swap and no cache lines bouncing between cores, even when a
+    few /24s take most of the traffic.
+
+Cons:
+  - IPs are copied once more, into the batches, and every count ends with a
+    popcount of the whole 512MB, shared among the owners.
+  - An uneven spread of high bits leaves some owners busier than others.
+  - Whether an IP was new is only known for the whole count, so there are no
+    first-occurrence semantics (see concurrent for those).
+*/
+package partitioned
+
+import (
+	"IP-Addr-Counter/ipcounter/format"
+	"IP-Addr-Counter/ipcounter/hugemem"
+	"IP-Addr-Counter/ipcounter/ipset"
+	"IP-Addr-Counter/ipcounter/pipeline"
+	"IP-Addr-Counter/ipcounter/popcount"
+	"IP-Addr-Counter/ipcounter/topk"
+	"IP-Addr-Counter/ipcounter/tuning"
+	"IP-Addr-Counter/ipcounter/utils"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"sync"
+)
+
+// Constants defining configuration for the partitioned implementation.
+const (
+	batchLen    = 4096        // IPs per batch sent to an owner.
+	inboxLen    = 4           // Batches that may wait for an owner, per worker.
+	maxOwners   = 64          // Most ranges the bitset is split into.
+	ipsPerWord  = 64          // IPs covered by one bitset word.
+	bitsetBytes = 1 << 32 / 8 // The bitset over the whole IPv4 space.
+)
+
+// PartitionedCounter counts unique IPs into a bitset whose ranges are each
+// written by a single goroutine.
+type PartitionedCounter struct {
+	set     *ipset.Bitmap    // IPs seen so far.
+	total   int64            // Popcount of set after the last count.
+	opts    tuning.Options   // Worker count, chunk size and queue length.
+	topK    int              // Number of heavy hitters to track, 0 to disable.
+	top     *topk.Result     // Heavy hitters found by the last count.
+	extract format.Extractor // Pulls the IP field out of each line, nil for bare IPs.
+}
+
+// parser parses bare IPs with utils.ParseIPv4.
+var parser = pipeline.ParserFunc(func(field []byte) (uint32, bool) {
+	ip, err := utils.ParseIPv4(field)
+	return ip, err == nil
+})
+
+// New returns an empty PartitionedCounter using the default settings changed
+// by opts; the shard count is not used. It panics if the settings are invalid.
+func New(opts ...tuning.Option) *PartitionedCounter {
+	o, err := tuning.New(opts...)
+	if err != nil {
+		panic("partitioned: " + err.Error())
+	}
+	return &PartitionedCounter{set: ipset.NewBitmapIn(o.Alloc(bitsetBytes)), opts: o}
+}
+
+// Memory describes the memory the bitset is on, including the page size.
+func (c *PartitionedCounter) Memory() hugemem.Info {
+	return c.set.Memory()
+}
+
+// UseFormat makes subsequent counts read the IP from the field selected by e
+// instead of treating each line as a bare IP. A nil e restores bare IPs.
+func (c *PartitionedCounter) UseFormat(e format.Extractor) {
+	c.extract = e
+}
+
+// TrackTopK enables heavy-hitter tracking for subsequent counts. A k of 0
+// disables tracking.
+func (c *PartitionedCounter) TrackTopK(k int) {
+	c.topK = k
+}
+
+// TopK returns the heavy hitters found by the last count, or nil if tracking
+// was disabled.
+func (c *PartitionedCounter) TopK() *topk.Result {
+	return c.top
+}
+
+// Contains reports whether ip was seen by a previous count. Unlike the other
+// sharded counters, it must not be called while a count is running.
+func (c *PartitionedCounter) Contains(ip uint32) bool {
+	return c.set.Contains(ip)
+}
+
+// ContainsAll reports, for each of ips, whether it was seen by a previous count.
+func (c *PartitionedCounter) ContainsAll(ips []uint32) []bool {
+	found := make([]bool, len(ips))
+	for i, ip := range ips {
+		found[i] = c.set.Contains(ip)
+	}
+	return found
+}
+
+// Bitmap returns the seen IPs. The bitmap is the counter's own, not a copy.
+func (c *PartitionedCounter) Bitmap() *ipset.Bitmap {
+	return c.set
+}
+
+// CountUniqueIPs counts the unique IPv4 addresses of the file that were not
+// seen by a previous count.
+func (c *PartitionedCounter) CountUniqueIPs(filename string) (int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	return c.Count(file)
+}
+
+// Count counts the lines of r like CountUniqueIPs counts a file.
+func (c *PartitionedCounter) Count(r io.Reader) (int64, error) {
+	numWorkers := c.opts.NumWorkers()
+	numOwners := owners(numWorkers)
+	shift := 32 - bits.TrailingZeros(uint(numOwners)) // An IP's owner is ip>>shift.
+
+	// Owners set the bits of their range, then popcount it.
+	batchPool := sync.Pool{New: func() interface{} { return make([]uint32, 0, batchLen) }}
+	inboxes := make([]chan []uint32, numOwners)
+	counts := make([]int64, numOwners)
+	words := c.set.Words()
+	rangeWords := len(words) / numOwners
+	var owned sync.WaitGroup
+	for i := range inboxes {
+		inboxes[i] = make(chan []uint32, inboxLen*numWorkers)
+		owned.Add(1)
+		go func(owner int) {
+			defer owned.Done()
+			for batch := range inboxes[owner] {
+				for _, ip := range batch {
+					words[ip/ipsPerWord] |= 1 << (ip % ipsPerWord) // Plain store: no one else writes this range.
+				}
+				batchPool.Put(batch[:0])
+			}
+			counts[owner] = popcount.Count(words[owner*rangeWords : (owner+1)*rangeWords])
+		}(i)
+	}
+
+	// Workers parse their chunks into one batch per owner.
+	scatters := make([]*scatter, numWorkers)
+	trackers := make([]*topk.Tracker, numWorkers)
+	for i := range scatters {
+		scatters[i] = &scatter{batches: make([][]uint32, numOwners), shift: uint(shift), inboxes: inboxes, pool: &batchPool}
+		if c.topK > 0 {
+			trackers[i] = topk.NewTracker(c.topK)
+		}
+	}
+	cfg := pipeline.Config{Options: c.opts, Extract: c.extract}
+	cfg.Options.Workers = numWorkers
+	err := pipeline.Run(r, cfg, func(worker int, chunk []byte) {
+		pipeline.CountChunk(chunk, parser, scatters[worker], cfg, trackers[worker])
+	})
+
+	// Send what is left, then let the owners finish and count.
+	for _, s := range scatters {
+		s.flushAll()
+	}
+	for _, inbox := range inboxes {
+		close(inbox)
+	}
+	owned.Wait()
+
+	var total int64
+	for _, n := range counts {
+		total += n
+	}
+	added := total - c.total
+	c.total = total // Kept even on error, so the next count starts from the bits set.
+	if err != nil {
+		return 0, err
+	}
+	if c.topK > 0 {
+		c.top = topk.Merge(c.topK, trackers...)
+	}
+	return added, nil
+}
+
+// Footprint returns the least memory a count with settings o needs: that of
+// o.Footprint, plus the batches on their way to the owners. Every worker fills
+// one batch per owner, and each owner may have inboxLen batches per worker
+// waiting and one being set.
+func Footprint(o tuning.Options) int64 {
+	numWorkers := o.NumWorkers()
+	batches := owners(numWorkers) * (numWorkers*(inboxLen+1) + 1)
+	return o.Footprint() + int64(batches)*batchLen*4
+}
+
+// owners returns the number of ranges to split the bitset into for numWorkers
+// workers: the power of two at or above it, up to maxOwners.
+func owners(numWorkers int) int {
+	n := 1
+	for n < numWorkers && n < maxOwners {
+		n *= 2
+	}
+	return n
+}
+
+// scatter is a worker's set of batches, one per owner. It is a pipeline.Set
+// that never knows whether an IP is new.
+type scatter struct {
+	batches [][]uint32      // Pending IPs of each owner.
+	shift   uint            // An IP's owner is ip>>shift.
+	inboxes []chan []uint32 // Where full batches go, by owner.
+	pool    *sync.Pool      // Recycles batches.
+}
+
+// Add queues ip for its owner. It reports false: the owner decides later.
+func (s *scatter) Add(ip uint32) bool {
+	owner := int(uint64(ip) >> s.shift)
+	b := s.batches[owner]
+	if b == nil {
+		b = s.pool.Get().([]uint32)
+	}
+	b = append(b, ip)
+	if len(b) == batchLen {
+		s.inboxes[owner] <- b
+		b = nil
+	}
+	s.batches[owner] = b
+	return false
+}
+
+// flushAll sends every non-empty batch to its owner.
+func (s *scatter) flushAll() {
+	for owner, b := range s.batches {
+		if len(b) > 0 {
+			s.inboxes[owner] <- b
+		}
+		s.batches[owner] = nil
+	}
+}
@@ -0,0 +1,48 @@
+package partitioned
+
+import (
+	"IP-Addr-Counter/ipcounter/tuning"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCountUniqueIPs(t *testing.T) {
+	// IPs from every /8 reach every owner; the repeats land in other batches.
+	var sb strings.Builder
+	for i := 0; i < 3*256; i++ {
+		fmt.Fprintf(&sb, "%d.%d.0.1\n", i%256, i/256%2)
+	}
+	sb.WriteString("\n bad \n255.255.255.255")
+	path := filepath.Join(t.TempDir(), "ips.txt")
+	os.WriteFile(path, []byte(sb.String()), 0o644)
+
+	for _, workers := range []int{1, 3, 8} {
+		c := New(tuning.Workers(workers), tuning.ChunkBytes(100))
+		count, err := c.CountUniqueIPs(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count != 513 {
+			t.Errorf("%d workers: CountUniqueIPs() = %d, want 513", workers, count)
+		}
+		if !c.Contains(0xFFFFFFFF) || c.Contains(0x01020001) {
+			t.Errorf("%d workers: Contains() disagrees with the input", workers)
+		}
+
+		// A second count only reports the IPs it added.
+		if count, err := c.CountUniqueIPs(path); err != nil || count != 0 {
+			t.Errorf("%d workers: second CountUniqueIPs() = %d, %v, want 0", workers, count, err)
+		}
+	}
+}
+
+func TestOwners(t *testing.T) {
+	for workers, want := range map[int]int{1: 1, 2: 2, 3: 4, 8: 8, 100: maxOwners} {
+		if got := owners(workers); got != want {
+			t.Errorf("owners(%d) = %d, want %d", workers, got, want)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package pipeline
+
+import (
+	"IP-Addr-Counter/ipcounter/checkpoint"
+	"IP-Addr-Counter/ipcounter/format"
+	"IP-Addr-Counter/ipcounter/hugemem"
+	"IP-Addr-Counter/ipcounter/ipset"
+	"IP-Addr-Counter/ipcounter/topk"
+	"IP-Addr-Counter/ipcounter/tuning"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"time"
+)
+
+// Bitset is the set of a Counter: a bitset over the whole IPv4 space, with the
+// kernels a counter sets its bits with. Add is used by counts and Mark by
+// deferred ones; both may also take batches, as a BatchSet and a BatchMarkSet.
+type Bitset interface {
+	Set
+	MarkSet
+	// Contains reports whether ip is in the set. It must be safe to call
+	// while IPs are added.
+	Contains(ip uint32) bool
+	// Bitmap copies the set into a flat ipset.Bitmap.
+	Bitmap() *ipset.Bitmap
+	// Memory describes the memory the set is on.
+	Memory() hugemem.Info
+}
+
+// Counter is the part of a sharded counter above its Parser and Bitset: the
+// settings, record format, heavy hitters, checkpoints and deferred counts of
+// its counts, and the queries on the IPs it has seen. Counters embed it and
+// only supply the two pieces.
+type Counter struct {
+	set      Bitset             // IPs seen so far.
+	parser   Parser             // Parses the IP field of every line.
+	opts     tuning.Options     // Worker count, chunk size, queue length and shard layout.
+	metrics  *Metrics           // Running totals, nil to keep none.
+	topK     int                // Number of heavy hitters to track, 0 to disable.
+	top      *topk.Result       // Heavy hitters found by the last count.
+	extract  format.Extractor   // Pulls the IP field out of each line, nil for bare IPs.
+	ckpt     checkpoint.Options // Checkpointing of the next count.
+	deferred Deferred           // Popcount bookkeeping of tuning.Deferred counts.
+}
+
+// NewCounter returns a Counter that inserts the IPs p parses into set, with
+// settings o.
+func NewCounter(set Bitset, p Parser, o tuning.Options) *Counter {
+	return &Counter{set: set, parser: p, opts: o}
+}
+
+// Options returns the settings of the counts.
+func (c *Counter) Options() tuning.Options {
+	return c.opts
+}
+
+// Memory describes the memory the bitset is on, including the page size.
+func (c *Counter) Memory() hugemem.Info {
+	return c.set.Memory()
+}
+
+// Contains reports whether ip was seen by a previous count. It is safe to call
+// while a count is running.
+func (c *Counter) Contains(ip uint32) bool {
+	return c.set.Contains(ip)
+}
+
+// ContainsAll reports, for each of ips, whether it was seen by a previous count.
+func (c *Counter) ContainsAll(ips []uint32) []bool {
+	found := make([]bool, len(ips))
+	for i, ip := range ips {
+		found[i] = c.Contains(ip)
+	}
+	return found
+}
+
+// Bitmap copies the bitset into a flat ipset.Bitmap.
+func (c *Counter) Bitmap() *ipset.Bitmap {
+	return c.set.Bitmap()
+}
+
+// Index builds a rank index over the seen IPs for range counts and select
+// queries. The bitset is flattened first, so this allocates another 512MB.
+func (c *Counter) Index() *ipset.Index {
+	return ipset.NewIndex(c.Bitmap())
+}
+
+// UseFormat makes subsequent counts read the IP from the field selected by e
+// instead of treating each line as a bare IP. A nil e restores bare IPs.
+func (c *Counter) UseFormat(e format.Extractor) {
+	c.extract = e
+}
+
+// Field returns the IP field of a trimmed line, as selected by UseFormat.
+func (c *Counter) Field(line []byte) []byte {
+	if c.extract == nil {
+		return line
+	}
+	return c.extract.Extract(line)
+}
+
+// TrackTopK enables heavy-hitter tracking for subsequent counts. Each worker
+// feeds its own topk.Tracker while processing chunks, and the trackers are
+// merged when the count finishes. A k of 0 disables tracking.
+func (c *Counter) TrackTopK(k int) {
+	c.topK = k
+}
+
+// TopK returns the heavy hitters found by the last count, or nil if tracking
+// was disabled.
+func (c *Counter) TopK() *topk.Result {
+	return c.top
+}
+
+// Metrics returns the running totals of the counter, nil if it keeps none.
+func (c *Counter) Metrics() *Metrics {
+	return c.metrics
+}
+
+// UseMetrics makes the counter add its totals to m, so several counters can
+// report together. A nil m keeps none.
+func (c *Counter) UseMetrics(m *Metrics) {
+	c.metrics = m
+}
+
+// CountUniqueIPs counts unique IPv4 addresses in the specified file.
+// It reads the file in chunks, processes them concurrently using multiple goroutines,
+// and aggregates the count of unique IPs in the bitset.
+func (c *Counter) CountUniqueIPs(filename string) (int64, error) {
+	// Open the input file for reading.
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+	if c.ckpt.Enabled() {
+		defer func() { c.ckpt.Offset, c.ckpt.Resumed, c.ckpt.From = 0, 0, nil }() // Resuming is one-shot.
+		return checkpoint.Count(file, c.ckpt, c.Bitmap, c.count)
+	}
+	return c.count(file, nil)
+}
+
+// Count counts the lines of r like CountUniqueIPs counts a file, returning the
+// number of IPs that were not seen before. It lets callers count part of a
+// file, such as an io.SectionReader over a byte range.
+func (c *Counter) Count(r io.Reader) (int64, error) {
+	return c.count(r, nil)
+}
+
+// count is Count, also reporting finished chunks to wm.
+func (c *Counter) count(r io.Reader, wm *checkpoint.Watermark) (int64, error) {
+	var (
+		res Result
+		err error
+	)
+	if c.opts.Deferred {
+		res, err = c.deferred.Count(r, c.parser, c.set, c.config(wm))
+	} else {
+		c.deferred.Touch()
+		res, err = Count(r, c.parser, c.set, c.config(wm))
+	}
+	if err != nil {
+		return 0, err
+	}
+	if c.topK > 0 {
+		c.top = res.Top
+	}
+	return res.Unique, nil
+}
+
+// AddLines inserts the IP of every valid line of chunk like Count, on the
+// calling goroutine, and returns the number of IPs that were new. Every line
+// must be newline-terminated. It is safe for concurrent use.
+func (c *Counter) AddLines(chunk []byte) int64 {
+	c.deferred.Touch()
+	return CountChunk(chunk, c.parser, c.set, c.config(nil), nil)
+}
+
+// Insert marks a single ip as seen and reports whether it was new. It is safe
+// for concurrent use.
+func (c *Counter) Insert(ip uint32) bool {
+	c.deferred.Touch()
+	return c.set.Add(ip)
+}
+
+// config returns the pipeline settings of a count reporting to wm.
+func (c *Counter) config(wm *checkpoint.Watermark) Config {
+	return Config{Options: c.opts, Extract: c.extract, TopK: c.topK, Metrics: c.metrics, Watermark: wm}
+}
+
+// UseCheckpoint makes CountUniqueIPs save a checkpoint to filename every
+// interval and when it ends, recording that the input is read with the format
+// spec format. Each checkpoint flattens the bitset, which briefly takes
+// another 512MB.
+func (c *Counter) UseCheckpoint(filename string, interval time.Duration, format string) {
+	c.ckpt.Filename = filename
+	c.ckpt.Interval = interval
+	c.ckpt.Format = format
+}
+
+// Resume adds the IPs of cp to the bitset and makes the next CountUniqueIPs
+// start reading at cp.Offset, if the file is the one cp was taken of. Its
+// result still counts the whole file.
+func (c *Counter) Resume(cp *checkpoint.Checkpoint) {
+	var n int64
+	for i, word := range cp.Set.Words() {
+		for word != 0 {
+			ip := uint32(i*64 + bits.TrailingZeros64(word))
+			if c.Insert(ip) {
+				n++
+			}
+			word &= word - 1
+		}
+	}
+	c.ckpt.Offset = cp.Offset
+	c.ckpt.Resumed += n
+	c.ckpt.From = &cp.Input
+}
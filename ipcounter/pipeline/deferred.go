@@ -0,0 +1,90 @@
+package pipeline
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// MarkSet is the set of a deferred count: it marks IPs without telling whether
+// they were new, and counts them all once the input ends.
+type MarkSet interface {
+	// Mark inserts ip. It must be safe for concurrent use.
+	Mark(ip uint32)
+	// Count returns the number of IPs in the set, sweeping it with up to
+	// workers goroutines.
+	Count(workers int) int64
+}
+
+// BatchMarkSet is a MarkSet that can also mark many IPs in one call, for the
+// batches of a LineParser.
+type BatchMarkSet interface {
+	MarkSet
+	// MarkBatch inserts ips. It must be safe for concurrent use.
+	MarkBatch(ips []uint32)
+}
+
+// Deferred runs the deferred counts of one set, tuning.Deferred: IPs are
+// marked without a compare, and the new ones are how much the popcount of the
+// set grew. It keeps the popcount taken after each count, so the next one
+// sweeps the set once rather than twice. Its zero value suits an empty set.
+type Deferred struct {
+	swept int64       // Popcount of the set after the last deferred count.
+	stale atomic.Bool // Whether IPs may have been added since swept was taken.
+}
+
+// Touch notes that IPs may be added to the set other than by Count. It only
+// writes the flag if it is not set yet, so it is cheap to call per IP.
+func (d *Deferred) Touch() {
+	if !d.stale.Load() {
+		d.stale.Store(true)
+	}
+}
+
+// Count is pipeline.Count with the IPs marked in s. The new IPs are added to
+// cfg.Metrics at the end, since the workers cannot tell them.
+func (d *Deferred) Count(r io.Reader, p Parser, s MarkSet, cfg Config) (Result, error) {
+	numWorkers := cfg.Options.NumWorkers()
+	before := d.swept
+	if d.stale.Swap(true) { // Stays stale if the count fails halfway.
+		before = s.Count(numWorkers)
+	}
+	var set Set = marker{s}
+	if bs, ok := s.(BatchMarkSet); ok {
+		set = batchMarker{marker{s}, bs}
+	}
+	res, err := Count(r, p, set, cfg)
+	if err != nil {
+		return Result{}, err
+	}
+	d.swept = s.Count(numWorkers)
+	d.stale.Store(false)
+	res.Unique = d.swept - before
+	if cfg.Metrics != nil {
+		cfg.Metrics.Unique.Add(res.Unique)
+	}
+	return res, nil
+}
+
+// marker is the Set of a deferred count over a MarkSet.
+type marker struct {
+	s MarkSet
+}
+
+// Add marks ip. It reports false: only the popcount at the end tells how many
+// IPs were new.
+func (m marker) Add(ip uint32) bool {
+	m.s.Mark(ip)
+	return false
+}
+
+// batchMarker is the BatchSet of a deferred count over a BatchMarkSet.
+type batchMarker struct {
+	marker
+	bs BatchMarkSet
+}
+
+// AddBatch marks ips. It returns 0, like Add.
+func (m batchMarker) AddBatch(ips []uint32) int64 {
+	m.bs.MarkBatch(ips)
+	return 0
+}
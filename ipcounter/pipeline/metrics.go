@@ -0,0 +1,38 @@
+package pipeline
+
+import (
+	"IP-Addr-Counter/ipcounter/metrics"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics holds the running totals of a counter for monitoring. Workers add
+// their line counts once per chunk, so keeping them costs a few atomic
+// operations per chunk rather than per line.
+type Metrics struct {
+	Lines      atomic.Int64 // Non-empty lines processed.
+	Invalid    atomic.Int64 // Non-empty lines without a valid IP.
+	Unique     atomic.Int64 // IPs inserted for the first time.
+	Bytes      atomic.Int64 // Input bytes read.
+	QueueDepth atomic.Int64 // Chunks waiting for a worker, sampled at every send and receive.
+	BusyNanos  atomic.Int64 // Time workers spent processing chunks.
+	StallNanos atomic.Int64 // Time the reader waited for room in the chunk queue.
+}
+
+// Register exposes m in r under the ipcounter_ prefix.
+func (m *Metrics) Register(r *metrics.Registry) {
+	r.CounterFunc("ipcounter_lines_processed_total", "Non-empty lines processed.",
+		func() float64 { return float64(m.Lines.Load()) })
+	r.CounterFunc("ipcounter_bytes_read_total", "Input bytes read.",
+		func() float64 { return float64(m.Bytes.Load()) })
+	r.CounterFunc("ipcounter_invalid_lines_total", "Non-empty lines without a valid IP.",
+		func() float64 { return float64(m.Invalid.Load()) })
+	r.GaugeFunc("ipcounter_unique_ips", "Distinct IPs seen so far.",
+		func() float64 { return float64(m.Unique.Load()) })
+	r.GaugeFunc("ipcounter_chunk_queue_depth", "Chunks waiting in the queue for a worker.",
+		func() float64 { return float64(m.QueueDepth.Load()) })
+	r.CounterFunc("ipcounter_worker_busy_seconds_total", "Time workers spent processing chunks, summed over workers.",
+		func() float64 { return time.Duration(m.BusyNanos.Load()).Seconds() })
+	r.CounterFunc("ipcounter_reader_stall_seconds_total", "Time the reader waited for room in the chunk queue.",
+		func() float64 { return time.Duration(m.StallNanos.Load()).Seconds() })
+}
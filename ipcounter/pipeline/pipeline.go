@@ -0,0 +1,344 @@
+/*
+Package pipeline is the counting engine shared by the sharded counters: a
+reader cuts the input into chunks of whole lines, a pool of workers parses
+each chunk and inserts its IPs into a set, and the per-worker results are
+aggregated when the input ends.
+
+A counter only supplies the two pieces that make it different: a Parser that
+turns a line into an address and a Set that records it. Everything else, from
+line splitting and whitespace trimming to the chunk queue, metrics,
+checkpoint watermarks and heavy hitters, behaves the same for all of them.
+
+	res, err := pipeline.Count(file, pipeline.ParserFunc(parse), set, pipeline.Config{Options: opts})
+
+A counter with vector kernels can also take whole runs of lines at a time by
+making its Parser a LineParser and its Set a BatchSet; lines the kernels do not
+take still go through Parse and Add one by one. For tuning.Deferred, a counter
+supplies a MarkSet instead, and Deferred tells the new IPs from its popcount.
+*/
+package pipeline
+
+import (
+	"IP-Addr-Counter/ipcounter/checkpoint"
+	"IP-Addr-Counter/ipcounter/format"
+	"IP-Addr-Counter/ipcounter/topk"
+	"IP-Addr-Counter/ipcounter/tuning"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Parser turns the IP field of a line, trimmed of surrounding whitespace, into
+// an address. It must be safe for concurrent use.
+type Parser interface {
+	Parse(field []byte) (ip uint32, ok bool)
+}
+
+// ParserFunc adapts a function to a Parser.
+type ParserFunc func(field []byte) (uint32, bool)
+
+// Parse calls f.
+func (f ParserFunc) Parse(field []byte) (uint32, bool) {
+	return f(field)
+}
+
+// LineParser is a Parser that can also parse many lines of bare IPs in one
+// call. CountChunk uses it when there is no Config.Extract.
+type LineParser interface {
+	Parser
+	// ParseLines parses newline-terminated lines from the start of buf into
+	// ips for as long as each is a bare address and ips has room. It returns
+	// the number of addresses written and the bytes of buf they took. It may
+	// stop before any line, which CountChunk then handles with Parse.
+	ParseLines(buf []byte, ips []uint32) (n, used int)
+}
+
+// Set records the IPs found. Add must be safe for concurrent use.
+type Set interface {
+	// Add inserts ip and reports whether it was not present before.
+	Add(ip uint32) bool
+}
+
+// BatchSet is a Set that can also insert many IPs in one call. CountChunk
+// uses it for the batches of a LineParser.
+type BatchSet interface {
+	Set
+	// AddBatch inserts ips and returns how many were not present before,
+	// counting an IP repeated within ips once. It must be safe for concurrent use.
+	AddBatch(ips []uint32) int64
+}
+
+// batchLen is the number of IPs a LineParser parses before they are inserted,
+// few enough for the batch to stay in L1 cache.
+const batchLen = 1024
+
+// batchPool recycles the batches of CountChunk.
+var batchPool = sync.Pool{New: func() interface{} { return new([batchLen]uint32) }}
+
+// Config describes how Count and Run process the input.
+type Config struct {
+	Options   tuning.Options        // Worker count, chunk size and queue length.
+	Extract   format.Extractor      // Pulls the IP field out of each line, nil for bare IPs.
+	TopK      int                   // Number of heavy hitters to track, 0 to disable.
+	Metrics   *Metrics              // Running totals, nil to keep none.
+	Watermark *checkpoint.Watermark // Receives the finished chunks, nil for none.
+}
+
+// Result is the outcome of Count.
+type Result struct {
+	Unique int64        // IPs that were not in the set before.
+	Top    *topk.Result // Heavy hitters, nil unless Config.TopK is set.
+}
+
+// Count reads r and inserts the IP of every valid line into s, parsing with p
+// on the workers of cfg. Empty lines are skipped; lines whose IP does not parse
+// are counted as invalid in cfg.Metrics.
+func Count(r io.Reader, p Parser, s Set, cfg Config) (Result, error) {
+	numWorkers := cfg.Options.NumWorkers()
+	cfg.Options.Workers = numWorkers // Size the per-worker state and the pool alike.
+
+	// Give each worker its own heavy-hitter tracker so the hot path stays lock-free.
+	trackers := make([]*topk.Tracker, numWorkers)
+	if cfg.TopK > 0 {
+		for i := range trackers {
+			trackers[i] = topk.NewTracker(cfg.TopK)
+		}
+	}
+
+	// Each worker sums the unique IPs of its own chunks.
+	counts := make([]int64, numWorkers)
+	err := Run(r, cfg, func(worker int, chunk []byte) {
+		counts[worker] += CountChunk(chunk, p, s, cfg, trackers[worker])
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	var res Result
+	for _, c := range counts {
+		res.Unique += c
+	}
+	if cfg.TopK > 0 {
+		res.Top = topk.Merge(cfg.TopK, trackers...)
+	}
+	return res, nil
+}
+
+// CountChunk inserts the IP of every valid line of chunk into s, like Count
+// but on the calling goroutine, and returns the number of IPs that were new.
+// Every line must be newline-terminated. If hh is not nil, every parsed IP is
+// also fed to it.
+func CountChunk(chunk []byte, p Parser, s Set, cfg Config, hh *topk.Tracker) int64 {
+	var t tally
+	if lp, ok := p.(LineParser); ok && cfg.Extract == nil {
+		t = countBatches(chunk, lp, s, hh)
+	} else {
+		t = countLines(chunk, p, s, cfg.Extract, hh)
+	}
+	if m := cfg.Metrics; m != nil {
+		m.Lines.Add(t.lines)
+		m.Invalid.Add(t.invalid)
+		m.Unique.Add(t.count)
+	}
+	return t.count
+}
+
+// tally is what CountChunk found in a chunk.
+type tally struct {
+	count   int64 // IPs that were new.
+	lines   int64 // Non-empty lines.
+	invalid int64 // Lines without a valid IP.
+}
+
+// countLines is CountChunk one line at a time.
+func countLines(chunk []byte, p Parser, s Set, extract format.Extractor, hh *topk.Tracker) tally {
+	var t tally
+	start := 0
+	for {
+		i := bytes.IndexByte(chunk[start:], '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimSpace(chunk[start : start+i])
+		start += i + 1
+		if len(line) == 0 {
+			continue // Skip empty lines.
+		}
+		t.lines++
+		if extract != nil {
+			if line = extract.Extract(line); len(line) == 0 {
+				t.invalid++
+				continue // Skip records without an IP field.
+			}
+		}
+		ip, ok := p.Parse(line)
+		if !ok {
+			t.invalid++
+			continue // Skip invalid IPs.
+		}
+		if hh != nil {
+			hh.Add(ip)
+		}
+		if s.Add(ip) {
+			t.count++
+		}
+	}
+	return t
+}
+
+// countBatches is CountChunk a batch of lines at a time, parsed by p and
+// inserted together. Each line p stops at goes through countLines.
+func countBatches(chunk []byte, p LineParser, s Set, hh *topk.Tracker) tally {
+	batch := batchPool.Get().(*[batchLen]uint32)
+	defer batchPool.Put(batch)
+	bs, _ := s.(BatchSet)
+
+	var t tally
+	for start := 0; start < len(chunk); {
+		n, used := p.ParseLines(chunk[start:], batch[:])
+		start += used
+		if n > 0 {
+			ips := batch[:n]
+			if hh != nil {
+				for _, ip := range ips {
+					hh.Add(ip)
+				}
+			}
+			if bs != nil {
+				t.count += bs.AddBatch(ips)
+			} else {
+				for _, ip := range ips {
+					if s.Add(ip) {
+						t.count++
+					}
+				}
+			}
+			t.lines += int64(n)
+		}
+		if n == batchLen {
+			continue // Stopped because the batch was full.
+		}
+
+		// Stopped at a line p does not take: blank, padded, invalid or too
+		// close to the end of the chunk.
+		i := bytes.IndexByte(chunk[start:], '\n')
+		if i < 0 {
+			break
+		}
+		lt := countLines(chunk[start:start+i+1], p, s, nil, hh)
+		t.count += lt.count
+		t.lines += lt.lines
+		t.invalid += lt.invalid
+		start += i + 1
+	}
+	return t
+}
+
+// Run reads r in chunks and calls process for each of them on one of the
+// workers of cfg. Chunks hold whole newline-terminated lines. The worker index
+// lets callers keep per-worker state without locking. A chunk is only valid
+// during the call; its buffer is reused afterwards. The reader and worker
+// timings go to cfg.Metrics and the finished chunks to cfg.Watermark. With
+// cfg.Options.IOUring, a regular file is read through io_uring, from its
+// current offset, if the kernel supports it.
+func Run(r io.Reader, cfg Config, process func(worker int, chunk []byte)) error {
+	o, m, wm := cfg.Options, cfg.Metrics, cfg.Watermark
+	numWorkers := o.NumWorkers()
+	// Read the input through io_uring or a buffered reader.
+	src := newSource(r, o)
+	defer src.Close()
+	// Channel for distributing chunks to workers, numbered in input order.
+	type seqChunk struct {
+		seq  int
+		data []byte
+	}
+	chunkChan := make(chan seqChunk, o.Queue())
+
+	// Start worker goroutines to process chunks concurrently.
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for chunk := range chunkChan {
+				if m == nil {
+					process(worker, chunk.data)
+				} else {
+					m.QueueDepth.Store(int64(len(chunkChan)))
+					start := time.Now()
+					process(worker, chunk.data)
+					m.BusyNanos.Add(int64(time.Since(start)))
+				}
+				wm.Done(chunk.seq)
+				// Return buffer to the source for reuse.
+				src.Release(chunk.data)
+			}
+		}(i)
+	}
+
+	// Read the input in chunks and distribute to workers.
+	readErr := src.Read(func(chunk []byte) {
+		next := seqChunk{seq: wm.Add(len(chunk)), data: chunk}
+		if m == nil {
+			chunkChan <- next // Send chunk to workers without copying.
+			return
+		}
+		m.Bytes.Add(int64(len(chunk)))
+		start := time.Now()
+		chunkChan <- next
+		m.StallNanos.Add(int64(time.Since(start)))
+		m.QueueDepth.Store(int64(len(chunkChan)))
+	})
+
+	// Close the channel and wait for workers to finish.
+	close(chunkChan)
+	wg.Wait()
+	if m != nil {
+		m.QueueDepth.Store(0)
+	}
+	return readErr
+}
+
+// NewBufferPool returns a pool of chunk buffers of size bytes for ReadChunks.
+func NewBufferPool(size int) *sync.Pool {
+	return &sync.Pool{
+		New: func() interface{} {
+			return make([]byte, size)
+		},
+	}
+}
+
+// ReadChunks reads the input in chunks the size of the pooled buffers, each
+// extended to the next newline so no IP address is split across chunks, and
+// hands them to emit without copying. Buffers come from bufPool and ownership
+// passes to emit. The final line is newline-terminated even if the input is not.
+func ReadChunks(reader *bufio.Reader, bufPool *sync.Pool, emit func(chunk []byte)) error {
+	for {
+		// Get a buffer from the pool.
+		buf := bufPool.Get().([]byte)
+		n, err := io.ReadFull(reader, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			bufPool.Put(buf)
+			return fmt.Errorf("read error: %w", err)
+		}
+		if n == 0 {
+			bufPool.Put(buf) // Return unused buffer.
+			return nil
+		}
+
+		// Extend chunk to include complete IP addresses (until newline).
+		rem, _ := reader.ReadBytes('\n')
+		buf = append(buf[:n], rem...)
+		if buf[len(buf)-1] != '\n' {
+			buf = append(buf, '\n') // Last line of the input has no newline.
+		}
+		emit(buf)
+
+		if err != nil {
+			return nil // Short read: that was the end of the input.
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package pipeline
+
+import (
+	"IP-Addr-Counter/ipcounter/checkpoint"
+	"IP-Addr-Counter/ipcounter/format"
+	"IP-Addr-Counter/ipcounter/topk"
+	"IP-Addr-Counter/ipcounter/tuning"
+	"IP-Addr-Counter/ipcounter/utils"
+	"bytes"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// mapSet is a Set backed by a map.
+type mapSet struct {
+	mu  sync.Mutex
+	ips map[uint32]bool
+}
+
+func (s *mapSet) Add(ip uint32) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ips[ip] {
+		return false
+	}
+	s.ips[ip] = true
+	return true
+}
+
+// AddBatch inserts ips one by one.
+func (s *mapSet) AddBatch(ips []uint32) int64 {
+	var n int64
+	for _, ip := range ips {
+		if s.Add(ip) {
+			n++
+		}
+	}
+	return n
+}
+
+// Mark inserts ip, for Deferred.
+func (s *mapSet) Mark(ip uint32) {
+	s.Add(ip)
+}
+
+// Count returns the number of IPs in the set.
+func (s *mapSet) Count(int) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return int64(len(s.ips))
+}
+
+// pickyParser is a LineParser that takes at most max lines per call and stops
+// at any line that is not a bare address.
+type pickyParser struct {
+	ParserFunc
+	max int
+}
+
+func (p pickyParser) ParseLines(buf []byte, ips []uint32) (n, used int) {
+	for n < len(ips) && n < p.max {
+		i := bytes.IndexByte(buf[used:], '\n')
+		if i < 0 {
+			break
+		}
+		ip, err := utils.ParseIPv4(buf[used : used+i])
+		if err != nil {
+			break
+		}
+		ips[n] = ip
+		n++
+		used += i + 1
+	}
+	return n, used
+}
+
+var parser = ParserFunc(func(field []byte) (uint32, bool) {
+	ip, err := utils.ParseIPv4(field)
+	return ip, err == nil
+})
+
+func TestCount(t *testing.T) {
+	input := "10.0.0.1\n  10.0.0.2 \r\n\nbad\n10.0.0.1\n10.0.0.3\n10.0.0.2\n192.168.0.1"
+	for _, chunk := range []int{1, 7, 1 << 20} {
+		o, err := tuning.New(tuning.Workers(3), tuning.ChunkBytes(chunk), tuning.QueueLen(2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		set := &mapSet{ips: make(map[uint32]bool)}
+		m := &Metrics{}
+		wm := checkpoint.NewWatermark(0, int64(len(input)))
+		res, err := Count(strings.NewReader(input), parser, set, Config{Options: o, TopK: 2, Metrics: m, Watermark: wm})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Unique != 4 || len(set.ips) != 4 {
+			t.Errorf("chunk %d: Count() = %d unique, set has %d, want 4", chunk, res.Unique, len(set.ips))
+		}
+		if !set.ips[0xC0A80001] {
+			t.Errorf("chunk %d: the unterminated last line was dropped", chunk)
+		}
+		if m.Lines.Load() != 7 || m.Invalid.Load() != 1 || m.Unique.Load() != 4 {
+			t.Errorf("chunk %d: metrics lines=%d invalid=%d unique=%d, want 7, 1 and 4",
+				chunk, m.Lines.Load(), m.Invalid.Load(), m.Unique.Load())
+		}
+		if wm.Offset() != int64(len(input)) {
+			t.Errorf("chunk %d: watermark at %d, want %d", chunk, wm.Offset(), len(input))
+		}
+		if res.Top == nil || len(res.Top.Entries) != 2 || res.Top.Total != 6 {
+			t.Errorf("chunk %d: Top = %+v, want 2 heavy hitters of 6 IPs", chunk, res.Top)
+		}
+	}
+}
+
+func TestDeferred(t *testing.T) {
+	o, err := tuning.New(tuning.Workers(2), tuning.ChunkBytes(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := &mapSet{ips: make(map[uint32]bool)}
+	m := &Metrics{}
+	var d Deferred
+	for i, tt := range []struct {
+		input string
+		added uint32 // IP added between counts, 0 for none.
+		want  int64
+	}{
+		{"10.0.0.1\n10.0.0.2\n10.0.0.1\n", 0, 2},
+		{"10.0.0.2\n10.0.0.3\n", 0, 1},
+		{"10.0.0.4\n10.0.0.5\n", 0x0A000004, 1}, // 10.0.0.4 is not new.
+	} {
+		if tt.added != 0 {
+			set.Add(tt.added)
+			d.Touch()
+		}
+		res, err := d.Count(strings.NewReader(tt.input), parser, set, Config{Options: o, Metrics: m})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Unique != tt.want {
+			t.Errorf("count %d: %d new IPs, want %d", i, res.Unique, tt.want)
+		}
+	}
+	if m.Unique.Load() != 4 {
+		t.Errorf("metrics unique = %d, want the 4 IPs found by the counts", m.Unique.Load())
+	}
+}
+
+func TestCountExtract(t *testing.T) {
+	csv, err := format.Parse("csv:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := &mapSet{ips: make(map[uint32]bool)}
+	m := &Metrics{}
+	input := "a,10.0.0.1\nb,10.0.0.1\nc\nd,10.0.0.9\n"
+	n := CountChunk([]byte(input), parser, set, Config{Extract: csv, Metrics: m}, nil)
+	if n != 2 || m.Invalid.Load() != 1 {
+		t.Errorf("CountChunk() = %d with %d invalid, want 2 with 1", n, m.Invalid.Load())
+	}
+}
+
+func TestCountChunkBatches(t *testing.T) {
+	var sb strings.Builder
+	for i := 0; i < 5000; i++ {
+		switch i % 97 {
+		case 3:
+			sb.WriteString("\n") // Blank.
+		case 40:
+			sb.WriteString(" 10.0.0.7\r\n") // Padded.
+		case 71:
+			sb.WriteString("10.0.0.256\n") // Invalid.
+		default:
+			fmt.Fprintf(&sb, "10.%d.%d.%d\n", i%7, i%13, i%251)
+		}
+	}
+	chunk := []byte(sb.String())
+
+	wantSet := &mapSet{ips: make(map[uint32]bool)}
+	wantM := &Metrics{}
+	wantTop := topk.NewTracker(3)
+	want := CountChunk(chunk, parser, wantSet, Config{Metrics: wantM}, wantTop)
+	for _, max := range []int{0, 1, 5, batchLen, 1 << 20} {
+		// With and without AddBatch.
+		for _, set := range []Set{&mapSet{ips: make(map[uint32]bool)}, struct{ Set }{&mapSet{ips: make(map[uint32]bool)}}} {
+			m := &Metrics{}
+			top := topk.NewTracker(3)
+			got := CountChunk(chunk, pickyParser{parser, max}, set, Config{Metrics: m}, top)
+			if got != want || m.Lines.Load() != wantM.Lines.Load() || m.Invalid.Load() != wantM.Invalid.Load() {
+				t.Errorf("max %d: CountChunk() = %d with %d lines, %d invalid; want %d with %d, %d",
+					max, got, m.Lines.Load(), m.Invalid.Load(), want, wantM.Lines.Load(), wantM.Invalid.Load())
+			}
+			if g, w := topk.Merge(3, top), topk.Merge(3, wantTop); g.Total != w.Total {
+				t.Errorf("max %d: heavy hitters saw %d IPs, want %d", max, g.Total, w.Total)
+			}
+		}
+	}
+}
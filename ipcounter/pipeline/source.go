@@ -0,0 +1,472 @@
+package pipeline
+
+import (
+	"IP-Addr-Counter/ipcounter/tuning"
+	"IP-Addr-Counter/ipcounter/uring"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+)
+
+// Sizes of the io_uring and O_DIRECT readers.
+const (
+	ringChunks    = 8        // Chunks read ahead of the workers, beyond one per worker.
+	ringReadSize  = 1 << 20  // Bytes per read, so a chunk is several reads in flight.
+	ringEntries   = 4096     // Most reads in flight, the ring size older kernels allow.
+	chunkHeadroom = 64 << 10 // Bytes before each chunk for the line it continues.
+	directAlign   = 4096     // Alignment of O_DIRECT buffers, offsets and lengths.
+)
+
+// source cuts the input of Run into chunks of whole lines.
+type source interface {
+	// Read reads the input to its end, passing each chunk to emit, whose
+	// buffer belongs to the caller until it is given back with Release.
+	Read(emit func(chunk []byte)) error
+	// Release gives back the buffer of a chunk once it is processed. It is
+	// safe for concurrent use.
+	Release(chunk []byte)
+	// Close frees what the source holds, once every chunk is released.
+	Close() error
+}
+
+// newSource returns the source Run reads r with. A regular file is read
+// through io_uring if o.IOUring asks for it and the kernel has it, and with
+// O_DIRECT if o.Direct asks for it and its file system takes it; where it
+// does not, the file's pages are dropped from the page cache behind the
+// reader instead. Anything else is read with ReadChunks.
+func newSource(r io.Reader, o tuning.Options) source {
+	f, ok := r.(*os.File)
+	if !ok || !o.IOUring && !o.Direct {
+		return newReaderSource(r, o)
+	}
+	in, err := openInput(f, o.Direct)
+	if err != nil {
+		return newReaderSource(r, o) // Pipes and devices do not fill the page cache.
+	}
+	if o.IOUring {
+		if s, err := newRingSource(in, o); err == nil {
+			return s
+		}
+	}
+	switch {
+	case in.direct:
+		return newDirectSource(in, o)
+	case o.Direct:
+		return newReaderSource(&dropBehind{in: in, pos: in.start, dropped: in.start, every: int64(o.ChunkBytes)}, o)
+	}
+	return newReaderSource(r, o)
+}
+
+// input is a regular file a source reads at offsets.
+type input struct {
+	file       *os.File
+	fd         int
+	start, end int64 // Offset to read from, and the size of the file.
+	direct     bool  // O_DIRECT is on.
+	restore    func()
+}
+
+// openInput returns f as an input from its current offset, with O_DIRECT on
+// if direct asks for it and f's file system takes it, or an error if f is not
+// a regular file.
+func openInput(f *os.File, direct bool) (*input, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, errors.New("not a regular file")
+	}
+	start, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	in := &input{file: f, fd: int(f.Fd()), start: start, end: info.Size()}
+	if direct {
+		if restore, err := setDirect(in.fd); err == nil {
+			in.direct, in.restore = true, restore
+		}
+	}
+	return in, nil
+}
+
+// close turns O_DIRECT back off, so f reads as it did before.
+func (in *input) close() {
+	if in.restore != nil {
+		in.restore()
+		in.restore = nil
+	}
+}
+
+// ProbeDirect reports whether the file name can be read with O_DIRECT. When
+// it cannot, tuning.Direct drops the pages of the file from the page cache
+// behind the reader instead.
+func ProbeDirect(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	restore, err := setDirect(int(f.Fd()))
+	if err != nil {
+		return err
+	}
+	restore()
+	return nil
+}
+
+// readerSource reads chunks with ReadChunks, into buffers from a pool.
+type readerSource struct {
+	reader *bufio.Reader
+	pool   *sync.Pool
+}
+
+// newReaderSource returns a readerSource reading r.
+func newReaderSource(r io.Reader, o tuning.Options) *readerSource {
+	return &readerSource{reader: bufio.NewReader(r), pool: NewBufferPool(o.ChunkBytes)}
+}
+
+func (s *readerSource) Read(emit func(chunk []byte)) error { return ReadChunks(s.reader, s.pool, emit) }
+func (s *readerSource) Release(chunk []byte)               { s.pool.Put(chunk) }
+func (s *readerSource) Close() error                       { return nil }
+
+// dropBehind reads a file, and every so many bytes drops the pages it has read
+// from the page cache, for file systems that do not take O_DIRECT.
+type dropBehind struct {
+	in           *input
+	pos, dropped int64 // Offset reached, and up to which pages were dropped.
+	every        int64
+}
+
+func (d *dropBehind) Read(p []byte) (int, error) {
+	n, err := d.in.file.Read(p)
+	d.pos += int64(n)
+	switch {
+	case err != nil:
+		// Again from the start: pages still being read ahead were skipped.
+		dropCache(d.in.fd, d.in.start, d.pos-d.in.start)
+		d.dropped = d.pos
+	case d.pos-d.dropped >= d.every:
+		dropCache(d.in.fd, d.dropped, d.pos-d.dropped)
+		d.dropped = d.pos
+	}
+	return n, err
+}
+
+// slotPool is a fixed set of chunk buffers: slots of one arena, each with
+// chunkHeadroom bytes before its data, where the end of the last line of the
+// previous chunk is copied so the chunk starts on a whole line. The data of
+// every slot is aligned for O_DIRECT.
+type slotPool struct {
+	arena []byte
+	slot  int      // Bytes per slot.
+	free  chan int // Slots not being read into nor held by a worker.
+}
+
+// newSlotPool returns a pool of n slots of at least size bytes of data each,
+// all free.
+func newSlotPool(n, size int) *slotPool {
+	p := &slotPool{slot: chunkHeadroom + roundUp(size, directAlign), free: make(chan int, n)}
+	p.arena = alignedBytes(n*p.slot, directAlign)
+	for i := 0; i < n; i++ {
+		p.free <- i
+	}
+	return p
+}
+
+// buffer returns slot i.
+func (p *slotPool) buffer(i int) []byte {
+	return p.arena[i*p.slot : (i+1)*p.slot : (i+1)*p.slot]
+}
+
+// data returns the bytes of slot i that are read into.
+func (p *slotPool) data(i int) []byte {
+	return p.buffer(i)[chunkHeadroom:]
+}
+
+// release frees the slot of chunk, if it has one.
+func (p *slotPool) release(chunk []byte) {
+	c := uintptr(unsafe.Pointer(unsafe.SliceData(chunk)))
+	base := uintptr(unsafe.Pointer(unsafe.SliceData(p.arena)))
+	if c >= base && c < base+uintptr(len(p.arena)) {
+		p.free <- int(c-base) / p.slot
+	}
+}
+
+// stitcher cuts the data read into slots into chunks of whole lines, carrying
+// the partial line each ends with over to the next.
+type stitcher struct {
+	carry []byte
+}
+
+// cut emits the whole lines of bytes [from, to) of slot i, read right after
+// those of the previous call, led by the partial line that ended those. A
+// partial line too long for the headroom goes into a chunk of its own
+// allocation, and the slot is freed.
+func (st *stitcher) cut(p *slotPool, i, from, to int, emit func(chunk []byte)) {
+	buf := p.buffer(i)
+	data := buf[from:to]
+	last := bytes.LastIndexByte(data, '\n')
+	if last < 0 {
+		st.carry = append(st.carry, data...) // No line ends here.
+		p.free <- i
+		return
+	}
+	var chunk []byte
+	if len(st.carry) <= from {
+		start := from - len(st.carry)
+		copy(buf[start:], st.carry)
+		chunk = buf[start : from+last+1]
+		st.carry = append(st.carry[:0], data[last+1:]...)
+	} else {
+		chunk = append(st.carry, data[:last+1]...)
+		st.carry = append([]byte(nil), data[last+1:]...)
+		p.free <- i
+	}
+	emit(chunk)
+}
+
+// flush emits the partial line the input ended with, if any.
+func (st *stitcher) flush(emit func(chunk []byte)) {
+	if len(st.carry) > 0 {
+		emit(append(st.carry, '\n')) // Last line of the input has no newline.
+		st.carry = nil
+	}
+}
+
+// directSource reads a regular file with O_DIRECT, bypassing the page cache,
+// into the aligned slots of a slotPool. The reads start on a block boundary,
+// so a file read from the middle starts with part of a block, which is
+// skipped, and only the last read, at the end of the file, is short.
+type directSource struct {
+	in    *input
+	pool  *slotPool
+	lines stitcher
+}
+
+// newDirectSource returns a directSource reading in, which has O_DIRECT on.
+func newDirectSource(in *input, o tuning.Options) *directSource {
+	return &directSource{in: in, pool: newSlotPool(o.NumWorkers()+min(o.Queue(), ringChunks), o.ChunkBytes)}
+}
+
+func (s *directSource) Read(emit func(chunk []byte)) error {
+	pos := s.in.start &^ (directAlign - 1)
+	skip := int(s.in.start - pos)
+	for {
+		i := <-s.pool.free
+		data := s.pool.data(i)
+		n, err := pread(s.in.fd, data, pos)
+		if err != nil {
+			s.pool.free <- i
+			return fmt.Errorf("read error: %w", err)
+		}
+		pos += int64(n)
+		s.lines.cut(s.pool, i, chunkHeadroom+min(skip, n), chunkHeadroom+n, emit)
+		skip = 0
+		if n < len(data) {
+			break // A short read is the end of the file.
+		}
+	}
+	s.lines.flush(emit)
+	return nil
+}
+
+func (s *directSource) Release(chunk []byte) { s.pool.release(chunk) }
+
+func (s *directSource) Close() error {
+	s.in.close()
+	return nil
+}
+
+// ringSource reads a regular file through io_uring. Its chunk buffers are the
+// slots of a slotPool registered with the kernel, and every slot not held by a
+// worker is being read into, in reads of ringReadSize, so many reads are in
+// flight at once. Slots are filled in file order but may complete in any
+// order; they are emitted in file order. With O_DIRECT, the reads are aligned
+// like those of a directSource; when O_DIRECT was asked for but is not on,
+// the pages of each chunk are dropped from the page cache once it is read.
+type ringSource struct {
+	ring  *uring.Ring
+	in    *input
+	pool  *slotPool
+	chunk int // Bytes of data per slot, aligned with O_DIRECT.
+	read  int // Bytes per read.
+	align int // Alignment of the reads, 1 without O_DIRECT.
+	drop  bool
+
+	off, end int64      // Next byte to read, and the end of the file.
+	slots    []ringSlot // What each slot holds.
+	pending  []int      // Slots being read into or waiting to be emitted, in file order.
+	lines    stitcher
+}
+
+// ringSlot is the read a slot is in.
+type ringSlot struct {
+	pos     int64 // File offset of the chunk.
+	skip    int   // Bytes before the start of the input, with O_DIRECT.
+	size    int   // Bytes asked for.
+	n       int   // Bytes the file had for it.
+	waiting int   // Reads not yet completed.
+}
+
+// newRingSource returns a ringSource reading in, or an error if io_uring is
+// not available.
+func newRingSource(in *input, o tuning.Options) (*ringSource, error) {
+	s := &ringSource{in: in, chunk: o.ChunkBytes, align: 1, off: in.start, end: in.end, drop: o.Direct && !in.direct}
+	if in.direct {
+		s.align = directAlign
+		s.chunk = roundUp(o.ChunkBytes, directAlign)
+		s.off &^= directAlign - 1
+	}
+	chunks := int((s.end - s.off + int64(s.chunk) - 1) / int64(s.chunk))
+	numSlots := max(1, min(o.NumWorkers()+min(o.Queue(), ringChunks), chunks, ringEntries))
+	perSlot := ringEntries / numSlots // Reads in flight per slot, so all fit in the ring.
+	s.read = max(ringReadSize, roundUp((s.chunk+perSlot-1)/perSlot, directAlign))
+	s.slots = make([]ringSlot, numSlots)
+	s.pool = newSlotPool(numSlots, s.chunk)
+
+	bufs := make([][]byte, numSlots)
+	for i := range bufs {
+		bufs[i] = s.pool.buffer(i)
+	}
+	var err error
+	if s.ring, err = uring.New(ringEntries, bufs); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ringSource) Read(emit func(chunk []byte)) error {
+	for {
+		if err := s.fill(); err != nil {
+			return fmt.Errorf("read error: %w", err)
+		}
+		switch {
+		case len(s.pending) > 0 && s.slots[s.pending[0]].waiting == 0:
+			i := s.pending[0]
+			s.pending = s.pending[1:]
+			sl := &s.slots[i]
+			if s.drop {
+				dropCache(s.in.fd, sl.pos, int64(sl.n))
+			}
+			s.lines.cut(s.pool, i, chunkHeadroom+min(sl.skip, sl.n), chunkHeadroom+sl.n, emit)
+		case len(s.pending) > 0:
+			// Wait for the reads, the first slot's among them.
+			if err := s.ring.Submit(1); err != nil {
+				return fmt.Errorf("read error: %w", err)
+			}
+			if err := s.complete(); err != nil {
+				return fmt.Errorf("read error: %w", err)
+			}
+		case s.off < s.end:
+			// Every slot is with the workers.
+			if err := s.start(<-s.pool.free); err != nil {
+				return fmt.Errorf("read error: %w", err)
+			}
+		default:
+			if s.drop {
+				dropCache(s.in.fd, s.in.start, s.end-s.in.start) // Pages that were being read ahead too.
+			}
+			s.lines.flush(emit)
+			return nil
+		}
+	}
+}
+
+// fill starts reading the next chunks of the file into the free slots.
+func (s *ringSource) fill() error {
+	for s.off < s.end {
+		select {
+		case i := <-s.pool.free:
+			if err := s.start(i); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// start queues the reads of the next chunk of the file into slot i.
+func (s *ringSource) start(i int) error {
+	size := int(min(int64(s.chunk), s.end-s.off))
+	s.slots[i] = ringSlot{pos: s.off, size: size, n: size}
+	if s.off < s.in.start {
+		s.slots[i].skip = int(s.in.start - s.off) // First block, read whole.
+	}
+	s.pending = append(s.pending, i)
+	s.off += int64(size)
+	for at := 0; at < size; at += s.read {
+		if err := s.queue(i, at, min(at+s.read, size)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queue queues a read of bytes [from, to) of the chunk of slot i. With
+// O_DIRECT, a read at the end of the file asks for whole blocks, and gets the
+// bytes there are.
+func (s *ringSource) queue(i, from, to int) error {
+	sl := &s.slots[i]
+	b := s.pool.data(i)[from : from+roundUp(to-from, s.align)]
+	for !s.ring.Read(s.in.fd, i, b, sl.pos+int64(from), uint64(i)<<32|uint64(from)) {
+		// Full, which the read size should prevent: start what is queued.
+		if err := s.ring.Submit(0); err != nil {
+			return err
+		}
+	}
+	sl.waiting++
+	return nil
+}
+
+// complete takes the completed reads off the ring.
+func (s *ringSource) complete() error {
+	for c, ok := s.ring.Next(); ok; c, ok = s.ring.Next() {
+		i, from := int(c.Tag>>32), int(uint32(c.Tag))
+		sl := &s.slots[i]
+		sl.waiting--
+		to := min(from/s.read*s.read+s.read, sl.size) // End of the read from is in.
+		switch {
+		case c.Err != nil:
+			return c.Err
+		case c.N == 0:
+			// The file shrank: keep what came before and read no further.
+			sl.n = min(sl.n, from)
+			s.end = min(s.end, s.off)
+		case from+c.N < to:
+			if err := s.queue(i, from+c.N, to); err != nil { // Short read: read the rest.
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *ringSource) Release(chunk []byte) { s.pool.release(chunk) }
+
+// Close tears the ring down, after the reads in flight if Read failed.
+func (s *ringSource) Close() error {
+	err := s.ring.Close()
+	s.in.close()
+	return err
+}
+
+// alignedBytes returns n zeroed bytes starting at a multiple of align, a
+// power of two.
+func alignedBytes(n, align int) []byte {
+	b := make([]byte, n+align)
+	off := roundUp(int(uintptr(unsafe.Pointer(unsafe.SliceData(b)))), align) - int(uintptr(unsafe.Pointer(unsafe.SliceData(b))))
+	return b[off : off+n : off+n]
+}
+
+// roundUp rounds n up to a multiple of align, a power of two.
+func roundUp(n, align int) int {
+	return (n + align - 1) &^ (align - 1)
+}
@@ -0,0 +1,38 @@
+//go:build linux
+// +build linux
+
+package pipeline
+
+import "syscall"
+
+// fadvDontNeed is POSIX_FADV_DONTNEED, the advice that drops cached pages.
+const fadvDontNeed = 4
+
+// setDirect turns O_DIRECT on for fd and returns a function that turns it back
+// off. It fails on file systems without direct I/O.
+func setDirect(fd int) (restore func(), err error) {
+	flags, _, errno := syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_GETFL, 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	if _, _, errno = syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_SETFL, flags|syscall.O_DIRECT); errno != 0 {
+		return nil, errno
+	}
+	return func() { syscall.Syscall(syscall.SYS_FCNTL, uintptr(fd), syscall.F_SETFL, flags) }, nil
+}
+
+// dropCache asks the kernel to drop the cached pages of the n bytes of fd at
+// off. It is advice, so failures are ignored.
+func dropCache(fd int, off, n int64) {
+	syscall.Syscall6(syscall.SYS_FADVISE64, uintptr(fd), uintptr(off), uintptr(n), fadvDontNeed, 0, 0)
+}
+
+// pread reads len(b) bytes of fd at off, or fewer at the end of the file.
+func pread(fd int, b []byte, off int64) (int, error) {
+	for {
+		n, err := syscall.Pread(fd, b, off)
+		if err != syscall.EINTR {
+			return max(n, 0), err
+		}
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package pipeline
+
+import (
+	"errors"
+	"syscall"
+)
+
+// setDirect reports that O_DIRECT is only used on Linux.
+func setDirect(fd int) (restore func(), err error) {
+	return nil, errors.ErrUnsupported
+}
+
+// dropCache does nothing: dropping cached pages is only asked for on Linux.
+func dropCache(fd int, off, n int64) {}
+
+// pread reads len(b) bytes of fd at off, or fewer at the end of the file.
+func pread(fd int, b []byte, off int64) (int, error) {
+	for {
+		n, err := syscall.Pread(fd, b, off)
+		if err != syscall.EINTR {
+			return max(n, 0), err
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+	"IP-Addr-Counter/ipcounter/tuning"
+	"IP-Addr-Counter/ipcounter/uring"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sourceInput returns lines of IPs with a line longer than chunkHeadroom among
+// them and no final newline.
+func sourceInput() []byte {
+	var b bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		fmt.Fprintf(&b, "10.0.%d.%d\n", i/256, i%256)
+		if i == 5000 {
+			b.WriteString(string(bytes.Repeat([]byte{' '}, chunkHeadroom+100)) + "10.1.1.1\n")
+		}
+	}
+	b.WriteString("10.2.2.2")
+	return b.Bytes()
+}
+
+// readAll runs Run over f with a single worker, so the chunks come in order,
+// and returns their concatenation, failing if a chunk does not hold whole lines.
+func readAll(t *testing.T, f *os.File, o tuning.Options) []byte {
+	t.Helper()
+	var got bytes.Buffer
+	err := Run(f, Config{Options: o}, func(_ int, chunk []byte) {
+		if len(chunk) == 0 || chunk[len(chunk)-1] != '\n' {
+			t.Fatalf("chunk %q does not end with a newline", chunk)
+		}
+		got.Write(chunk)
+	})
+	if err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	return got.Bytes()
+}
+
+// writeSourceInput writes sourceInput to a file and returns its path and the
+// bytes Run should read from it.
+func writeSourceInput(t *testing.T) (string, []byte) {
+	t.Helper()
+	input := sourceInput()
+	path := filepath.Join(t.TempDir(), "ips.txt")
+	if err := os.WriteFile(path, input, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path, append(input, '\n')
+}
+
+// testSource checks that Run reads the file at path with the source newSource
+// makes for opts, of the type of kind, whole and from the middle of a line.
+func testSource(t *testing.T, path string, want []byte, kind source, opts ...tuning.Option) {
+	t.Helper()
+	for _, chunkBytes := range []int{7, 4096, 3 << 20} {
+		o, _ := tuning.New(append([]tuning.Option{tuning.Workers(1), tuning.ChunkBytes(chunkBytes)}, opts...)...)
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := newSource(f, o)
+		if fmt.Sprintf("%T", src) != fmt.Sprintf("%T", kind) {
+			t.Fatalf("chunk size %d: newSource() = %T, want %T", chunkBytes, src, kind)
+		}
+		src.Close()
+		if got := readAll(t, f, o); !bytes.Equal(got, want) {
+			t.Errorf("chunk size %d: read %d bytes, want the %d of the file", chunkBytes, len(got), len(want))
+		}
+
+		// Resuming from a line in the middle, as after a checkpoint.
+		mid := int64(bytes.IndexByte(want[len(want)/2:], '\n') + len(want)/2 + 1)
+		f.Seek(mid, io.SeekStart)
+		if got := readAll(t, f, o); !bytes.Equal(got, want[mid:]) {
+			t.Errorf("chunk size %d: read %d bytes from offset %d, want %d", chunkBytes, len(got), mid, len(want)-int(mid))
+		}
+		// The file reads as before: unaligned reads fail with O_DIRECT.
+		if _, err := f.ReadAt(make([]byte, 3), 1); err != nil {
+			t.Errorf("chunk size %d: ReadAt after Run: %v", chunkBytes, err)
+		}
+		f.Close()
+	}
+}
+
+func TestRunIOUring(t *testing.T) {
+	if err := uring.Probe(); err != nil {
+		t.Skipf("io_uring unavailable: %v", err)
+	}
+	path, want := writeSourceInput(t)
+	testSource(t, path, want, &ringSource{}, tuning.IOUring(true))
+}
+
+func TestRunDirect(t *testing.T) {
+	path, want := writeSourceInput(t)
+	if err := ProbeDirect(path); err != nil {
+		t.Skipf("O_DIRECT unavailable: %v", err)
+	}
+	testSource(t, path, want, &directSource{}, tuning.Direct(true))
+	if uring.Probe() == nil {
+		testSource(t, path, want, &ringSource{}, tuning.Direct(true), tuning.IOUring(true))
+	}
+}
+
+func TestDropBehind(t *testing.T) {
+	// What Direct falls back to on file systems without O_DIRECT.
+	path, want := writeSourceInput(t)
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	in, err := openInput(f, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &dropBehind{in: in, every: 4096}
+	var got bytes.Buffer
+	if err := ReadChunks(bufio.NewReader(d), NewBufferPool(1000), func(chunk []byte) { got.Write(chunk) }); err != nil {
+		t.Fatalf("ReadChunks: %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want) || d.dropped != int64(len(want)-1) {
+		t.Errorf("read %d bytes and dropped %d, want %d of both", got.Len(), d.dropped, len(want)-1)
+	}
+}
+
+func TestRunIOUringFallback(t *testing.T) {
+	// A pipe cannot be read at offsets, so it is read the usual way.
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	o, _ := tuning.New(tuning.Workers(1), tuning.ChunkBytes(4096), tuning.IOUring(true))
+	if src := newSource(pr, o); src.Close() != nil {
+		t.Errorf("Close of %T failed", src)
+	} else if _, ok := src.(*readerSource); !ok {
+		t.Errorf("newSource(pipe) = %T, want a readerSource", src)
+	}
+	input := sourceInput()
+	go func() {
+		pw.Write(input)
+		pw.Close()
+	}()
+	if got := readAll(t, pr, o); !bytes.Equal(got, append(input, '\n')) {
+		t.Errorf("read %d bytes from a pipe, want %d", len(got), len(input)+1)
+	}
+}
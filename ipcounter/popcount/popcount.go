@@ -0,0 +1,65 @@
+/*
+Package popcount counts the set bits of a bitset, the cardinality of the IPs
+it holds. Counters that set bits without knowing whether they were new, such
+as the deferred mode of concurrent and assembly, use it once the input ends.
+
+The sweep is in assembly: POPCNT on four words per iteration on amd64, and
+NEON's per-byte count on two words per iteration on arm64. Other architectures,
+and amd64 CPUs without POPCNT, use math/bits.OnesCount64. Parallel splits the
+words among goroutines, since a 512MB sweep is bound by memory bandwidth,
+which one core rarely saturates.
+*/
+package popcount
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// minParallelWords is the fewest words Parallel hands to each goroutine; fewer
+// are not worth starting one for.
+const minParallelWords = 1 << 16
+
+// Count returns the number of set bits in words.
+func Count(words []uint64) int64 {
+	if len(words) == 0 {
+		return 0
+	}
+	return count(words)
+}
+
+// Parallel is Count split among up to n goroutines.
+func Parallel(words []uint64, n int) int64 {
+	if n > len(words)/minParallelWords {
+		n = len(words) / minParallelWords
+	}
+	if n <= 1 {
+		return Count(words)
+	}
+	counts := make([]int64, n)
+	part := (len(words) + n - 1) / n
+	var wg sync.WaitGroup
+	for i := range counts {
+		lo, hi := i*part, min((i+1)*part, len(words))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			counts[i] = Count(words[lo:hi])
+		}()
+	}
+	wg.Wait()
+	var total int64
+	for _, c := range counts {
+		total += c
+	}
+	return total
+}
+
+// countGeneric is count in Go.
+func countGeneric(words []uint64) int64 {
+	var n int
+	for _, w := range words {
+		n += bits.OnesCount64(w)
+	}
+	return int64(n)
+}
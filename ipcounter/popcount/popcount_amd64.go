@@ -0,0 +1,17 @@
+//go:build amd64
+// +build amd64
+
+package popcount
+
+import "golang.org/x/sys/cpu"
+
+// count returns the set bits of words, which is not empty.
+func count(words []uint64) int64 {
+	if !cpu.X86.HasPOPCNT {
+		return countGeneric(words)
+	}
+	return countAsm(words)
+}
+
+//go:noescape
+func countAsm(words []uint64) int64
@@ -0,0 +1,44 @@
+#include "textflag.h"
+
+// func countAsm(words []uint64) int64
+TEXT ·countAsm(SB), NOSPLIT, $0-32
+    MOVQ words_base+0(FP), SI  // SI = next word
+    MOVQ words_len+8(FP), CX   // CX = words left
+    XORQ AX, AX                // Four independent sums, so the
+    XORQ R8, R8                // POPCNTs of an iteration do not
+    XORQ R9, R9                // wait on each other.
+    XORQ R10, R10
+    CMPQ CX, $4
+    JB tail
+
+loop4:
+    POPCNTQ 0(SI), DX
+    ADDQ DX, AX
+    POPCNTQ 8(SI), R11
+    ADDQ R11, R8
+    POPCNTQ 16(SI), R12
+    ADDQ R12, R9
+    POPCNTQ 24(SI), R13
+    ADDQ R13, R10
+    ADDQ $32, SI
+    SUBQ $4, CX
+    CMPQ CX, $4
+    JAE loop4
+    ADDQ R8, AX
+    ADDQ R9, AX
+    ADDQ R10, AX
+
+tail:
+    TESTQ CX, CX
+    JZ done
+
+loop1:
+    POPCNTQ 0(SI), DX
+    ADDQ DX, AX
+    ADDQ $8, SI
+    DECQ CX
+    JNZ loop1
+
+done:
+    MOVQ AX, ret+24(FP)
+    RET
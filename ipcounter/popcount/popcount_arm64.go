@@ -0,0 +1,12 @@
+//go:build arm64
+// +build arm64
+
+package popcount
+
+// count returns the set bits of words, which is not empty.
+func count(words []uint64) int64 {
+	return countAsm(words)
+}
+
+//go:noescape
+func countAsm(words []uint64) int64
@@ -0,0 +1,30 @@
+#include "textflag.h"
+
+// func countAsm(words []uint64) int64
+TEXT ·countAsm(SB), NOSPLIT, $0-32
+    MOVD words_base+0(FP), R0  // R0 = next word
+    MOVD words_len+8(FP), R1   // R1 = words left
+    MOVD $0, R2                // R2 = total
+    LSR $1, R1, R3             // R3 = pairs of words
+    CBZ R3, tail
+
+loop2:
+    VLD1.P 16(R0), [V0.B16]    // Two words
+    VCNT V0.B16, V0.B16        // Set bits of each byte
+    VUADDLV V0.B16, V0         // Sum of the 16 bytes
+    FMOVD F0, R4
+    ADD R4, R2
+    SUB $1, R3
+    CBNZ R3, loop2
+
+tail:
+    TBZ $0, R1, done           // Even count: no word left
+    FMOVD (R0), F0
+    VCNT V0.B8, V0.B8
+    VUADDLV V0.B8, V0
+    FMOVD F0, R4
+    ADD R4, R2
+
+done:
+    MOVD R2, ret+24(FP)
+    RET
@@ -0,0 +1,9 @@
+//go:build !amd64 && !arm64
+// +build !amd64,!arm64
+
+package popcount
+
+// count returns the set bits of words, which is not empty.
+func count(words []uint64) int64 {
+	return countGeneric(words)
+}
@@ -0,0 +1,55 @@
+package popcount
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestCountMatchesGeneric(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	// Every length up to a few unrolled iterations, so each tail is covered.
+	for n := 0; n <= 19; n++ {
+		words := make([]uint64, n)
+		for i := range words {
+			words[i] = rng.Uint64()
+		}
+		if n > 0 {
+			words[0] = ^uint64(0)
+		}
+		if got, want := Count(words), countGeneric(words); got != want {
+			t.Errorf("Count(%d words) = %d, want %d", n, got, want)
+		}
+	}
+}
+
+func TestParallel(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	words := make([]uint64, 5*minParallelWords+3)
+	for i := range words {
+		words[i] = rng.Uint64() & rng.Uint64()
+	}
+	want := countGeneric(words)
+	for _, n := range []int{0, 1, 2, 3, 8, 64} {
+		if got := Parallel(words, n); got != want {
+			t.Errorf("Parallel(words, %d) = %d, want %d", n, got, want)
+		}
+	}
+}
+
+func BenchmarkCount(b *testing.B) {
+	words := make([]uint64, 1<<20)
+	for i := range words {
+		words[i] = uint64(i) * 0x9E3779B97F4A7C15
+	}
+	b.SetBytes(int64(len(words)) * 8)
+	b.Run("asm", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Count(words)
+		}
+	})
+	b.Run("generic", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			countGeneric(words)
+		}
+	})
+}
@@ -0,0 +1,682 @@
+/*
+Package server exposes the counters over a local HTTP/JSON API, so other
+services can count files and query IP sets without shelling out to the CLI.
+
+Counts run as jobs on the concurrent or asm counter: the client POSTs the data
+(or names a file under the server's root) and polls the job until it is done.
+Named sets are kept in memory as adaptive sets and persisted as snapshots, so
+they survive restarts; they can be filled from uploads or from a count, and
+queried for membership, cardinality, unions, intersections and differences.
+
+Endpoints:
+  - POST   /count?impl=concurrent|asm&format=SPEC&path=FILE&save=SET  start a count job
+  - GET    /jobs, /jobs/{id}                                          job status
+  - GET    /sets, /sets/{name}                                        set sizes
+  - PUT    /sets/{name}                                               replace a set with the posted IPs
+  - POST   /sets/{name}                                               add the posted IPs to a set
+  - DELETE /sets/{name}                                               drop a set
+  - POST   /sets/{name}/contains  {"ips": [...]}                      membership
+  - POST   /ops  {"op": "union|intersect|diff", "sets": [...], "save": SET}  set algebra
+  - GET    /metrics                                                   Prometheus metrics
+
+Pros:
+- No process start-up or bitset allocation per query.
+- Limits on concurrent counts and memory protect the host.
+
+Cons:
+  - Memory accounting is an estimate: each count is charged what its counter may
+    hold, whatever it actually uses.
+  - No authentication: bind it to localhost or put it behind a proxy.
+*/
+package server
+
+import (
+	"IP-Addr-Counter/ipcounter"
+	"IP-Addr-Counter/ipcounter/assembly"
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/format"
+	"IP-Addr-Counter/ipcounter/ipset"
+	"IP-Addr-Counter/ipcounter/metrics"
+	"IP-Addr-Counter/ipcounter/sysinfo"
+	"IP-Addr-Counter/ipcounter/tuning"
+	"IP-Addr-Counter/ipcounter/utils"
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Constants defining the default server limits.
+const (
+	DefaultMaxJobs  = 2                // Counts running at once.
+	DefaultKeepJobs = 1000             // Finished jobs remembered.
+	DefaultMaxBytes = 8 << 30          // Memory budget for sets and counts (8GB).
+	maxQueryIPs     = 1 << 20          // Largest membership query.
+	maxQueryBytes   = maxQueryIPs * 32 // Largest query or set operation body: room for 32 bytes per quoted IP.
+	uploadBatch     = 1 << 16          // IPs read from an upload per memory reservation.
+)
+
+// Config holds the server settings.
+type Config struct {
+	DataDir  string // Directory for set snapshots and spooled uploads.
+	PathRoot string // Directory that server-side paths must be under; empty disables them.
+	MaxJobs  int    // Counts running at once; 0 means DefaultMaxJobs. Others wait queued.
+	KeepJobs int    // Finished jobs remembered, the oldest forgotten first; 0 means DefaultKeepJobs.
+	MaxBytes int64  // Memory budget for sets and counts; 0 means DefaultMaxBytes.
+}
+
+// Job statuses.
+const (
+	StatusQueued  = "queued"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Job is the state of one count.
+type Job struct {
+	ID       string    `json:"id"`
+	Impl     string    `json:"impl"`
+	Source   string    `json:"source"` // Server-side path, or "upload".
+	Status   string    `json:"status"`
+	Unique   int64     `json:"unique"`
+	Set      string    `json:"set,omitempty"` // Named set the result is saved to.
+	Error    string    `json:"error,omitempty"`
+	Created  time.Time `json:"created"`
+	Started  time.Time `json:"started,omitzero"`
+	Finished time.Time `json:"finished,omitzero"`
+}
+
+// Server serves the API. Create it with New.
+type Server struct {
+	cfg      Config
+	opts     tuning.Options // Settings of the counters jobs run on.
+	sets     *store
+	slots    chan struct{}       // One token per running count.
+	counts   *concurrent.Metrics // Totals of every concurrent count.
+	registry *metrics.Registry
+
+	mu       sync.Mutex // Guards jobs, finished, nextID and reserved.
+	jobs     map[string]*Job
+	finished []string // IDs of the finished jobs still in jobs, oldest first.
+	nextID   int
+	reserved int64 // Memory charged to queued and running counts.
+}
+
+// New returns a Server for cfg and loads the sets persisted in its data directory.
+func New(cfg Config) (*Server, error) {
+	if cfg.DataDir == "" {
+		return nil, fmt.Errorf("a data directory is required")
+	}
+	if cfg.MaxJobs <= 0 {
+		cfg.MaxJobs = DefaultMaxJobs
+	}
+	if cfg.KeepJobs <= 0 {
+		cfg.KeepJobs = DefaultKeepJobs
+	}
+	if cfg.MaxBytes <= 0 {
+		cfg.MaxBytes = DefaultMaxBytes
+	}
+	opts := jobOptions()
+	if need := jobBytes(opts, true); cfg.MaxBytes < need {
+		return nil, fmt.Errorf("memory budget of %d bytes cannot fit a single count (%d bytes)", cfg.MaxBytes, need)
+	}
+	if cfg.PathRoot != "" {
+		root, err := filepath.Abs(cfg.PathRoot)
+		if err == nil {
+			root, err = filepath.EvalSymlinks(root)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid path root: %w", err)
+		}
+		cfg.PathRoot = root
+	}
+	sets, err := openStore(filepath.Join(cfg.DataDir, "sets"))
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Join(cfg.DataDir, "uploads"), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+	s := &Server{
+		cfg:      cfg,
+		opts:     opts,
+		sets:     sets,
+		slots:    make(chan struct{}, cfg.MaxJobs),
+		counts:   &concurrent.Metrics{},
+		registry: metrics.NewRegistry(),
+		jobs:     make(map[string]*Job),
+	}
+	s.registerMetrics()
+	return s, nil
+}
+
+// registerMetrics exposes the count totals, jobs and sets. Only the concurrent
+// counter reports line, byte and timing totals.
+func (s *Server) registerMetrics() {
+	s.counts.Register(s.registry)
+	s.registry.GaugeFunc("ipcounter_bitset_bytes", "Memory held by the bitsets of running counts.",
+		func() float64 { return float64(len(s.slots)) * concurrent.BitsetBytes })
+	s.registry.GaugeFunc("ipcounter_jobs_running", "Counts running.",
+		func() float64 { return float64(len(s.slots)) })
+	s.registry.GaugeFunc("ipcounter_jobs_queued", "Counts waiting for a free slot.",
+		func() float64 { return float64(s.jobsIn(StatusQueued)) })
+	s.registry.GaugeFunc("ipcounter_sets", "Named sets.",
+		func() float64 { return float64(len(s.sets.list())) })
+	s.registry.GaugeFunc("ipcounter_sets_bytes", "Memory held by the named sets.",
+		func() float64 { return float64(s.sets.bytes()) })
+}
+
+// jobsIn returns the number of jobs with the given status.
+func (s *Server) jobsIn(status string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, job := range s.jobs {
+		if job.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// Handler returns the HTTP handler of the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /count", s.handleCount)
+	mux.HandleFunc("GET /jobs", s.handleJobs)
+	mux.HandleFunc("GET /jobs/{id}", s.handleJob)
+	mux.HandleFunc("GET /sets", s.handleSets)
+	mux.HandleFunc("GET /sets/{name}", s.handleSet)
+	mux.HandleFunc("PUT /sets/{name}", s.handleUpload)
+	mux.HandleFunc("POST /sets/{name}", s.handleUpload)
+	mux.HandleFunc("DELETE /sets/{name}", s.handleDelete)
+	mux.HandleFunc("POST /sets/{name}/contains", s.handleContains)
+	mux.HandleFunc("POST /ops", s.handleOps)
+	mux.Handle("GET /metrics", s.registry)
+	return mux
+}
+
+// handleCount starts a count job on an uploaded body or a server-side path.
+func (s *Server) handleCount(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	impl := q.Get("impl")
+	if impl == "" {
+		impl = "concurrent"
+	}
+	if impl != "concurrent" && impl != "asm" {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown implementation: %s (implementations: concurrent, asm)", impl))
+		return
+	}
+	extract, err := format.Parse(q.Get("format"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	save := q.Get("save")
+	if save != "" && !validSetName.MatchString(save) {
+		writeError(w, http.StatusBadRequest, errBadSetName)
+		return
+	}
+
+	source, path, cleanup := "upload", "", func() {}
+	if p := q.Get("path"); p != "" {
+		if path, err = s.resolvePath(p); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		source = p
+	}
+
+	charge := jobBytes(s.opts, save != "")
+	if err := s.reserve(charge); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	if path == "" {
+		// The counters read files, so the body is spooled to disk first.
+		if path, err = s.spool(r.Body); err != nil {
+			s.release(charge)
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		cleanup = func() { os.Remove(path) }
+	}
+
+	job := s.newJob(impl, source, save)
+	go func() {
+		defer s.release(charge)
+		defer cleanup()
+		s.run(job, extract, path)
+	}()
+	writeJSON(w, http.StatusAccepted, s.snapshot(job))
+}
+
+// jobOptions returns the settings of the counters jobs run on: the defaults,
+// with one chunk queued per worker so the memory of a count is known up front.
+func jobOptions() tuning.Options {
+	o, _ := tuning.New(tuning.QueueLen(sysinfo.CPUs()))
+	return o
+}
+
+// jobBytes returns the memory charged to a count on a counter with options o:
+// its footprint with the chunk queue full and, if it is saved to a set, the
+// bitmap the bitset is flattened into. Large sets keep that bitmap, and once
+// saved are charged as named sets.
+func jobBytes(o tuning.Options, save bool) int64 {
+	n := o.Footprint() + int64(o.QueueLen-1)*int64(o.ChunkBytes)
+	if save {
+		n += concurrent.BitsetBytes
+	}
+	return n
+}
+
+// counter is implemented by the counters jobs run on.
+type counter interface {
+	ipcounter.Counter
+	UseFormat(e format.Extractor)
+	Bitmap() *ipset.Bitmap
+}
+
+// newCounter returns a counter of the named implementation, which must be
+// concurrent or asm. Concurrent counters add to the server's totals.
+func (s *Server) newCounter(impl string) counter {
+	queue := tuning.QueueLen(s.opts.QueueLen)
+	if impl == "asm" {
+		return assembly.New(queue)
+	}
+	c := concurrent.New(queue)
+	c.UseMetrics(s.counts)
+	return c
+}
+
+// resolvePath returns the absolute form of a client path, which must name a
+// file under PathRoot.
+func (s *Server) resolvePath(p string) (string, error) {
+	if s.cfg.PathRoot == "" {
+		return "", fmt.Errorf("server-side paths are disabled")
+	}
+	path := p
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.cfg.PathRoot, path)
+	}
+	path, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", fmt.Errorf("invalid path: %s", p)
+	}
+	rel, err := filepath.Rel(s.cfg.PathRoot, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path is outside the server root: %s", p)
+	}
+	return path, nil
+}
+
+// spool copies body to a temporary file in the upload directory.
+func (s *Server) spool(body io.Reader) (string, error) {
+	file, err := os.CreateTemp(filepath.Join(s.cfg.DataDir, "uploads"), "count-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create upload file: %w", err)
+	}
+	if _, err := io.Copy(file, body); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to read upload: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write upload: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// run waits for a free slot, counts path and records the outcome in job.
+func (s *Server) run(job *Job, extract format.Extractor, path string) {
+	s.slots <- struct{}{}
+	defer func() { <-s.slots }()
+	s.setJob(job, func(j *Job) {
+		j.Status = StatusRunning
+		j.Started = time.Now()
+	})
+
+	c := s.newCounter(job.Impl)
+	c.UseFormat(extract)
+	unique, err := c.CountUniqueIPs(path)
+	if err == nil && job.Set != "" {
+		// The bitmap is kept as is if the set is large.
+		_, err = s.sets.put(job.Set, ipset.FromBitmap(c.Bitmap()))
+	}
+
+	s.finish(job, func(j *Job) {
+		j.Finished = time.Now()
+		if err != nil {
+			j.Status = StatusFailed
+			j.Error = err.Error()
+			return
+		}
+		j.Status = StatusDone
+		j.Unique = unique
+	})
+}
+
+// reserve charges n bytes to the memory budget, failing if it would not fit
+// next to the named sets.
+func (s *Server) reserve(n int64) error {
+	used := s.sets.bytes()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if used+s.reserved+n > s.cfg.MaxBytes {
+		return fmt.Errorf("%w: %d of %d bytes in use", errMemoryLimit, used+s.reserved, s.cfg.MaxBytes)
+	}
+	s.reserved += n
+	return nil
+}
+
+// release returns n bytes to the memory budget.
+func (s *Server) release(n int64) {
+	s.mu.Lock()
+	s.reserved -= n
+	s.mu.Unlock()
+}
+
+// newJob registers a queued job.
+func (s *Server) newJob(impl, source, set string) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nextID++
+	job := &Job{
+		ID:      fmt.Sprintf("job-%d", s.nextID),
+		Impl:    impl,
+		Source:  source,
+		Status:  StatusQueued,
+		Set:     set,
+		Created: time.Now(),
+	}
+	s.jobs[job.ID] = job
+	return job
+}
+
+// setJob updates job under the server lock.
+func (s *Server) setJob(job *Job, fn func(j *Job)) {
+	s.mu.Lock()
+	fn(job)
+	s.mu.Unlock()
+}
+
+// finish updates job like setJob as it finishes, and forgets the oldest
+// finished jobs beyond cfg.KeepJobs.
+func (s *Server) finish(job *Job, fn func(j *Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fn(job)
+	s.finished = append(s.finished, job.ID)
+	for len(s.finished) > s.cfg.KeepJobs {
+		delete(s.jobs, s.finished[0])
+		s.finished = s.finished[1:]
+	}
+}
+
+// snapshot returns a copy of job that is safe to encode.
+func (s *Server) snapshot(job *Job) Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *job
+}
+
+// Job returns the state of the job with id.
+func (s *Server) Job(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+func (s *Server) handleJobs(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		jobs = append(jobs, *job)
+	}
+	s.mu.Unlock()
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Created.Before(jobs[j].Created) })
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *Server) handleJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.Job(r.PathValue("id"))
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("no such job: %s", r.PathValue("id")))
+		return
+	}
+	writeJSON(w, http.StatusOK, job)
+}
+
+func (s *Server) handleSets(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.sets.list())
+}
+
+func (s *Server) handleSet(w http.ResponseWriter, r *http.Request) {
+	info, err := s.sets.info(r.PathValue("name"))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// uploadResult is the response to a set upload.
+type uploadResult struct {
+	SetInfo
+	Added   int64 `json:"added"`   // Valid IPs read from the body.
+	Invalid int64 `json:"invalid"` // Non-empty lines that were not IPs.
+}
+
+// handleUpload reads one IP per line into a named set. PUT replaces the set,
+// POST adds to it. The body may be as large as the memory budget.
+func (s *Server) handleUpload(w http.ResponseWriter, r *http.Request) {
+	// Every IP costs 4 bytes in the list read and 4 in the sorted array it goes
+	// into; both are charged as the body is read, until the set has grown.
+	var reserved int64
+	defer func() { s.release(reserved) }()
+	ips, invalid, err := readIPs(http.MaxBytesReader(w, r.Body, s.cfg.MaxBytes), func(n int) error {
+		if err := s.reserve(int64(n) * 8); err != nil {
+			return err
+		}
+		reserved += int64(n) * 8
+		return nil
+	})
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	info, err := s.sets.update(r.PathValue("name"), true, r.Method == http.MethodPut, func(set *ipset.Adaptive) {
+		for _, ip := range ips {
+			set.Add(ip)
+		}
+	})
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	writeJSON(w, http.StatusOK, uploadResult{SetInfo: info, Added: int64(len(ips)), Invalid: invalid})
+}
+
+func (s *Server) handleDelete(w http.ResponseWriter, r *http.Request) {
+	if err := s.sets.remove(r.PathValue("name")); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Membership is the answer for one queried IP.
+type Membership struct {
+	IP      string `json:"ip"`
+	Present bool   `json:"present"`
+}
+
+func (s *Server) handleContains(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		IPs []string `json:"ips"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxQueryBytes)).Decode(&req); err != nil {
+		writeBodyError(w, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if len(req.IPs) > maxQueryIPs {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("too many IPs: %d (limit %d)", len(req.IPs), maxQueryIPs))
+		return
+	}
+	ips := make([]uint32, len(req.IPs))
+	for i, text := range req.IPs {
+		ip, err := utils.ParseIPv4([]byte(text))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("invalid IP: %q", text))
+			return
+		}
+		ips[i] = ip
+	}
+
+	ns, err := s.sets.get(r.PathValue("name"))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	results := make([]Membership, len(ips))
+	ns.mu.Lock()
+	for i, ip := range ips {
+		results[i] = Membership{IP: req.IPs[i], Present: ns.set.Contains(ip)}
+	}
+	ns.mu.Unlock()
+	writeJSON(w, http.StatusOK, map[string][]Membership{"results": results})
+}
+
+// opResult is the response to a set operation.
+type opResult struct {
+	Count int64  `json:"count"`
+	Saved string `json:"saved,omitempty"`
+}
+
+func (s *Server) handleOps(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Op   string   `json:"op"`
+		Sets []string `json:"sets"`
+		Save string   `json:"save"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxQueryBytes)).Decode(&req); err != nil {
+		writeBodyError(w, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	// The result holds at most the IPs of the operands, and never takes more
+	// than a bitmap; charge that before building it.
+	size, err := s.sets.size(req.Sets)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	bound := min(size, concurrent.BitsetBytes)
+	if err := s.reserve(bound); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer s.release(bound)
+
+	result, err := s.sets.combine(req.Op, req.Sets)
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+	res := opResult{Count: result.Count()}
+	if req.Save != "" {
+		if _, err := s.sets.put(req.Save, result); err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+		res.Saved = req.Save
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// readIPs parses one IP per line, skipping empty lines and counting invalid
+// ones. It calls grow for every uploadBatch IPs before reading them, and stops
+// if grow fails.
+func readIPs(r io.Reader, grow func(n int) error) ([]uint32, int64, error) {
+	var (
+		ips     []uint32
+		invalid int64
+	)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		ip, err := utils.ParseIPv4(line)
+		if err != nil {
+			invalid++
+			continue
+		}
+		if len(ips)%uploadBatch == 0 {
+			if err := grow(uploadBatch); err != nil {
+				return nil, 0, err
+			}
+		}
+		ips = append(ips, ip)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read IPs: %w", err)
+	}
+	return ips, invalid, nil
+}
+
+// statusOf maps an error to an HTTP status.
+func statusOf(err error) int {
+	switch {
+	case errors.Is(err, errNoSet):
+		return http.StatusNotFound
+	case errors.Is(err, errMemoryLimit):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, errBadSetName), errors.Is(err, errBadOp):
+		return http.StatusBadRequest
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeBodyError writes the response for a request body that could not be
+// read: 413 past its size limit, 503 past the memory budget and 400 otherwise.
+func writeBodyError(w http.ResponseWriter, err error) {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, err)
+	case errors.Is(err, errMemoryLimit):
+		writeError(w, http.StatusServiceUnavailable, err)
+	default:
+		writeError(w, http.StatusBadRequest, err)
+	}
+}
+
+// writeJSON writes v as the JSON response body.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as a JSON error response.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
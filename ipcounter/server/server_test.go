@@ -0,0 +1,294 @@
+package server
+
+import (
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/ipset"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// call sends a request to the test server and decodes the JSON response into out.
+func call(t *testing.T, srv *httptest.Server, method, path, body string, out any) int {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if out != nil && resp.StatusCode < 300 {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("%s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func newTestServer(t *testing.T, cfg Config) (*Server, *httptest.Server) {
+	t.Helper()
+	if cfg.DataDir == "" {
+		cfg.DataDir = t.TempDir()
+	}
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s.Handler())
+	t.Cleanup(srv.Close)
+	return s, srv
+}
+
+func TestSets(t *testing.T) {
+	dir := t.TempDir()
+	_, srv := newTestServer(t, Config{DataDir: dir})
+
+	var up uploadResult
+	if code := call(t, srv, "PUT", "/sets/a", "1.1.1.1\n2.2.2.2\nbogus\n\n3.3.3.3\n", &up); code != 200 {
+		t.Fatalf("PUT /sets/a: status %d", code)
+	}
+	if up.Count != 3 || up.Added != 3 || up.Invalid != 1 {
+		t.Errorf("PUT /sets/a = %+v", up)
+	}
+	call(t, srv, "PUT", "/sets/b", "2.2.2.2\n3.3.3.3\n4.4.4.4\n", nil)
+	call(t, srv, "POST", "/sets/b", "5.5.5.5\n", &up)
+	if up.Count != 4 {
+		t.Errorf("POST /sets/b: count %d, want 4", up.Count)
+	}
+
+	var contains struct{ Results []Membership }
+	call(t, srv, "POST", "/sets/a/contains", `{"ips":["1.1.1.1","4.4.4.4"]}`, &contains)
+	if len(contains.Results) != 2 || !contains.Results[0].Present || contains.Results[1].Present {
+		t.Errorf("contains = %+v", contains.Results)
+	}
+	if code := call(t, srv, "POST", "/sets/a/contains", `{"ips":["nope"]}`, nil); code != 400 {
+		t.Errorf("invalid IP query: status %d, want 400", code)
+	}
+	huge := `{"ips":["` + strings.Repeat(" ", maxQueryBytes) + `"]}`
+	if code := call(t, srv, "POST", "/sets/a/contains", huge, nil); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("query over the size limit: status %d, want 413", code)
+	}
+
+	for op, want := range map[string]int64{"union": 5, "intersect": 2, "diff": 1} {
+		var res opResult
+		if code := call(t, srv, "POST", "/ops", `{"op":"`+op+`","sets":["a","b"]}`, &res); code != 200 || res.Count != want {
+			t.Errorf("%s: status %d count %d, want %d", op, code, res.Count, want)
+		}
+	}
+	var res opResult
+	call(t, srv, "POST", "/ops", `{"op":"union","sets":["a","b"],"save":"ab"}`, &res)
+	if code := call(t, srv, "POST", "/ops", `{"op":"xor","sets":["a"]}`, nil); code != 400 {
+		t.Errorf("unknown op: status %d, want 400", code)
+	}
+	huge = `{"op":"union","sets":["` + strings.Repeat("a", maxQueryBytes) + `"]}`
+	if code := call(t, srv, "POST", "/ops", huge, nil); code != http.StatusRequestEntityTooLarge {
+		t.Errorf("set operation over the size limit: status %d, want 413", code)
+	}
+	if code := call(t, srv, "GET", "/sets/missing", "", nil); code != 404 {
+		t.Errorf("missing set: status %d, want 404", code)
+	}
+	if code := call(t, srv, "PUT", "/sets/.hidden", "1.1.1.1\n", nil); code != 400 {
+		t.Errorf("bad set name: status %d, want 400", code)
+	}
+	if code := call(t, srv, "DELETE", "/sets/b", "", nil); code != 204 {
+		t.Errorf("DELETE /sets/b: status %d", code)
+	}
+
+	// The sets survive a restart.
+	_, srv2 := newTestServer(t, Config{DataDir: dir})
+	var infos []SetInfo
+	call(t, srv2, "GET", "/sets", "", &infos)
+	if len(infos) != 2 || infos[0].Name != "a" || infos[0].Count != 3 || infos[1].Name != "ab" || infos[1].Count != 5 {
+		t.Errorf("sets after restart = %+v", infos)
+	}
+}
+
+// waitJob polls a job until it finishes.
+func waitJob(t *testing.T, srv *httptest.Server, id string) Job {
+	t.Helper()
+	deadline := time.Now().Add(30 * time.Second)
+	for time.Now().Before(deadline) {
+		var job Job
+		call(t, srv, "GET", "/jobs/"+id, "", &job)
+		if job.Status == StatusDone || job.Status == StatusFailed {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", id)
+	return Job{}
+}
+
+func TestRemoveDuringUpdate(t *testing.T) {
+	dir := t.TempDir()
+	st, err := openStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.update("a", true, false, func(set *ipset.Adaptive) { set.Add(1) }); err != nil {
+		t.Fatal(err)
+	}
+	started, release, updated := make(chan struct{}), make(chan struct{}), make(chan error)
+	go func() {
+		_, err := st.update("a", false, false, func(set *ipset.Adaptive) {
+			close(started)
+			<-release
+			set.Add(2)
+		})
+		updated <- err
+	}()
+	<-started
+	removed := make(chan error)
+	go func() { removed <- st.remove("a") }()
+	time.Sleep(10 * time.Millisecond) // Let remove reach the set being updated.
+	close(release)
+	if err := <-updated; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-removed; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.update("a", false, false, func(*ipset.Adaptive) {}); err == nil {
+		t.Error("update of a removed set succeeded")
+	}
+	reopened, err := openStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.get("a"); err == nil {
+		t.Error("removed set was saved back while being removed")
+	}
+}
+
+func TestFailedReplace(t *testing.T) {
+	st, err := openStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.update("a", true, false, func(set *ipset.Adaptive) { set.Add(1) }); err != nil {
+		t.Fatal(err)
+	}
+	st.dir = filepath.Join(st.dir, "missing") // Saving fails from now on.
+	if _, err := st.update("a", false, true, func(set *ipset.Adaptive) { set.Add(2) }); err == nil {
+		t.Fatal("replace succeeded without saving")
+	}
+	if info, err := st.info("a"); err != nil || info.Count != 1 {
+		t.Errorf("after a failed replace: info = %+v, %v; want the old set of 1 IP", info, err)
+	}
+}
+
+func TestCountJobs(t *testing.T) {
+	root := t.TempDir()
+	os.WriteFile(filepath.Join(root, "ips.txt"), []byte("1.1.1.1\n1.1.1.1\n2.2.2.2\n"), 0o644)
+	_, srv := newTestServer(t, Config{PathRoot: root, MaxJobs: 1, MaxBytes: 2 * jobBytes(jobOptions(), true)})
+
+	var job Job
+	if code := call(t, srv, "POST", "/count?impl=asm&save=up", "9.9.9.9\n8.8.8.8\n9.9.9.9\n7.7.7.7\n", &job); code != 202 {
+		t.Fatalf("POST /count: status %d", code)
+	}
+	if job = waitJob(t, srv, job.ID); job.Status != StatusDone || job.Unique != 3 {
+		t.Errorf("upload job = %+v", job)
+	}
+	var info SetInfo
+	call(t, srv, "GET", "/sets/up", "", &info)
+	if info.Count != 3 {
+		t.Errorf("saved set count = %d, want 3", info.Count)
+	}
+
+	call(t, srv, "POST", "/count?path=ips.txt&format=plain", "", &job)
+	if job = waitJob(t, srv, job.ID); job.Status != StatusDone || job.Unique != 2 {
+		t.Errorf("path job = %+v", job)
+	}
+
+	if code := call(t, srv, "POST", "/count?path=../etc/passwd", "", nil); code != 400 {
+		t.Errorf("path outside root: status %d, want 400", code)
+	}
+	if code := call(t, srv, "POST", "/count?impl=naive", "", nil); code != 400 {
+		t.Errorf("unknown impl: status %d, want 400", code)
+	}
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	for _, want := range []string{"ipcounter_lines_processed_total 3\n", "ipcounter_sets 1\n", "ipcounter_jobs_running 0\n"} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("GET /metrics lacks %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestKeepJobs(t *testing.T) {
+	_, srv := newTestServer(t, Config{KeepJobs: 2})
+	ids := make([]string, 3)
+	for i := range ids {
+		var job Job
+		call(t, srv, "POST", "/count", "1.1.1.1\n", &job)
+		ids[i] = waitJob(t, srv, job.ID).ID
+	}
+	if code := call(t, srv, "GET", "/jobs/"+ids[0], "", nil); code != http.StatusNotFound {
+		t.Errorf("oldest finished job: status %d, want 404", code)
+	}
+	var jobs []Job
+	call(t, srv, "GET", "/jobs", "", &jobs)
+	if len(jobs) != 2 || jobs[0].ID != ids[1] || jobs[1].ID != ids[2] {
+		t.Errorf("GET /jobs = %+v, want the last 2 jobs", jobs)
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	s, srv := newTestServer(t, Config{MaxBytes: jobBytes(jobOptions(), true)})
+	call(t, srv, "PUT", "/sets/a", "1.1.1.1\n", nil)
+	full := s.cfg.MaxBytes - s.sets.bytes()
+	if err := s.reserve(full); err != nil {
+		t.Fatal(err)
+	}
+	defer s.release(full)
+	if code := call(t, srv, "POST", "/count", "1.1.1.1\n", nil); code != http.StatusServiceUnavailable {
+		t.Errorf("count over budget: status %d, want 503", code)
+	}
+	if code := call(t, srv, "PUT", "/sets/a", "1.1.1.1\n", nil); code != http.StatusServiceUnavailable {
+		t.Errorf("upload over budget: status %d, want 503", code)
+	}
+	if code := call(t, srv, "POST", "/ops", `{"op":"union","sets":["a"]}`, nil); code != http.StatusServiceUnavailable {
+		t.Errorf("set operation over budget: status %d, want 503", code)
+	}
+
+	// No body may be larger than the whole budget, even without a valid IP.
+	s.cfg.MaxBytes = 64
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("PUT", "/sets/a", strings.NewReader(strings.Repeat("bogus\n", 20)))
+	req.SetPathValue("name", "a")
+	s.handleUpload(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("upload over the size limit: status %d, want 413", rec.Code)
+	}
+}
+
+func TestSavedCountCharge(t *testing.T) {
+	// A count saved to a set is charged the bitmap the set is made from.
+	s, srv := newTestServer(t, Config{MaxBytes: jobBytes(jobOptions(), true)})
+	if err := s.reserve(concurrent.BitsetBytes); err != nil {
+		t.Fatal(err)
+	}
+	defer s.release(concurrent.BitsetBytes)
+	if code := call(t, srv, "POST", "/count?save=up", "1.1.1.1\n", nil); code != http.StatusServiceUnavailable {
+		t.Errorf("saved count over budget: status %d, want 503", code)
+	}
+	var job Job
+	if code := call(t, srv, "POST", "/count", "1.1.1.1\n", &job); code != http.StatusAccepted {
+		t.Fatalf("count within budget: status %d, want 202", code)
+	}
+	waitJob(t, srv, job.ID)
+}
@@ -0,0 +1,260 @@
+package server
+
+import (
+	"IP-Addr-Counter/ipcounter/ipset"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// snapshotExt is the file extension of persisted sets.
+const snapshotExt = ".snap"
+
+var (
+	errNoSet       = errors.New("no such set")
+	validSetName   = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]{0,63}$`)
+	errBadSetName  = errors.New("set names must be 1-64 letters, digits, '_', '-' or '.', not starting with '.'")
+	errMemoryLimit = errors.New("memory limit reached")
+	errBadOp       = errors.New("invalid set operation")
+)
+
+// namedSet is one persistent set. Adaptive compacts itself on reads, so every
+// access takes the lock.
+type namedSet struct {
+	mu      sync.Mutex
+	set     *ipset.Adaptive
+	deleted bool // Removed from the store; it must not be saved again.
+}
+
+// SetInfo describes a named set.
+type SetInfo struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+	Bytes int64  `json:"bytes"`
+}
+
+// store holds the named sets and mirrors every change to a snapshot in dir.
+type store struct {
+	dir  string
+	mu   sync.RWMutex
+	sets map[string]*namedSet
+}
+
+// openStore loads every snapshot of dir, creating it if needed.
+func openStore(dir string) (*store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create set directory: %w", err)
+	}
+	st := &store{dir: dir, sets: make(map[string]*namedSet)}
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+snapshotExt))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		set, err := ipset.LoadAdaptive(path)
+		if err != nil {
+			return nil, err
+		}
+		st.sets[strings.TrimSuffix(filepath.Base(path), snapshotExt)] = &namedSet{set: set}
+	}
+	return st, nil
+}
+
+// get returns the named set.
+func (st *store) get(name string) (*namedSet, error) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	ns, ok := st.sets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", errNoSet, name)
+	}
+	return ns, nil
+}
+
+// lock returns the named set with its lock held, creating it first if create
+// is set. A set removed while lock waited for it is looked up again, so the
+// caller never saves a deleted set back.
+func (st *store) lock(name string, create bool) (*namedSet, error) {
+	if !validSetName.MatchString(name) {
+		return nil, errBadSetName
+	}
+	for {
+		st.mu.Lock()
+		ns, ok := st.sets[name]
+		if !ok {
+			if !create {
+				st.mu.Unlock()
+				return nil, fmt.Errorf("%w: %s", errNoSet, name)
+			}
+			ns = &namedSet{set: ipset.NewAdaptive()}
+			st.sets[name] = ns
+		}
+		st.mu.Unlock()
+
+		ns.mu.Lock()
+		if !ns.deleted {
+			return ns, nil
+		}
+		ns.mu.Unlock()
+	}
+}
+
+// update runs fn on the named set, creating it first if create is set, and
+// persists the result. With replace, fn starts from an empty set.
+func (st *store) update(name string, create, replace bool, fn func(set *ipset.Adaptive)) (SetInfo, error) {
+	ns, err := st.lock(name, create)
+	if err != nil {
+		return SetInfo{}, err
+	}
+	defer ns.mu.Unlock()
+	set := ns.set
+	if replace {
+		set = ipset.NewAdaptive() // Kept only once saved, like put.
+	}
+	fn(set)
+	if err := set.Save(st.path(name)); err != nil {
+		return SetInfo{}, err
+	}
+	ns.set = set
+	return SetInfo{Name: name, Count: set.Count(), Bytes: set.Bytes()}, nil
+}
+
+// put stores set under name, replacing any set of that name. Like update, it
+// holds the named set's lock while saving, so the snapshot on disk is the one
+// of the last change made.
+func (st *store) put(name string, set *ipset.Adaptive) (SetInfo, error) {
+	ns, err := st.lock(name, true)
+	if err != nil {
+		return SetInfo{}, err
+	}
+	defer ns.mu.Unlock()
+	if err := set.Save(st.path(name)); err != nil {
+		return SetInfo{}, err
+	}
+	ns.set = set
+	return SetInfo{Name: name, Count: set.Count(), Bytes: set.Bytes()}, nil
+}
+
+// remove deletes the named set and its snapshot. It waits for a change in
+// progress to be saved, then marks the set deleted so no later one is.
+func (st *store) remove(name string) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	ns, ok := st.sets[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", errNoSet, name)
+	}
+	delete(st.sets, name)
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	ns.deleted = true
+	if err := os.Remove(st.path(name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove snapshot: %w", err)
+	}
+	return nil
+}
+
+// info returns the description of the named set.
+func (st *store) info(name string) (SetInfo, error) {
+	ns, err := st.get(name)
+	if err != nil {
+		return SetInfo{}, err
+	}
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	return SetInfo{Name: name, Count: ns.set.Count(), Bytes: ns.set.Bytes()}, nil
+}
+
+// list describes every set, sorted by name.
+func (st *store) list() []SetInfo {
+	st.mu.RLock()
+	names := make([]string, 0, len(st.sets))
+	for name := range st.sets {
+		names = append(names, name)
+	}
+	st.mu.RUnlock()
+	sort.Strings(names)
+
+	infos := make([]SetInfo, 0, len(names))
+	for _, name := range names {
+		if info, err := st.info(name); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// bytes returns the memory held by all sets.
+func (st *store) bytes() int64 {
+	var total int64
+	for _, info := range st.list() {
+		total += info.Bytes
+	}
+	return total
+}
+
+// size returns the memory held by the named sets.
+func (st *store) size(names []string) (int64, error) {
+	var total int64
+	for _, name := range names {
+		info, err := st.info(name)
+		if err != nil {
+			return 0, err
+		}
+		total += info.Bytes
+	}
+	return total, nil
+}
+
+// path returns the snapshot file of the named set.
+func (st *store) path(name string) string {
+	return filepath.Join(st.dir, name+snapshotExt)
+}
+
+// combine applies op to the named sets, in order, and returns the result as a
+// new set. The operands are never modified.
+func (st *store) combine(op string, names []string) (*ipset.Adaptive, error) {
+	if op != "union" && op != "intersect" && op != "diff" {
+		return nil, fmt.Errorf("%w: %q (operations: union, intersect, diff)", errBadOp, op)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%w: %s needs at least one set", errBadOp, op)
+	}
+	operands := make([]*namedSet, len(names))
+	for i, name := range names {
+		ns, err := st.get(name)
+		if err != nil {
+			return nil, err
+		}
+		operands[i] = ns
+	}
+
+	result := ipset.NewAdaptive()
+	first := operands[0]
+	first.mu.Lock()
+	result.Merge(first.set)
+	first.mu.Unlock()
+
+	for _, ns := range operands[1:] {
+		ns.mu.Lock()
+		if op == "union" {
+			result.Merge(ns.set)
+		} else {
+			next := ipset.NewAdaptive()
+			keep := op == "intersect"
+			result.ForEach(func(ip uint32) {
+				if ns.set.Contains(ip) == keep {
+					next.Add(ip)
+				}
+			})
+			result = next
+		}
+		ns.mu.Unlock()
+	}
+	return result, nil
+}
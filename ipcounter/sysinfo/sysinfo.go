@@ -0,0 +1,235 @@
+/*
+Package sysinfo reports the resources the process may use, taking container
+limits into account: a cgroup memory limit can be far below what the host has
+free, and exceeding it gets the process OOM-killed rather than swapped, while a
+CPU quota below the host's core count leaves extra workers throttled.
+
+Both cgroup v1 and v2 are read, walking from the process's own cgroup up to the
+root, since a limit set on any ancestor applies too. On systems without
+/proc and /sys, the figures are reported as unknown.
+*/
+package sysinfo
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// unlimited is the smallest cgroup v1 limit treated as no limit; the kernel
+// reports an unset limit as the largest page-aligned int64.
+const unlimited = 1 << 62
+
+// Memory describes the memory the process can still allocate.
+type Memory struct {
+	Available int64  // Bytes, or -1 if unknown.
+	Source    string // The figure that bounds Available: "cgroup v2", "cgroup v1", "/proc/meminfo" or "unknown".
+}
+
+// AvailableMemory returns the memory the process can still allocate: the
+// smallest of the host's available memory and the room left under each
+// cgroup limit. Reclaimable page cache is counted as available.
+func AvailableMemory() Memory {
+	return readMemory("/")
+}
+
+// CPUs returns the number of CPUs the process can keep busy: GOMAXPROCS,
+// capped by the cgroup CPU quota rounded up. It never changes GOMAXPROCS.
+func CPUs() int {
+	return readCPUs("/", runtime.GOMAXPROCS(0))
+}
+
+// Buffers returns how many buffers of size bytes fit in the available memory
+// once reserved bytes are set aside, clamped to between 1 and limit. With the
+// available memory unknown, it returns limit.
+func Buffers(reserved, size int64, limit int) int {
+	avail := AvailableMemory().Available
+	if avail < 0 {
+		return limit
+	}
+	n := (avail - reserved) / size
+	return int(max(1, min(n, int64(limit))))
+}
+
+// readCPUs is CPUs with /proc and /sys looked up under root.
+func readCPUs(root string, procs int) int {
+	cpus := procs
+	lower := func(quota, period int64) {
+		if quota <= 0 || period <= 0 {
+			return
+		}
+		n := int((quota + period - 1) / period)
+		if n < cpus {
+			cpus = max(n, 1)
+		}
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "proc/self/cgroup"))
+	if err != nil {
+		return cpus
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			walkUp(filepath.Join(root, "sys/fs/cgroup"), parts[2], func(dir string) {
+				// cpu.max holds "$MAX $PERIOD", with $MAX "max" when unlimited.
+				data, err := os.ReadFile(filepath.Join(dir, "cpu.max"))
+				if f := strings.Fields(string(data)); err == nil && len(f) == 2 {
+					quota, _ := strconv.ParseInt(f[0], 10, 64)
+					period, _ := strconv.ParseInt(f[1], 10, 64)
+					lower(quota, period)
+				}
+			})
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			if controller != "cpu" {
+				continue
+			}
+			// The cpu controller is often co-mounted with cpuacct.
+			for _, mount := range []string{"cpu", "cpu,cpuacct", "cpuacct,cpu"} {
+				base := filepath.Join(root, "sys/fs/cgroup", mount)
+				if _, err := os.Stat(base); err != nil {
+					continue
+				}
+				walkUp(base, parts[2], func(dir string) {
+					quota, ok := readInt(filepath.Join(dir, "cpu.cfs_quota_us"))
+					period, _ := readInt(filepath.Join(dir, "cpu.cfs_period_us"))
+					if ok {
+						lower(quota, period) // An unset quota is -1.
+					}
+				})
+				break
+			}
+		}
+	}
+	return cpus
+}
+
+// readMemory is AvailableMemory with /proc and /sys looked up under root.
+func readMemory(root string) Memory {
+	m := Memory{Available: -1, Source: "unknown"}
+	lower := func(avail int64, source string) {
+		if avail < 0 {
+			avail = 0
+		}
+		if m.Available < 0 || avail < m.Available {
+			m.Available, m.Source = avail, source
+		}
+	}
+
+	if kb, ok := statValue(filepath.Join(root, "proc/meminfo"), "MemAvailable:"); ok {
+		lower(kb*1024, "/proc/meminfo")
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "proc/self/cgroup"))
+	if err != nil {
+		return m
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		// Each line is hierarchy-ID:controller-list:cgroup-path.
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			base := filepath.Join(root, "sys/fs/cgroup")
+			walkUp(base, parts[2], func(dir string) {
+				limit, ok := readLimit(filepath.Join(dir, "memory.max"))
+				if !ok {
+					return
+				}
+				usage, _ := readInt(filepath.Join(dir, "memory.current"))
+				cache, _ := statValue(filepath.Join(dir, "memory.stat"), "inactive_file")
+				lower(limit-usage+cache, "cgroup v2")
+			})
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			if controller != "memory" {
+				continue
+			}
+			base := filepath.Join(root, "sys/fs/cgroup/memory")
+			walkUp(base, parts[2], func(dir string) {
+				limit, ok := readLimit(filepath.Join(dir, "memory.limit_in_bytes"))
+				if !ok {
+					return
+				}
+				usage, _ := readInt(filepath.Join(dir, "memory.usage_in_bytes"))
+				cache, _ := statValue(filepath.Join(dir, "memory.stat"), "total_inactive_file")
+				lower(limit-usage+cache, "cgroup v1")
+			})
+		}
+	}
+	return m
+}
+
+// walkUp calls fn for the directory of cgroup path p under base and for each
+// of its ancestors up to base. Inside a cgroup namespace, p may not exist under
+// base, in which case only the existing ancestors are visited.
+func walkUp(base, p string, fn func(dir string)) {
+	for p = path.Clean("/" + p); ; p = path.Dir(p) {
+		dir := filepath.Join(base, filepath.FromSlash(p))
+		if _, err := os.Stat(dir); err == nil {
+			fn(dir)
+		}
+		if p == "/" {
+			return
+		}
+	}
+}
+
+// readLimit reads a cgroup limit, reporting false if the file is missing or
+// the limit is unset.
+func readLimit(name string) (int64, bool) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0, false
+	}
+	s := strings.TrimSpace(string(data))
+	if s == "max" {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || v >= unlimited {
+		return 0, false
+	}
+	return v, true
+}
+
+// readInt reads a file holding a single integer.
+func readInt(name string) (int64, bool) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return v, err == nil
+}
+
+// statValue returns the number following key at the start of a line of a
+// "key value" file such as /proc/meminfo or memory.stat.
+func statValue(name, key string) (int64, bool) {
+	file, err := os.Open(name)
+	if err != nil {
+		return 0, false
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := bytes.Fields(scanner.Bytes())
+		if len(fields) >= 2 && string(fields[0]) == key {
+			v, err := strconv.ParseInt(string(fields[1]), 10, 64)
+			return v, err == nil
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,99 @@
+package sysinfo
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeRoot writes files under a temporary root directory.
+func fakeRoot(t *testing.T, files map[string]string) string {
+	t.Helper()
+	root := t.TempDir()
+	for name, data := range files {
+		p := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+const meminfo = "MemTotal:       16000000 kB\nMemAvailable:    8000000 kB\n"
+
+func TestMemory(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  Memory
+	}{
+		{"host", map[string]string{"proc/meminfo": meminfo}, Memory{8000000 * 1024, "/proc/meminfo"}},
+		{"unknown", nil, Memory{-1, "unknown"}},
+		{"v2 parent limit", map[string]string{
+			"proc/meminfo":                         meminfo,
+			"proc/self/cgroup":                     "0::/app/job\n",
+			"sys/fs/cgroup/app/memory.max":         "1073741824\n",
+			"sys/fs/cgroup/app/memory.current":     "536870912\n",
+			"sys/fs/cgroup/app/memory.stat":        "anon 1\ninactive_file 1048576\n",
+			"sys/fs/cgroup/app/job/memory.max":     "max\n",
+			"sys/fs/cgroup/app/job/memory.current": "4096\n",
+		}, Memory{512<<20 + 1<<20, "cgroup v2"}},
+		{"v1 namespaced", map[string]string{
+			"proc/meminfo":     meminfo,
+			"proc/self/cgroup": "4:memory:/docker/abc\n3:cpu,cpuacct:/docker/abc\n",
+			"sys/fs/cgroup/memory/memory.limit_in_bytes": "2147483648\n",
+			"sys/fs/cgroup/memory/memory.usage_in_bytes": "1073741824\n",
+		}, Memory{1 << 30, "cgroup v1"}},
+		{"v1 unlimited", map[string]string{
+			"proc/meminfo":     meminfo,
+			"proc/self/cgroup": "4:memory:/\n",
+			"sys/fs/cgroup/memory/memory.limit_in_bytes": "9223372036854771712\n",
+		}, Memory{8000000 * 1024, "/proc/meminfo"}},
+	}
+	for _, tt := range tests {
+		if got := readMemory(fakeRoot(t, tt.files)); got != tt.want {
+			t.Errorf("%s: readMemory() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCPUs(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  int
+	}{
+		{"no cgroup", nil, 8},
+		{"v2 quota", map[string]string{
+			"proc/self/cgroup":          "0::/app\n",
+			"sys/fs/cgroup/cpu.max":     "max 100000\n",
+			"sys/fs/cgroup/app/cpu.max": "250000 100000\n",
+		}, 3},
+		{"v2 unlimited", map[string]string{
+			"proc/self/cgroup":      "0::/\n",
+			"sys/fs/cgroup/cpu.max": "max 100000\n",
+		}, 8},
+		{"v1 quota", map[string]string{
+			"proc/self/cgroup": "3:cpu,cpuacct:/docker/abc\n",
+			"sys/fs/cgroup/cpu,cpuacct/docker/abc/cpu.cfs_quota_us":  "50000\n",
+			"sys/fs/cgroup/cpu,cpuacct/docker/abc/cpu.cfs_period_us": "100000\n",
+		}, 1},
+		{"v1 unset", map[string]string{
+			"proc/self/cgroup":                    "3:cpu:/\n",
+			"sys/fs/cgroup/cpu/cpu.cfs_quota_us":  "-1\n",
+			"sys/fs/cgroup/cpu/cpu.cfs_period_us": "100000\n",
+		}, 8},
+		{"quota above GOMAXPROCS", map[string]string{
+			"proc/self/cgroup":      "0::/\n",
+			"sys/fs/cgroup/cpu.max": "1600000 100000\n",
+		}, 8},
+	}
+	for _, tt := range tests {
+		if got := readCPUs(fakeRoot(t, tt.files), 8); got != tt.want {
+			t.Errorf("%s: readCPUs() = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
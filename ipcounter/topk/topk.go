@@ -0,0 +1,223 @@
+/*
+Package topk estimates the most frequent IPv4 addresses in a stream.
+
+Exact per-IP counters for all 2^32 addresses would need 16GB, so hit counts are
+approximated with a Count-Min Sketch and the current heavy hitters are kept in a
+small min-heap keyed by their sketch estimate. Every worker owns its own Tracker,
+so the hot path needs no synchronization; the trackers are merged once the
+stream ends.
+
+Pros:
+- Fixed memory per worker, independent of the number of distinct IPs.
+- Estimates never undercount; the overcount is bounded by Epsilon * Total.
+
+Cons:
+- Counts are estimates; IPs with very few hits cannot be told apart.
+- Adds a few hashes per line to the counting hot path.
+*/
+package topk
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+)
+
+// Constants defining the default sketch dimensions.
+const (
+	sketchWidthBits = 19 // Each row has 2^19 counters, giving Epsilon = e / 2^19.
+	sketchDepth     = 4  // Number of independent rows, giving Delta = e^-4.
+)
+
+// rowSeeds are odd multipliers for multiply-shift hashing, one pair per row.
+var rowSeeds = [sketchDepth][2]uint64{
+	{0x9E3779B97F4A7C15, 0xC2B2AE3D27D4EB4F},
+	{0xBF58476D1CE4E5B9, 0x94D049BB133111EB},
+	{0xD6E8FEB86659FD93, 0xFF51AFD7ED558CCD},
+	{0xC4CEB9FE1A85EC53, 0xA0761D6478BD642F},
+}
+
+// Sketch is a Count-Min Sketch over IPv4 addresses.
+type Sketch struct {
+	counts []uint64 // sketchDepth rows of 2^sketchWidthBits counters.
+	total  uint64   // Number of items added.
+}
+
+// NewSketch returns an empty sketch with the default dimensions.
+func NewSketch() *Sketch {
+	return &Sketch{counts: make([]uint64, sketchDepth<<sketchWidthBits)}
+}
+
+// slot returns the index of ip's counter in the given row.
+func slot(row int, ip uint32) uint64 {
+	h := (uint64(ip)+1)*rowSeeds[row][0] + rowSeeds[row][1]
+	return uint64(row)<<sketchWidthBits | h>>(64-sketchWidthBits)
+}
+
+// Add records one hit for ip and returns its updated estimate.
+func (s *Sketch) Add(ip uint32) uint64 {
+	s.total++
+	est := uint64(math.MaxUint64)
+	for row := 0; row < sketchDepth; row++ {
+		i := slot(row, ip)
+		s.counts[i]++
+		if s.counts[i] < est {
+			est = s.counts[i]
+		}
+	}
+	return est
+}
+
+// Estimate returns the estimated number of hits for ip.
+func (s *Sketch) Estimate(ip uint32) uint64 {
+	est := uint64(math.MaxUint64)
+	for row := 0; row < sketchDepth; row++ {
+		if c := s.counts[slot(row, ip)]; c < est {
+			est = c
+		}
+	}
+	return est
+}
+
+// Merge adds the counters of other into s.
+func (s *Sketch) Merge(other *Sketch) {
+	for i, c := range other.counts {
+		s.counts[i] += c
+	}
+	s.total += other.total
+}
+
+// Total returns the number of items added to the sketch.
+func (s *Sketch) Total() uint64 {
+	return s.total
+}
+
+// Epsilon returns the relative error of the sketch: estimates exceed the true
+// count by at most Epsilon * Total with probability 1 - Delta.
+func Epsilon() float64 {
+	return math.E / float64(uint64(1)<<sketchWidthBits)
+}
+
+// Delta returns the probability that an estimate exceeds the Epsilon bound.
+func Delta() float64 {
+	return math.Exp(-sketchDepth)
+}
+
+// Entry is a heavy hitter with its estimated hit count.
+type Entry struct {
+	IP    uint32 // IPv4 address as a 32-bit integer.
+	Count uint64 // Estimated number of hits, never below the true count.
+	Error uint64 // Upper bound on the overcount, valid with probability 1 - Delta.
+}
+
+// Result holds the merged heavy hitters of a stream.
+type Result struct {
+	Entries []Entry // Heavy hitters sorted by descending estimate.
+	Total   uint64  // Number of IPs seen in the stream.
+	Epsilon float64 // Relative error of the estimates.
+	Delta   float64 // Probability that an estimate exceeds its error bound.
+}
+
+// candidates is a min-heap of entries ordered by Count, with an index for
+// updating entries in place.
+type candidates struct {
+	entries []Entry
+	index   map[uint32]int
+}
+
+func (c *candidates) Len() int           { return len(c.entries) }
+func (c *candidates) Less(i, j int) bool { return c.entries[i].Count < c.entries[j].Count }
+func (c *candidates) Swap(i, j int) {
+	c.entries[i], c.entries[j] = c.entries[j], c.entries[i]
+	c.index[c.entries[i].IP] = i
+	c.index[c.entries[j].IP] = j
+}
+func (c *candidates) Push(x interface{}) {
+	e := x.(Entry)
+	c.index[e.IP] = len(c.entries)
+	c.entries = append(c.entries, e)
+}
+func (c *candidates) Pop() interface{} {
+	e := c.entries[len(c.entries)-1]
+	c.entries = c.entries[:len(c.entries)-1]
+	delete(c.index, e.IP)
+	return e
+}
+
+// Tracker follows the k most frequent IPs of a stream. It is not safe for
+// concurrent use; give each worker its own Tracker and Merge them at the end.
+type Tracker struct {
+	k      int
+	sketch *Sketch
+	heap   candidates
+}
+
+// NewTracker returns a Tracker for the k most frequent IPs.
+func NewTracker(k int) *Tracker {
+	return &Tracker{
+		k:      k,
+		sketch: NewSketch(),
+		heap:   candidates{index: make(map[uint32]int, k)},
+	}
+}
+
+// Add records one hit for ip.
+func (t *Tracker) Add(ip uint32) {
+	est := t.sketch.Add(ip)
+	if len(t.heap.entries) == t.k && est <= t.heap.entries[0].Count {
+		return // Not a heavy hitter yet; skip the map lookup.
+	}
+	if i, ok := t.heap.index[ip]; ok {
+		t.heap.entries[i].Count = est
+		heap.Fix(&t.heap, i)
+		return
+	}
+	if len(t.heap.entries) < t.k {
+		heap.Push(&t.heap, Entry{IP: ip, Count: est})
+		return
+	}
+	// Replace the weakest candidate.
+	delete(t.heap.index, t.heap.entries[0].IP)
+	t.heap.entries[0] = Entry{IP: ip, Count: est}
+	t.heap.index[ip] = 0
+	heap.Fix(&t.heap, 0)
+}
+
+// Merge combines the given trackers into a single result with at most k
+// entries. Candidates from every tracker are re-estimated against the merged
+// sketch, so an IP spread evenly across workers is still ranked correctly.
+func Merge(k int, trackers ...*Tracker) *Result {
+	merged := NewSketch()
+	seen := make(map[uint32]struct{})
+	for _, t := range trackers {
+		if t == nil {
+			continue
+		}
+		merged.Merge(t.sketch)
+		for _, e := range t.heap.entries {
+			seen[e.IP] = struct{}{}
+		}
+	}
+
+	bound := uint64(math.Ceil(Epsilon() * float64(merged.total)))
+	entries := make([]Entry, 0, len(seen))
+	for ip := range seen {
+		entries = append(entries, Entry{IP: ip, Count: merged.Estimate(ip), Error: bound})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].IP < entries[j].IP
+	})
+	if len(entries) > k {
+		entries = entries[:k]
+	}
+
+	return &Result{
+		Entries: entries,
+		Total:   merged.total,
+		Epsilon: Epsilon(),
+		Delta:   Delta(),
+	}
+}
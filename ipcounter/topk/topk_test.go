@@ -0,0 +1,57 @@
+package topk
+
+import (
+	"testing"
+)
+
+func TestTrackerFindsHeavyHitters(t *testing.T) {
+	// IPs 1..5 get 1000, 900, ..., 600 hits; 100000 other IPs get one hit each.
+	tracker := NewTracker(5)
+	for ip := uint32(1); ip <= 5; ip++ {
+		for n := 0; n < int(1100-100*ip); n++ {
+			tracker.Add(ip)
+		}
+	}
+	for ip := uint32(1000); ip < 101000; ip++ {
+		tracker.Add(ip)
+	}
+
+	res := Merge(5, tracker)
+	if len(res.Entries) != 5 {
+		t.Fatalf("got %d entries, want 5", len(res.Entries))
+	}
+	for i, e := range res.Entries {
+		wantIP := uint32(i + 1)
+		wantCount := uint64(1100 - 100*wantIP)
+		if e.IP != wantIP {
+			t.Errorf("entry %d: IP = %d, want %d", i, e.IP, wantIP)
+		}
+		if e.Count < wantCount || e.Count > wantCount+e.Error {
+			t.Errorf("entry %d: Count = %d, want within [%d, %d]", i, e.Count, wantCount, wantCount+e.Error)
+		}
+	}
+	if res.Total != 104000 {
+		t.Errorf("Total = %d, want 104000", res.Total)
+	}
+}
+
+func TestMergeCombinesWorkers(t *testing.T) {
+	// The heavy hitter is spread evenly so that no single worker sees it as dominant.
+	workers := []*Tracker{NewTracker(2), NewTracker(2), NewTracker(2)}
+	for i, w := range workers {
+		for n := 0; n < 50; n++ {
+			w.Add(42)
+		}
+		for n := 0; n < 60; n++ {
+			w.Add(uint32(100 + i))
+		}
+	}
+
+	res := Merge(1, workers...)
+	if len(res.Entries) != 1 || res.Entries[0].IP != 42 {
+		t.Fatalf("Merge returned %+v, want IP 42 first", res.Entries)
+	}
+	if res.Entries[0].Count < 150 {
+		t.Errorf("Count = %d, want at least 150", res.Entries[0].Count)
+	}
+}
@@ -0,0 +1,172 @@
+/*
+Package tuning holds the settings of the sharded counters, concurrent and
+assembly: how many workers they run, how much they read per chunk, how many
+chunks may wait for a worker, how many shards the bitset is split into and
+in which layout, whether uniqueness is decided per IP or deferred to the end,
+whether the bitset is put on huge pages, and whether files are read through
+io_uring and around the page cache.
+
+The defaults suit most machines; the settings exist so they can be
+benchmarked per machine without recompiling. Both counters take them as
+functional options:
+
+	counter := concurrent.New(tuning.Workers(4), tuning.Shards(1024))
+*/
+package tuning
+
+import (
+	"IP-Addr-Counter/ipcounter/hugemem"
+	"IP-Addr-Counter/ipcounter/ipset"
+	"IP-Addr-Counter/ipcounter/sysinfo"
+	"fmt"
+	"math/bits"
+)
+
+// Defaults and limits of the settings.
+const (
+	DefaultChunkBytes = 16 << 20 // Bytes read per chunk.
+	DefaultQueueLen   = 128      // Chunks that may wait for a worker.
+	DefaultShards     = 16384    // Shards the bitset is split into.
+
+	MaxChunkBytes = 1 << 30 // Largest chunk, so a few in flight still fit in memory.
+	MaxShards     = 1 << 26 // Most shards, leaving each the 8 bytes the bitset is scanned by.
+
+	bitsetBytes = 1 << 32 / 8 // The bitset over the whole IPv4 space.
+)
+
+// Options are the settings of a sharded counter.
+type Options struct {
+	Workers    int // Worker goroutines, 0 for one per CPU the process may use.
+	ChunkBytes int // Bytes read per chunk, before extending it to the next newline.
+	QueueLen   int // Most chunks waiting for a worker; fewer if memory is short.
+	Shards     int // Shards the bitset is split into, a power of two.
+
+	// Layout is the order of the IPs in the bitset: interleaved across the
+	// shards, the default, or one range of IPs per shard; see ipset.Layout.
+	Layout ipset.Layout
+
+	// Deferred makes counts set bits with an atomic OR, without checking
+	// whether they were set, and find the number of new IPs with a popcount
+	// of the bitset before and after. It saves a compare per IP at the cost of
+	// two sweeps of 512MB, so it pays off on large inputs. Add, AddChunk and
+	// Dedup need first occurrences and keep using compare-and-swap.
+	Deferred bool
+
+	// HugePages puts the bitset on huge pages, spread over the NUMA nodes and
+	// faulted in by the workers when the counter is made, as hugemem.Alloc
+	// does. It cuts TLB misses on the random writes, but the whole 512MB is
+	// resident from the start.
+	HugePages bool
+
+	// IOUring reads regular files through io_uring on Linux, with the chunk
+	// buffers registered with the kernel and many reads in flight at once,
+	// which a fast NVMe array needs to reach its bandwidth. Where io_uring is
+	// not available, files are read the usual way.
+	IOUring bool
+
+	// Direct reads regular files with O_DIRECT on Linux, into aligned chunk
+	// buffers, so counting a file larger than memory does not push other
+	// programs' pages out of the page cache. On file systems without direct
+	// I/O, the pages read are dropped from the cache instead.
+	Direct bool
+}
+
+// Option changes one setting.
+type Option func(*Options)
+
+// Workers sets the number of worker goroutines, 0 for one per CPU.
+func Workers(n int) Option { return func(o *Options) { o.Workers = n } }
+
+// ChunkBytes sets the number of bytes read per chunk.
+func ChunkBytes(n int) Option { return func(o *Options) { o.ChunkBytes = n } }
+
+// QueueLen sets the most chunks that may wait for a worker.
+func QueueLen(n int) Option { return func(o *Options) { o.QueueLen = n } }
+
+// Shards sets the number of shards the bitset is split into.
+func Shards(n int) Option { return func(o *Options) { o.Shards = n } }
+
+// Deferred sets whether counts decide uniqueness with a popcount at the end.
+func Deferred(on bool) Option { return func(o *Options) { o.Deferred = on } }
+
+// Layout sets the order of the IPs in the bitset.
+func Layout(l ipset.Layout) Option { return func(o *Options) { o.Layout = l } }
+
+// HugePages sets whether the bitset is allocated on huge pages.
+func HugePages(on bool) Option { return func(o *Options) { o.HugePages = on } }
+
+// IOUring sets whether files are read through io_uring.
+func IOUring(on bool) Option { return func(o *Options) { o.IOUring = on } }
+
+// Direct sets whether files are read around the page cache.
+func Direct(on bool) Option { return func(o *Options) { o.Direct = on } }
+
+// Default returns the default Options.
+func Default() Options {
+	return Options{ChunkBytes: DefaultChunkBytes, QueueLen: DefaultQueueLen, Shards: DefaultShards}
+}
+
+// New returns the default Options with opts applied, or an error if they are
+// invalid.
+func New(opts ...Option) (Options, error) {
+	o := Default()
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o, o.Validate()
+}
+
+// Validate reports an error if a setting is out of range.
+func (o Options) Validate() error {
+	switch {
+	case o.Workers < 0:
+		return fmt.Errorf("invalid worker count %d: must be 0 (one per CPU) or more", o.Workers)
+	case o.ChunkBytes < 1 || o.ChunkBytes > MaxChunkBytes:
+		return fmt.Errorf("invalid chunk size %d: must be between 1 and %d bytes", o.ChunkBytes, MaxChunkBytes)
+	case o.QueueLen < 1:
+		return fmt.Errorf("invalid queue length %d: must be at least 1", o.QueueLen)
+	case o.Shards < 1 || o.Shards > MaxShards || o.Shards&(o.Shards-1) != 0:
+		// A power of two divides 2^32, so every shard covers as many IPs.
+		return fmt.Errorf("invalid shard count %d: must be a power of two between 1 and %d", o.Shards, MaxShards)
+	case o.Layout != ipset.Interleaved && o.Layout != ipset.Ranged:
+		return fmt.Errorf("invalid layout %v", o.Layout)
+	}
+	return nil
+}
+
+// NumWorkers returns the number of workers to start.
+func (o Options) NumWorkers() int {
+	if o.Workers > 0 {
+		return o.Workers
+	}
+	return sysinfo.CPUs()
+}
+
+// Alloc returns size zeroed bytes for a bitset: on huge pages if HugePages
+// is set, faulted in by the workers, and on the Go heap otherwise.
+func (o Options) Alloc(size int) *hugemem.Region {
+	if o.HugePages {
+		return hugemem.Alloc(size, o.NumWorkers())
+	}
+	return hugemem.Heap(size)
+}
+
+// ShardBits returns log2 of the shard count: in the Interleaved layout, an IP
+// goes to shard ip&(Shards-1), at offset ip>>ShardBits within it.
+func (o Options) ShardBits() uint {
+	return uint(bits.TrailingZeros(uint(o.Shards)))
+}
+
+// Footprint returns the least memory a count needs: the bitset plus a chunk
+// buffer per worker, one being read and one queued. The queue only grows past
+// one chunk when there is memory to spare; see Queue.
+func (o Options) Footprint() int64 {
+	return bitsetBytes + int64(o.NumWorkers()+2)*int64(o.ChunkBytes)
+}
+
+// Queue returns how many chunks may wait for a worker: up to QueueLen, as many
+// as fit in the available memory next to the bitset and the buffers of the
+// reader and the workers.
+func (o Options) Queue() int {
+	return sysinfo.Buffers(o.Footprint()-int64(o.ChunkBytes), int64(o.ChunkBytes), o.QueueLen)
+}
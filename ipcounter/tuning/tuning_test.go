@@ -0,0 +1,59 @@
+package tuning
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	o, err := New()
+	if err != nil || o != Default() {
+		t.Fatalf("New() = %+v, %v, want the defaults", o, err)
+	}
+	o, err = New(Workers(3), ChunkBytes(1<<20), QueueLen(4), Shards(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (Options{Workers: 3, ChunkBytes: 1 << 20, QueueLen: 4, Shards: 1024}); o != want {
+		t.Errorf("New() = %+v, want %+v", o, want)
+	}
+	if o.NumWorkers() != 3 || o.ShardBits() != 10 {
+		t.Errorf("NumWorkers() = %d, ShardBits() = %d, want 3 and 10", o.NumWorkers(), o.ShardBits())
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		opt  Option
+		want string
+	}{
+		{Workers(-1), "invalid worker count"},
+		{ChunkBytes(0), "invalid chunk size"},
+		{ChunkBytes(MaxChunkBytes + 1), "invalid chunk size"},
+		{QueueLen(0), "invalid queue length"},
+		{Shards(0), "invalid shard count"},
+		{Shards(1000), "invalid shard count"},
+		{Shards(MaxShards * 2), "invalid shard count"},
+	}
+	for _, tt := range tests {
+		_, err := New(tt.opt)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("New() = %v, want %q", err, tt.want)
+		}
+	}
+	for _, n := range []int{1, 2, 16384, MaxShards} {
+		if _, err := New(Shards(n)); err != nil {
+			t.Errorf("New(Shards(%d)) = %v, want nil", n, err)
+		}
+	}
+}
+
+func TestFootprint(t *testing.T) {
+	o := Options{Workers: 2, ChunkBytes: 1 << 20, QueueLen: 8, Shards: 1}
+	if got, want := o.Footprint(), int64(512<<20+4<<20); got != want {
+		t.Errorf("Footprint() = %d, want %d", got, want)
+	}
+	if q := o.Queue(); q < 1 || q > 8 {
+		t.Errorf("Queue() = %d, want between 1 and 8", q)
+	}
+}
@@ -0,0 +1,25 @@
+/*
+Package uring reads files through io_uring, the Linux interface that takes
+batches of I/O requests through rings shared with the kernel.
+
+A blocking read keeps one request in flight per reading goroutine, too few for
+an NVMe array to reach its bandwidth. A Ring takes any number of reads at
+once, each into part of a set of buffers, and hands back their completions in
+whatever order the device finishes them. The buffers are registered with the
+kernel when the ring is made, so they are mapped once instead of on every
+read. If registering them fails, typically because they exceed
+RLIMIT_MEMLOCK, the ring still works with plain reads, on kernels that have
+them (Linux 5.6 and later).
+
+The package uses raw system calls, without cgo. On other systems, and on
+kernels without io_uring or where it is disabled, New returns an error
+matching errors.ErrUnsupported or the reason, and callers read the usual way.
+*/
+package uring
+
+// Completion is the outcome of a read.
+type Completion struct {
+	Tag uint64 // The tag the read was queued with.
+	N   int    // Bytes read, 0 at the end of the file.
+	Err error  // Why the read failed, nil if it did not.
+}
@@ -0,0 +1,307 @@
+//go:build linux
+// +build linux
+
+package uring
+
+import (
+	"fmt"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// System calls, opcodes and mmap offsets of io_uring, the same on every
+// architecture.
+const (
+	sysSetup    = 425 // io_uring_setup(2)
+	sysEnter    = 426 // io_uring_enter(2)
+	sysRegister = 427 // io_uring_register(2)
+
+	opReadFixed = 4  // IORING_OP_READ_FIXED: read into a registered buffer.
+	opRead      = 22 // IORING_OP_READ: read into any memory, since Linux 5.6.
+
+	enterGetEvents  = 1 // IORING_ENTER_GETEVENTS: wait for completions.
+	registerBuffers = 0 // IORING_REGISTER_BUFFERS
+	registerProbe   = 8 // IORING_REGISTER_PROBE: which opcodes the kernel has, since Linux 5.6.
+	opSupported     = 1 // IO_URING_OP_SUPPORTED, in the flags of a probed opcode.
+
+	offSQRing = 0          // IORING_OFF_SQ_RING
+	offCQRing = 0x8000000  // IORING_OFF_CQ_RING
+	offSQEs   = 0x10000000 // IORING_OFF_SQES
+)
+
+// params is struct io_uring_params, filled in by io_uring_setup.
+type params struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFD         uint32
+	resv         [3]uint32
+	sqOff        sqOffsets
+	cqOff        cqOffsets
+}
+
+// sqOffsets is struct io_sqring_offsets: where the fields of the submission
+// ring are in its mapping.
+type sqOffsets struct {
+	head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// cqOffsets is struct io_cqring_offsets: where the fields of the completion
+// ring are in its mapping.
+type cqOffsets struct {
+	head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+	userAddr                                                        uint64
+}
+
+// sqe is struct io_uring_sqe, a request.
+type sqe struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	rwFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFDIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+// probe is struct io_uring_probe, with room for every opcode.
+type probe struct {
+	lastOp uint8
+	opsLen uint8
+	resv   uint16
+	resv2  [3]uint32
+	ops    [256]probeOp
+}
+
+// probeOp is struct io_uring_probe_op.
+type probeOp struct {
+	op    uint8
+	resv  uint8
+	flags uint16
+	resv2 uint32
+}
+
+// cqe is struct io_uring_cqe, a completion.
+type cqe struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+// Ring is an io_uring instance with a set of buffers to read into. It is not
+// safe for concurrent use.
+type Ring struct {
+	fd         int
+	bufs       [][]byte // Kept alive while the kernel may write to them.
+	registered bool
+
+	sqRing, cqRing, sqeMem []byte // The mappings shared with the kernel.
+
+	sqHead, sqTail *uint32
+	sqMask         uint32
+	sqArray        []uint32
+	sqes           []sqe
+	queued         uint32 // Requests written to the ring but not yet submitted.
+	inflight       int    // Reads queued whose completion Next has not returned.
+
+	cqHead, cqTail *uint32
+	cqMask         uint32
+	cqes           []cqe
+}
+
+// New sets up a ring of entries requests, a power of two, and registers bufs
+// as its buffers. If they cannot be registered, the ring reads into them with
+// plain reads, which need Linux 5.6; on older kernels New fails instead.
+// Whoever queues more reads than entries at once may have completions dropped
+// by older kernels.
+func New(entries int, bufs [][]byte) (*Ring, error) {
+	var p params
+	fd, _, errno := syscall.Syscall(sysSetup, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, fmt.Errorf("io_uring_setup: %w", errno)
+	}
+	r := &Ring{fd: int(fd), bufs: bufs}
+	if err := r.mmap(&p); err != nil {
+		r.Close()
+		return nil, err
+	}
+	if err := r.register(); err != nil {
+		if !r.supports(opRead) {
+			r.Close()
+			return nil, fmt.Errorf("failed to register buffers, and the kernel has no plain reads: %w", err)
+		}
+		return r, nil
+	}
+	r.registered = true
+	return r, nil
+}
+
+// Probe reports whether io_uring can be used, by setting up a small ring.
+func Probe() error {
+	r, err := New(1, nil)
+	if err != nil {
+		return err
+	}
+	return r.Close()
+}
+
+// mmap maps the rings described by p.
+func (r *Ring) mmap(p *params) error {
+	var err error
+	prot, flags := syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE
+	sqLen := int(p.sqOff.array + p.sqEntries*4)
+	if r.sqRing, err = syscall.Mmap(r.fd, offSQRing, sqLen, prot, flags); err != nil {
+		return fmt.Errorf("mmap submission ring: %w", err)
+	}
+	cqLen := int(p.cqOff.cqes) + int(p.cqEntries)*int(unsafe.Sizeof(cqe{}))
+	if r.cqRing, err = syscall.Mmap(r.fd, offCQRing, cqLen, prot, flags); err != nil {
+		return fmt.Errorf("mmap completion ring: %w", err)
+	}
+	sqesLen := int(p.sqEntries) * int(unsafe.Sizeof(sqe{}))
+	if r.sqeMem, err = syscall.Mmap(r.fd, offSQEs, sqesLen, prot, flags); err != nil {
+		return fmt.Errorf("mmap requests: %w", err)
+	}
+
+	r.sqHead = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.head]))
+	r.sqTail = (*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.tail]))
+	r.sqMask = *(*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.ringMask]))
+	r.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&r.sqRing[p.sqOff.array])), p.sqEntries)
+	r.sqes = unsafe.Slice((*sqe)(unsafe.Pointer(&r.sqeMem[0])), p.sqEntries)
+	r.cqHead = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.head]))
+	r.cqTail = (*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.tail]))
+	r.cqMask = *(*uint32)(unsafe.Pointer(&r.cqRing[p.cqOff.ringMask]))
+	r.cqes = unsafe.Slice((*cqe)(unsafe.Pointer(&r.cqRing[p.cqOff.cqes])), p.cqEntries)
+	return nil
+}
+
+// register registers the buffers of r with the kernel.
+func (r *Ring) register() error {
+	if len(r.bufs) == 0 {
+		return nil
+	}
+	iovecs := make([]syscall.Iovec, len(r.bufs))
+	for i, b := range r.bufs {
+		iovecs[i].Base = &b[0]
+		iovecs[i].SetLen(len(b))
+	}
+	_, _, errno := syscall.Syscall6(sysRegister, uintptr(r.fd), registerBuffers,
+		uintptr(unsafe.Pointer(&iovecs[0])), uintptr(len(iovecs)), 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// supports reports whether the kernel has opcode op. Kernels without
+// IORING_REGISTER_PROBE predate every opcode it would be asked about.
+func (r *Ring) supports(op uint8) bool {
+	var p probe
+	_, _, errno := syscall.Syscall6(sysRegister, uintptr(r.fd), registerProbe,
+		uintptr(unsafe.Pointer(&p)), uintptr(len(p.ops)), 0, 0)
+	if errno != 0 {
+		return false
+	}
+	return op <= p.lastOp && p.ops[op].flags&opSupported != 0
+}
+
+// Registered reports whether the buffers are registered with the kernel, or
+// are read into with plain reads.
+func (r *Ring) Registered() bool {
+	return r.registered
+}
+
+// Read queues a read of len(b) bytes at offset off of the file fd into b,
+// which must lie within buffer buf. The read starts at the next Submit, and its
+// completion carries tag. Read reports false if the ring is full.
+func (r *Ring) Read(fd, buf int, b []byte, off int64, tag uint64) bool {
+	tail := *r.sqTail // Only written by us.
+	if tail-atomic.LoadUint32(r.sqHead) > r.sqMask {
+		return false
+	}
+	i := tail & r.sqMask
+	r.sqes[i] = sqe{
+		opcode:   opRead,
+		fd:       int32(fd),
+		off:      uint64(off),
+		addr:     uint64(uintptr(unsafe.Pointer(unsafe.SliceData(b)))),
+		len:      uint32(len(b)),
+		userData: tag,
+	}
+	if r.registered {
+		r.sqes[i].opcode = opReadFixed
+		r.sqes[i].bufIndex = uint16(buf)
+	}
+	r.sqArray[i] = i
+	atomic.StoreUint32(r.sqTail, tail+1) // Publishes the request to the kernel.
+	r.queued++
+	r.inflight++
+	return true
+}
+
+// Submit starts the queued reads and waits until at least wait reads have
+// completed, counting those not yet taken with Next.
+func (r *Ring) Submit(wait int) error {
+	flags := uintptr(0)
+	if wait > 0 {
+		flags = enterGetEvents
+	}
+	for {
+		n, _, errno := syscall.Syscall6(sysEnter, uintptr(r.fd), uintptr(r.queued), uintptr(wait), flags, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			return fmt.Errorf("io_uring_enter: %w", errno)
+		}
+		r.queued -= uint32(n)
+		return nil
+	}
+}
+
+// Next returns the next completed read, if there is one.
+func (r *Ring) Next() (Completion, bool) {
+	head := *r.cqHead // Only written by us.
+	if head == atomic.LoadUint32(r.cqTail) {
+		return Completion{}, false
+	}
+	e := r.cqes[head&r.cqMask]
+	atomic.StoreUint32(r.cqHead, head+1) // Frees the slot for the kernel.
+	r.inflight--
+	c := Completion{Tag: e.userData, N: int(e.res)}
+	if e.res < 0 {
+		c.N, c.Err = 0, syscall.Errno(-e.res)
+	}
+	return c, true
+}
+
+// Close waits for the reads still in flight, discarding their completions,
+// and tears the ring down. The kernel writes into the buffers until a read
+// completes, so they must not be reused before.
+func (r *Ring) Close() error {
+	for r.inflight > 0 && r.sqRing != nil {
+		if r.Submit(1) != nil {
+			break
+		}
+		for _, ok := r.Next(); ok; _, ok = r.Next() {
+		}
+	}
+	for _, m := range [][]byte{r.sqeMem, r.cqRing, r.sqRing} {
+		if m != nil {
+			syscall.Munmap(m)
+		}
+	}
+	r.sqeMem, r.cqRing, r.sqRing = nil, nil, nil
+	return syscall.Close(r.fd)
+}
@@ -0,0 +1,124 @@
+//go:build linux
+// +build linux
+
+package uring
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// newTestRing returns a ring of 8 entries over bufs, skipping the test where
+// io_uring is unavailable.
+func newTestRing(t *testing.T, bufs [][]byte) *Ring {
+	t.Helper()
+	r, err := New(8, bufs)
+	if err != nil {
+		t.Skipf("io_uring unavailable: %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestRead(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1024) // 16KB
+	path := filepath.Join(t.TempDir(), "data")
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	for _, registered := range []bool{true, false} {
+		bufs := [][]byte{make([]byte, 8192), make([]byte, 8192)}
+		r := newTestRing(t, bufs)
+		if !registered {
+			if !r.supports(opRead) {
+				continue // New would have failed rather than read this way.
+			}
+			r.registered = false // Plain reads, as when registering fails.
+		}
+		// Both halves of the file, read into the second half of each buffer,
+		// and a read past the end.
+		reads := []struct {
+			buf  int
+			b    []byte
+			off  int64
+			want []byte
+		}{
+			{0, bufs[0][4096:], 0, data[:4096]},
+			{1, bufs[1][4096:], 12288, data[12288:]},
+			{0, bufs[0][:100], int64(len(data)), nil},
+		}
+		for i, rd := range reads {
+			if !r.Read(int(f.Fd()), rd.buf, rd.b, rd.off, uint64(i)) {
+				t.Fatalf("Read %d: ring full", i)
+			}
+		}
+		if err := r.Submit(len(reads)); err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+		seen := 0
+		for c, ok := r.Next(); ok; c, ok = r.Next() {
+			rd := reads[c.Tag]
+			if c.Err != nil || c.N != len(rd.want) || !bytes.Equal(rd.b[:c.N], rd.want) {
+				t.Errorf("registered=%v: read %d = %d bytes, %v; want %d bytes of the file",
+					registered, c.Tag, c.N, c.Err, len(rd.want))
+			}
+			seen++
+		}
+		if seen != len(reads) {
+			t.Errorf("registered=%v: %d completions, want %d", registered, seen, len(reads))
+		}
+	}
+}
+
+func TestSupports(t *testing.T) {
+	r := newTestRing(t, nil)
+	if r.supports(255) {
+		t.Errorf("supports(255) = true for an opcode that does not exist")
+	}
+	// The probe and IORING_OP_READ both came with Linux 5.6.
+	if r.supports(opRead) != r.supports(opReadFixed) {
+		t.Errorf("supports(IORING_OP_READ) = %v, supports(IORING_OP_READ_FIXED) = %v; want both from the probe",
+			r.supports(opRead), r.supports(opReadFixed))
+	}
+}
+
+func TestReadError(t *testing.T) {
+	buf := make([]byte, 4096)
+	r := newTestRing(t, [][]byte{buf})
+	r.Read(-1, 0, buf, 0, 7)
+	if err := r.Submit(1); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if c, ok := r.Next(); !ok || c.Tag != 7 || c.Err != syscall.EBADF {
+		t.Errorf("Next() = %+v, %v; want a completion of tag 7 with EBADF", c, ok)
+	}
+}
+
+func TestCloseWaits(t *testing.T) {
+	buf := make([]byte, 4096)
+	r, err := New(8, [][]byte{buf})
+	if err != nil {
+		t.Skipf("io_uring unavailable: %v", err)
+	}
+	f, err := os.Open("/dev/zero")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r.Read(int(f.Fd()), 0, buf, 0, 0) // Queued, never submitted or taken.
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if r.inflight != 0 {
+		t.Errorf("Close returned with %d reads in flight", r.inflight)
+	}
+}
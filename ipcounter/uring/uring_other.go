@@ -0,0 +1,34 @@
+//go:build !linux
+// +build !linux
+
+package uring
+
+import "errors"
+
+// Ring is an io_uring instance; io_uring only exists on Linux.
+type Ring struct{}
+
+// New reports that io_uring is not supported.
+func New(entries int, bufs [][]byte) (*Ring, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// Probe reports that io_uring is not supported.
+func Probe() error {
+	return errors.ErrUnsupported
+}
+
+// Registered reports whether the buffers are registered.
+func (r *Ring) Registered() bool { return false }
+
+// Read queues nothing.
+func (r *Ring) Read(fd, buf int, b []byte, off int64, tag uint64) bool { return false }
+
+// Submit does nothing.
+func (r *Ring) Submit(wait int) error { return errors.ErrUnsupported }
+
+// Next returns no completion.
+func (r *Ring) Next() (Completion, bool) { return Completion{}, false }
+
+// Close does nothing.
+func (r *Ring) Close() error { return nil }
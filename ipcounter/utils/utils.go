@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"strings"
 )
 
@@ -17,6 +18,46 @@ func IPToUint32(ipStr string) (uint32, error) {
 	return uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3]), nil
 }
 
+// Uint32ToIP converts a 32-bit integer back into its dotted IPv4 form.
+func Uint32ToIP(ip uint32) string {
+	return fmt.Sprintf("%d.%d.%d.%d", byte(ip>>24), byte(ip>>16), byte(ip>>8), byte(ip))
+}
+
+// ParseRange parses an inclusive range of IPv4 addresses written as a CIDR
+// prefix ("10.0.0.0/8"), two addresses ("10.0.0.1-10.0.0.9"), or a single address.
+func ParseRange(s string) (lo, hi uint32, err error) {
+	s = strings.TrimSpace(s)
+	if addr, bits, ok := strings.Cut(s, "/"); ok {
+		ip, err := IPToUint32(addr)
+		if err != nil {
+			return 0, 0, err
+		}
+		n, err := strconv.Atoi(bits)
+		if err != nil || n < 0 || n > 32 {
+			return 0, 0, fmt.Errorf("invalid prefix length: %s", s)
+		}
+		mask := uint32(0)
+		if n > 0 {
+			mask = ^uint32(0) << (32 - n)
+		}
+		return ip & mask, ip | ^mask, nil
+	}
+	if first, last, ok := strings.Cut(s, "-"); ok {
+		if lo, err = IPToUint32(first); err != nil {
+			return 0, 0, err
+		}
+		if hi, err = IPToUint32(last); err != nil {
+			return 0, 0, err
+		}
+		if lo > hi {
+			return 0, 0, fmt.Errorf("invalid range: %s starts after it ends", s)
+		}
+		return lo, hi, nil
+	}
+	ip, err := IPToUint32(s)
+	return ip, ip, err
+}
+
 func ParseIPv4(b []byte) (uint32, error) {
 	var ip, part uint32
 	pos := 0
@@ -31,3 +31,31 @@ func TestParseIPv4(t *testing.T) {
 		})
 	}
 }
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		input   string
+		lo, hi  uint32
+		wantErr bool
+	}{
+		{"10.0.0.0/8", 0x0A000000, 0x0AFFFFFF, false},
+		{"10.1.2.3/8", 0x0A000000, 0x0AFFFFFF, false},
+		{"0.0.0.0/0", 0, 0xFFFFFFFF, false},
+		{"1.2.3.4/32", 0x01020304, 0x01020304, false},
+		{"1.2.3.4-1.2.3.9", 0x01020304, 0x01020309, false},
+		{"1.2.3.4", 0x01020304, 0x01020304, false},
+		{"1.2.3.9-1.2.3.4", 0, 0, true},
+		{"1.2.3.4/33", 0, 0, true},
+		{"bogus/8", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		lo, hi, err := ParseRange(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRange(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+		}
+		if lo != tt.lo || hi != tt.hi {
+			t.Errorf("ParseRange(%q) = %08X-%08X, want %08X-%08X", tt.input, lo, hi, tt.lo, tt.hi)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package window
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// CLF extracts the bracketed timestamp of Apache/Nginx common and combined
+// logs, e.g. 10/Oct/2000:13:55:36 -0700.
+type CLF struct{}
+
+// Extract returns the text between the first '[' of line and the next ']'.
+func (CLF) Extract(line []byte) []byte {
+	open := bytes.IndexByte(line, '[')
+	if open < 0 {
+		return nil
+	}
+	end := bytes.IndexByte(line[open+1:], ']')
+	if end < 0 {
+		return nil
+	}
+	return line[open+1 : open+1+end]
+}
+
+// Constants defining the accepted timestamps.
+const (
+	clfLayout   = "02/Jan/2006:15:04:05 -0700" // Timestamp layout of common log format.
+	epochMillis = 100_000_000_000              // Smallest epoch read as milliseconds (year 5138 in seconds).
+)
+
+// months maps the CLF month abbreviations to month numbers.
+var months = map[string]int{
+	"Jan": 1, "Feb": 2, "Mar": 3, "Apr": 4, "May": 5, "Jun": 6,
+	"Jul": 7, "Aug": 8, "Sep": 9, "Oct": 10, "Nov": 11, "Dec": 12,
+}
+
+// ParseTime parses a timestamp field into Unix seconds. It accepts the CLF
+// layout, RFC3339, and Unix epoch seconds with an optional fraction; epochs
+// too large to be seconds are taken as milliseconds.
+func ParseTime(b []byte) (int64, error) {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 {
+		return 0, fmt.Errorf("empty timestamp")
+	}
+	if len(b) == len(clfLayout) && b[2] == '/' && b[6] == '/' {
+		if sec, ok := parseCLF(b); ok {
+			return sec, nil
+		}
+		return 0, fmt.Errorf("invalid CLF timestamp: %q", b)
+	}
+	if isEpoch(b) {
+		return parseEpoch(b)
+	}
+	t, err := time.Parse(time.RFC3339Nano, string(b))
+	if err != nil {
+		return 0, fmt.Errorf("unrecognized timestamp: %q", b)
+	}
+	return t.Unix(), nil
+}
+
+// parseCLF parses a CLF timestamp without allocating.
+func parseCLF(b []byte) (int64, bool) {
+	day, ok1 := digits(b[0:2])
+	month, ok2 := months[string(b[3:6])]
+	year, ok3 := digits(b[7:11])
+	hour, ok4 := digits(b[12:14])
+	min, ok5 := digits(b[15:17])
+	sec, ok6 := digits(b[18:20])
+	zh, ok7 := digits(b[22:24])
+	zm, ok8 := digits(b[24:26])
+	if !(ok1 && ok2 && ok3 && ok4 && ok5 && ok6 && ok7 && ok8) ||
+		b[11] != ':' || b[14] != ':' || b[17] != ':' || b[20] != ' ' ||
+		(b[21] != '+' && b[21] != '-') {
+		return 0, false
+	}
+	offset := int64(zh*3600 + zm*60)
+	if b[21] == '-' {
+		offset = -offset
+	}
+	unix := daysFromCivil(int64(year), int64(month), int64(day))*86400 +
+		int64(hour*3600+min*60+sec)
+	return unix - offset, true
+}
+
+// digits parses a fixed-width run of ASCII digits.
+func digits(b []byte) (int, bool) {
+	n := 0
+	for _, c := range b {
+		if c < '0' || c > '9' {
+			return 0, false
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n, true
+}
+
+// daysFromCivil returns the number of days from 1970-01-01 to the given
+// proleptic Gregorian date.
+func daysFromCivil(y, m, d int64) int64 {
+	if m <= 2 {
+		y--
+	}
+	era := y / 400
+	if y < 0 && y%400 != 0 {
+		era--
+	}
+	yoe := y - era*400
+	mp := (m + 9) % 12
+	doy := (153*mp+2)/5 + d - 1
+	doe := yoe*365 + yoe/4 - yoe/100 + doy
+	return era*146097 + doe - 719468
+}
+
+// isEpoch reports whether b looks like a number of seconds or milliseconds.
+func isEpoch(b []byte) bool {
+	dot := false
+	for i, c := range b {
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '.' && !dot && i > 0:
+			dot = true
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// parseEpoch parses a Unix epoch, dropping any fraction of a second.
+func parseEpoch(b []byte) (int64, error) {
+	whole := b
+	if i := bytes.IndexByte(b, '.'); i >= 0 {
+		whole = b[:i]
+	}
+	n, err := strconv.ParseInt(string(whole), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid epoch timestamp: %q", b)
+	}
+	if n >= epochMillis {
+		n /= 1000
+	}
+	return n, nil
+}
@@ -0,0 +1,365 @@
+/*
+Package window counts distinct IPv4 addresses per time window in timestamped
+logs, such as access logs or `ip,timestamp` CSV exports.
+
+Records are assigned to fixed-width time buckets and every bucket keeps its own
+set of IPs. Tumbling windows are the buckets themselves; sliding windows are the
+union of the consecutive buckets they cover, so each record is only inserted
+once whatever the overlap. Fine buckets use a 16KB HyperLogLog sketch each,
+coarse ones an exact adaptive set that grows into a bitmap.
+
+The input is read and parsed with the concurrent package's worker pool. Workers
+batch their IPs per bucket and only lock a bucket to flush a batch.
+
+Pros:
+- One pass over the input for any number of overlapping windows.
+- Memory follows the number of buckets, not the number of windows.
+
+Cons:
+- Sliding windows need Size to be a multiple of Slide.
+- Estimated counts are off by about 0.81%; exact ones cost up to 512MB per bucket.
+*/
+package window
+
+import (
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/format"
+	"IP-Addr-Counter/ipcounter/hll"
+	"IP-Addr-Counter/ipcounter/ipset"
+	"IP-Addr-Counter/ipcounter/sysinfo"
+	"IP-Addr-Counter/ipcounter/utils"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Constants defining the bucketing behaviour.
+const (
+	batchSize    = 4096      // IPs a worker buffers per bucket before locking it.
+	maxGap       = 1024      // Longest run of empty windows filled with zero rows.
+	CoarseBucket = time.Hour // Buckets at least this wide are counted exactly by default.
+)
+
+// Config describes the windows to compute.
+type Config struct {
+	Size  time.Duration // Length of each window.
+	Slide time.Duration // Step between window starts; 0 means tumbling windows of Size.
+	Exact bool          // Count with exact sets instead of HyperLogLog sketches.
+}
+
+// Validate checks that cfg describes a usable set of windows.
+func (cfg Config) Validate() error {
+	if cfg.Size < time.Second || cfg.Size%time.Second != 0 {
+		return fmt.Errorf("window size must be a whole number of seconds: %v", cfg.Size)
+	}
+	if cfg.Slide == 0 {
+		return nil
+	}
+	if cfg.Slide < time.Second || cfg.Slide%time.Second != 0 {
+		return fmt.Errorf("window slide must be a whole number of seconds: %v", cfg.Slide)
+	}
+	if cfg.Slide > cfg.Size || cfg.Size%cfg.Slide != 0 {
+		return fmt.Errorf("window size %v must be a multiple of the slide %v", cfg.Size, cfg.Slide)
+	}
+	return nil
+}
+
+// Bucket returns the width of the buckets the input is split into.
+func (cfg Config) Bucket() time.Duration {
+	if cfg.Slide == 0 {
+		return cfg.Size
+	}
+	return cfg.Slide
+}
+
+// Row is the distinct count of one window.
+type Row struct {
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Distinct int64     `json:"distinct"`
+}
+
+// bucket holds the IPs seen in one time bucket. Exactly one of exact and
+// estimate is set.
+type bucket struct {
+	mu       sync.Mutex
+	exact    *ipset.Adaptive
+	estimate *hll.Sketch
+}
+
+// add inserts ips into the bucket.
+func (b *bucket) add(ips []uint32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, ip := range ips {
+		if b.exact != nil {
+			b.exact.Add(ip)
+		} else {
+			b.estimate.Add(ip)
+		}
+	}
+}
+
+// Counter computes windowed distinct counts.
+type Counter struct {
+	cfg    Config
+	width  int64 // Bucket width in seconds.
+	ip     format.Extractor
+	stamp  format.Extractor
+	mu     sync.Mutex
+	bucket map[int64]*bucket // Keyed by bucket start in seconds divided by width.
+
+	invalid atomic.Int64
+}
+
+// New returns a Counter for cfg that reads IPs with ip and timestamps with
+// stamp. A nil ip extractor uses the whole line, trimmed.
+func New(cfg Config, ip, stamp format.Extractor) (*Counter, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if stamp == nil {
+		return nil, fmt.Errorf("a timestamp field is required")
+	}
+	return &Counter{
+		cfg:    cfg,
+		width:  int64(cfg.Bucket() / time.Second),
+		ip:     ip,
+		stamp:  stamp,
+		bucket: make(map[int64]*bucket),
+	}, nil
+}
+
+// Invalid returns the number of non-empty records skipped so far because their
+// IP or timestamp could not be parsed.
+func (c *Counter) Invalid() int64 {
+	return c.invalid.Load()
+}
+
+// worker is the per-goroutine state of Add: pending batches and the last
+// timestamp parsed, since consecutive log lines usually share it.
+type worker struct {
+	batches   map[int64][]uint32
+	lastStamp []byte
+	lastKey   int64
+}
+
+// Add reads r and counts its records into their buckets. It may be called
+// several times to combine inputs.
+func (c *Counter) Add(r io.Reader) error {
+	numWorkers := sysinfo.CPUs()
+	workers := make([]worker, numWorkers)
+	for i := range workers {
+		workers[i].batches = make(map[int64][]uint32)
+	}
+	err := concurrent.ProcessChunks(r, numWorkers, func(i int, chunk []byte) {
+		c.processChunk(&workers[i], chunk)
+	})
+	for i := range workers {
+		for key, ips := range workers[i].batches {
+			if len(ips) > 0 {
+				c.get(key).add(ips)
+			}
+		}
+	}
+	return err
+}
+
+// processChunk buckets every valid line of chunk.
+func (c *Counter) processChunk(w *worker, chunk []byte) {
+	start := 0
+	for i, ch := range chunk {
+		if ch != '\n' {
+			continue
+		}
+		line := bytes.TrimSpace(chunk[start:i])
+		start = i + 1
+		if len(line) == 0 {
+			continue // Skip empty lines.
+		}
+
+		field := line
+		if c.ip != nil {
+			field = c.ip.Extract(line)
+		}
+		ip, err := utils.ParseIPv4(field)
+		if err != nil {
+			c.invalid.Add(1)
+			continue
+		}
+
+		stamp := c.stamp.Extract(line)
+		var key int64
+		if w.lastStamp != nil && bytes.Equal(stamp, w.lastStamp) {
+			key = w.lastKey
+		} else {
+			sec, err := ParseTime(stamp)
+			if err != nil {
+				c.invalid.Add(1)
+				continue
+			}
+			key = floorDiv(sec, c.width)
+			w.lastStamp = append(w.lastStamp[:0], stamp...)
+			w.lastKey = key
+		}
+
+		batch := append(w.batches[key], ip)
+		if len(batch) >= batchSize {
+			c.get(key).add(batch)
+			batch = batch[:0]
+		}
+		w.batches[key] = batch
+	}
+}
+
+// get returns the bucket for key, creating it if needed.
+func (c *Counter) get(key int64) *bucket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.bucket[key]
+	if !ok {
+		b = &bucket{}
+		if c.cfg.Exact {
+			b.exact = ipset.NewAdaptive()
+		} else {
+			b.estimate = hll.New()
+		}
+		c.bucket[key] = b
+	}
+	return b
+}
+
+// Rows returns the distinct count of every window that covers data, in time
+// order: the window ending at each bucket with data and, for sliding windows,
+// the ones after it that still overlap it, including the partial windows at
+// the start. Runs of up to maxGap empty windows in between produce rows with a
+// zero count, so short gaps show in the series; longer ones, such as those
+// left by a stray timestamp far from the rest, are skipped.
+func (c *Counter) Rows() []Row {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.bucket) == 0 {
+		return nil
+	}
+	keys := make([]int64, 0, len(c.bucket))
+	for key := range c.bucket {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	span := int64(1) // Buckets per window.
+	if c.cfg.Slide != 0 {
+		span = int64(c.cfg.Size / c.cfg.Slide)
+	}
+	var (
+		rows []Row
+		u    union
+		last = keys[len(keys)-1]
+		next = keys[0] // Window end not yet reported.
+	)
+	for _, key := range keys {
+		if key-next > maxGap {
+			next = key
+		}
+		for ; next <= min(key+span-1, last); next++ {
+			startKey := next - span + 1
+			rows = append(rows, Row{
+				Start:    time.Unix(startKey*c.width, 0).UTC(),
+				End:      time.Unix((next+1)*c.width, 0).UTC(),
+				Distinct: u.count(c.bucket, startKey, next),
+			})
+		}
+	}
+	return rows
+}
+
+// union counts the distinct IPs over consecutive buckets. It reuses one set
+// for every window, so a sliding window over large exact buckets does not
+// allocate a bitmap per row.
+type union struct {
+	exact    *ipset.Adaptive
+	estimate hll.Sketch
+}
+
+// count returns the distinct count over buckets from through to.
+func (u *union) count(buckets map[int64]*bucket, from, to int64) int64 {
+	if from == to {
+		if b, ok := buckets[from]; ok {
+			if b.exact != nil {
+				return b.exact.Count()
+			}
+			return b.estimate.Count()
+		}
+		return 0
+	}
+
+	var exact, estimate bool
+	for key := from; key <= to; key++ {
+		b, ok := buckets[key]
+		switch {
+		case !ok:
+			continue
+		case b.exact != nil && !exact:
+			if u.exact == nil {
+				u.exact = ipset.NewAdaptive()
+			}
+			u.exact.Reset()
+			u.exact.Merge(b.exact)
+			exact = true
+		case b.exact != nil:
+			u.exact.Merge(b.exact)
+		case !estimate:
+			u.estimate = *b.estimate
+			estimate = true
+		default:
+			u.estimate.Merge(b.estimate)
+		}
+	}
+	switch {
+	case exact:
+		return u.exact.Count()
+	case estimate:
+		return u.estimate.Count()
+	}
+	return 0
+}
+
+// WriteCSV writes rows as CSV with a start,end,distinct header. Times are
+// RFC3339 in UTC.
+func WriteCSV(w io.Writer, rows []Row) error {
+	if _, err := fmt.Fprintln(w, "start,end,distinct"); err != nil {
+		return err
+	}
+	for _, r := range rows {
+		_, err := fmt.Fprintf(w, "%s,%s,%d\n", r.Start.Format(time.RFC3339), r.End.Format(time.RFC3339), r.Distinct)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes rows as a JSON array.
+func WriteJSON(w io.Writer, rows []Row) error {
+	if rows == nil {
+		rows = []Row{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(rows)
+}
+
+// floorDiv divides a by b rounding towards negative infinity, so timestamps
+// before 1970 land in the right bucket.
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
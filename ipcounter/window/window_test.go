@@ -0,0 +1,144 @@
+package window
+
+import (
+	"IP-Addr-Counter/ipcounter/format"
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	want := time.Date(2000, time.October, 10, 20, 55, 36, 0, time.UTC).Unix()
+	for _, s := range []string{
+		"10/Oct/2000:13:55:36 -0700",
+		"2000-10-10T20:55:36Z",
+		"2000-10-10T13:55:36.250-07:00",
+		fmt.Sprint(want),
+		fmt.Sprintf("%d.75", want),
+		fmt.Sprintf("%d123", want),
+	} {
+		got, err := ParseTime([]byte(s))
+		if err != nil || got != want {
+			t.Errorf("ParseTime(%q) = %d, %v; want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "yesterday", "10/Foo/2000:13:55:36 -0700", "1.2.3"} {
+		if _, err := ParseTime([]byte(s)); err == nil {
+			t.Errorf("ParseTime(%q) succeeded", s)
+		}
+	}
+}
+
+// countCSV runs a Counter over ip,epoch lines.
+func countCSV(t *testing.T, cfg Config, input string) []Row {
+	t.Helper()
+	c, err := New(cfg, format.CSV{Column: 0, Separator: ','}, format.CSV{Column: 1, Separator: ','})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add(strings.NewReader(input)); err != nil {
+		t.Fatal(err)
+	}
+	return c.Rows()
+}
+
+func TestTumblingAndSliding(t *testing.T) {
+	// Minute 0: a, b. Minute 1: b. Minute 2: nothing. Minute 3: c, a.
+	input := "1.0.0.1,0\n1.0.0.2,10\n1.0.0.2,59\nbogus,60\n1.0.0.2,61\n1.0.0.3,185\n1.0.0.1,190\n"
+
+	for _, exact := range []bool{true, false} {
+		rows := countCSV(t, Config{Size: time.Minute, Exact: exact}, input)
+		want := []int64{2, 1, 0, 2}
+		if len(rows) != len(want) {
+			t.Fatalf("exact=%v: got %d tumbling rows, want %d", exact, len(rows), len(want))
+		}
+		for i, r := range rows {
+			if r.Distinct != want[i] || r.Start.Unix() != int64(i*60) || r.End.Unix() != int64(i*60+60) {
+				t.Errorf("exact=%v: row %d = %+v, want distinct %d", exact, i, r, want[i])
+			}
+		}
+
+		rows = countCSV(t, Config{Size: 2 * time.Minute, Slide: time.Minute, Exact: exact}, input)
+		want = []int64{2, 2, 1, 2}
+		for i, r := range rows {
+			if r.Distinct != want[i] || r.Start.Unix() != int64(i*60-60) {
+				t.Errorf("exact=%v: sliding row %d = %+v, want distinct %d", exact, i, r, want[i])
+			}
+		}
+	}
+}
+
+func TestOutlierTimestamp(t *testing.T) {
+	// One record stamped 1970 in a log from 2026: the 56 years in between are
+	// skipped instead of being reported one empty second at a time.
+	now := time.Date(2026, time.March, 1, 0, 0, 0, 0, time.UTC).Unix()
+	input := fmt.Sprintf("1.0.0.1,%d\n1.0.0.2,%d\n1.0.0.9,0\n1.0.0.3,%d\n", now, now+1, now+3)
+
+	rows := countCSV(t, Config{Size: time.Second, Exact: true}, input)
+	want := []Row{
+		{time.Unix(0, 0).UTC(), time.Unix(1, 0).UTC(), 1},
+		{time.Unix(now, 0).UTC(), time.Unix(now+1, 0).UTC(), 1},
+		{time.Unix(now+1, 0).UTC(), time.Unix(now+2, 0).UTC(), 1},
+		{time.Unix(now+2, 0).UTC(), time.Unix(now+3, 0).UTC(), 0},
+		{time.Unix(now+3, 0).UTC(), time.Unix(now+4, 0).UTC(), 1},
+	}
+	if fmt.Sprint(rows) != fmt.Sprint(want) {
+		t.Errorf("tumbling rows = %v, want %v", rows, want)
+	}
+
+	rows = countCSV(t, Config{Size: 3 * time.Second, Slide: time.Second}, input)
+	var distinct []int64
+	for _, r := range rows {
+		distinct = append(distinct, r.Distinct)
+	}
+	// The outlier's window and the two after it, then the log from its
+	// partial windows on.
+	if want := []int64{1, 1, 1, 1, 2, 2, 2}; fmt.Sprint(distinct) != fmt.Sprint(want) {
+		t.Errorf("sliding rows = %v, want distinct counts %v", rows, want)
+	}
+	if rows[3].Start.Unix() != now-2 {
+		t.Errorf("sliding row 3 starts at %v, want the partial window ending at %d", rows[3].Start, now+1)
+	}
+}
+
+func TestCLFBuckets(t *testing.T) {
+	c, err := New(Config{Size: time.Hour, Exact: true}, format.Combined{}, CLF{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var log bytes.Buffer
+	for i := 0; i < 10000; i++ {
+		hour := i % 3
+		fmt.Fprintf(&log, "10.0.%d.%d - - [10/Oct/2000:%02d:%02d:00 +0000] \"GET / HTTP/1.1\" 200 2\n", hour, i%100, hour, i%60)
+	}
+	if err := c.Add(&log); err != nil {
+		t.Fatal(err)
+	}
+	rows := c.Rows()
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want 3", len(rows))
+	}
+	for _, r := range rows {
+		if r.Distinct != 100 {
+			t.Errorf("row %+v: want 100 distinct", r)
+		}
+	}
+	if c.Invalid() != 0 {
+		t.Errorf("Invalid() = %d, want 0", c.Invalid())
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, cfg := range []Config{
+		{Size: 0},
+		{Size: 1500 * time.Millisecond},
+		{Size: time.Hour, Slide: 7 * time.Minute},
+		{Size: time.Minute, Slide: time.Hour},
+	} {
+		if cfg.Validate() == nil {
+			t.Errorf("%+v: expected an error", cfg)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package tests
+
+import (
+	"IP-Addr-Counter/ipcounter/adaptive"
+	"testing"
+)
+
+func BenchmarkAdaptiveCountUniqueIPs(b *testing.B) {
+	file, err := getTestFile("sample_1M.txt")
+	if err != nil {
+		b.Fatalf("Failed to get test file: %v", err)
+	}
+	counter := adaptive.New()
+	for i := 0; i < b.N; i++ {
+		_, err := counter.CountUniqueIPs(file)
+		if err != nil {
+			b.Fatalf("AdaptiveCounter failed: %v", err)
+		}
+	}
+}
+
+func TestAdaptiveWithSampleData(t *testing.T) {
+	file, err := getTestFile("sample_1M.txt")
+	if err != nil {
+		t.Fatalf("Failed to get test file: %v", err)
+	}
+	expected, err := getExpectedUniqueCount(file)
+	if err != nil {
+		t.Fatalf("Failed to get expected count: %v", err)
+	}
+	counter := adaptive.New()
+	actual, err := counter.CountUniqueIPs(file)
+	if err != nil {
+		t.Fatalf("AdaptiveCounter failed: %v", err)
+	}
+	if expected != int64(actual) {
+		t.Errorf("Expected %d unique IPs, got %d", expected, actual)
+	}
+}
+
+func TestAdaptiveWithDuplicates(t *testing.T) {
+	file, err := getTestFile("sample_1M_with_duplicates.txt")
+	if err != nil {
+		t.Fatalf("Failed to get test file: %v", err)
+	}
+	expected, err := getExpectedUniqueCount(file)
+	if err != nil {
+		t.Fatalf("Failed to get expected count: %v", err)
+	}
+	counter := adaptive.New()
+	actual, err := counter.CountUniqueIPs(file)
+	if err != nil {
+		t.Fatalf("AdaptiveCounter failed: %v", err)
+	}
+	if expected != int64(actual) {
+		t.Errorf("Expected %d unique IPs, got %d", expected, actual)
+	}
+}
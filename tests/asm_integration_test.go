@@ -2,6 +2,9 @@ package tests
 
 import (
 	"IP-Addr-Counter/ipcounter/assembly"
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -56,3 +59,31 @@ func TestAsmWithDuplicates(t *testing.T) {
 		t.Errorf("Expected %d unique IPs, got %d", expected, actual)
 	}
 }
+
+// TestAsmMatchesConcurrentOnEdgeCases checks that both sharded counters treat
+// blank lines, surrounding whitespace and an unterminated last line alike.
+func TestAsmMatchesConcurrentOnEdgeCases(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edge.txt")
+	input := "10.0.0.1\n\n  10.0.0.2\r\n\n10.0.0.1\n172.16.0.9"
+	if err := os.WriteFile(path, []byte(input), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for name, counter := range map[string]interface {
+		CountUniqueIPs(string) (int64, error)
+		Contains(uint32) bool
+	}{"asm": assembly.New(), "concurrent": concurrent.New()} {
+		n, err := counter.CountUniqueIPs(path)
+		if err != nil {
+			t.Fatalf("%s failed: %v", name, err)
+		}
+		if n != 3 {
+			t.Errorf("%s: expected 3 unique IPs, got %d", name, n)
+		}
+		if counter.Contains(0) {
+			t.Errorf("%s: blank lines were counted as 0.0.0.0", name)
+		}
+		if !counter.Contains(0xAC100009) {
+			t.Errorf("%s: the unterminated last line was dropped", name)
+		}
+	}
+}
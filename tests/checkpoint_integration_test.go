@@ -0,0 +1,135 @@
+package tests
+
+import (
+	"IP-Addr-Counter/ipcounter/assembly"
+	"IP-Addr-Counter/ipcounter/checkpoint"
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/ipset"
+	"IP-Addr-Counter/ipcounter/utils"
+	"bufio"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// resumableCounter is a counter that can checkpoint and resume a count.
+type resumableCounter interface {
+	CountUniqueIPs(filename string) (int64, error)
+	UseCheckpoint(filename string, interval time.Duration, format string)
+	Resume(c *checkpoint.Checkpoint)
+}
+
+// partialCheckpoint returns the checkpoint a run that died after the first n
+// lines of filename could have left.
+func partialCheckpoint(filename string, n int) (*checkpoint.Checkpoint, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	c := &checkpoint.Checkpoint{Input: checkpoint.InputOf(info, ""), Set: ipset.NewBitmap()}
+	reader := bufio.NewReader(file)
+	for i := 0; i < n; i++ {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			return nil, err
+		}
+		c.Offset += int64(len(line))
+		if ip, err := utils.ParseIPv4(bytes.TrimSpace(line)); err == nil {
+			c.Set.Add(ip)
+		}
+	}
+	return c, nil
+}
+
+func TestResumeFromCheckpoint(t *testing.T) {
+	file, err := getTestFile("sample_1M_with_duplicates.txt")
+	if err != nil {
+		t.Fatalf("Failed to get test file: %v", err)
+	}
+	expected, err := getExpectedUniqueCount(file)
+	if err != nil {
+		t.Fatalf("Failed to compute expected count: %v", err)
+	}
+	info, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	partial, err := partialCheckpoint(file, 400000)
+	if err != nil {
+		t.Fatalf("Failed to build checkpoint: %v", err)
+	}
+
+	counters := map[string]func() resumableCounter{
+		"concurrent": func() resumableCounter { return concurrent.New() },
+		"asm":        func() resumableCounter { return assembly.New() },
+	}
+	for name, newCounter := range counters {
+		path := filepath.Join(t.TempDir(), name+".ckpt")
+		counter := newCounter()
+		counter.Resume(partial)
+		counter.UseCheckpoint(path, time.Hour, "")
+		count, err := counter.CountUniqueIPs(file)
+		if err != nil {
+			t.Fatalf("%s: CountUniqueIPs failed: %v", name, err)
+		}
+		if count != expected {
+			t.Errorf("%s: resumed count = %d, want %d", name, count, expected)
+		}
+
+		// The final checkpoint covers the whole file, so resuming from it
+		// reads nothing and gives the same count.
+		final, err := checkpoint.Load(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if final.Offset != info.Size() || final.Set.Count() != expected {
+			t.Errorf("%s: final checkpoint at %d with %d IPs, want %d with %d", name, final.Offset, final.Set.Count(), info.Size(), expected)
+		}
+		counter = newCounter()
+		counter.Resume(final)
+		if count, err := counter.CountUniqueIPs(file); err != nil || count != expected {
+			t.Errorf("%s: count resumed at the end = %d, %v, want %d", name, count, err, expected)
+		}
+	}
+}
+
+func TestResumeRefusesOtherInput(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ips.txt")
+	if err := os.WriteFile(file, []byte("1.1.1.1\n2.2.2.2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := partialCheckpoint(file, 1)
+	if err != nil {
+		t.Fatalf("Failed to build checkpoint: %v", err)
+	}
+
+	// Read with another format.
+	counter := concurrent.New()
+	counter.Resume(c)
+	counter.UseCheckpoint(filepath.Join(t.TempDir(), "ckpt"), time.Hour, "csv:0")
+	if _, err := counter.CountUniqueIPs(file); err == nil {
+		t.Errorf("resumed a checkpoint taken with another format")
+	}
+
+	// The same size, written since.
+	if err := os.WriteFile(file, []byte("3.3.3.3\n4.4.4.4\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	later := c.Input.ModTime.Add(time.Second)
+	if err := os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	counter = concurrent.New()
+	counter.Resume(c)
+	if _, err := counter.CountUniqueIPs(file); err == nil {
+		t.Errorf("resumed a checkpoint of a file that has changed since")
+	}
+}
@@ -0,0 +1,73 @@
+package tests
+
+import (
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/tuning"
+	"bytes"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDedupOrderedMatchesFirstOccurrences(t *testing.T) {
+	file, err := getTestFile("sample_1M_with_duplicates.txt")
+	if err != nil {
+		t.Fatalf("Failed to get test file: %v", err)
+	}
+	expected, err := getFirstOccurrences(file)
+	if err != nil {
+		t.Fatalf("Failed to get expected output: %v", err)
+	}
+
+	// Also many small chunks, more workers than CPUs and a short queue, so
+	// chunks finish out of order while few may be in flight.
+	for _, opts := range [][]tuning.Option{nil, {tuning.ChunkBytes(4096), tuning.Workers(8), tuning.QueueLen(1)}} {
+		in, err := os.Open(file)
+		if err != nil {
+			t.Fatalf("Failed to open test file: %v", err)
+		}
+		var out bytes.Buffer
+		n, err := concurrent.New(opts...).Dedup(in, &out, true)
+		in.Close()
+		if err != nil {
+			t.Fatalf("Dedup failed: %v", err)
+		}
+		if out.String() != expected {
+			t.Errorf("ordered Dedup output differs from awk '!seen[$0]++'")
+		}
+		if want := int64(strings.Count(expected, "\n")); n != want {
+			t.Errorf("Dedup wrote %d lines, want %d", n, want)
+		}
+	}
+}
+
+func TestDedupUnorderedWritesEachIPOnce(t *testing.T) {
+	file, err := getTestFile("sample_1M_with_duplicates.txt")
+	if err != nil {
+		t.Fatalf("Failed to get test file: %v", err)
+	}
+	expected, err := getFirstOccurrences(file)
+	if err != nil {
+		t.Fatalf("Failed to get expected output: %v", err)
+	}
+
+	in, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("Failed to open test file: %v", err)
+	}
+	defer in.Close()
+
+	var out bytes.Buffer
+	if _, err := concurrent.New().Dedup(in, &out, false); err != nil {
+		t.Fatalf("Dedup failed: %v", err)
+	}
+
+	got := strings.Split(strings.TrimSpace(out.String()), "\n")
+	want := strings.Split(strings.TrimSpace(expected), "\n")
+	sort.Strings(got)
+	sort.Strings(want)
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unordered Dedup wrote %d lines, want the same %d IPs as awk", len(got), len(want))
+	}
+}
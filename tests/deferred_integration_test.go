@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/tuning"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeferredCounters(t *testing.T) {
+	file, err := getTestFile("sample_1M_with_duplicates.txt")
+	if err != nil {
+		t.Fatalf("Failed to get test file: %v", err)
+	}
+	expected, err := getExpectedUniqueCount(file)
+	if err != nil {
+		t.Fatalf("Failed to get expected count: %v", err)
+	}
+	for _, shards := range []int{1, tuning.DefaultShards} {
+		opts := []tuning.Option{tuning.Deferred(true), tuning.Shards(shards)}
+		for impl, counter := range newCounters(t, opts, "concurrent", "asm") {
+			actual, err := counter.CountUniqueIPs(file)
+			if err != nil {
+				t.Fatalf("deferred %s with %d shards failed: %v", impl, shards, err)
+			}
+			if actual != expected {
+				t.Errorf("deferred %s with %d shards: expected %d unique IPs, got %d", impl, shards, expected, actual)
+			}
+			// The IPs are all known now, so a second count finds none new.
+			if again, err := counter.CountUniqueIPs(file); err != nil || again != 0 {
+				t.Errorf("deferred %s with %d shards: second count = %d, %v; want 0, nil", impl, shards, again, err)
+			}
+		}
+	}
+}
+
+// BenchmarkDeferred compares a compare-and-swap per IP with atomic ORs and a
+// popcount at the end. Each iteration counts into a fresh bitset, so neither
+// mode finds its bits already set.
+func BenchmarkDeferred(b *testing.B) {
+	file, err := getTestFile("sample_1M.txt")
+	if err != nil {
+		b.Fatalf("Failed to get test file: %v", err)
+	}
+	for _, impl := range []string{"concurrent", "asm"} {
+		for _, deferred := range []bool{false, true} {
+			b.Run(fmt.Sprintf("%s/deferred=%v", impl, deferred), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					b.StopTimer()
+					counter := newCounter(b, impl, tuning.Deferred(deferred))
+					b.StartTimer()
+					if _, err := counter.CountUniqueIPs(file); err != nil {
+						b.Fatalf("%s failed: %v", impl, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestDeferredAfterExactInserts(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ips.txt")
+	if err := os.WriteFile(file, []byte("10.0.0.1\n10.0.0.2\n10.0.0.3\n10.0.0.2\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	counter := concurrent.New(tuning.Deferred(true))
+	// Seen one IP at a time, which the next popcount must not count as new.
+	counter.Add(0x0A000001)
+	counter.Add(0x0A000009)
+	if got, err := counter.CountUniqueIPs(file); err != nil || got != 2 {
+		t.Errorf("deferred count after Add = %d, %v; want 2, nil", got, err)
+	}
+	counter.Add(0x0A000004)
+	if got := counter.AddChunk([]byte("10.0.0.5\n")); got != 1 {
+		t.Errorf("AddChunk in deferred mode = %d, want 1", got)
+	}
+	if got, err := counter.CountUniqueIPs(file); err != nil || got != 0 {
+		t.Errorf("second deferred count = %d, %v; want 0, nil", got, err)
+	}
+}
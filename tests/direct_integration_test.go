@@ -0,0 +1,34 @@
+package tests
+
+import (
+	"IP-Addr-Counter/ipcounter/pipeline"
+	"IP-Addr-Counter/ipcounter/tuning"
+	"testing"
+)
+
+func TestDirectCounters(t *testing.T) {
+	file, err := getTestFile("sample_1M_with_duplicates.txt")
+	if err != nil {
+		t.Fatalf("Failed to get test file: %v", err)
+	}
+	if err := pipeline.ProbeDirect(file); err != nil {
+		t.Logf("O_DIRECT unavailable, testing the page cache fallback: %v", err)
+	}
+	expected, err := getExpectedUniqueCount(file)
+	if err != nil {
+		t.Fatalf("Failed to get expected count: %v", err)
+	}
+	// Chunks that are not a multiple of the block size, with and without io_uring.
+	for _, ioUring := range []bool{false, true} {
+		opts := []tuning.Option{tuning.Direct(true), tuning.IOUring(ioUring), tuning.ChunkBytes(1<<20 + 13)}
+		for impl, counter := range newCounters(t, opts, "concurrent", "asm", "partitioned") {
+			actual, err := counter.CountUniqueIPs(file)
+			if err != nil {
+				t.Fatalf("%s with O_DIRECT (io_uring=%v) failed: %v", impl, ioUring, err)
+			}
+			if actual != expected {
+				t.Errorf("%s with O_DIRECT (io_uring=%v): expected %d unique IPs, got %d", impl, ioUring, expected, actual)
+			}
+		}
+	}
+}
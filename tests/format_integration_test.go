@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"IP-Addr-Counter/ipcounter/assembly"
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/format"
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// formatCounter is a counter that can read IPs out of structured records.
+type formatCounter interface {
+	CountUniqueIPs(filename string) (int64, error)
+	UseFormat(e format.Extractor)
+}
+
+func TestFormatsWithSampleData(t *testing.T) {
+	file, err := getTestFile("sample_1M_with_duplicates.txt")
+	if err != nil {
+		t.Fatalf("Failed to get test file: %v", err)
+	}
+	expected, err := getExpectedUniqueCount(file)
+	if err != nil {
+		t.Fatalf("Failed to get expected count: %v", err)
+	}
+
+	templates := map[string]string{
+		"combined":                 "%s - - [10/Oct/2000:13:55:36 -0700] \"GET /index.html HTTP/1.1\" 200 2326\n",
+		"json:client":              "{\"path\":\"/api\",\"client\":\"%s\",\"status\":200}\n",
+		"csv:1":                    "2024-01-01T00:00:00Z,%s,GET\n",
+		"regex:ip=(?P<ip>[0-9.]+)": "level=info ip=%s msg=\"ok\"\n",
+	}
+	for spec, tmpl := range templates {
+		logFile, err := writeRecords(t, file, tmpl)
+		if err != nil {
+			t.Fatalf("%s: failed to write records: %v", spec, err)
+		}
+		e, err := format.Parse(spec)
+		if err != nil {
+			t.Fatalf("%s: Parse failed: %v", spec, err)
+		}
+
+		for name, counter := range map[string]formatCounter{"concurrent": concurrent.New(), "asm": assembly.New()} {
+			counter.UseFormat(e)
+			actual, err := counter.CountUniqueIPs(logFile)
+			if err != nil {
+				t.Fatalf("%s/%s: CountUniqueIPs failed: %v", spec, name, err)
+			}
+			if actual != expected {
+				t.Errorf("%s/%s: expected %d unique IPs, got %d", spec, name, expected, actual)
+			}
+		}
+	}
+}
+
+// writeRecords wraps every IP of the input file in the record template and
+// returns the path of the resulting file.
+func writeRecords(t *testing.T, input, tmpl string) (string, error) {
+	in, err := os.Open(input)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	path := filepath.Join(t.TempDir(), "records.log")
+	out, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(out)
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		fmt.Fprintf(w, tmpl, scanner.Text())
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	return path, out.Close()
+}
@@ -0,0 +1,45 @@
+package tests
+
+import (
+	"IP-Addr-Counter/ipcounter/hugemem"
+	"IP-Addr-Counter/ipcounter/tuning"
+	"runtime"
+	"testing"
+)
+
+// pagedCounter is a counter that tells what memory its bitset is on.
+type pagedCounter interface {
+	fileCounter
+	Memory() hugemem.Info
+}
+
+func TestHugePageCounters(t *testing.T) {
+	file, err := getTestFile("sample_1M_with_duplicates.txt")
+	if err != nil {
+		t.Fatalf("Failed to get test file: %v", err)
+	}
+	expected, err := getExpectedUniqueCount(file)
+	if err != nil {
+		t.Fatalf("Failed to get expected count: %v", err)
+	}
+	// One at a time, so that only one bitset is on huge pages at once.
+	for _, impl := range []string{"bitset", "concurrent", "asm", "partitioned"} {
+		counter, ok := newCounter(t, impl, tuning.HugePages(true)).(pagedCounter)
+		if !ok {
+			t.Fatalf("%s does not report its memory", impl)
+		}
+		actual, err := counter.CountUniqueIPs(file)
+		if err != nil {
+			t.Fatalf("%s on huge pages failed: %v", impl, err)
+		}
+		if actual != expected {
+			t.Errorf("%s on huge pages: expected %d unique IPs, got %d", impl, expected, actual)
+		}
+		mem := counter.Memory()
+		if mem.Size != 1<<32/8 || mem.PageSize <= 0 {
+			t.Errorf("%s on huge pages: Memory() = %+v", impl, mem)
+		}
+		t.Logf("%s: %v", impl, mem)
+		runtime.GC() // Unmap the bitset before the next one is faulted in.
+	}
+}
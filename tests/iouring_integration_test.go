@@ -0,0 +1,56 @@
+package tests
+
+import (
+	"IP-Addr-Counter/ipcounter/assembly"
+	"IP-Addr-Counter/ipcounter/tuning"
+	"IP-Addr-Counter/ipcounter/uring"
+	"fmt"
+	"testing"
+)
+
+func TestIOUringCounters(t *testing.T) {
+	if err := uring.Probe(); err != nil {
+		t.Skipf("io_uring unavailable: %v", err)
+	}
+	file, err := getTestFile("sample_1M_with_duplicates.txt")
+	if err != nil {
+		t.Fatalf("Failed to get test file: %v", err)
+	}
+	expected, err := getExpectedUniqueCount(file)
+	if err != nil {
+		t.Fatalf("Failed to get expected count: %v", err)
+	}
+	// Chunks of many reads, and chunks smaller than a line.
+	for _, chunkBytes := range []int{tuning.DefaultChunkBytes, 13} {
+		opts := []tuning.Option{tuning.IOUring(true), tuning.ChunkBytes(chunkBytes)}
+		for impl, counter := range newCounters(t, opts, "concurrent", "asm", "partitioned") {
+			actual, err := counter.CountUniqueIPs(file)
+			if err != nil {
+				t.Fatalf("%s with io_uring and %d-byte chunks failed: %v", impl, chunkBytes, err)
+			}
+			if actual != expected {
+				t.Errorf("%s with io_uring and %d-byte chunks: expected %d unique IPs, got %d", impl, chunkBytes, expected, actual)
+			}
+		}
+	}
+}
+
+// BenchmarkReaders compares reading the input with read(2) and with io_uring.
+// The gap shows on storage that needs many reads in flight, not on a file in
+// the page cache.
+func BenchmarkReaders(b *testing.B) {
+	file, err := getTestFile("sample_1M.txt")
+	if err != nil {
+		b.Fatalf("Failed to get test file: %v", err)
+	}
+	for _, ioUring := range []bool{false, true} {
+		b.Run(fmt.Sprintf("io_uring=%v", ioUring), func(b *testing.B) {
+			counter := assembly.New(tuning.IOUring(ioUring))
+			for i := 0; i < b.N; i++ {
+				if _, err := counter.CountUniqueIPs(file); err != nil {
+					b.Fatalf("count failed: %v", err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package tests
+
+import (
+	"IP-Addr-Counter/ipcounter/ipset"
+	"IP-Addr-Counter/ipcounter/tuning"
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// layoutInputs generate n IPs in the orders the bitset layouts are compared
+// on: uniformly random, sorted, and clustered in a few dozen /16s the way the
+// clients of a service usually are.
+var layoutInputs = []struct {
+	name string
+	gen  func(rng *rand.Rand, n int) []uint32
+}{
+	{"random", func(rng *rand.Rand, n int) []uint32 {
+		ips := make([]uint32, n)
+		for i := range ips {
+			ips[i] = rng.Uint32()
+		}
+		return ips
+	}},
+	{"sorted", func(rng *rand.Rand, n int) []uint32 {
+		ips := make([]uint32, n)
+		for i := range ips {
+			ips[i] = rng.Uint32()
+		}
+		slices.Sort(ips)
+		return ips
+	}},
+	{"clustered", func(rng *rand.Rand, n int) []uint32 {
+		prefixes := make([]uint32, 64)
+		for i := range prefixes {
+			prefixes[i] = rng.Uint32() &^ 0xFFFF
+		}
+		ips := make([]uint32, n)
+		for i := range ips {
+			ips[i] = prefixes[rng.Intn(len(prefixes))] | uint32(rng.Intn(1<<16))
+		}
+		return ips
+	}},
+}
+
+// writeIPs writes ips to a file in dir, one per line, and returns its path.
+func writeIPs(dir, name string, ips []uint32) (string, error) {
+	path := filepath.Join(dir, name+".txt")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	w := bufio.NewWriter(f)
+	for _, ip := range ips {
+		fmt.Fprintf(w, "%d.%d.%d.%d\n", ip>>24, ip>>16&0xFF, ip>>8&0xFF, ip&0xFF)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return "", err
+	}
+	return path, f.Close()
+}
+
+func TestLayouts(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	var ips []uint32
+	for _, input := range layoutInputs {
+		ips = append(ips, input.gen(rng, 50000)...)
+	}
+	ips = append(ips, ips[:1000]...) // Some duplicates.
+	file, err := writeIPs(t.TempDir(), "mixed", ips)
+	if err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	unique := make(map[uint32]bool)
+	for _, ip := range ips {
+		unique[ip] = true
+	}
+	expected := int64(len(unique))
+
+	for _, layout := range []ipset.Layout{ipset.Interleaved, ipset.Ranged} {
+		for _, deferred := range []bool{false, true} {
+			opts := []tuning.Option{tuning.Layout(layout), tuning.Deferred(deferred)}
+			for impl, counter := range newCounters(t, opts, "concurrent", "asm") {
+				actual, err := counter.CountUniqueIPs(file)
+				if err != nil {
+					t.Fatalf("%s %v (deferred=%v) failed: %v", impl, layout, deferred, err)
+				}
+				if actual != expected {
+					t.Errorf("%s %v (deferred=%v): expected %d unique IPs, got %d", impl, layout, deferred, expected, actual)
+				}
+			}
+		}
+	}
+}
+
+// BenchmarkLayouts compares the interleaved and ranged bitset layouts on
+// random, sorted and clustered inputs of 1M IPs. Each iteration counts into a
+// fresh bitset.
+func BenchmarkLayouts(b *testing.B) {
+	dir := b.TempDir()
+	for _, input := range layoutInputs {
+		file, err := writeIPs(dir, input.name, input.gen(rand.New(rand.NewSource(1)), 1_000_000))
+		if err != nil {
+			b.Fatalf("Failed to write %s input: %v", input.name, err)
+		}
+		for _, impl := range []string{"concurrent", "asm"} {
+			for _, layout := range []ipset.Layout{ipset.Interleaved, ipset.Ranged} {
+				b.Run(fmt.Sprintf("%s/%s/%v", input.name, impl, layout), func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						b.StopTimer()
+						counter := newCounter(b, impl, tuning.Layout(layout))
+						b.StartTimer()
+						if _, err := counter.CountUniqueIPs(file); err != nil {
+							b.Fatalf("%s failed: %v", impl, err)
+						}
+					}
+				})
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package tests
+
+import (
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/partitioned"
+	"bufio"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPartitionedWithSampleData(t *testing.T) {
+	for _, name := range []string{"sample_1M.txt", "sample_1M_with_duplicates.txt"} {
+		file, err := getTestFile(name)
+		if err != nil {
+			t.Fatalf("Failed to get test file: %v", err)
+		}
+		expected, err := getExpectedUniqueCount(file)
+		if err != nil {
+			t.Fatalf("Failed to get expected count: %v", err)
+		}
+		actual, err := partitioned.New().CountUniqueIPs(file)
+		if err != nil {
+			t.Fatalf("PartitionedCounter failed: %v", err)
+		}
+		if expected != actual {
+			t.Errorf("%s: expected %d unique IPs, got %d", name, expected, actual)
+		}
+	}
+}
+
+// writeHotInput writes n IPs of which nine in ten fall in a handful of /24s,
+// the traffic shape where atomic inserts fight over cache lines.
+func writeHotInput(dir string, n int) (string, error) {
+	path := filepath.Join(dir, "hot.txt")
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		if rng.Intn(10) > 0 {
+			fmt.Fprintf(w, "10.0.%d.%d\n", rng.Intn(4), rng.Intn(256))
+		} else {
+			fmt.Fprintf(w, "%d.%d.%d.%d\n", rng.Intn(256), rng.Intn(256), rng.Intn(256), rng.Intn(256))
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// BenchmarkInsertDesigns compares the atomic, compare-and-swap inserts of the
+// concurrent counter with the single-owner ranges of the partitioned one, on
+// uniform and on hot-/24 input.
+func BenchmarkInsertDesigns(b *testing.B) {
+	uniform, err := getTestFile("sample_1M.txt")
+	if err != nil {
+		b.Fatalf("Failed to get test file: %v", err)
+	}
+	hot, err := writeHotInput(b.TempDir(), 1_000_000)
+	if err != nil {
+		b.Fatalf("Failed to write hot input: %v", err)
+	}
+	for _, input := range []struct{ name, file string }{{"uniform", uniform}, {"hot", hot}} {
+		b.Run("atomic/"+input.name, func(b *testing.B) {
+			counter := concurrent.New()
+			for i := 0; i < b.N; i++ {
+				if _, err := counter.CountUniqueIPs(input.file); err != nil {
+					b.Fatalf("ConcurrentCounter failed: %v", err)
+				}
+			}
+		})
+		b.Run("partitioned/"+input.name, func(b *testing.B) {
+			counter := partitioned.New()
+			for i := 0; i < b.N; i++ {
+				if _, err := counter.CountUniqueIPs(input.file); err != nil {
+					b.Fatalf("PartitionedCounter failed: %v", err)
+				}
+			}
+		})
+	}
+}
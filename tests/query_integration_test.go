@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"IP-Addr-Counter/ipcounter/assembly"
+	"IP-Addr-Counter/ipcounter/bitset"
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/ipset"
+	"IP-Addr-Counter/ipcounter/utils"
+	"bufio"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// queryableCounter is a counter whose seen IPs can be queried and snapshotted.
+type queryableCounter interface {
+	CountUniqueIPs(filename string) (int64, error)
+	Contains(ip uint32) bool
+	Bitmap() *ipset.Bitmap
+}
+
+func TestContainsAfterCount(t *testing.T) {
+	file, err := getTestFile("sample_1M_with_duplicates.txt")
+	if err != nil {
+		t.Fatalf("Failed to get test file: %v", err)
+	}
+	ips, err := readIPs(file, 1000)
+	if err != nil {
+		t.Fatalf("Failed to read IPs: %v", err)
+	}
+
+	counters := map[string]queryableCounter{
+		"bitset":     bitset.New(),
+		"concurrent": concurrent.New(),
+		"asm":        assembly.New(),
+	}
+	for name, counter := range counters {
+		count, err := counter.CountUniqueIPs(file)
+		if err != nil {
+			t.Fatalf("%s: CountUniqueIPs failed: %v", name, err)
+		}
+		for _, ip := range ips {
+			if !counter.Contains(ip) {
+				t.Errorf("%s: Contains(%s) = false, want true", name, utils.Uint32ToIP(ip))
+			}
+		}
+
+		// A snapshot must round-trip to the same set, whatever the layout.
+		path := filepath.Join(t.TempDir(), name+".snap")
+		if err := counter.Bitmap().Save(path); err != nil {
+			t.Fatalf("%s: Save failed: %v", name, err)
+		}
+		loaded, err := ipset.Load(path)
+		if err != nil {
+			t.Fatalf("%s: Load failed: %v", name, err)
+		}
+		if loaded.Count() != count {
+			t.Errorf("%s: snapshot has %d IPs, want %d", name, loaded.Count(), count)
+		}
+		for i, found := range loaded.ContainsAll(ips) {
+			if !found {
+				t.Errorf("%s: snapshot is missing %s", name, utils.Uint32ToIP(ips[i]))
+			}
+		}
+	}
+}
+
+// readIPs returns up to n valid IPs from the beginning of the file.
+func readIPs(filename string, n int) ([]uint32, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var ips []uint32
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() && len(ips) < n {
+		ip, err := utils.ParseIPv4(scanner.Bytes())
+		if err == nil {
+			ips = append(ips, ip)
+		}
+	}
+	return ips, scanner.Err()
+}
@@ -0,0 +1,60 @@
+package tests
+
+import (
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/tuning"
+	"fmt"
+	"testing"
+)
+
+// tunings are settings far from the defaults, down to a single shard and a
+// chunk smaller than a line.
+var tunings = []struct {
+	name string
+	opts []tuning.Option
+}{
+	{"one shard", []tuning.Option{tuning.Shards(1), tuning.Workers(3)}},
+	{"many shards", []tuning.Option{tuning.Shards(1 << 20), tuning.QueueLen(1)}},
+	{"tiny chunks", []tuning.Option{tuning.ChunkBytes(5), tuning.Workers(2), tuning.QueueLen(2)}},
+}
+
+func TestTunedCounters(t *testing.T) {
+	file, err := getTestFile("sample_1M_with_duplicates.txt")
+	if err != nil {
+		t.Fatalf("Failed to get test file: %v", err)
+	}
+	expected, err := getExpectedUniqueCount(file)
+	if err != nil {
+		t.Fatalf("Failed to get expected count: %v", err)
+	}
+	for _, tt := range tunings {
+		for impl, counter := range newCounters(t, tt.opts, "concurrent", "asm") {
+			actual, err := counter.CountUniqueIPs(file)
+			if err != nil {
+				t.Fatalf("%s with %s failed: %v", impl, tt.name, err)
+			}
+			if actual != expected {
+				t.Errorf("%s with %s: expected %d unique IPs, got %d", impl, tt.name, expected, actual)
+			}
+		}
+	}
+}
+
+func BenchmarkTunedConcurrent(b *testing.B) {
+	file, err := getTestFile("sample_1M.txt")
+	if err != nil {
+		b.Fatalf("Failed to get test file: %v", err)
+	}
+	for _, shards := range []int{256, tuning.DefaultShards, 1 << 20} {
+		for _, chunk := range []int{1 << 20, tuning.DefaultChunkBytes} {
+			b.Run(fmt.Sprintf("shards=%d/chunk=%dK", shards, chunk>>10), func(b *testing.B) {
+				counter := concurrent.New(tuning.Shards(shards), tuning.ChunkBytes(chunk))
+				for i := 0; i < b.N; i++ {
+					if _, err := counter.CountUniqueIPs(file); err != nil {
+						b.Fatalf("ConcurrentCounter failed: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -2,11 +2,17 @@
 package tests
 
 import (
+	"IP-Addr-Counter/ipcounter/assembly"
+	"IP-Addr-Counter/ipcounter/bitset"
+	"IP-Addr-Counter/ipcounter/concurrent"
+	"IP-Addr-Counter/ipcounter/partitioned"
+	"IP-Addr-Counter/ipcounter/tuning"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"testing"
 )
 
 // getTestFile returns the absolute path to a test file given its name.
@@ -31,3 +37,45 @@ func getExpectedUniqueCount(filename string) (int64, error) {
 	res, err := strconv.Atoi(result)
 	return int64(res), err
 }
+
+// getFirstOccurrences runs the Unix pipeline: awk '!seen[$0]++'
+// and returns the first occurrence of every line, in file order.
+func getFirstOccurrences(filename string) (string, error) {
+	cmd := exec.Command("awk", "!seen[$0]++", filename)
+	output, err := cmd.Output()
+	return string(output), err
+}
+
+// fileCounter is a counter of the unique IPs of a file.
+type fileCounter interface {
+	CountUniqueIPs(filename string) (int64, error)
+}
+
+// newCounter returns a counter of the named implementation, one of those that
+// take tuning options: bitset, concurrent, asm or partitioned. It fails tb on
+// any other name.
+func newCounter(tb testing.TB, impl string, opts ...tuning.Option) fileCounter {
+	tb.Helper()
+	switch impl {
+	case "bitset":
+		return bitset.New(opts...)
+	case "concurrent":
+		return concurrent.New(opts...)
+	case "asm":
+		return assembly.New(opts...)
+	case "partitioned":
+		return partitioned.New(opts...)
+	}
+	tb.Fatalf("no tuned implementation %q", impl)
+	return nil
+}
+
+// newCounters returns a counter of each of impls with the same opts, by name.
+func newCounters(tb testing.TB, opts []tuning.Option, impls ...string) map[string]fileCounter {
+	tb.Helper()
+	counters := make(map[string]fileCounter, len(impls))
+	for _, impl := range impls {
+		counters[impl] = newCounter(tb, impl, opts...)
+	}
+	return counters
+}